@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Role is an account role granting permissions
+type Role string
+
+const (
+	// ReadOnly role grants Read permission
+	ReadOnly Role = "read-only"
+
+	// ReadWrite role grants Read and Write permissions
+	ReadWrite Role = "read-write"
+
+	// Admin role grants all permissions
+	Admin Role = "admin"
+
+	// Monitor role grants ReadMetrics permission only, e.g. to metrics scraper
+	Monitor Role = "monitor"
+)
+
+// Validate validates role
+func (r Role) Validate() error {
+	switch r {
+	case ReadOnly, ReadWrite, Admin, Monitor:
+		return nil
+	}
+	return fmt.Errorf(`unknown role "%s", must be one of: read-only, read-write, admin, monitor`, r)
+}
+
+// Allows reports whether role grants permission p
+func (r Role) Allows(p Permission) bool {
+	switch r {
+	case ReadOnly:
+		return p == Read
+	case ReadWrite:
+		return p == Read || p == Write
+	case Admin:
+		return true
+	case Monitor:
+		return p == ReadMetrics
+	}
+	return false
+}
+
+// Permission is an access permission
+type Permission int
+
+const (
+	// Read permits reading items
+	Read Permission = iota + 1
+
+	// Write permits modifying items
+	Write
+
+	// Administer permits whole store operations: export, import, dump, flush, info, replication and cluster management
+	Administer
+
+	// ReadMetrics permits reading metrics
+	ReadMetrics
+)
+
+// Limits are account's quotas and requests rate limit. Zero limit is unlimited
+type Limits struct {
+	// MaxKeys and MaxBytes limit items count and memory usage estimate of account's namespace, accounted by store
+	MaxKeys  int   `yaml:"max_keys" json:"max_keys" msgpack:"max_keys"`
+	MaxBytes int64 `yaml:"max_bytes" json:"max_bytes" msgpack:"max_bytes"`
+
+	// MaxValueSize limits request body size of set requests
+	MaxValueSize int64 `yaml:"max_value_size" json:"max_value_size" msgpack:"max_value_size"`
+
+	// RequestsPerSecond limits requests rate by token bucket of Burst size, which is 1 if not set
+	RequestsPerSecond float64 `yaml:"requests_per_second" json:"requests_per_second" msgpack:"requests_per_second"`
+	Burst             int     `yaml:"burst" json:"burst" msgpack:"burst"`
+}
+
+// Validate validates limits
+func (l Limits) Validate() error {
+	if l.MaxKeys < 0 || l.MaxBytes < 0 || l.MaxValueSize < 0 || l.RequestsPerSecond < 0 || l.Burst < 0 {
+		return errors.New("limits can't be negative")
+	}
+	return nil
+}
+
+// Quoted reports whether items count or memory usage is limited
+func (l Limits) Quoted() bool {
+	return l.MaxKeys > 0 || l.MaxBytes > 0
+}
+
+// Account is an account of accounts file. Password is either bcrypt or argon2id hash or plain password. Keys are
+// glob-style patterns of allowed keys, all keys are allowed if empty. Namespace is a logical database account is bound
+// to, default if empty. Account may be written as password string, which is an admin account of default namespace
+type Account struct {
+	Password  string   `yaml:"password"`
+	Role      Role     `yaml:"role"`
+	Keys      []string `yaml:"keys"`
+	Namespace string   `yaml:"namespace"`
+	Limits    Limits   `yaml:"limits"`
+}
+
+// UnmarshalYAML decodes account from plain password string or from map
+func (a *Account) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var password string
+	if err := unmarshal(&password); err == nil {
+		*a = Account{Password: password, Role: Admin}
+		return nil
+	}
+	type plain Account
+	return unmarshal((*plain)(a))
+}
+
+// Validate validates account. Admin account can't be restricted by keys, since it administers whole store
+func (a Account) Validate() error {
+	if a.Password == "" {
+		return errors.New("password required")
+	}
+	if err := validatePassword(a.Password); err != nil {
+		return err
+	}
+	if err := a.Role.Validate(); err != nil {
+		return err
+	}
+	if a.Role == Admin && len(a.Keys) > 0 {
+		return errors.New("admin account can't be restricted by keys")
+	}
+	return a.Limits.Validate()
+}
+
+// Allows reports whether account's role grants permission p
+func (a Account) Allows(p Permission) bool {
+	return a.Role.Allows(p)
+}
+
+// AllowsNamespace reports whether account may access namespace. Admin of default namespace may access any namespace,
+// other accounts only their own
+func (a Account) AllowsNamespace(namespace string) bool {
+	return namespace == a.Namespace || a.Role == Admin && a.Namespace == ""
+}
+
+// AllowsKey reports whether account may access key
+func (a Account) AllowsKey(key string) bool {
+	if len(a.Keys) == 0 {
+		return true
+	}
+	for _, pattern := range a.Keys {
+		if Match(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Accounts is an accounts by login
+type Accounts map[string]Account
+
+// Parse parses and validates YAML encoded accounts
+func Parse(data []byte) (Accounts, error) {
+	var a Accounts
+	if err := yaml.Unmarshal(data, &a); err != nil {
+		return nil, errors.New("failed to parse accounts YAML: " + err.Error())
+	}
+	for login, account := range a {
+		if err := account.Validate(); err != nil {
+			return nil, fmt.Errorf(`invalid account "%s": %s`, login, err)
+		}
+	}
+	return a, nil
+}
+
+// Namespaces returns sorted not empty namespaces of accounts
+func (a Accounts) Namespaces() []string {
+	unique := map[string]struct{}{}
+	for _, account := range a {
+		if account.Namespace != "" {
+			unique[account.Namespace] = struct{}{}
+		}
+	}
+	namespaces := make([]string, 0, len(unique))
+	for namespace := range unique {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// Authenticate returns account of login if password matches. Password hashes are verified on every call, use
+// Authenticator to cache them
+func (a Accounts) Authenticate(login string, password string) (Account, bool) {
+	account, exists := a[login]
+	if !exists || !verifyPassword(account.Password, password) {
+		return Account{}, false
+	}
+	return account, true
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Auth", func() {
+	Describe("Parse", func() {
+		Specify("plain password is admin account", func() {
+			Expect(Parse([]byte("a: pa"))).To(Equal(Accounts{"a": {Password: "pa", Role: Admin}}))
+		})
+		Specify("account with role and keys", func() {
+			Expect(Parse([]byte("b:\n  password: pb\n  role: read-only\n  keys: [\"user:*\"]\n"))).
+				To(Equal(Accounts{"b": {Password: "pb", Role: ReadOnly, Keys: []string{"user:*"}}}))
+		})
+		Specify("invalid YAML error", func() {
+			_, err := Parse([]byte("a: [pa"))
+			Expect(err).To(HaveOccurred())
+		})
+		Specify("unknown role error", func() {
+			_, err := Parse([]byte("a:\n  password: pa\n  role: root\n"))
+			Expect(err).To(MatchError(`invalid account "a": unknown role "root", must be one of: read-only, read-write, ` +
+				`admin, monitor`))
+		})
+		Specify("missing password error", func() {
+			_, err := Parse([]byte("a:\n  role: admin\n"))
+			Expect(err).To(MatchError(`invalid account "a": password required`))
+		})
+		Specify("restricted admin error", func() {
+			_, err := Parse([]byte("a:\n  password: pa\n  role: admin\n  keys: [a]\n"))
+			Expect(err).To(MatchError(`invalid account "a": admin account can't be restricted by keys`))
+		})
+		Specify("account with limits", func() {
+			Expect(Parse([]byte("a:\n  password: pa\n  role: read-write\n  limits:\n    max_keys: 10\n" +
+				"    max_bytes: 1024\n    max_value_size: 64\n    requests_per_second: 0.5\n    burst: 2\n"))).
+				To(Equal(Accounts{"a": {Password: "pa", Role: ReadWrite, Limits: Limits{MaxKeys: 10, MaxBytes: 1024,
+					MaxValueSize: 64, RequestsPerSecond: 0.5, Burst: 2}}}))
+		})
+		Specify("invalid password hash error", func() {
+			_, err := Parse([]byte("a: $2a$10$short\n"))
+			Expect(err).To(MatchError(`invalid account "a": invalid bcrypt password hash: crypto/bcrypt: ` +
+				`hashedSecret too short to be a bcrypted password`))
+		})
+		Specify("negative limits error", func() {
+			_, err := Parse([]byte("a:\n  password: pa\n  role: admin\n  limits:\n    max_keys: -1\n"))
+			Expect(err).To(MatchError(`invalid account "a": limits can't be negative`))
+		})
+	})
+	Specify("roles permissions", func() {
+		Expect(ReadOnly.Allows(Read)).To(BeTrue())
+		Expect(ReadOnly.Allows(Write)).To(BeFalse())
+		Expect(ReadOnly.Allows(Administer)).To(BeFalse())
+		Expect(ReadWrite.Allows(Read)).To(BeTrue())
+		Expect(ReadWrite.Allows(Write)).To(BeTrue())
+		Expect(ReadWrite.Allows(Administer)).To(BeFalse())
+		Expect(Admin.Allows(Administer)).To(BeTrue())
+		Expect(Admin.Allows(ReadMetrics)).To(BeTrue())
+		Expect(Monitor.Allows(ReadMetrics)).To(BeTrue())
+		Expect(Monitor.Allows(Read)).To(BeFalse())
+		Expect(ReadWrite.Allows(ReadMetrics)).To(BeFalse())
+		Expect(Role("").Allows(Read)).To(BeFalse())
+	})
+	Specify("allowed keys", func() {
+		Expect(Account{}.AllowsKey("a")).To(BeTrue())
+		a := Account{Keys: []string{"user:*", "session:?"}}
+		Expect(a.AllowsKey("user:1")).To(BeTrue())
+		Expect(a.AllowsKey("session:1")).To(BeTrue())
+		Expect(a.AllowsKey("session:10")).To(BeFalse())
+		Expect(a.AllowsKey("admin")).To(BeFalse())
+	})
+	Specify("namespaces", func() {
+		a := Accounts{
+			"a": {Role: Admin},
+			"b": {Role: ReadWrite, Namespace: "y"},
+			"c": {Role: ReadOnly, Namespace: "x"},
+			"d": {Role: Admin, Namespace: "y"},
+		}
+		Expect(a.Namespaces()).To(Equal([]string{"x", "y"}))
+		Expect(a["a"].AllowsNamespace("")).To(BeTrue())
+		Expect(a["a"].AllowsNamespace("x")).To(BeTrue())
+		Expect(a["b"].AllowsNamespace("y")).To(BeTrue())
+		Expect(a["b"].AllowsNamespace("")).To(BeFalse())
+		Expect(a["d"].AllowsNamespace("x")).To(BeFalse())
+		Expect(Account{Role: ReadWrite}.AllowsNamespace("x")).To(BeFalse())
+	})
+	Specify("authenticate", func() {
+		a := Accounts{"a": {Password: "pa", Role: ReadOnly}}
+		account, ok := a.Authenticate("a", "pa")
+		Expect(ok).To(BeTrue())
+		Expect(account).To(Equal(Account{Password: "pa", Role: ReadOnly}))
+		_, ok = a.Authenticate("a", "pb")
+		Expect(ok).To(BeFalse())
+		_, ok = a.Authenticate("b", "pa")
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Authenticator authenticates by accounts, which may be replaced without restart, and by API tokens of accounts.
+// Safe for concurrent use. Slow password hashes are verified once per login and password: SHA-256 of verified
+// password is cached until accounts are replaced. Requests rate of accounts is limited by authenticator's limiter
+type Authenticator struct {
+	state   atomic.Value
+	tokens  *Tokens
+	limiter *Limiter
+}
+
+// authState is an authenticator's accounts with verified passwords cache
+type authState struct {
+	accounts Accounts
+	mutex    sync.RWMutex
+	verified map[string][sha256.Size]byte
+}
+
+// NewAuthenticator constructs authenticator by accounts a with tokens kept in memory only
+func NewAuthenticator(a Accounts) *Authenticator {
+	t, _ := NewTokens("")
+	return NewTokensAuthenticator(a, t)
+}
+
+// NewTokensAuthenticator constructs authenticator by accounts a and tokens t
+func NewTokensAuthenticator(a Accounts, t *Tokens) *Authenticator {
+	au := &Authenticator{tokens: t, limiter: NewLimiter()}
+	au.Set(a)
+	return au
+}
+
+// Tokens returns tokens registry
+func (au *Authenticator) Tokens() *Tokens {
+	return au.tokens
+}
+
+// Limiter returns requests rate limiter
+func (au *Authenticator) Limiter() *Limiter {
+	return au.limiter
+}
+
+// Accounts returns current accounts
+func (au *Authenticator) Accounts() Accounts {
+	return au.current().accounts
+}
+
+// Set atomically replaces accounts with a. Requests being authenticated finish with previous accounts
+func (au *Authenticator) Set(a Accounts) {
+	au.state.Store(&authState{accounts: a, verified: map[string][sha256.Size]byte{}})
+}
+
+// Authenticate returns account of login if password matches
+func (au *Authenticator) Authenticate(login string, password string) (Account, bool) {
+	st := au.current()
+	account, exists := st.accounts[login]
+	if !exists {
+		return Account{}, false
+	}
+	if !hashed(account.Password) {
+		return st.accounts.Authenticate(login, password)
+	}
+	sum := sha256.Sum256([]byte(password))
+	st.mutex.RLock()
+	cached, exists := st.verified[login]
+	st.mutex.RUnlock()
+	if exists && subtle.ConstantTimeCompare(cached[:], sum[:]) == 1 {
+		return account, true
+	}
+	if !verifyPassword(account.Password, password) {
+		return Account{}, false
+	}
+	st.mutex.Lock()
+	st.verified[login] = sum
+	st.mutex.Unlock()
+	return account, true
+}
+
+// Reauthenticate returns current account of login previously authenticated as account a, so long-lived connections
+// follow accounts replacement: role, keys and limits changes apply to next command. Fails if account was removed or
+// its password or namespace was changed
+func (au *Authenticator) Reauthenticate(login string, a Account) (Account, bool) {
+	account, exists := au.Accounts()[login]
+	if !exists || account.Password != a.Password || account.Namespace != a.Namespace {
+		return Account{}, false
+	}
+	return account, true
+}
+
+// AuthenticateToken returns account and token of token secret. Tokens of removed accounts are rejected
+func (au *Authenticator) AuthenticateToken(secret string) (Account, Token, error) {
+	t, err := au.tokens.Verify(secret)
+	if err != nil {
+		return Account{}, Token{}, err
+	}
+	return au.tokenAccount(t)
+}
+
+// AuthenticateSigned returns account and token of request with method, uri and body signed by Authorization header
+// value of SignatureScheme. Tokens of removed accounts are rejected
+func (au *Authenticator) AuthenticateSigned(authorization string, method string, uri string, body []byte) (Account,
+	Token, error) {
+	t, err := au.tokens.VerifySigned(authorization, method, uri, body)
+	if err != nil {
+		return Account{}, Token{}, err
+	}
+	return au.tokenAccount(t)
+}
+
+// SignedAccount returns account of token of Authorization header value of SignatureScheme without verifying
+// signature, so request body can be limited by account before it's read for verification
+func (au *Authenticator) SignedAccount(authorization string) (Account, error) {
+	t, err := au.tokens.signed(authorization)
+	if err != nil {
+		return Account{}, err
+	}
+	account, _, err := au.tokenAccount(t)
+	return account, err
+}
+
+// AuthenticateCertificate returns account of verified client certificate cert, which is account of login equal to
+// certificate's subject common name
+func (au *Authenticator) AuthenticateCertificate(cert *x509.Certificate) (Account, string, bool) {
+	login := cert.Subject.CommonName
+	account, exists := au.Accounts()[login]
+	if login == "" || !exists {
+		return Account{}, "", false
+	}
+	return account, login, true
+}
+
+// tokenAccount returns account of token t
+func (au *Authenticator) tokenAccount(t Token) (Account, Token, error) {
+	account, exists := au.Accounts()[t.Login]
+	if !exists {
+		return Account{}, Token{}, ErrInvalidToken
+	}
+	return account, t, nil
+}
+
+// current returns current state
+func (au *Authenticator) current() *authState {
+	return au.state.Load().(*authState)
+}
+
+// Load reads and parses accounts file of path
+func Load(path string) (Accounts, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("failed to read accounts file: " + err.Error())
+	}
+	return Parse(data)
+}
+
+// WatchFile checks file of path every period and calls changed if its modification time or size changed, until ctx
+// is done. Not existing file is ignored, so file may be replaced by renaming
+func WatchFile(ctx context.Context, path string, period time.Duration, changed func()) {
+	stat := func() (time.Time, int64) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return fi.ModTime(), fi.Size()
+	}
+	modTime, size := stat()
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			mt, sz := stat()
+			if sz < 0 || mt.Equal(modTime) && sz == size {
+				continue
+			}
+			modTime, size = mt, sz
+			changed()
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Authenticator", func() {
+	Specify("authenticate", func() {
+		hash, err := HashPassword("pb", Bcrypt)
+		Expect(err).ToNot(HaveOccurred())
+		au := NewAuthenticator(Accounts{"a": {Password: "pa", Role: ReadOnly}, "b": {Password: hash, Role: Admin}})
+		_, ok := au.Authenticate("a", "pa")
+		Expect(ok).To(BeTrue())
+		_, ok = au.Authenticate("a", "pb")
+		Expect(ok).To(BeFalse())
+
+		By("hashed password")
+		for i := 0; i < 2; i++ {
+			account, ok := au.Authenticate("b", "pb")
+			Expect(ok).To(BeTrue())
+			Expect(account.Role).To(Equal(Admin))
+		}
+		Expect(au.current().verified).To(HaveKey("b"))
+		_, ok = au.Authenticate("b", "pa")
+		Expect(ok).To(BeFalse())
+		_, ok = au.Authenticate("c", "pb")
+		Expect(ok).To(BeFalse())
+	})
+	Specify("set", func() {
+		au := NewAuthenticator(Accounts{"a": {Password: "pa", Role: ReadOnly}})
+		au.Set(Accounts{"b": {Password: "pb", Role: ReadWrite}})
+		Expect(au.Accounts()).To(Equal(Accounts{"b": {Password: "pb", Role: ReadWrite}}))
+		_, ok := au.Authenticate("a", "pa")
+		Expect(ok).To(BeFalse())
+		_, ok = au.Authenticate("b", "pb")
+		Expect(ok).To(BeTrue())
+	})
+	Specify("reauthenticate", func() {
+		au := NewAuthenticator(Accounts{"a": {Password: "pa", Role: ReadWrite}})
+		account, ok := au.Authenticate("a", "pa")
+		Expect(ok).To(BeTrue())
+		au.Set(Accounts{"a": {Password: "pa", Role: ReadOnly}})
+		account, ok = au.Reauthenticate("a", account)
+		Expect(ok).To(BeTrue())
+		Expect(account.Role).To(Equal(ReadOnly))
+
+		By("changed password")
+		au.Set(Accounts{"a": {Password: "pb", Role: ReadOnly}})
+		_, ok = au.Reauthenticate("a", account)
+		Expect(ok).To(BeFalse())
+
+		By("changed namespace")
+		au.Set(Accounts{"a": {Password: "pa", Role: ReadOnly, Namespace: "n"}})
+		_, ok = au.Reauthenticate("a", account)
+		Expect(ok).To(BeFalse())
+
+		By("removed account")
+		au.Set(Accounts{})
+		_, ok = au.Reauthenticate("a", account)
+		Expect(ok).To(BeFalse())
+	})
+	Describe("file", func() {
+		var dir, path string
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "yamc-auth")
+			Expect(err).ToNot(HaveOccurred())
+			path = filepath.Join(dir, "accounts")
+			Expect(ioutil.WriteFile(path, []byte("a: pa\n"), 0600)).To(Succeed())
+		})
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+		Specify("load", func() {
+			Expect(Load(path)).To(Equal(Accounts{"a": {Password: "pa", Role: Admin}}))
+			_, err := Load(path + ".x")
+			Expect(err).To(HaveOccurred())
+		})
+		Specify("watch", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			changes := make(chan struct{}, 10)
+			go WatchFile(ctx, path, 10*time.Millisecond, func() {
+				changes <- struct{}{}
+			})
+			Consistently(changes, 50*time.Millisecond).ShouldNot(Receive())
+			Expect(ioutil.WriteFile(path, []byte("a: pab\n"), 0600)).To(Succeed())
+			Eventually(changes).Should(Receive())
+			Consistently(changes, 50*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+})
@@ -0,0 +1,83 @@
+package auth
+
+// Match reports whether s matches Redis glob-style pattern. Pattern supports "*", "?", character classes "[abc]",
+// "[^abc]", "[a-z]" and "\" escaping. Only last "*" is backtracked to, so matching takes at most len(pattern) * len(s)
+// steps whatever the pattern is
+func Match(pattern string, s string) bool {
+	p, i := 0, 0
+	star, next := -1, 0
+	for i < len(s) {
+		if p < len(pattern) && pattern[p] == '*' {
+			for p < len(pattern) && pattern[p] == '*' {
+				p++
+			}
+			if p == len(pattern) {
+				return true
+			}
+			star, next = p, i
+			continue
+		}
+		if p < len(pattern) {
+			if rest, matched := matchOne(pattern[p:], s[i]); matched {
+				p, i = len(pattern)-len(rest), i+1
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		next++
+		p, i = star, next
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchOne reports whether c matches single character pattern at the beginning of pattern. Returns pattern rest after
+// it
+func matchOne(pattern string, c byte) (string, bool) {
+	switch pattern[0] {
+	case '?':
+		return pattern[1:], true
+	case '[':
+		return matchClass(pattern[1:], c)
+	case '\\':
+		if len(pattern) > 1 {
+			pattern = pattern[1:]
+		}
+	}
+	return pattern[1:], pattern[0] == c
+}
+
+// matchClass reports whether c matches character class at the beginning of pattern, going after "[". Returns pattern
+// rest after class
+func matchClass(pattern string, c byte) (string, bool) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			matched = matched || pattern[1] == c
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			from, to := pattern[0], pattern[2]
+			if from > to {
+				from, to = to, from
+			}
+			matched = matched || from <= c && c <= to
+			pattern = pattern[3:]
+		default:
+			matched = matched || pattern[0] == c
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	return pattern, matched != negate
+}
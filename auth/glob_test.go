@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Match", func() {
+	Specify("matching patterns", func() {
+		for pattern, s := range map[string]string{
+			"":          "",
+			"*":         "",
+			"a*":        "abc",
+			"*c":        "abc",
+			"a**c":      "a/b/c",
+			"a?c":       "abc",
+			"[ab]c":     "bc",
+			"[^ab]c":    "cc",
+			"[a-c]d":    "bd",
+			"[c-a]d":    "bd",
+			`[\]]`:      "]",
+			`a\*`:       "a*",
+			"user:*:id": "user:1:id",
+			"[abc":      "b",
+			"*a*b*":     "xaybz",
+			`\`:         `\`,
+		} {
+			Expect(Match(pattern, s)).To(BeTrue(), pattern+" "+s)
+		}
+	})
+	Specify("not matching patterns", func() {
+		for pattern, s := range map[string]string{
+			"":          "a",
+			"a":         "",
+			"a*":        "ba",
+			"*c":        "cb",
+			"a?c":       "ac",
+			"[ab]c":     "cc",
+			"[^ab]c":    "ac",
+			"[a-c]d":    "dd",
+			`a\*`:       "ab",
+			"user:*:id": "user:1:name",
+			"?":         "",
+			"[a]":       "",
+			"*a*b":      "xaybz",
+		} {
+			Expect(Match(pattern, s)).To(BeFalse(), pattern+" "+s)
+		}
+	})
+	Specify("pathological pattern in bounded time", func() {
+		s := strings.Repeat("a", 10000)
+		start := time.Now()
+		Expect(Match(strings.Repeat("*a", 20)+"*b", s)).To(BeFalse())
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+})
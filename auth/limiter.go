@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter is a requests rate limiter with token bucket per login. It also counts rejected requests per login, so
+// requests rejected by any front end are counted together. Safe for concurrent use
+type Limiter struct {
+	mutex    sync.Mutex
+	buckets  map[string]*bucket
+	rejected map[string]uint64
+	now      func() time.Time
+}
+
+// bucket is a token bucket filled with requests rate up to burst size
+type bucket struct {
+	tokens float64
+	filled time.Time
+}
+
+// NewLimiter constructs limiter
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: map[string]*bucket{}, rejected: map[string]uint64{}, now: time.Now}
+}
+
+// Allow takes token from bucket of login filled according limits lm. Returns false and time to wait for next token if
+// bucket is empty. Requests rate is not limited if lm has no requests per second limit
+func (l *Limiter) Allow(login string, lm Limits) (bool, time.Duration) {
+	if lm.RequestsPerSecond == 0 {
+		return true, 0
+	}
+	burst := math.Max(float64(lm.Burst), 1)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	b, exists := l.buckets[login]
+	if !exists {
+		b = &bucket{tokens: burst, filled: now}
+		l.buckets[login] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.filled).Seconds()*lm.RequestsPerSecond)
+	b.filled = now
+	if b.tokens < 1 {
+		l.rejected[login]++
+		return false, time.Duration((1 - b.tokens) / lm.RequestsPerSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Reject counts rejected request of login
+func (l *Limiter) Reject(login string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.rejected[login]++
+}
+
+// Rejected returns rejected requests count of login
+func (l *Limiter) Rejected(login string) uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.rejected[login]
+}
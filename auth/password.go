@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algorithm is a password hashing algorithm
+type Algorithm string
+
+const (
+	// Bcrypt is a bcrypt algorithm, hashes are in $2a$ modular crypt format
+	Bcrypt Algorithm = "bcrypt"
+
+	// Argon2id is an argon2id algorithm, hashes are in $argon2id$ PHC string format
+	Argon2id Algorithm = "argon2id"
+)
+
+const (
+	// argon2 params of new hashes, as recommended by RFC 9106 for memory constrained environments
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// HashPassword returns hash of password by algorithm
+func HashPassword(password string, algorithm Algorithm) (string, error) {
+	switch algorithm {
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", errors.New("failed to hash password: " + err.Error())
+		}
+		return string(hash), nil
+	case Argon2id:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", errors.New("failed to generate salt: " + err.Error())
+		}
+		p := argon2Params{time: argon2Time, memory: argon2Memory, threads: argon2Threads, salt: salt}
+		p.key = argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, argon2KeyLen)
+		return p.String(), nil
+	}
+	return "", fmt.Errorf(`unknown algorithm "%s", must be one of: bcrypt, argon2id`, algorithm)
+}
+
+// hashed reports whether account password is hash
+func hashed(password string) bool {
+	return isBcrypt(password) || strings.HasPrefix(password, "$argon2id$")
+}
+
+// isBcrypt reports whether account password is bcrypt hash
+func isBcrypt(password string) bool {
+	return strings.HasPrefix(password, "$2a$") || strings.HasPrefix(password, "$2b$") ||
+		strings.HasPrefix(password, "$2y$")
+}
+
+// validatePassword validates account password hash, plain passwords are always valid
+func validatePassword(password string) error {
+	if isBcrypt(password) {
+		if _, err := bcrypt.Cost([]byte(password)); err != nil {
+			return errors.New("invalid bcrypt password hash: " + err.Error())
+		}
+	} else if strings.HasPrefix(password, "$argon2id$") {
+		if _, err := parseArgon2(password); err != nil {
+			return errors.New("invalid argon2id password hash: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// verifyPassword reports whether password matches account password, which is either hash or plain password
+func verifyPassword(accountPassword string, password string) bool {
+	if isBcrypt(accountPassword) {
+		return bcrypt.CompareHashAndPassword([]byte(accountPassword), []byte(password)) == nil
+	}
+	if strings.HasPrefix(accountPassword, "$argon2id$") {
+		p, err := parseArgon2(accountPassword)
+		if err != nil {
+			return false
+		}
+		key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+		return subtle.ConstantTimeCompare(key, p.key) == 1
+	}
+	return subtle.ConstantTimeCompare([]byte(accountPassword), []byte(password)) == 1
+}
+
+// argon2Params is a decoded argon2id hash
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// String encodes hash in PHC string format
+func (p argon2Params) String() string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(p.salt), base64.RawStdEncoding.EncodeToString(p.key))
+}
+
+// parseArgon2 decodes argon2id hash of PHC string format
+func parseArgon2(hash string) (argon2Params, error) {
+	var p argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return p, errors.New("expected $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, errors.New("failed to parse version: " + err.Error())
+	}
+	if version != argon2.Version {
+		return p, fmt.Errorf("unsupported version %d", version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, errors.New("failed to parse params: " + err.Error())
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, errors.New("failed to decode salt: " + err.Error())
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return p, errors.New("failed to decode key: " + err.Error())
+	}
+	if p.time == 0 || p.threads == 0 || len(p.key) == 0 {
+		return p, errors.New("time, threads and key can't be empty")
+	}
+	return p, nil
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Password", func() {
+	for _, algorithm := range []Algorithm{Bcrypt, Argon2id} {
+		algorithm := algorithm
+		Specify(string(algorithm)+" hash", func() {
+			hash, err := HashPassword("secret", algorithm)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hashed(hash)).To(BeTrue())
+			Expect(validatePassword(hash)).To(Succeed())
+			Expect(verifyPassword(hash, "secret")).To(BeTrue())
+			Expect(verifyPassword(hash, "wrong")).To(BeFalse())
+			other, err := HashPassword("secret", algorithm)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(other).ToNot(Equal(hash))
+		})
+	}
+	Specify("argon2id hash format", func() {
+		hash, err := HashPassword("secret", Argon2id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=4$")).To(BeTrue())
+	})
+	Specify("unknown algorithm error", func() {
+		_, err := HashPassword("secret", "md5")
+		Expect(err).To(MatchError(`unknown algorithm "md5", must be one of: bcrypt, argon2id`))
+	})
+	Specify("plain password", func() {
+		Expect(hashed("secret")).To(BeFalse())
+		Expect(validatePassword("secret")).To(Succeed())
+		Expect(verifyPassword("secret", "secret")).To(BeTrue())
+		Expect(verifyPassword("secret", "wrong")).To(BeFalse())
+	})
+	Specify("invalid hash error", func() {
+		Expect(validatePassword("$2a$10$short")).ToNot(Succeed())
+		Expect(validatePassword("$argon2id$v=19$m=65536")).ToNot(Succeed())
+		Expect(validatePassword("$argon2id$v=18$m=65536,t=3,p=4$c2FsdA$a2V5")).
+			To(MatchError("invalid argon2id password hash: unsupported version 18"))
+		Expect(verifyPassword("$argon2id$v=19$m=65536", "")).To(BeFalse())
+	})
+})
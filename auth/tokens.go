@@ -0,0 +1,383 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// SignatureScheme is an Authorization header scheme of HMAC signed requests
+	SignatureScheme = "YAMC-HMAC-SHA256"
+
+	// SignatureWindow is max difference between signed request timestamp and server time. Signatures are remembered
+	// within window, so signed request can't be replayed
+	SignatureWindow = 5 * time.Minute
+)
+
+var (
+	// token errors
+	ErrTokenNotExists   = errors.New("token not exists")
+	ErrInvalidToken     = errors.New("invalid token")
+	ErrTokenExpired     = errors.New("token expired")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrSignatureReused  = errors.New("signature reused")
+)
+
+// Scope is a token scope granting permission
+type Scope string
+
+const (
+	// ReadScope grants Read permission
+	ReadScope Scope = "read"
+
+	// WriteScope grants Write permission
+	WriteScope Scope = "write"
+
+	// AdminScope grants Administer and ReadMetrics permissions
+	AdminScope Scope = "admin"
+
+	// MetricsScope grants ReadMetrics permission
+	MetricsScope Scope = "metrics"
+)
+
+// Validate validates scope
+func (s Scope) Validate() error {
+	switch s {
+	case ReadScope, WriteScope, AdminScope, MetricsScope:
+		return nil
+	}
+	return fmt.Errorf(`unknown scope "%s", must be one of: read, write, admin, metrics`, s)
+}
+
+// allows reports whether scope grants permission p
+func (s Scope) allows(p Permission) bool {
+	switch s {
+	case ReadScope:
+		return p == Read
+	case WriteScope:
+		return p == Write
+	case AdminScope:
+		return p == Administer || p == ReadMetrics
+	case MetricsScope:
+		return p == ReadMetrics
+	}
+	return false
+}
+
+// Token is an API token of account. Token grants permissions of its scopes, which are also granted by account's role.
+// Zero expiry never expires
+type Token struct {
+	ID      string    `yaml:"id" json:"id" msgpack:"id"`
+	Login   string    `yaml:"login" json:"login" msgpack:"login"`
+	Scopes  []Scope   `yaml:"scopes" json:"scopes" msgpack:"scopes"`
+	Created time.Time `yaml:"created" json:"created" msgpack:"created"`
+	Expiry  time.Time `yaml:"expiry" json:"expiry" msgpack:"expiry"`
+}
+
+// Allows reports whether token's scopes grant permission p
+func (t Token) Allows(p Permission) bool {
+	for _, s := range t.Scopes {
+		if s.allows(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// expired reports whether token is expired at now
+func (t Token) expired(now time.Time) bool {
+	return !t.Expiry.IsZero() && !now.Before(t.Expiry)
+}
+
+// tokenRecord is a token with SHA-256 of its secret, which is also a key of token's request signatures
+type tokenRecord struct {
+	Token `yaml:",inline"`
+	Key   string `yaml:"key"`
+}
+
+// Tokens is an API tokens registry, saved to file on every change if file path is set. Only SHA-256 of token secrets
+// is kept. Safe for concurrent use
+type Tokens struct {
+	mutex   sync.Mutex
+	path    string
+	records map[string]tokenRecord
+	seen    map[string]time.Time
+	now     func() time.Time
+}
+
+// NewTokens constructs tokens registry saved to file of path, loading tokens from it if file exists. Path may be
+// empty, then tokens are kept in memory only
+func NewTokens(path string) (*Tokens, error) {
+	t := &Tokens{path: path, records: map[string]tokenRecord{}, seen: map[string]time.Time{}, now: time.Now}
+	if path == "" {
+		return t, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return t, nil
+	} else if err != nil {
+		return nil, errors.New("failed to read tokens file: " + err.Error())
+	}
+	var records []tokenRecord
+	if err := yaml.Unmarshal(data, &records); err != nil {
+		return nil, errors.New("failed to parse tokens file: " + err.Error())
+	}
+	for _, r := range records {
+		t.records[r.ID] = r
+	}
+	return t, nil
+}
+
+// Issue issues token of login with scopes expiring after ttl, never if ttl is zero. Returns token and its secret
+// "<id>.<secret>", which is shown only once
+func (t *Tokens) Issue(login string, scopes []Scope, ttl time.Duration) (Token, string, error) {
+	if len(scopes) == 0 {
+		return Token{}, "", errors.New("scopes required")
+	}
+	for _, s := range scopes {
+		if err := s.Validate(); err != nil {
+			return Token{}, "", err
+		}
+	}
+	id, err := random(8)
+	if err != nil {
+		return Token{}, "", err
+	}
+	secret, err := random(32)
+	if err != nil {
+		return Token{}, "", err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	token := Token{ID: id, Login: login, Scopes: scopes, Created: t.now().UTC()}
+	if ttl > 0 {
+		token.Expiry = token.Created.Add(ttl)
+	}
+	key := sha256.Sum256([]byte(secret))
+	t.records[id] = tokenRecord{Token: token, Key: hex.EncodeToString(key[:])}
+	if err := t.save(); err != nil {
+		delete(t.records, id)
+		return Token{}, "", err
+	}
+	return token, id + "." + secret, nil
+}
+
+// List returns not expired tokens sorted by creation time
+func (t *Tokens) List() []Token {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := t.now()
+	tokens := make([]Token, 0, len(t.records))
+	for _, r := range t.records {
+		if !r.expired(now) {
+			tokens = append(tokens, r.Token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Created.Before(tokens[j].Created)
+	})
+	return tokens
+}
+
+// Get returns not expired token by id
+func (t *Tokens) Get(id string) (Token, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	r, exists := t.records[id]
+	if !exists || r.expired(t.now()) {
+		return Token{}, ErrTokenNotExists
+	}
+	return r.Token, nil
+}
+
+// Revoke revokes token by id
+func (t *Tokens) Revoke(id string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	r, exists := t.records[id]
+	if !exists {
+		return ErrTokenNotExists
+	}
+	delete(t.records, id)
+	if err := t.save(); err != nil {
+		t.records[id] = r
+		return err
+	}
+	return nil
+}
+
+// Verify returns token of secret "<id>.<secret>"
+func (t *Tokens) Verify(secret string) (Token, error) {
+	i := strings.IndexByte(secret, '.')
+	if i < 0 {
+		return Token{}, ErrInvalidToken
+	}
+	key := sha256.Sum256([]byte(secret[i+1:]))
+	r, err := t.record(secret[:i])
+	if err != nil {
+		return Token{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(key[:])), []byte(r.Key)) != 1 {
+		return Token{}, ErrInvalidToken
+	}
+	return r.Token, nil
+}
+
+// VerifySigned returns token of request signed by Authorization header value of SignatureScheme. Signature must be
+// made within SignatureWindow and not used before
+func (t *Tokens) VerifySigned(authorization string, method string, uri string, body []byte) (Token, error) {
+	id, ts, signature, err := parseSignature(authorization)
+	if err != nil {
+		return Token{}, err
+	}
+	r, err := t.record(id)
+	if err != nil {
+		return Token{}, err
+	}
+	key, err := hex.DecodeString(r.Key)
+	if err != nil {
+		return Token{}, ErrInvalidToken
+	}
+	expected := Sign(key, method, uri, ts, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return Token{}, ErrInvalidSignature
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := t.now()
+	signed := time.Unix(ts, 0)
+	if signed.Before(now.Add(-SignatureWindow)) || signed.After(now.Add(SignatureWindow)) {
+		return Token{}, ErrInvalidSignature
+	}
+	for s, at := range t.seen {
+		if at.Before(now.Add(-SignatureWindow)) {
+			delete(t.seen, s)
+		}
+	}
+	if _, seen := t.seen[signature]; seen {
+		return Token{}, ErrSignatureReused
+	}
+	t.seen[signature] = signed
+	return r.Token, nil
+}
+
+// signed returns not expired token of Authorization header value of SignatureScheme without verifying signature
+func (t *Tokens) signed(authorization string) (Token, error) {
+	id, _, _, err := parseSignature(authorization)
+	if err != nil {
+		return Token{}, err
+	}
+	r, err := t.record(id)
+	if err != nil {
+		return Token{}, err
+	}
+	return r.Token, nil
+}
+
+// record returns not expired token record by id
+func (t *Tokens) record(id string) (tokenRecord, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	r, exists := t.records[id]
+	if !exists {
+		return tokenRecord{}, ErrInvalidToken
+	}
+	if r.expired(t.now()) {
+		return tokenRecord{}, ErrTokenExpired
+	}
+	return r, nil
+}
+
+// save writes not expired tokens to file, if path is set. Must be called under lock
+func (t *Tokens) save() error {
+	if t.path == "" {
+		return nil
+	}
+	now := t.now()
+	records := make([]tokenRecord, 0, len(t.records))
+	for _, r := range t.records {
+		if !r.expired(now) {
+			records = append(records, r)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Created.Before(records[j].Created)
+	})
+	data, err := yaml.Marshal(records)
+	if err != nil {
+		return errors.New("failed to marshal tokens: " + err.Error())
+	}
+	tmpPath := t.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return errors.New("failed to write tokens file: " + err.Error())
+	}
+	if err := os.Rename(tmpPath, t.path); err != nil {
+		os.Remove(tmpPath)
+		return errors.New("failed to rename tokens file: " + err.Error())
+	}
+	return nil
+}
+
+// Sign returns hex encoded HMAC-SHA256 signature of request with method, uri, unix timestamp ts and body by key,
+// which is SHA-256 of token secret
+func Sign(key []byte, method string, uri string, ts int64, body []byte) string {
+	bodySum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(method + "\n" + uri + "\n" + strconv.FormatInt(ts, 10) + "\n" + hex.EncodeToString(bodySum[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignatureAuthorization returns Authorization header value of request with method, uri and body signed at time ts
+// by token secret "<id>.<secret>"
+func SignatureAuthorization(secret string, method string, uri string, ts time.Time, body []byte) (string, error) {
+	i := strings.IndexByte(secret, '.')
+	if i < 0 {
+		return "", ErrInvalidToken
+	}
+	key := sha256.Sum256([]byte(secret[i+1:]))
+	return fmt.Sprintf("%s Credential=%s, Timestamp=%d, Signature=%s", SignatureScheme, secret[:i], ts.Unix(),
+		Sign(key[:], method, uri, ts.Unix(), body)), nil
+}
+
+// parseSignature parses Authorization header value of SignatureScheme into token id, timestamp and signature
+func parseSignature(authorization string) (string, int64, string, error) {
+	if !strings.HasPrefix(authorization, SignatureScheme+" ") {
+		return "", 0, "", ErrInvalidSignature
+	}
+	params := map[string]string{}
+	for _, param := range strings.Split(authorization[len(SignatureScheme)+1:], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			return "", 0, "", ErrInvalidSignature
+		}
+		params[kv[0]] = kv[1]
+	}
+	ts, err := strconv.ParseInt(params["Timestamp"], 10, 64)
+	if err != nil || params["Credential"] == "" || params["Signature"] == "" {
+		return "", 0, "", ErrInvalidSignature
+	}
+	return params["Credential"], ts, params["Signature"], nil
+}
+
+// random returns hex encoded n random bytes
+func random(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.New("failed to generate random: " + err.Error())
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tokens", func() {
+	var (
+		dir string
+		t   *Tokens
+		now time.Time
+	)
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "yamc-tokens")
+		Expect(err).ToNot(HaveOccurred())
+		t, err = NewTokens(filepath.Join(dir, "tokens"))
+		Expect(err).ToNot(HaveOccurred())
+		now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		t.now = func() time.Time {
+			return now
+		}
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+	Specify("issue and verify", func() {
+		token, secret, err := t.Issue("a", []Scope{ReadScope}, time.Hour)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(token).To(Equal(Token{ID: token.ID, Login: "a", Scopes: []Scope{ReadScope}, Created: now,
+			Expiry: now.Add(time.Hour)}))
+		Expect(token.Allows(Read)).To(BeTrue())
+		Expect(token.Allows(Write)).To(BeFalse())
+		Expect(t.Verify(secret)).To(Equal(token))
+		_, err = t.Verify(secret + "x")
+		Expect(err).To(MatchError(ErrInvalidToken))
+		_, err = t.Verify("x")
+		Expect(err).To(MatchError(ErrInvalidToken))
+
+		By("expired")
+		now = now.Add(time.Hour)
+		_, err = t.Verify(secret)
+		Expect(err).To(MatchError(ErrTokenExpired))
+		Expect(t.List()).To(BeEmpty())
+	})
+	Specify("invalid scopes error", func() {
+		_, _, err := t.Issue("a", nil, 0)
+		Expect(err).To(MatchError("scopes required"))
+		_, _, err = t.Issue("a", []Scope{"root"}, 0)
+		Expect(err).To(MatchError(`unknown scope "root", must be one of: read, write, admin, metrics`))
+	})
+	Specify("list, revoke and load", func() {
+		a, _, err := t.Issue("a", []Scope{ReadScope}, 0)
+		Expect(err).ToNot(HaveOccurred())
+		now = now.Add(time.Second)
+		b, secret, err := t.Issue("b", []Scope{WriteScope, AdminScope}, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(t.List()).To(Equal([]Token{a, b}))
+
+		loaded, err := NewTokens(filepath.Join(dir, "tokens"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded.List()).To(Equal([]Token{a, b}))
+		Expect(loaded.Verify(secret)).To(Equal(b))
+
+		Expect(t.Revoke(a.ID)).To(Succeed())
+		Expect(t.Revoke(a.ID)).To(MatchError(ErrTokenNotExists))
+		_, err = t.Get(a.ID)
+		Expect(err).To(MatchError(ErrTokenNotExists))
+		Expect(t.Get(b.ID)).To(Equal(b))
+		loaded, err = NewTokens(filepath.Join(dir, "tokens"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(loaded.List()).To(Equal([]Token{b}))
+	})
+	Specify("signed request", func() {
+		token, secret, err := t.Issue("a", []Scope{ReadScope}, 0)
+		Expect(err).ToNot(HaveOccurred())
+		authorization, err := SignatureAuthorization(secret, "PUT", "/key?key=a", now, []byte("v"))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = t.VerifySigned(authorization, "PUT", "/key?key=b", []byte("v"))
+		Expect(err).To(MatchError(ErrInvalidSignature))
+		_, err = t.VerifySigned(authorization, "PUT", "/key?key=a", []byte("w"))
+		Expect(err).To(MatchError(ErrInvalidSignature))
+		Expect(t.VerifySigned(authorization, "PUT", "/key?key=a", []byte("v"))).To(Equal(token))
+
+		By("replayed")
+		_, err = t.VerifySigned(authorization, "PUT", "/key?key=a", []byte("v"))
+		Expect(err).To(MatchError(ErrSignatureReused))
+
+		By("out of window")
+		authorization, err = SignatureAuthorization(secret, "GET", "/key?key=a", now.Add(-SignatureWindow-time.Second),
+			nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = t.VerifySigned(authorization, "GET", "/key?key=a", nil)
+		Expect(err).To(MatchError(ErrInvalidSignature))
+
+		By("malformed")
+		_, err = t.VerifySigned(SignatureScheme+" Credential="+token.ID, "GET", "/", nil)
+		Expect(err).To(MatchError(ErrInvalidSignature))
+	})
+})
@@ -2,14 +2,20 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	gourl "net/url"
+	"strconv"
 	"time"
 
-	"gopkg.in/yaml.v2"
+	"github.com/someanon/yamc/auth"
+	"github.com/someanon/yamc/codec"
 )
 
 const (
@@ -18,38 +24,136 @@ const (
 	ttlKey   = "ttl"
 	dkeyKey  = "dkey"
 	indexKey = "index"
+	startKey = "start"
+	stopKey  = "stop"
+	fieldKey = "field"
+	idKey    = "id"
+	seqKey   = "seq"
+	slotsKey = "slots"
+	nodeKey  = "node"
+	dbKey    = "db"
+	loginKey = "login"
+	scopeKey = "scope"
 
 	// api paths
 	keyPath  = "/key"
 	listPath = "/list"
 	dictPath = "/dict"
 	keysPath = "/keys"
+
+	importPath              = "/admin/import"
+	replicationSnapshotPath = "/admin/replication/snapshot"
+	replicationStreamPath   = "/admin/replication/stream"
+	slotsPath               = "/cluster/slots"
+	tokensPath              = "/admin/tokens"
+	updateSlotsPath         = "/admin/cluster/slots"
+	migratePath             = "/admin/cluster/migrate"
+
+	// maxRedirects is max count of cluster redirects followed by single request
+	maxRedirects = 5
+
+	// maxErrorBodySize is max size of error response body read
+	maxErrorBodySize = 64 * 1024
 )
 
 var (
-	// server side errors
+	// server response status errors, matched by errors.Is against Error
 	ErrNotFound              = errors.New("key not found")
 	ErrUnauthorized          = errors.New("unauthorized")
+	ErrForbidden             = errors.New("forbidden")
+	ErrConflict              = errors.New("conflict")
+	ErrNotAcceptable         = errors.New("not acceptable")
+	ErrUnsupportedMediaType  = errors.New("unsupported media type")
 	ErrInvalidParams         = errors.New("invalid params")
+	ErrTooManyRequests       = errors.New("too many requests")
+	ErrInsufficientStorage   = errors.New("insufficient storage")
 	ErrInternalServerError   = errors.New("internal server error")
 	ErrUnknownResponseStatus = errors.New("unknown response status")
 	ErrInvalidServerResponse = errors.New("invalid server response")
+
+	// replication errors
+	ErrReplicationLogTruncated = errors.New("replication log truncated")
+
+	// cluster errors
+	ErrTooManyRedirects = errors.New("too many redirects")
 )
 
-// Client is a memory cache server client
+// Client is a memory cache server client. If server is a cluster node, key requests follow redirects to owning nodes,
+// and cluster slot map is cached to send next requests to owning nodes directly. Structured bodies are encoded with
+// YAML unless other codec is chosen
 type Client struct {
-	method   string
-	url      *gourl.URL
-	login    string
-	password string
-	query    gourl.Values
+	method      string
+	url         *gourl.URL
+	login       string
+	password    string
+	token       string
+	signed      bool
+	tls         *tls.Config
+	transport   http.RoundTripper
+	query       gourl.Values
+	slots       *slotCache
+	codec       codec.Codec
+	contentType string
+}
+
+// Option is a client option
+type Option func(c *Client)
+
+// DB sets namespace of all requests, account's namespace is used if not set
+func DB(db string) Option {
+	return func(c *Client) {
+		c.query.Set(dbKey, db)
+	}
+}
+
+// Token authenticates requests by API token "<id>.<secret>" sent as Bearer token instead of login and password
+func Token(token string) Option {
+	return func(c *Client) {
+		c.token = token
+		c.signed = false
+	}
 }
 
-// NewClient constructs memory cache server client
-func NewClient(url string, login string, password string) (Client, error) {
+// SignedToken authenticates requests by HMAC signature made with API token "<id>.<secret>" instead of login and
+// password, so token secret is not sent and signed request can't be replayed
+func SignedToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+		c.signed = true
+	}
+}
+
+// RootCAs verifies HTTPS server certificates by CAs of pool instead of system CAs
+func RootCAs(pool *x509.CertPool) Option {
+	return func(c *Client) {
+		c.tlsConfig().RootCAs = pool
+	}
+}
+
+// Certificate presents client certificate cert to HTTPS server, which authenticates requests without login and
+// password by account of certificate's subject common name
+func Certificate(cert tls.Certificate) Option {
+	return func(c *Client) {
+		c.tlsConfig().Certificates = []tls.Certificate{cert}
+	}
+}
+
+// tlsConfig returns client's TLS config, creating it if not set
+func (c *Client) tlsConfig() *tls.Config {
+	if c.tls == nil {
+		c.tls = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return c.tls
+}
+
+// NewClient constructs memory cache server client with options. Login and password are not used if client is
+// authenticated by token or if both are empty and client certificate is set
+func NewClient(url string, login string, password string, options ...Option) (Client, error) {
 	c := Client{
 		login:    login,
 		password: password,
+		slots:    &slotCache{},
+		codec:    codec.YAML,
 	}
 	var err error
 	if c.url, err = gourl.Parse(url); err != nil {
@@ -58,56 +162,184 @@ func NewClient(url string, login string, password string) (Client, error) {
 	if c.query, err = gourl.ParseQuery(c.url.RawQuery); err != nil {
 		return c, errors.New("failed to parse query params: " + err.Error())
 	}
+	for _, option := range options {
+		option(&c)
+	}
+	if c.tls != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = c.tls
+		c.transport = transport
+	}
 	return c, nil
 }
 
+// WithCodec returns Client copy encoding and decoding structured bodies with codec cd. Errors if codec is unknown
+func (c Client) WithCodec(cd codec.Codec) (Client, error) {
+	if err := cd.Validate(); err != nil {
+		return c, err
+	}
+	c.codec = cd
+	return c, nil
+}
+
+// prepare returns Client copy with own url and query params set up for request with method to path, so Client can
+// be used concurrently and params of previous requests are not leaked
+func (c Client) prepare(method string, path string) Client {
+	url := *c.url
+	c.url = &url
+	c.url.Path = path
+	query := make(gourl.Values, len(c.query))
+	for k, v := range c.query {
+		query[k] = append([]string(nil), v...)
+	}
+	c.query = query
+	c.method = method
+	return c
+}
+
+// prepareKey returns Client copy set up for request with method to path with key, pointing to node owning key
+// according to cached slot map
+func (c Client) prepareKey(method string, path string, key string) Client {
+	c = c.prepare(method, path)
+	c.query.Set(keyKey, key)
+	if c.slots == nil {
+		return c
+	}
+	if node, err := gourl.Parse(c.slots.owner(key)); err == nil && node.Host != "" {
+		c.url.Scheme, c.url.Host = node.Scheme, node.Host
+	}
+	return c
+}
+
 // doReq performs request according Client data and given body
 func (c Client) doReq(body []byte) (string, error) {
-	req, err := http.NewRequest(c.method, c.url.String(), bytes.NewReader(body))
+	res, err := c.do(context.Background(), body)
+	if err != nil {
+		return "", err
+	}
+	resBody, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return "", errors.New("failed to create http doReq: " + err.Error())
+		return "", errors.New("failed to read response body: " + err.Error())
+	}
+	if err := res.Body.Close(); err != nil {
+		return "", errors.New("failed to close response body: " + err.Error())
 	}
-	req.SetBasicAuth(c.login, c.password)
-	res, err := (&http.Client{}).Do(req)
+	return string(resBody), nil
+}
+
+// doCodecReq performs request according Client data with v encoded by Client's codec as body
+func (c Client) doCodecReq(v interface{}) (string, error) {
+	body, err := c.codec.Marshal(v)
 	if err != nil {
-		return "", errors.New("failed to do http doReq: " + err.Error())
+		return "", errors.New("failed to marshal body: " + err.Error())
 	}
-	switch res.StatusCode {
-	case http.StatusOK:
-		body, err := ioutil.ReadAll(res.Body)
+	c.contentType = c.codec.ContentType()
+	return c.doReq(body)
+}
+
+// doStreamReq performs request according Client data without body. Returns response body stream, which must be
+// closed. Stream is broken when ctx is done
+func (c Client) doStreamReq(ctx context.Context) (io.ReadCloser, error) {
+	res, err := c.do(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// do performs request according Client data and given body. Follows cluster redirects refreshing cached slot map.
+// Returns response if status is OK, otherwise Error
+func (c Client) do(ctx context.Context, body []byte) (*http.Response, error) {
+	httpClient := &http.Client{
+		Transport: c.transport,
+		// redirects are followed manually to keep authorization and refresh slot map
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for redirects := 0; ; redirects++ {
+		req, err := http.NewRequestWithContext(ctx, c.method, c.url.String(), bytes.NewReader(body))
 		if err != nil {
-			return "", errors.New("failed to read response body: " + err.Error())
+			return nil, errors.New("failed to create http doReq: " + err.Error())
 		}
-		if res.Body.Close(); err != nil {
-			return "", errors.New("failed to close response body: " + err.Error())
+		if err := c.authorize(req, body); err != nil {
+			return nil, err
+		}
+		if c.contentType != "" {
+			req.Header.Set("Content-Type", c.contentType)
+		}
+		if accept := c.codec.ContentType(); accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res, err := httpClient.Do(req)
+		if err != nil {
+			return nil, errors.New("failed to do http doReq: " + err.Error())
+		}
+		if res.StatusCode == http.StatusOK {
+			return res, nil
+		}
+		if res.StatusCode != http.StatusTemporaryRedirect {
+			body, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+			res.Body.Close()
+			return nil, newError(res.StatusCode, body)
+		}
+		res.Body.Close()
+		if redirects == maxRedirects {
+			return nil, ErrTooManyRedirects
+		}
+		location, err := res.Location()
+		if err != nil {
+			return nil, ErrInvalidServerResponse
+		}
+		c.url = location
+		c.refreshSlots()
+	}
+}
+
+// authorize sets authorization header of request req with body
+func (c Client) authorize(req *http.Request, body []byte) error {
+	switch {
+	case c.token == "" && c.login == "" && c.password == "" && c.tls != nil && len(c.tls.Certificates) > 0:
+		// authenticated by client certificate
+	case c.token == "":
+		req.SetBasicAuth(c.login, c.password)
+	case c.signed:
+		authorization, err := auth.SignatureAuthorization(c.token, req.Method, req.URL.RequestURI(), time.Now(), body)
+		if err != nil {
+			return errors.New("failed to sign request: " + err.Error())
 		}
-		return string(body), nil
-	case http.StatusNotFound:
-		return "", ErrNotFound
-	case http.StatusUnauthorized:
-		return "", ErrUnauthorized
-	case http.StatusBadRequest:
-		return "", ErrInvalidParams
-	case http.StatusInternalServerError:
-		return "", ErrInternalServerError
+		req.Header.Set("Authorization", authorization)
+	default:
+		req.Header.Set("Authorization", "Bearer "+c.token)
 	}
-	return "", ErrUnknownResponseStatus
+	return nil
 }
 
 // Get gets value by key
 func (c Client) Get(key string) (string, error) {
-	c.method = http.MethodGet
-	c.url.Path = keyPath
-	c.query.Set(keyKey, key)
+	c = c.prepareKey(http.MethodGet, keyPath, key)
 	c.url.RawQuery = c.query.Encode()
 	return c.doReq(nil)
 }
 
+// GetMulti gets values by keys. Not found keys and keys of other types are omitted from result
+func (c Client) GetMulti(keys []string) (map[string]string, error) {
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		value, err := c.Get(key)
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrWrongType) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
 // Set sets key to value with time to live ttl
 func (c Client) Set(key string, value string, ttl time.Duration) error {
-	c.method = http.MethodPut
-	c.url.Path = keyPath
-	c.query.Set(keyKey, key)
+	c = c.prepareKey(http.MethodPut, keyPath, key)
 	c.query.Set(ttlKey, ttl.String())
 	c.url.RawQuery = c.query.Encode()
 	_, err := c.doReq([]byte(value))
@@ -116,59 +348,103 @@ func (c Client) Set(key string, value string, ttl time.Duration) error {
 
 // ListGet gets value by key and index
 func (c Client) ListGet(key string, index uint) (string, error) {
-	c.method = http.MethodGet
-	c.url.Path = listPath
-	c.query.Set(keyKey, key)
+	c = c.prepareKey(http.MethodGet, listPath, key)
 	c.query.Set(indexKey, fmt.Sprintf("%d", index))
 	c.url.RawQuery = c.query.Encode()
 	return c.doReq(nil)
 }
 
+// ListGetAll gets whole list by key
+func (c Client) ListGetAll(key string) ([]string, error) {
+	return c.ListGetRange(key, 0, -1)
+}
+
+// ListGetRange gets list elements by key from start to stop inclusive. Negative indexes are counted from the end of
+// list
+func (c Client) ListGetRange(key string, start int, stop int) ([]string, error) {
+	c = c.prepareKey(http.MethodGet, listPath, key)
+	c.query.Set(startKey, strconv.Itoa(start))
+	c.query.Set(stopKey, strconv.Itoa(stop))
+	c.url.RawQuery = c.query.Encode()
+	body, err := c.doReq(nil)
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	if err := c.codec.Unmarshal([]byte(body), &list); err != nil {
+		return nil, ErrInvalidServerResponse
+	}
+	return list, nil
+}
+
 // ListSet sets string list to the key
 func (c Client) ListSet(key string, list []string, ttl time.Duration) error {
-	c.method = http.MethodPut
-	c.url.Path = listPath
-	c.query.Set(keyKey, key)
+	c = c.prepareKey(http.MethodPut, listPath, key)
 	c.query.Set(ttlKey, ttl.String())
 	c.url.RawQuery = c.query.Encode()
-	listYAML, err := yaml.Marshal(list)
-	if err != nil {
-		return errors.New("failed to marshal list: " + err.Error())
-	}
-	_, err = c.doReq(listYAML)
+	_, err := c.doCodecReq(list)
 	return err
 }
 
 // DictGet returns value by key and dkey
 func (c Client) DictGet(key string, dkey string) (string, error) {
-	c.method = http.MethodGet
-	c.url.Path = dictPath
-	c.query.Set(keyKey, key)
+	c = c.prepareKey(http.MethodGet, dictPath, key)
 	c.query.Set(dkeyKey, dkey)
 	c.url.RawQuery = c.query.Encode()
 	return c.doReq(nil)
 }
 
+// DictGetAll gets whole dict by key
+func (c Client) DictGetAll(key string) (map[string]string, error) {
+	return c.DictGetFields(key)
+}
+
+// DictGetFields gets dict by key with given fields only, all fields if none given. Missing fields are omitted from
+// result
+func (c Client) DictGetFields(key string, fields ...string) (map[string]string, error) {
+	c = c.prepareKey(http.MethodGet, dictPath, key)
+	for _, field := range fields {
+		c.query.Add(fieldKey, field)
+	}
+	c.url.RawQuery = c.query.Encode()
+	body, err := c.doReq(nil)
+	if err != nil {
+		return nil, err
+	}
+	var dict map[string]string
+	if err := c.codec.Unmarshal([]byte(body), &dict); err != nil {
+		return nil, ErrInvalidServerResponse
+	}
+	return dict, nil
+}
+
 // DictSet sets string dict to the key
 func (c Client) DictSet(key string, dict map[string]string, ttl time.Duration) error {
-	c.method = http.MethodPut
-	c.url.Path = dictPath
-	c.query.Set(keyKey, key)
+	c = c.prepareKey(http.MethodPut, dictPath, key)
 	c.query.Set(ttlKey, ttl.String())
 	c.url.RawQuery = c.query.Encode()
-	dictYAML, err := yaml.Marshal(dict)
-	if err != nil {
-		return errors.New("failed to marshal dict: " + err.Error())
-	}
-	_, err = c.doReq(dictYAML)
+	_, err := c.doCodecReq(dict)
 	return err
 }
 
-// Remove removes value by key
+// Remove removes value by key. Errors with ErrWrongType if key is not a simple key
 func (c Client) Remove(key string) error {
-	c.method = http.MethodDelete
-	c.url.Path = keyPath
-	c.query.Set(keyKey, key)
+	return c.remove(keyPath, key)
+}
+
+// ListRemove removes list by key. Errors with ErrWrongType if key is not a list
+func (c Client) ListRemove(key string) error {
+	return c.remove(listPath, key)
+}
+
+// DictRemove removes dict by key. Errors with ErrWrongType if key is not a dict
+func (c Client) DictRemove(key string) error {
+	return c.remove(dictPath, key)
+}
+
+// remove removes item of path's type by key
+func (c Client) remove(path string, key string) error {
+	c = c.prepareKey(http.MethodDelete, path, key)
 	c.url.RawQuery = c.query.Encode()
 	_, err := c.doReq(nil)
 	return err
@@ -176,16 +452,53 @@ func (c Client) Remove(key string) error {
 
 // Keys returns all keys list
 func (c Client) Keys() ([]string, error) {
-	c.method = http.MethodGet
-	c.url.Path = keysPath
+	c = c.prepare(http.MethodGet, keysPath)
 	c.url.RawQuery = c.query.Encode()
 	body, err := c.doReq(nil)
 	if err != nil {
 		return nil, err
 	}
 	var keys []string
-	if err := yaml.Unmarshal([]byte(body), &keys); err != nil {
+	if err := c.codec.Unmarshal([]byte(body), &keys); err != nil {
 		return nil, ErrInvalidServerResponse
 	}
 	return keys, nil
 }
+
+// Import imports newline delimited JSON records read from r. Returns imported records count
+func (c Client) Import(r io.Reader) (int, error) {
+	c = c.prepare(http.MethodPost, importPath)
+	c.url.RawQuery = c.query.Encode()
+	records, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, errors.New("failed to read records: " + err.Error())
+	}
+	body, err := c.doReq(records)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(body)
+	if err != nil {
+		return 0, ErrInvalidServerResponse
+	}
+	return n, nil
+}
+
+// ReplicationSnapshot returns stream of replication snapshot: replication position followed by all items as newline
+// delimited JSON. Stream must be closed
+func (c Client) ReplicationSnapshot(ctx context.Context) (io.ReadCloser, error) {
+	c = c.prepare(http.MethodGet, replicationSnapshotPath)
+	c.url.RawQuery = c.query.Encode()
+	return c.doStreamReq(ctx)
+}
+
+// ReplicationStream returns endless stream of newline delimited JSON mutations following replication position with
+// history id and sequence number seq. Stream must be closed. Errors with ErrReplicationLogTruncated if position is
+// lost on server and replica must be restored from snapshot
+func (c Client) ReplicationStream(ctx context.Context, id string, seq uint64) (io.ReadCloser, error) {
+	c = c.prepare(http.MethodGet, replicationStreamPath)
+	c.query.Set(idKey, id)
+	c.query.Set(seqKey, fmt.Sprintf("%d", seq))
+	c.url.RawQuery = c.query.Encode()
+	return c.doStreamReq(ctx)
+}
@@ -1,13 +1,17 @@
 package client_test
 
 import (
+	"io/ioutil"
 	"testing"
 
+	"github.com/gin-gonic/gin"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
 func TestClient(t *testing.T) {
+	gin.SetMode("test")
+	gin.DefaultWriter = ioutil.Discard
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Client Suite")
 }
@@ -1,6 +1,9 @@
 package client_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -11,6 +14,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/someanon/yamc/client"
+	"github.com/someanon/yamc/codec"
 )
 
 var _ = Describe("Client", func() {
@@ -156,6 +160,35 @@ var _ = Describe("Client", func() {
 			s.expNoReq()
 		})
 	})
+	Describe("ListGetAll", func() {
+		Specify("not found error", func() {
+			s.status = http.StatusNotFound
+			_, err := c.ListGetAll("a")
+			Expect(err).To(MatchError(ErrNotFound))
+			s.expReq(http.MethodGet, "/list", "tlogin", "tpassword", []string{"key=a", "start=0", "stop=-1"}, "")
+			s.expNoReq()
+		})
+		Specify("response parse error", func() {
+			s.status = http.StatusOK
+			s.body = "a: b"
+			_, err := c.ListGetAll("a")
+			Expect(err).To(MatchError(ErrInvalidServerResponse))
+		})
+		Specify("succeed", func() {
+			s.status = http.StatusOK
+			s.body = "- a\n- b\n"
+			Expect(c.ListGetAll("a")).To(Equal([]string{"a", "b"}))
+			s.expReq(http.MethodGet, "/list", "tlogin", "tpassword", []string{"key=a", "start=0", "stop=-1"}, "")
+			s.expNoReq()
+		})
+		Specify("range", func() {
+			s.status = http.StatusOK
+			s.body = "- b\n"
+			Expect(c.ListGetRange("a", 1, -2)).To(Equal([]string{"b"}))
+			s.expReq(http.MethodGet, "/list", "tlogin", "tpassword", []string{"key=a", "start=1", "stop=-2"}, "")
+			s.expNoReq()
+		})
+	})
 	Describe("ListSet", func() {
 		Specify("unknown response status", func() {
 			s.status = http.StatusCreated
@@ -240,6 +273,35 @@ var _ = Describe("Client", func() {
 			s.expNoReq()
 		})
 	})
+	Describe("DictGetAll", func() {
+		Specify("not found error", func() {
+			s.status = http.StatusNotFound
+			_, err := c.DictGetAll("a")
+			Expect(err).To(MatchError(ErrNotFound))
+			s.expReq(http.MethodGet, "/dict", "tlogin", "tpassword", []string{"key=a"}, "")
+			s.expNoReq()
+		})
+		Specify("response parse error", func() {
+			s.status = http.StatusOK
+			s.body = "- a"
+			_, err := c.DictGetAll("a")
+			Expect(err).To(MatchError(ErrInvalidServerResponse))
+		})
+		Specify("succeed", func() {
+			s.status = http.StatusOK
+			s.body = "a: b\n"
+			Expect(c.DictGetAll("a")).To(Equal(map[string]string{"a": "b"}))
+			s.expReq(http.MethodGet, "/dict", "tlogin", "tpassword", []string{"key=a"}, "")
+			s.expNoReq()
+		})
+		Specify("fields", func() {
+			s.status = http.StatusOK
+			s.body = "a: b\n"
+			Expect(c.DictGetFields("a", "a", "c")).To(Equal(map[string]string{"a": "b"}))
+			s.expReq(http.MethodGet, "/dict", "tlogin", "tpassword", []string{"key=a", "field=a", "field=c"}, "")
+			s.expNoReq()
+		})
+	})
 	Describe("DictSet", func() {
 		Specify("unknown response status", func() {
 			s.status = http.StatusCreated
@@ -303,6 +365,25 @@ var _ = Describe("Client", func() {
 			s.expReq(http.MethodDelete, "/key", "tlogin", "tpassword", []string{"key=a"}, "")
 			s.expNoReq()
 		})
+		Specify("wrong type error", func() {
+			s.status = http.StatusConflict
+			s.body = `{"code":10,"error":"not key item"}`
+			Expect(c.Remove("a")).To(MatchError(ErrWrongType))
+			s.expReq(http.MethodDelete, "/key", "tlogin", "tpassword", []string{"key=a"}, "")
+			s.expNoReq()
+		})
+		Specify("list", func() {
+			s.status = http.StatusOK
+			Expect(c.ListRemove("a")).To(Succeed())
+			s.expReq(http.MethodDelete, "/list", "tlogin", "tpassword", []string{"key=a"}, "")
+			s.expNoReq()
+		})
+		Specify("dict", func() {
+			s.status = http.StatusOK
+			Expect(c.DictRemove("a")).To(Succeed())
+			s.expReq(http.MethodDelete, "/dict", "tlogin", "tpassword", []string{"key=a"}, "")
+			s.expNoReq()
+		})
 		Specify("unauthorized error", func() {
 			s.status = http.StatusUnauthorized
 			Expect(c.Remove("a")).To(MatchError(ErrUnauthorized))
@@ -371,21 +452,220 @@ var _ = Describe("Client", func() {
 			s.expNoReq()
 		})
 	})
+	Describe("Error", func() {
+		Specify("status error without JSON body", func() {
+			s.status = http.StatusNotFound
+			_, err := c.Get("a")
+			Expect(err).To(MatchError(ErrNotFound))
+			Expect(err).ToNot(MatchError(ErrKeyNotExists))
+			Expect(err.Error()).To(Equal("key not found"))
+		})
+		Specify("missing key", func() {
+			s.status = http.StatusNotFound
+			s.body = `{"code":20,"error":"key not exists"}`
+			_, err := c.ListGet("a", 1)
+			Expect(err).To(MatchError(ErrNotFound))
+			Expect(err).To(MatchError(ErrKeyNotExists))
+			Expect(err).ToNot(MatchError(ErrNotListItem))
+			Expect(err.Error()).To(Equal("key not exists"))
+		})
+		Specify("wrong type", func() {
+			s.status = http.StatusConflict
+			s.body = `{"code":11,"error":"not list item"}`
+			_, err := c.ListGet("a", 1)
+			Expect(err).To(MatchError(ErrConflict))
+			Expect(err).To(MatchError(ErrWrongType))
+			Expect(err).To(MatchError(ErrNotListItem))
+			Expect(err).ToNot(MatchError(ErrNotFound))
+			Expect(err).ToNot(MatchError(ErrKeyNotExists))
+		})
+		Specify("limits", func() {
+			s.status = http.StatusTooManyRequests
+			s.body = `{"code":180,"error":"rate limit exceeded"}`
+			_, err := c.ListGet("a", 1)
+			Expect(err).To(MatchError(ErrTooManyRequests))
+			s.status = http.StatusInsufficientStorage
+			s.body = `{"code":181,"error":"value too large","details":"limit is 3 bytes"}`
+			err = c.Set("a", "v", time.Second)
+			Expect(err).To(MatchError(ErrInsufficientStorage))
+			Expect(err.Error()).To(Equal("value too large: limit is 3 bytes"))
+		})
+		Specify("conditional mutations", func() {
+			s.status = http.StatusConflict
+			for code, target := range map[int]error{32: ErrKeyExists, 33: ErrVersionMismatch, 34: ErrNotNumber} {
+				s.body = fmt.Sprintf(`{"code":%d,"error":"error"}`, code)
+				err := c.Set("a", "v", time.Second)
+				Expect(err).To(MatchError(target))
+				Expect(err).To(MatchError(ErrConflict))
+				Expect(err).ToNot(MatchError(ErrWrongType))
+			}
+		})
+		Specify("index out of range", func() {
+			s.status = http.StatusNotFound
+			s.body = `{"code":21,"error":"list index not exists"}`
+			_, err := c.ListGet("a", 1)
+			Expect(err).To(MatchError(ErrListIndexNotExists))
+			Expect(err).ToNot(MatchError(ErrKeyNotExists))
+		})
+		Specify("details", func() {
+			s.status = http.StatusBadRequest
+			s.body = `{"code":110,"error":"invalid ttl","details":"time: invalid duration"}`
+			err := c.Set("a", "v", time.Second)
+			Expect(err).To(MatchError(ErrInvalidParams))
+			var e Error
+			Expect(errors.As(err, &e)).To(BeTrue())
+			Expect(e).To(Equal(Error{Status: http.StatusBadRequest, Code: 110, Err: "invalid ttl",
+				Details: "time: invalid duration"}))
+			Expect(err.Error()).To(Equal("invalid ttl: time: invalid duration"))
+		})
+	})
+	Describe("db", func() {
+		Specify("db is sent with every request", func() {
+			c, err := NewClient(ts.URL, "tlogin", "tpassword", DB("team"))
+			Expect(err).ToNot(HaveOccurred())
+			s.status = http.StatusOK
+			s.body = "v"
+			Expect(c.Get("a")).To(Equal("v"))
+			s.expReq(http.MethodGet, "/key", "tlogin", "tpassword", []string{"db=team", "key=a"}, "")
+			s.body = "- a\n"
+			Expect(c.Keys()).To(Equal([]string{"a"}))
+			s.expReq(http.MethodGet, "/keys", "tlogin", "tpassword", []string{"db=team"}, "")
+			s.expNoReq()
+		})
+	})
+	Describe("token", func() {
+		Specify("bearer token", func() {
+			c, err := NewClient(ts.URL, "tlogin", "tpassword", Token("id.secret"))
+			Expect(err).ToNot(HaveOccurred())
+			s.status = http.StatusOK
+			Expect(c.Remove("a")).To(Succeed())
+			s.expReq(http.MethodDelete, "/key", "", "", []string{"key=a"}, "")
+			Expect(s.authorizations).To(Equal([]string{"Bearer id.secret"}))
+		})
+		Specify("signed token", func() {
+			c, err := NewClient(ts.URL, "tlogin", "tpassword", SignedToken("id.secret"))
+			Expect(err).ToNot(HaveOccurred())
+			s.status = http.StatusOK
+			Expect(c.Set("a", "v", time.Second)).To(Succeed())
+			s.expReq(http.MethodPut, "/key", "", "", []string{"key=a", "ttl=1s"}, "v")
+			Expect(s.authorizations).To(HaveLen(1))
+			Expect(s.authorizations[0]).To(HavePrefix("YAMC-HMAC-SHA256 Credential=id, Timestamp="))
+		})
+		Specify("invalid signed token error", func() {
+			c, err := NewClient(ts.URL, "tlogin", "tpassword", SignedToken("secret"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.Remove("a")).To(MatchError("failed to sign request: invalid token"))
+			s.expNoReq()
+		})
+	})
+	Describe("WithCodec", func() {
+		Specify("unknown codec error", func() {
+			_, err := c.WithCodec("xml")
+			Expect(err).To(MatchError(`unknown codec "xml", must be one of: yaml, json, msgpack`))
+		})
+		Specify("YAML by default", func() {
+			s.status = http.StatusOK
+			Expect(c.DictSet("a", map[string]string{"b": "c"}, 10*time.Second)).ToNot(HaveOccurred())
+			Expect(s.requests[0].contentType).To(Equal("application/yaml"))
+			Expect(s.requests[0].accept).To(Equal("application/yaml"))
+			s.expReq(http.MethodPut, "/dict", "tlogin", "tpassword", []string{"key=a", "ttl=10s"}, "b: c\n")
+			s.expNoReq()
+		})
+		Specify("JSON request body", func() {
+			jc, err := c.WithCodec(codec.JSON)
+			Expect(err).ToNot(HaveOccurred())
+			s.status = http.StatusOK
+			Expect(jc.ListSet("a", []string{"yes", "1.0"}, 10*time.Second)).ToNot(HaveOccurred())
+			Expect(s.requests[0].contentType).To(Equal("application/json"))
+			Expect(s.requests[0].accept).To(Equal("application/json"))
+			s.expReq(http.MethodPut, "/list", "tlogin", "tpassword", []string{"key=a", "ttl=10s"}, `["yes","1.0"]`)
+			s.expNoReq()
+		})
+		Specify("MessagePack response body", func() {
+			mc, err := c.WithCodec(codec.MessagePack)
+			Expect(err).ToNot(HaveOccurred())
+			body, err := codec.MessagePack.Marshal([]string{"a", "b"})
+			Expect(err).ToNot(HaveOccurred())
+			s.status = http.StatusOK
+			s.body = string(body)
+			Expect(mc.Keys()).To(Equal([]string{"a", "b"}))
+			Expect(s.requests[0].accept).To(Equal("application/msgpack"))
+			s.expReq(http.MethodGet, "/keys", "tlogin", "tpassword", nil, "")
+			s.expNoReq()
+		})
+		Specify("not acceptable error", func() {
+			s.status = http.StatusNotAcceptable
+			_, err := c.Keys()
+			Expect(err).To(MatchError(ErrNotAcceptable))
+		})
+		Specify("unsupported media type error", func() {
+			s.status = http.StatusUnsupportedMediaType
+			Expect(c.ListSet("a", nil, time.Second)).To(MatchError(ErrUnsupportedMediaType))
+		})
+	})
+	Describe("ReplicationSnapshot", func() {
+		Specify("unauthorized error", func() {
+			s.status = http.StatusUnauthorized
+			_, err := c.ReplicationSnapshot(context.Background())
+			Expect(err).To(MatchError(ErrUnauthorized))
+			s.expReq(http.MethodGet, "/admin/replication/snapshot", "tlogin", "tpassword", nil, "")
+			s.expNoReq()
+		})
+		Specify("succeed", func() {
+			s.status = http.StatusOK
+			s.body = `{"id":"id","seq":1}` + "\n"
+			stream, err := c.ReplicationSnapshot(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			defer stream.Close()
+			Expect(ioutil.ReadAll(stream)).To(BeEquivalentTo(`{"id":"id","seq":1}` + "\n"))
+			s.expReq(http.MethodGet, "/admin/replication/snapshot", "tlogin", "tpassword", nil, "")
+			s.expNoReq()
+		})
+	})
+	Describe("ReplicationStream", func() {
+		Specify("truncated log error", func() {
+			s.status = http.StatusGone
+			_, err := c.ReplicationStream(context.Background(), "id", 1)
+			Expect(err).To(MatchError(ErrReplicationLogTruncated))
+			s.expReq(http.MethodGet, "/admin/replication/stream", "tlogin", "tpassword", []string{"id=id", "seq=1"}, "")
+			s.expNoReq()
+		})
+		Specify("forbidden error", func() {
+			s.status = http.StatusForbidden
+			_, err := c.ReplicationStream(context.Background(), "id", 1)
+			Expect(err).To(MatchError(ErrForbidden))
+			s.expReq(http.MethodGet, "/admin/replication/stream", "tlogin", "tpassword", []string{"id=id", "seq=1"}, "")
+			s.expNoReq()
+		})
+		Specify("succeed", func() {
+			s.status = http.StatusOK
+			s.body = `{"seq":2,"op":"flush"}` + "\n"
+			stream, err := c.ReplicationStream(context.Background(), "id", 1)
+			Expect(err).ToNot(HaveOccurred())
+			defer stream.Close()
+			Expect(ioutil.ReadAll(stream)).To(BeEquivalentTo(`{"seq":2,"op":"flush"}` + "\n"))
+			s.expReq(http.MethodGet, "/admin/replication/stream", "tlogin", "tpassword", []string{"id=id", "seq=1"}, "")
+			s.expNoReq()
+		})
+	})
 })
 
 type request struct {
-	method   string
-	path     string
-	login    string
-	password string
-	query    []string
-	body     string
+	method      string
+	path        string
+	login       string
+	password    string
+	query       []string
+	body        string
+	contentType string
+	accept      string
 }
 
 type testServer struct {
-	requests []request
-	status   int
-	body     string
+	requests       []request
+	authorizations []string
+	status         int
+	body           string
 }
 
 func (s *testServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
@@ -399,13 +679,16 @@ func (s *testServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		sort.Strings(q)
 	}
 	login, password, _ := req.BasicAuth()
+	s.authorizations = append(s.authorizations, req.Header.Get("Authorization"))
 	s.requests = append(s.requests, request{
-		method:   req.Method,
-		path:     req.URL.Path,
-		login:    login,
-		password: password,
-		query:    q,
-		body:     string(b),
+		method:      req.Method,
+		path:        req.URL.Path,
+		login:       login,
+		password:    password,
+		query:       q,
+		body:        string(b),
+		contentType: req.Header.Get("Content-Type"),
+		accept:      req.Header.Get("Accept"),
 	})
 	res.WriteHeader(s.status)
 	res.Write([]byte(s.body))
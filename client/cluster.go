@@ -0,0 +1,352 @@
+package client
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/someanon/yamc/codec"
+)
+
+var (
+	// cluster errors
+	ErrNoNodes           = errors.New("no cluster nodes")
+	ErrNodeAlreadyExists = errors.New("cluster node already exists")
+	ErrNodeNotExists     = errors.New("cluster node not exists")
+)
+
+// Cluster is a memory cache servers cluster client. Keys are distributed between nodes by consistent hashing, so
+// adding or removing node moves only about 1/n of keys. All nodes share same account and options
+type Cluster struct {
+	mutex    sync.RWMutex
+	login    string
+	password string
+	options  []Option
+	codec    codec.Codec
+	nodes    map[string]Client
+	ring     ring
+}
+
+// NewCluster constructs cluster client of nodes with given urls. Options are applied to clients of all nodes
+func NewCluster(urls []string, login string, password string, options ...Option) (*Cluster, error) {
+	c := &Cluster{
+		login:    login,
+		password: password,
+		options:  options,
+		codec:    codec.YAML,
+		nodes:    map[string]Client{},
+	}
+	for _, url := range urls {
+		if err := c.AddNode(url); err != nil {
+			return nil, errors.New("failed to add node " + url + ": " + err.Error())
+		}
+	}
+	return c, nil
+}
+
+// AddNode adds node with url. Keys owned by new node become unavailable until they are set again
+func (c *Cluster) AddNode(url string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, exists := c.nodes[url]; exists {
+		return ErrNodeAlreadyExists
+	}
+	client, err := NewClient(url, c.login, c.password, c.options...)
+	if err != nil {
+		return err
+	}
+	if client, err = client.WithCodec(c.codec); err != nil {
+		return err
+	}
+	c.nodes[url] = client
+	c.ring.add(url)
+	return nil
+}
+
+// RemoveNode removes node with url. Keys owned by node are moved to remaining nodes and become unavailable until they
+// are set again
+func (c *Cluster) RemoveNode(url string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, exists := c.nodes[url]; !exists {
+		return ErrNodeNotExists
+	}
+	delete(c.nodes, url)
+	c.ring.remove(url)
+	return nil
+}
+
+// WithCodec returns Cluster copy encoding and decoding structured bodies with codec cd. Nodes added to or removed from
+// copy are not added to or removed from Cluster. Errors if codec is unknown
+func (c *Cluster) WithCodec(cd codec.Codec) (*Cluster, error) {
+	if err := cd.Validate(); err != nil {
+		return nil, err
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	copied := &Cluster{
+		login:    c.login,
+		password: c.password,
+		options:  c.options,
+		codec:    cd,
+		nodes:    make(map[string]Client, len(c.nodes)),
+		ring:     c.ring,
+	}
+	for url, n := range c.nodes {
+		copied.nodes[url], _ = n.WithCodec(cd)
+	}
+	return copied, nil
+}
+
+// Nodes returns sorted nodes urls
+func (c *Cluster) Nodes() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	urls := make([]string, 0, len(c.nodes))
+	for url := range c.nodes {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// NodeFor returns url of node owning key
+func (c *Cluster) NodeFor(key string) (string, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	url := c.ring.get(key)
+	if url == "" {
+		return "", ErrNoNodes
+	}
+	return url, nil
+}
+
+// Get gets value by key
+func (c *Cluster) Get(key string) (string, error) {
+	n, err := c.node(key)
+	if err != nil {
+		return "", err
+	}
+	return n.Get(key)
+}
+
+// GetMulti gets values by keys from owning nodes concurrently. Not found keys are omitted from result
+func (c *Cluster) GetMulti(keys []string) (map[string]string, error) {
+	c.mutex.RLock()
+	if len(c.nodes) == 0 {
+		c.mutex.RUnlock()
+		return nil, ErrNoNodes
+	}
+	batches := map[string]*batch{}
+	for _, key := range keys {
+		url := c.ring.get(key)
+		if batches[url] == nil {
+			batches[url] = &batch{url: url, node: c.nodes[url]}
+		}
+		batches[url].keys = append(batches[url].keys, key)
+	}
+	c.mutex.RUnlock()
+	values := make(map[string]string, len(keys))
+	err := fanOut(batches, func(b *batch, mutex *sync.Mutex) error {
+		nodeValues, err := b.node.GetMulti(b.keys)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		for k, v := range nodeValues {
+			values[k] = v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Set sets key to value with time to live ttl
+func (c *Cluster) Set(key string, value string, ttl time.Duration) error {
+	n, err := c.node(key)
+	if err != nil {
+		return err
+	}
+	return n.Set(key, value, ttl)
+}
+
+// ListGet gets value by key and index
+func (c *Cluster) ListGet(key string, index uint) (string, error) {
+	n, err := c.node(key)
+	if err != nil {
+		return "", err
+	}
+	return n.ListGet(key, index)
+}
+
+// ListGetAll gets whole list by key
+func (c *Cluster) ListGetAll(key string) ([]string, error) {
+	n, err := c.node(key)
+	if err != nil {
+		return nil, err
+	}
+	return n.ListGetAll(key)
+}
+
+// ListGetRange gets list elements by key from start to stop inclusive. Negative indexes are counted from the end of
+// list
+func (c *Cluster) ListGetRange(key string, start int, stop int) ([]string, error) {
+	n, err := c.node(key)
+	if err != nil {
+		return nil, err
+	}
+	return n.ListGetRange(key, start, stop)
+}
+
+// ListSet sets string list to the key
+func (c *Cluster) ListSet(key string, list []string, ttl time.Duration) error {
+	n, err := c.node(key)
+	if err != nil {
+		return err
+	}
+	return n.ListSet(key, list, ttl)
+}
+
+// DictGet returns value by key and dkey
+func (c *Cluster) DictGet(key string, dkey string) (string, error) {
+	n, err := c.node(key)
+	if err != nil {
+		return "", err
+	}
+	return n.DictGet(key, dkey)
+}
+
+// DictGetAll gets whole dict by key
+func (c *Cluster) DictGetAll(key string) (map[string]string, error) {
+	n, err := c.node(key)
+	if err != nil {
+		return nil, err
+	}
+	return n.DictGetAll(key)
+}
+
+// DictGetFields gets dict by key with given fields only, all fields if none given. Missing fields are omitted from
+// result
+func (c *Cluster) DictGetFields(key string, fields ...string) (map[string]string, error) {
+	n, err := c.node(key)
+	if err != nil {
+		return nil, err
+	}
+	return n.DictGetFields(key, fields...)
+}
+
+// DictSet sets string dict to the key
+func (c *Cluster) DictSet(key string, dict map[string]string, ttl time.Duration) error {
+	n, err := c.node(key)
+	if err != nil {
+		return err
+	}
+	return n.DictSet(key, dict, ttl)
+}
+
+// Remove removes value by key
+func (c *Cluster) Remove(key string) error {
+	n, err := c.node(key)
+	if err != nil {
+		return err
+	}
+	return n.Remove(key)
+}
+
+// ListRemove removes list by key
+func (c *Cluster) ListRemove(key string) error {
+	n, err := c.node(key)
+	if err != nil {
+		return err
+	}
+	return n.ListRemove(key)
+}
+
+// DictRemove removes dict by key
+func (c *Cluster) DictRemove(key string) error {
+	n, err := c.node(key)
+	if err != nil {
+		return err
+	}
+	return n.DictRemove(key)
+}
+
+// Keys returns all nodes keys list. Nodes are requested concurrently. Keys stored on node, which doesn't own them
+// anymore after nodes change, are omitted
+func (c *Cluster) Keys() ([]string, error) {
+	c.mutex.RLock()
+	if len(c.nodes) == 0 {
+		c.mutex.RUnlock()
+		return nil, ErrNoNodes
+	}
+	batches := make(map[string]*batch, len(c.nodes))
+	for url, n := range c.nodes {
+		batches[url] = &batch{url: url, node: n}
+	}
+	// ring points are never modified in place, so ring copy can be read without lock
+	r := c.ring
+	c.mutex.RUnlock()
+	var keys []string
+	err := fanOut(batches, func(b *batch, mutex *sync.Mutex) error {
+		nodeKeys, err := b.node.Keys()
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, key := range nodeKeys {
+			if r.get(key) == b.url {
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// node returns client of node owning key
+func (c *Cluster) node(key string) (Client, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	url := c.ring.get(key)
+	if url == "" {
+		return Client{}, ErrNoNodes
+	}
+	return c.nodes[url], nil
+}
+
+// batch is a node request batch
+type batch struct {
+	url  string
+	node Client
+	keys []string
+}
+
+// fanOut calls f concurrently for each batch. Mutex is shared by all calls to guard results. Returns first error
+func fanOut(batches map[string]*batch, f func(b *batch, mutex *sync.Mutex) error) error {
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		errs  = make(chan error, len(batches))
+	)
+	for _, b := range batches {
+		wg.Add(1)
+		go func(b *batch) {
+			defer wg.Done()
+			if err := f(b, &mutex); err != nil {
+				errs <- err
+			}
+		}(b)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
@@ -0,0 +1,242 @@
+package client_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/someanon/yamc/auth"
+	. "github.com/someanon/yamc/client"
+	"github.com/someanon/yamc/codec"
+	"github.com/someanon/yamc/server"
+	"github.com/someanon/yamc/store"
+)
+
+var _ = Describe("Cluster", func() {
+	var (
+		dir   string
+		nodes []*testNode
+		urls  []string
+		c     *Cluster
+	)
+	newNode := func() *testNode {
+		d, err := store.NewFileDumper(filepath.Join(dir, fmt.Sprintf("dump%d", len(nodes))), store.GobFormat,
+			store.NoCompression)
+		Expect(err).ToNot(HaveOccurred())
+		s, err := store.NewStore(store.Params{CleaningPeriod: time.Second, DumpingPeriod: time.Minute},
+			store.SystemClock{}, d)
+		Expect(err).ToNot(HaveOccurred())
+		a := auth.NewAuthenticator(auth.Accounts{"test": {Password: "test", Role: auth.Admin}})
+		n := &testNode{store: s, server: httptest.NewServer(server.NewRouter(a, s))}
+		nodes = append(nodes, n)
+		return n
+	}
+	keysOf := func(count int) []string {
+		keys := make([]string, count)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("key%d", i)
+		}
+		return keys
+	}
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "yamc-cluster")
+		Expect(err).ToNot(HaveOccurred())
+		nodes, urls = nil, nil
+		for i := 0; i < 3; i++ {
+			urls = append(urls, newNode().server.URL)
+		}
+		c, err = NewCluster(urls, "test", "test")
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		for _, n := range nodes {
+			n.server.Close()
+			n.store.Close(false)
+		}
+		os.RemoveAll(dir)
+	})
+	Describe("NewCluster", func() {
+		Specify("duplicated node error", func() {
+			_, err := NewCluster([]string{urls[0], urls[0]}, "test", "test")
+			Expect(err).To(MatchError("failed to add node " + urls[0] + ": " + ErrNodeAlreadyExists.Error()))
+		})
+		Specify("invalid url error", func() {
+			_, err := NewCluster([]string{":"}, "test", "test")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Specify("no nodes error", func() {
+		c, err := NewCluster(nil, "test", "test")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = c.Get("a")
+		Expect(err).To(MatchError(ErrNoNodes))
+		Expect(c.Set("a", "v", time.Minute)).To(MatchError(ErrNoNodes))
+		_, err = c.Keys()
+		Expect(err).To(MatchError(ErrNoNodes))
+		_, err = c.GetMulti([]string{"a"})
+		Expect(err).To(MatchError(ErrNoNodes))
+	})
+	Specify("AddNode and RemoveNode errors", func() {
+		Expect(c.AddNode(urls[0])).To(MatchError(ErrNodeAlreadyExists))
+		Expect(c.RemoveNode("http://unknown")).To(MatchError(ErrNodeNotExists))
+		Expect(c.Nodes()).To(ConsistOf(urls))
+	})
+	Specify("keys are stored on owning nodes", func() {
+		Expect(c.Set("a", "v", time.Minute)).To(Succeed())
+		Expect(c.ListSet("b", []string{"l1", "l2"}, time.Minute)).To(Succeed())
+		Expect(c.DictSet("c", map[string]string{"dk": "dv"}, time.Minute)).To(Succeed())
+		Expect(c.Get("a")).To(Equal("v"))
+		Expect(c.ListGet("b", 1)).To(Equal("l2"))
+		Expect(c.DictGet("c", "dk")).To(Equal("dv"))
+		Expect(c.ListGetAll("b")).To(Equal([]string{"l1", "l2"}))
+		Expect(c.ListGetRange("b", -1, -1)).To(Equal([]string{"l2"}))
+		Expect(c.DictGetAll("c")).To(Equal(map[string]string{"dk": "dv"}))
+		Expect(c.DictGetFields("c", "dk", "absent")).To(Equal(map[string]string{"dk": "dv"}))
+		_, err := c.ListGet("b", 5)
+		Expect(err).To(MatchError(ErrListIndexNotExists))
+		_, err = c.ListGet("a", 0)
+		Expect(err).To(MatchError(ErrNotListItem))
+		for key, value := range map[string]string{"a": "v"} {
+			url, err := c.NodeFor(key)
+			Expect(err).ToNot(HaveOccurred())
+			for _, n := range nodes {
+				v, err := n.store.Get(key)
+				if n.server.URL == url {
+					Expect(v).To(Equal(value))
+				} else {
+					Expect(err).To(MatchError(store.ErrKeyNotExists))
+				}
+			}
+		}
+		Expect(c.Remove("a")).To(Succeed())
+		_, err = c.Get("a")
+		Expect(err).To(MatchError(ErrNotFound))
+		Expect(err).To(MatchError(ErrKeyNotExists))
+	})
+	Specify("WithCodec", func() {
+		_, err := c.WithCodec("xml")
+		Expect(err).To(HaveOccurred())
+		jc, err := c.WithCodec(codec.JSON)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(jc.Nodes()).To(Equal(c.Nodes()))
+		Expect(jc.ListSet("b", []string{"yes", "1.0"}, time.Minute)).To(Succeed())
+		Expect(jc.ListGetAll("b")).To(Equal([]string{"yes", "1.0"}))
+		Expect(c.ListGetAll("b")).To(Equal([]string{"yes", "1.0"}))
+
+		By("copy has own nodes")
+		Expect(jc.RemoveNode(urls[0])).To(Succeed())
+		Expect(c.Nodes()).To(ConsistOf(urls))
+	})
+	Specify("options are applied to nodes", func() {
+		d, err := store.NewFileDumper(filepath.Join(dir, "dump.team"), store.GobFormat, store.NoCompression)
+		Expect(err).ToNot(HaveOccurred())
+		ns, err := store.NewStore(store.Params{CleaningPeriod: time.Second, DumpingPeriod: time.Minute},
+			store.SystemClock{}, d)
+		Expect(err).ToNot(HaveOccurred())
+		defer ns.Close(false)
+		a := auth.NewAuthenticator(auth.Accounts{"test": {Password: "test", Role: auth.Admin}})
+		ts := httptest.NewServer(server.NewNamespacesRouter(a, store.Namespaces{
+			store.DefaultNamespace: nodes[0].store, "team": ns}))
+		defer ts.Close()
+		dc, err := NewCluster([]string{ts.URL}, "test", "test", DB("team"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(dc.Set("a", "v", time.Minute)).To(Succeed())
+		Expect(ns.Get("a")).To(Equal("v"))
+		_, err = nodes[0].store.Get("a")
+		Expect(err).To(MatchError(store.ErrKeyNotExists))
+	})
+	Specify("keys are distributed evenly", func() {
+		counts := map[string]int{}
+		for _, key := range keysOf(30000) {
+			url, err := c.NodeFor(key)
+			Expect(err).ToNot(HaveOccurred())
+			counts[url]++
+		}
+		Expect(counts).To(HaveLen(3))
+		for _, count := range counts {
+			Expect(count).To(BeNumerically("~", 10000, 3000))
+		}
+	})
+	Specify("adding and removing node moves minimal keys", func() {
+		keys := keysOf(30000)
+		owners := map[string]string{}
+		for _, key := range keys {
+			owners[key], _ = c.NodeFor(key)
+		}
+
+		By("adding node")
+		added := newNode().server.URL
+		Expect(c.AddNode(added)).To(Succeed())
+		moved := 0
+		for _, key := range keys {
+			url, _ := c.NodeFor(key)
+			if url != owners[key] {
+				Expect(url).To(Equal(added))
+				moved++
+			}
+		}
+		Expect(moved).To(BeNumerically("~", 7500, 2500))
+
+		By("removing node")
+		Expect(c.RemoveNode(added)).To(Succeed())
+		for _, key := range keys {
+			Expect(c.NodeFor(key)).To(Equal(owners[key]))
+		}
+	})
+	Specify("Keys and GetMulti fan out to nodes", func() {
+		keys := keysOf(30)
+		for _, key := range keys {
+			Expect(c.Set(key, "v"+key, time.Minute)).To(Succeed())
+		}
+		for _, n := range nodes {
+			Expect(n.store.Keys()).ToNot(BeEmpty())
+		}
+		Expect(c.Keys()).To(ConsistOf(keys))
+		values, err := c.GetMulti(append(keys[:3:3], "not exists"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(values).To(Equal(map[string]string{"key0": "vkey0", "key1": "vkey1", "key2": "vkey2"}))
+
+		By("omitting keys of not owning nodes")
+		Expect(c.RemoveNode(urls[0])).To(Succeed())
+		for _, key := range keys {
+			url, _ := c.NodeFor(key)
+			Expect(url).ToNot(Equal(urls[0]))
+		}
+		clusterKeys, err := c.Keys()
+		Expect(err).ToNot(HaveOccurred())
+		nodeKeys, err := nodes[0].store.Keys()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(clusterKeys).To(HaveLen(len(keys) - len(nodeKeys)))
+	})
+	Specify("node error", func() {
+		nodes[0].server.Close()
+		_, err := c.Keys()
+		Expect(err).To(HaveOccurred())
+	})
+	Specify("concurrent usage", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				key := fmt.Sprintf("key%d", i)
+				Expect(c.Set(key, "v", time.Minute)).To(Succeed())
+				Expect(c.Get(key)).To(Equal("v"))
+			}(i)
+		}
+		wg.Wait()
+	})
+})
+
+type testNode struct {
+	store  store.Store
+	server *httptest.Server
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+var (
+	// server error codes, matched by errors.Is against Error
+	ErrNotKeyItem         = errors.New("not key item")
+	ErrNotListItem        = errors.New("not list item")
+	ErrNotDictItem        = errors.New("not dict item")
+	ErrKeyNotExists       = errors.New("key not exists")
+	ErrListIndexNotExists = errors.New("list index not exists")
+	ErrDictKeyNotExists   = errors.New("dict key not exists")
+	ErrInvalidListIndex   = errors.New("invalid list index")
+	ErrKeyExists          = errors.New("key exists")
+	ErrVersionMismatch    = errors.New("version mismatch")
+	ErrNotNumber          = errors.New("not number")
+
+	// ErrWrongType matches any of ErrNotKeyItem, ErrNotListItem and ErrNotDictItem
+	ErrWrongType = errors.New("wrong type")
+)
+
+// codes are server error codes of code errors
+var codes = map[error]int{
+	ErrNotKeyItem:         10,
+	ErrNotListItem:        11,
+	ErrNotDictItem:        12,
+	ErrKeyNotExists:       20,
+	ErrListIndexNotExists: 21,
+	ErrDictKeyNotExists:   22,
+	ErrInvalidListIndex:   30,
+	ErrKeyExists:          32,
+	ErrVersionMismatch:    33,
+	ErrNotNumber:          34,
+}
+
+// Error is a server error response. Matches status errors like ErrNotFound and code errors like ErrKeyNotExists by
+// errors.Is. Code, Err and Details are empty if response has no JSON error body
+type Error struct {
+	Status  int    `json:"-"`
+	Code    int    `json:"code"`
+	Err     string `json:"error"`
+	Details string `json:"details"`
+}
+
+// newError constructs error of response with status and body
+func newError(status int, body []byte) Error {
+	var e Error
+	if err := json.Unmarshal(body, &e); err != nil {
+		e = Error{}
+	}
+	e.Status = status
+	return e
+}
+
+// Error returns error's string representation, status error's one if response has no JSON error body
+func (e Error) Error() string {
+	if e.Err == "" {
+		return statusError(e.Status).Error()
+	}
+	s := e.Err
+	if e.Details != "" {
+		s += ": " + e.Details
+	}
+	return s
+}
+
+// Is reports whether target is status error of error's status or code error of error's code
+func (e Error) Is(target error) bool {
+	if target == ErrWrongType {
+		return e.Code == codes[ErrNotKeyItem] || e.Code == codes[ErrNotListItem] || e.Code == codes[ErrNotDictItem]
+	}
+	if code, exists := codes[target]; exists {
+		return e.Code == code
+	}
+	return statusError(e.Status) == target
+}
+
+// statusError returns status error of response status
+func statusError(status int) error {
+	switch status {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusBadRequest:
+		return ErrInvalidParams
+	case http.StatusConflict:
+		return ErrConflict
+	case http.StatusNotAcceptable:
+		return ErrNotAcceptable
+	case http.StatusUnsupportedMediaType:
+		return ErrUnsupportedMediaType
+	case http.StatusGone:
+		return ErrReplicationLogTruncated
+	case http.StatusTooManyRequests:
+		return ErrTooManyRequests
+	case http.StatusInsufficientStorage:
+		return ErrInsufficientStorage
+	case http.StatusInternalServerError:
+		return ErrInternalServerError
+	}
+	return ErrUnknownResponseStatus
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// virtualNodes is count of ring points per node. More points give more even keys distribution
+const virtualNodes = 160
+
+// ringPoint is a consistent hash ring point owned by node
+type ringPoint struct {
+	hash uint64
+	node string
+}
+
+// ring is a consistent hash ring. Key is owned by node of first point clockwise from key hash, so adding or removing
+// node moves only keys of its points
+type ring struct {
+	points []ringPoint
+}
+
+// add adds virtual nodes points of node. Points slice is replaced, not modified in place
+func (r *ring) add(node string) {
+	points := make([]ringPoint, len(r.points), len(r.points)+virtualNodes)
+	copy(points, r.points)
+	for i := 0; i < virtualNodes; i++ {
+		points = append(points, ringPoint{hash: hash(node + "#" + strconv.Itoa(i)), node: node})
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			return points[i].node < points[j].node
+		}
+		return points[i].hash < points[j].hash
+	})
+	r.points = points
+}
+
+// remove removes all points of node. Points slice is replaced, not modified in place
+func (r *ring) remove(node string) {
+	points := make([]ringPoint, 0, len(r.points))
+	for _, p := range r.points {
+		if p.node != node {
+			points = append(points, p)
+		}
+	}
+	r.points = points
+}
+
+// get returns node owning key. Returns empty string if ring is empty
+func (r *ring) get(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
+
+// hash is first 64 bits of MD5 hash of s. Unlike fast non-cryptographic hashes it spreads similar strings evenly
+func hash(s string) uint64 {
+	sum := md5.Sum([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/someanon/yamc/cluster"
+)
+
+// slotCache is a cached cluster slot map shared by Client copies
+type slotCache struct {
+	mutex sync.RWMutex
+	slots *cluster.SlotMap
+}
+
+// owner returns URL of node owning key, empty if slot map is not cached
+func (sc *slotCache) owner(key string) string {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+	if sc.slots == nil {
+		return ""
+	}
+	return sc.slots.Owner(cluster.Slot(key))
+}
+
+// set caches slot map m unless cached one has greater epoch
+func (sc *slotCache) set(m cluster.SlotMap) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	if sc.slots == nil || sc.slots.Epoch <= m.Epoch {
+		sc.slots = &m
+	}
+}
+
+// refreshSlots requests slot map of node Client points to and caches it. Cache is kept on error
+func (c Client) refreshSlots() {
+	if c.slots == nil {
+		return
+	}
+	slots, err := c.Slots()
+	if err != nil {
+		return
+	}
+	c.slots.set(slots)
+}
+
+// Slots returns cluster slot map of node
+func (c Client) Slots() (cluster.SlotMap, error) {
+	c = c.prepare(http.MethodGet, slotsPath)
+	c.url.RawQuery = c.query.Encode()
+	body, err := c.doReq(nil)
+	if err != nil {
+		return cluster.SlotMap{}, err
+	}
+	var slots cluster.SlotMap
+	if err := c.codec.Unmarshal([]byte(body), &slots); err != nil {
+		return cluster.SlotMap{}, ErrInvalidServerResponse
+	}
+	if err := slots.Validate(); err != nil {
+		return cluster.SlotMap{}, ErrInvalidServerResponse
+	}
+	return slots, nil
+}
+
+// UpdateSlots replaces cluster slot map of node with m. Errors with ErrConflict if m's epoch is not greater than
+// node's one
+func (c Client) UpdateSlots(m cluster.SlotMap) error {
+	c = c.prepare(http.MethodPut, updateSlotsPath)
+	c.url.RawQuery = c.query.Encode()
+	_, err := c.doCodecReq(m)
+	return err
+}
+
+// MigrateSlots moves keys of slots from from to to inclusive from node to node with URL node and assigns slots to
+// it. Returns moved keys count
+func (c Client) MigrateSlots(from int, to int, node string) (int, error) {
+	c = c.prepare(http.MethodPost, migratePath)
+	c.query.Set(slotsKey, fmt.Sprintf("%d-%d", from, to))
+	c.query.Set(nodeKey, node)
+	c.url.RawQuery = c.query.Encode()
+	body, err := c.doReq(nil)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(body)
+	if err != nil {
+		return 0, ErrInvalidServerResponse
+	}
+	return n, nil
+}
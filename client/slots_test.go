@@ -0,0 +1,168 @@
+package client_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/someanon/yamc/auth"
+	. "github.com/someanon/yamc/client"
+	"github.com/someanon/yamc/cluster"
+	"github.com/someanon/yamc/server"
+	"github.com/someanon/yamc/store"
+)
+
+var _ = Describe("cluster node client", func() {
+	var (
+		dir       string
+		nodes     []*testNode
+		urls      []string
+		mutex     sync.Mutex
+		redirects int
+		c         Client
+	)
+	dial := func(url string) (cluster.Peer, error) {
+		return NewClient(url, "test", "test")
+	}
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "yamc-cluster")
+		Expect(err).ToNot(HaveOccurred())
+		nodes, urls, redirects = nil, nil, 0
+		for i := 0; i < 3; i++ {
+			n := &testNode{server: httptest.NewUnstartedServer(nil)}
+			nodes = append(nodes, n)
+			urls = append(urls, "http://"+n.server.Listener.Addr().String())
+		}
+		for i, n := range nodes {
+			d, err := store.NewFileDumper(filepath.Join(dir, fmt.Sprintf("dump%d", i)), store.GobFormat,
+				store.NoCompression)
+			Expect(err).ToNot(HaveOccurred())
+			n.store, err = store.NewStore(store.Params{CleaningPeriod: time.Second, DumpingPeriod: time.Minute},
+				store.SystemClock{}, d)
+			Expect(err).ToNot(HaveOccurred())
+			node, err := cluster.NewNode(urls[i], urls, n.store, dial)
+			Expect(err).ToNot(HaveOccurred())
+			a := auth.NewAuthenticator(auth.Accounts{"test": {Password: "test", Role: auth.Admin}})
+			router := server.NewClusterRouter(a, n.store, node)
+			n.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				router.ServeHTTP(w, r)
+				if w.Header().Get("Location") != "" {
+					mutex.Lock()
+					redirects++
+					mutex.Unlock()
+				}
+			})
+			n.server.Start()
+		}
+		c, err = NewClient(urls[0], "test", "test")
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		for _, n := range nodes {
+			n.server.Close()
+			n.store.Close(false)
+		}
+		os.RemoveAll(dir)
+	})
+	redirected := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return redirects
+	}
+	// nodeOf returns index of node owning key
+	nodeOf := func(key string) int {
+		slots, err := c.Slots()
+		Expect(err).ToNot(HaveOccurred())
+		owner := slots.Owner(cluster.Slot(key))
+		for i, url := range urls {
+			if url == owner {
+				return i
+			}
+		}
+		Fail("no owner of key " + key)
+		return 0
+	}
+	Specify("follows redirects and caches slot map", func() {
+		var keys []string
+		for i := 0; i < 30; i++ {
+			keys = append(keys, fmt.Sprintf("key%d", i))
+		}
+		Expect(c.Set(keys[0], "v", time.Minute)).To(Succeed())
+		for _, key := range keys {
+			Expect(c.Set(key, "v"+key, time.Minute)).To(Succeed())
+			Expect(c.ListSet("l"+key, []string{"l"}, time.Minute)).To(Succeed())
+			Expect(c.DictSet("d"+key, map[string]string{"dk": "dv"}, time.Minute)).To(Succeed())
+		}
+		Expect(redirected()).To(BeNumerically("<=", 1))
+		for _, key := range keys {
+			Expect(c.Get(key)).To(Equal("v" + key))
+			Expect(c.ListGet("l"+key, 0)).To(Equal("l"))
+			Expect(c.DictGet("d"+key, "dk")).To(Equal("dv"))
+			Expect(nodes[nodeOf(key)].store.Get(key)).To(Equal("v" + key))
+		}
+		for _, n := range nodes {
+			Expect(n.store.Keys()).ToNot(BeEmpty())
+		}
+		Expect(c.Remove(keys[0])).To(Succeed())
+		_, err := nodes[nodeOf(keys[0])].store.Get(keys[0])
+		Expect(err).To(MatchError(store.ErrKeyNotExists))
+		Expect(redirected()).To(BeNumerically("<=", 1))
+	})
+	Specify("MigrateSlots moves keys", func() {
+		key := "key"
+		Expect(c.Set(key, "v", time.Minute)).To(Succeed())
+		from := nodeOf(key)
+		to := (from + 1) % len(nodes)
+		owner, err := NewClient(urls[from], "test", "test")
+		Expect(err).ToNot(HaveOccurred())
+		slot := cluster.Slot(key)
+		Expect(owner.MigrateSlots(slot, slot, urls[to])).To(Equal(1))
+		for _, url := range urls {
+			n, err := NewClient(url, "test", "test")
+			Expect(err).ToNot(HaveOccurred())
+			slots, err := n.Slots()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(slots.Epoch).To(BeEquivalentTo(2))
+			Expect(slots.Migration).To(BeNil())
+			Expect(slots.Owner(slot)).To(Equal(urls[to]))
+		}
+		_, err = nodes[from].store.Get(key)
+		Expect(err).To(MatchError(store.ErrKeyNotExists))
+		Expect(nodes[to].store.Get(key)).To(Equal("v"))
+
+		By("following redirect of stale slot map")
+		before := redirected()
+		Expect(c.Get(key)).To(Equal("v"))
+		Expect(redirected()).To(Equal(before + 1))
+		Expect(c.Get(key)).To(Equal("v"))
+		Expect(redirected()).To(Equal(before + 1))
+
+		By("not owned slot error")
+		_, err = owner.MigrateSlots(slot, slot, urls[to])
+		Expect(err).To(MatchError(ErrConflict))
+	})
+	Specify("UpdateSlots stale slot map error", func() {
+		slots, err := c.Slots()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.UpdateSlots(slots)).To(MatchError(ErrConflict))
+	})
+	Specify("too many redirects error", func() {
+		slots, err := c.Slots()
+		Expect(err).ToNot(HaveOccurred())
+		for i, url := range urls {
+			n, err := NewClient(url, "test", "test")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n.UpdateSlots(slots.Assign(0, cluster.SlotCount-1, urls[(i+1)%len(urls)]))).To(Succeed())
+		}
+		_, err = c.Get("a")
+		Expect(err).To(MatchError(ErrTooManyRedirects))
+	})
+})
@@ -0,0 +1,123 @@
+package client_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/someanon/yamc/auth"
+	. "github.com/someanon/yamc/client"
+	"github.com/someanon/yamc/server"
+	"github.com/someanon/yamc/store"
+)
+
+// newCertificate generates certificate of common name signed by parent, self-signed CA if parent is nil
+func newCertificate(commonName string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
+
+var _ = Describe("TLS", func() {
+	var (
+		dir  string
+		ca   tls.Certificate
+		pool *x509.CertPool
+		st   store.Store
+		ts   *httptest.Server
+	)
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "yamc-tls")
+		Expect(err).ToNot(HaveOccurred())
+		ca = newCertificate("ca", nil)
+		pool = x509.NewCertPool()
+		pool.AddCert(ca.Leaf)
+		cert := newCertificate("server", &ca)
+		key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "ca.pem"),
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Leaf.Raw}), 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "cert.pem"),
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Leaf.Raw}), 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "key.pem"),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600)).To(Succeed())
+		t, err := server.NewTLS(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"),
+			filepath.Join(dir, "ca.pem"))
+		Expect(err).ToNot(HaveOccurred())
+
+		d, err := store.NewFileDumper(filepath.Join(dir, "dump"), store.GobFormat, store.NoCompression)
+		Expect(err).ToNot(HaveOccurred())
+		st, err = store.NewStore(store.Params{CleaningPeriod: time.Second, DumpingPeriod: time.Minute},
+			store.SystemClock{}, d)
+		Expect(err).ToNot(HaveOccurred())
+		ts = httptest.NewUnstartedServer(server.NewRouter(auth.NewAuthenticator(auth.Accounts{
+			"test": {Password: "test", Role: auth.Admin},
+			"app":  {Password: "app", Role: auth.ReadWrite},
+		}), st))
+		ts.TLS = t.Config()
+		ts.StartTLS()
+	})
+	AfterEach(func() {
+		ts.Close()
+		st.Close(false)
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+	Specify("client certificate", func() {
+		c, err := NewClient(ts.URL, "", "", RootCAs(pool), Certificate(newCertificate("app", &ca)))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Set("a", "v", time.Minute)).To(Succeed())
+		Expect(c.Get("a")).To(Equal("v"))
+
+		By("login and password take precedence")
+		c, err = NewClient(ts.URL, "app", "wrong", RootCAs(pool), Certificate(newCertificate("app", &ca)))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = c.Get("a")
+		Expect(err).To(MatchError(ErrUnauthorized))
+
+		By("certificate without account")
+		c, err = NewClient(ts.URL, "", "", RootCAs(pool), Certificate(newCertificate("unknown", &ca)))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = c.Get("a")
+		Expect(err).To(MatchError(ErrUnauthorized))
+	})
+	Specify("unknown CA", func() {
+		c, err := NewClient(ts.URL, "", "", Certificate(newCertificate("app", &ca)))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = c.Get("a")
+		Expect(err).To(MatchError(ContainSubstring("certificate signed by unknown authority")))
+	})
+})
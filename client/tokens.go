@@ -0,0 +1,66 @@
+package client
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/someanon/yamc/auth"
+)
+
+// IssuedToken is an issued API token with its secret "<id>.<secret>" used by Token and SignedToken options
+type IssuedToken struct {
+	auth.Token `yaml:",inline" msgpack:",inline"`
+	Secret     string `yaml:"secret" json:"secret" msgpack:"secret"`
+}
+
+// IssueToken issues API token of login with scopes expiring after ttl, never if ttl is zero. Token of requesting
+// account is issued if login is empty
+func (c Client) IssueToken(login string, scopes []auth.Scope, ttl time.Duration) (IssuedToken, error) {
+	c = c.prepare(http.MethodPost, tokensPath)
+	if login != "" {
+		c.query.Set(loginKey, login)
+	}
+	s := make([]string, len(scopes))
+	for i, scope := range scopes {
+		s[i] = string(scope)
+	}
+	c.query.Set(scopeKey, strings.Join(s, ","))
+	if ttl > 0 {
+		c.query.Set(ttlKey, ttl.String())
+	}
+	c.url.RawQuery = c.query.Encode()
+	body, err := c.doReq(nil)
+	if err != nil {
+		return IssuedToken{}, err
+	}
+	var t IssuedToken
+	if err := c.codec.Unmarshal([]byte(body), &t); err != nil {
+		return IssuedToken{}, ErrInvalidServerResponse
+	}
+	return t, nil
+}
+
+// Tokens returns not expired API tokens without secrets
+func (c Client) Tokens() ([]auth.Token, error) {
+	c = c.prepare(http.MethodGet, tokensPath)
+	c.url.RawQuery = c.query.Encode()
+	body, err := c.doReq(nil)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []auth.Token
+	if err := c.codec.Unmarshal([]byte(body), &tokens); err != nil {
+		return nil, ErrInvalidServerResponse
+	}
+	return tokens, nil
+}
+
+// RevokeToken revokes API token by id
+func (c Client) RevokeToken(id string) error {
+	c = c.prepare(http.MethodDelete, tokensPath)
+	c.query.Set(idKey, id)
+	c.url.RawQuery = c.query.Encode()
+	_, err := c.doReq(nil)
+	return err
+}
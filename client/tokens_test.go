@@ -0,0 +1,87 @@
+package client_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/someanon/yamc/auth"
+	. "github.com/someanon/yamc/client"
+	"github.com/someanon/yamc/server"
+	"github.com/someanon/yamc/store"
+)
+
+var _ = Describe("Tokens", func() {
+	var (
+		dir string
+		st  store.Store
+		ts  *httptest.Server
+		c   Client
+	)
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "yamc-tokens")
+		Expect(err).ToNot(HaveOccurred())
+		d, err := store.NewFileDumper(filepath.Join(dir, "dump"), store.GobFormat, store.NoCompression)
+		Expect(err).ToNot(HaveOccurred())
+		st, err = store.NewStore(store.Params{CleaningPeriod: time.Second, DumpingPeriod: time.Minute},
+			store.SystemClock{}, d)
+		Expect(err).ToNot(HaveOccurred())
+		a := auth.NewAuthenticator(auth.Accounts{
+			"test": {Password: "test", Role: auth.Admin},
+			"app":  {Password: "app", Role: auth.ReadWrite},
+		})
+		ts = httptest.NewServer(server.NewRouter(a, st))
+		c, err = NewClient(ts.URL, "test", "test")
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		ts.Close()
+		st.Close(false)
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+	Specify("issue, use and revoke", func() {
+		t, err := c.IssueToken("app", []auth.Scope{auth.ReadScope, auth.WriteScope}, time.Hour)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(t.Login).To(Equal("app"))
+		Expect(t.Scopes).To(Equal([]auth.Scope{auth.ReadScope, auth.WriteScope}))
+		Expect(t.Expiry.Sub(t.Created)).To(Equal(time.Hour))
+		Expect(t.Secret).To(HavePrefix(t.ID + "."))
+		Expect(c.Tokens()).To(Equal([]auth.Token{t.Token}))
+
+		By("bearer token")
+		bc, err := NewClient(ts.URL, "", "", Token(t.Secret))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bc.Set("a", "v", time.Minute)).To(Succeed())
+		Expect(bc.Get("a")).To(Equal("v"))
+		_, err = bc.Tokens()
+		Expect(err).To(MatchError(ErrForbidden))
+
+		By("signed token")
+		sc, err := NewClient(ts.URL, "", "", SignedToken(t.Secret))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sc.Set("b", "v", time.Minute)).To(Succeed())
+		Expect(sc.Get("b")).To(Equal("v"))
+
+		By("revoked token")
+		Expect(c.RevokeToken(t.ID)).To(Succeed())
+		_, err = bc.Get("a")
+		Expect(err).To(MatchError(ErrUnauthorized))
+		_, err = sc.Get("a")
+		Expect(err).To(MatchError(ErrUnauthorized))
+		Expect(c.RevokeToken(t.ID)).To(MatchError(ErrNotFound))
+	})
+	Specify("own read token", func() {
+		t, err := c.IssueToken("", []auth.Scope{auth.ReadScope}, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(t.Login).To(Equal("test"))
+		Expect(t.Expiry.IsZero()).To(BeTrue())
+		tc, err := NewClient(ts.URL, "", "", Token(t.Secret))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tc.Set("a", "v", time.Minute)).To(MatchError(ErrForbidden))
+	})
+})
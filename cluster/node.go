@@ -0,0 +1,423 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/someanon/yamc/store"
+)
+
+var (
+	// node errors
+	ErrStaleSlotMap        = errors.New("stale slot map")
+	ErrSlotNotOwned        = errors.New("slot is not owned by node")
+	ErrInvalidSlotRange    = errors.New("invalid slot range")
+	ErrInvalidTarget       = errors.New("invalid migration target node")
+	ErrMigrationInProgress = errors.New("slot migration in progress")
+)
+
+// migrationBatchBytes is a size of migrated records sent to target node by single import
+const migrationBatchBytes = 1 << 20
+
+// MovedError is an error of key request to node not owning key's slot
+type MovedError struct {
+	// Slot is key's slot
+	Slot int
+
+	// Node is URL of node owning slot
+	Node string
+}
+
+// Error returns error's string representation
+func (e MovedError) Error() string {
+	return fmt.Sprintf("slot %d moved to %s", e.Slot, e.Node)
+}
+
+// Peer is other cluster node API
+type Peer interface {
+	// Slots returns node's slot map
+	Slots() (SlotMap, error)
+
+	// UpdateSlots replaces node's slot map with m of greater epoch
+	UpdateSlots(m SlotMap) error
+
+	// Import imports newline delimited JSON records from r to node's store
+	Import(r io.Reader) (int, error)
+}
+
+// Node is a cluster node state: static cluster membership, slot map and slot migrations
+type Node struct {
+	mutex     sync.Mutex
+	idle      *sync.Cond
+	self      string
+	members   map[string]bool
+	slots     SlotMap
+	store     store.Store
+	dial      func(url string) (Peer, error)
+	inflight  map[int]int
+	migrating map[int]chan struct{}
+}
+
+// NewNode constructs node with URL self of cluster of nodes with URLs nodes. Slots are evenly split between nodes
+// until greater epoch slot map is received. Keys are stored in s, other nodes are requested by peers constructed by
+// dial
+func NewNode(self string, nodes []string, s store.Store, dial func(url string) (Peer, error)) (*Node, error) {
+	slots, err := NewSlotMap(nodes)
+	if err != nil {
+		return nil, errors.New("failed to assign slots: " + err.Error())
+	}
+	n := &Node{
+		self:      self,
+		members:   map[string]bool{},
+		slots:     slots,
+		store:     s,
+		dial:      dial,
+		inflight:  map[int]int{},
+		migrating: map[int]chan struct{}{},
+	}
+	n.idle = sync.NewCond(&n.mutex)
+	for _, node := range nodes {
+		n.members[node] = true
+	}
+	if !n.members[self] {
+		return nil, errors.New("node " + self + " is not a cluster member")
+	}
+	return n, nil
+}
+
+// Self returns node's URL
+func (n *Node) Self() string {
+	return n.self
+}
+
+// Slots returns node's slot map
+func (n *Node) Slots() SlotMap {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.slots
+}
+
+// UpdateSlots replaces slot map with m. Errors with ErrStaleSlotMap if m's epoch is not greater than current one and
+// with ErrMigrationInProgress if m has migration other than the one of current slot map
+func (n *Node) UpdateSlots(m SlotMap) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if m.Epoch <= n.slots.Epoch {
+		return ErrStaleSlotMap
+	}
+	if m.Migration != nil && n.slots.Migration != nil && *m.Migration != *n.slots.Migration {
+		return ErrMigrationInProgress
+	}
+	n.slots = m
+	return nil
+}
+
+// Sync requests slot maps of other nodes and takes the one of greatest epoch. Returns first request error
+func (n *Node) Sync() error {
+	var (
+		latest   = n.Slots()
+		firstErr error
+	)
+	for node := range n.members {
+		if node == n.self {
+			continue
+		}
+		slots, err := n.slotsOf(node)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if slots.Epoch > latest.Epoch && slots.Validate() == nil {
+			latest = slots
+		}
+	}
+	if err := n.UpdateSlots(latest); err != nil && err != ErrStaleSlotMap {
+		return err
+	}
+	return firstErr
+}
+
+// Acquire checks key's slot is owned by node and holds it from migration until release is called. Waits if slot is
+// being migrated. Errors with MovedError if slot is owned by other node
+func (n *Node) Acquire(ctx context.Context, key string) (release func(), err error) {
+	slot := Slot(key)
+	n.mutex.Lock()
+	for {
+		done, migrating := n.migrating[slot]
+		if !migrating {
+			break
+		}
+		n.mutex.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		n.mutex.Lock()
+	}
+	defer n.mutex.Unlock()
+	if owner := n.slots.Owner(slot); owner != n.self {
+		return nil, MovedError{Slot: slot, Node: owner}
+	}
+	n.inflight[slot]++
+	return func() {
+		n.mutex.Lock()
+		defer n.mutex.Unlock()
+		if n.inflight[slot]--; n.inflight[slot] == 0 {
+			delete(n.inflight, slot)
+			n.idle.Broadcast()
+		}
+	}, nil
+}
+
+// Migrate moves keys of slots from from to to inclusive to node with URL target, then assigns slots to target and
+// sends new slot map to all nodes. Before moving keys all nodes are sent slot map with migration, so concurrent
+// migrations started on other nodes are rejected. Key requests of migrated slots wait until migration is over.
+// Returns count of moved keys
+func (n *Node) Migrate(from int, to int, target string) (int, error) {
+	if from < 0 || to >= SlotCount || from > to {
+		return 0, ErrInvalidSlotRange
+	}
+	done, err := n.startMigration(from, to, target)
+	if err != nil {
+		return 0, err
+	}
+	defer n.finishMigration(from, to, done)
+
+	claim := n.Slots().Migrating(SlotMigration{From: from, To: to, Source: n.self, Target: target})
+	if err := n.lock(claim); err != nil {
+		return 0, err
+	}
+	peer, err := n.dial(target)
+	if err != nil {
+		n.unlock(claim)
+		return 0, errors.New("failed to connect node " + target + ": " + err.Error())
+	}
+	keys, err := n.move(from, to, target, peer)
+	if err != nil {
+		n.unlock(claim)
+		return 0, err
+	}
+	slots := claim.Assign(from, to, target)
+	if err := peer.UpdateSlots(slots); err != nil {
+		n.unlock(claim)
+		return 0, errors.New("failed to update slots of node " + target + ": " + err.Error())
+	}
+	n.mutex.Lock()
+	n.slots = slots
+	n.mutex.Unlock()
+
+	for _, key := range keys {
+		if err := n.store.Remove(key); err != nil && err != store.ErrKeyNotExists {
+			return len(keys), errors.New("failed to remove moved key: " + err.Error())
+		}
+	}
+	for node := range n.members {
+		if node == n.self || node == target {
+			continue
+		}
+		if err := n.updateSlotsOf(node, slots); err != nil {
+			return len(keys), errors.New("failed to update slots of node " + node + ": " + err.Error())
+		}
+	}
+	return len(keys), nil
+}
+
+// lock sends slot map with migration claim to all nodes in order of sorted URLs, so of concurrent migrations only
+// the one locking first node proceeds. Unlocks already locked nodes on failure
+func (n *Node) lock(claim SlotMap) error {
+	nodes := make([]string, 0, len(n.members))
+	for node := range n.members {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for i, node := range nodes {
+		var err error
+		if node == n.self {
+			if err = n.UpdateSlots(claim); err == ErrStaleSlotMap {
+				err = ErrMigrationInProgress
+			}
+		} else if err = n.updateSlotsOf(node, claim); err != nil {
+			err = errors.New("failed to lock slots of node " + node + ": " + err.Error())
+		}
+		if err != nil {
+			n.release(claim, nodes[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// unlock sends slot map without migration claim to all nodes
+func (n *Node) unlock(claim SlotMap) {
+	nodes := make([]string, 0, len(n.members))
+	for node := range n.members {
+		nodes = append(nodes, node)
+	}
+	n.release(claim, nodes)
+}
+
+// release sends slot map without migration claim to nodes ignoring errors
+func (n *Node) release(claim SlotMap, nodes []string) {
+	released := claim.Released()
+	for _, node := range nodes {
+		if node == n.self {
+			n.UpdateSlots(released)
+		} else {
+			n.updateSlotsOf(node, released)
+		}
+	}
+}
+
+// startMigration marks slots as migrating and waits until their in-flight requests are over
+func (n *Node) startMigration(from int, to int, target string) (chan struct{}, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if target == n.self || !n.members[target] {
+		return nil, ErrInvalidTarget
+	}
+	if n.slots.Migration != nil {
+		return nil, ErrMigrationInProgress
+	}
+	for slot := from; slot <= to; slot++ {
+		if _, migrating := n.migrating[slot]; migrating {
+			return nil, ErrMigrationInProgress
+		}
+		if n.slots.Owner(slot) != n.self {
+			return nil, ErrSlotNotOwned
+		}
+	}
+	done := make(chan struct{})
+	for slot := from; slot <= to; slot++ {
+		n.migrating[slot] = done
+	}
+	for n.busy(from, to) {
+		n.idle.Wait()
+	}
+	return done, nil
+}
+
+// finishMigration unmarks migrating slots and wakes up waiting requests
+func (n *Node) finishMigration(from int, to int, done chan struct{}) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for slot := from; slot <= to; slot++ {
+		delete(n.migrating, slot)
+	}
+	close(done)
+}
+
+// busy returns true if any of slots from from to to has in-flight requests. Must be called under lock
+func (n *Node) busy(from int, to int) bool {
+	for slot := range n.inflight {
+		if slot >= from && slot <= to {
+			return true
+		}
+	}
+	return false
+}
+
+// move streams newline delimited JSON records of store's keys of slots from from to to inclusive to node with URL target
+// by batches of migrationBatchBytes and returns moved keys
+func (n *Node) move(from int, to int, target string, peer Peer) ([]string, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(n.store.Export(pw))
+	}()
+	defer pr.Close()
+	var (
+		batch bytes.Buffer
+		keys  []string
+	)
+	flush := func() error {
+		if batch.Len() == 0 {
+			return nil
+		}
+		if _, err := peer.Import(&batch); err != nil {
+			return errors.New("failed to import keys to node " + target + ": " + err.Error())
+		}
+		batch.Reset()
+		return nil
+	}
+	decoder := json.NewDecoder(pr)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.New("failed to export keys: " + err.Error())
+		}
+		key, err := recordKey(raw)
+		if err != nil {
+			return nil, errors.New("failed to export keys: " + err.Error())
+		}
+		if slot := Slot(key); slot < from || slot > to {
+			continue
+		}
+		batch.Write(raw)
+		batch.WriteByte('\n')
+		keys = append(keys, key)
+		if batch.Len() >= migrationBatchBytes {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// recordKey returns key of exported JSON record
+func recordKey(raw json.RawMessage) (string, error) {
+	var rec struct {
+		Key      string `json:"key"`
+		Encoding string `json:"encoding"`
+	}
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return "", err
+	}
+	switch rec.Encoding {
+	case "":
+		return rec.Key, nil
+	case "base64":
+		key, err := base64.StdEncoding.DecodeString(rec.Key)
+		if err != nil {
+			return "", errors.New("invalid base64 key: " + err.Error())
+		}
+		return string(key), nil
+	default:
+		return "", errors.New("unknown encoding \"" + rec.Encoding + "\" of record")
+	}
+}
+
+// slotsOf requests slot map of node
+func (n *Node) slotsOf(node string) (SlotMap, error) {
+	peer, err := n.dial(node)
+	if err != nil {
+		return SlotMap{}, err
+	}
+	return peer.Slots()
+}
+
+// updateSlotsOf sends slot map m to node
+func (n *Node) updateSlotsOf(node string, m SlotMap) error {
+	peer, err := n.dial(node)
+	if err != nil {
+		return err
+	}
+	return peer.UpdateSlots(m)
+}
@@ -0,0 +1,316 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/someanon/yamc/store"
+)
+
+var _ = Describe("Node", func() {
+	var (
+		dir   string
+		s     store.Store
+		peers map[string]*testPeer
+		n     *Node
+	)
+	nodes := []string{"a", "b", "c"}
+	dial := func(url string) (Peer, error) {
+		p, ok := peers[url]
+		if !ok {
+			return nil, errors.New("unknown peer " + url)
+		}
+		return p, nil
+	}
+	// keyOf returns key of slot owned by node a or other node
+	keyOf := func(ownedByA bool) string {
+		for i := 0; ; i++ {
+			key := strings.Repeat("k", i)
+			if (Slot(key) < SlotCount/3) == ownedByA {
+				return key
+			}
+		}
+	}
+	// keysOf returns count keys with prefix of slots owned by node a
+	keysOf := func(prefix string, count int) []string {
+		var keys []string
+		for i := 0; len(keys) < count; i++ {
+			if key := prefix + strconv.Itoa(i); Slot(key) < SlotCount/3 {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	}
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "yamc-cluster")
+		Expect(err).ToNot(HaveOccurred())
+		d, err := store.NewFileDumper(filepath.Join(dir, "dump"), store.GobFormat, store.NoCompression)
+		Expect(err).ToNot(HaveOccurred())
+		s, err = store.NewStore(store.Params{CleaningPeriod: time.Second, DumpingPeriod: time.Minute},
+			store.SystemClock{}, d)
+		Expect(err).ToNot(HaveOccurred())
+		peers = map[string]*testPeer{"b": {}, "c": {}}
+		n, err = NewNode("a", nodes, s, dial)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		s.Close(false)
+		os.RemoveAll(dir)
+	})
+	Describe("NewNode", func() {
+		Specify("not member error", func() {
+			_, err := NewNode("d", nodes, s, dial)
+			Expect(err).To(MatchError("node d is not a cluster member"))
+		})
+		Specify("no nodes error", func() {
+			_, err := NewNode("a", nil, s, dial)
+			Expect(err).To(MatchError("failed to assign slots: no nodes"))
+		})
+		Specify("assigns slots evenly", func() {
+			Expect(n.Self()).To(Equal("a"))
+			Expect(n.Slots().Nodes()).To(Equal(nodes))
+			Expect(n.Slots().Epoch).To(BeZero())
+		})
+	})
+	Describe("Acquire", func() {
+		Specify("moved error", func() {
+			key := keyOf(false)
+			_, err := n.Acquire(context.Background(), key)
+			Expect(err).To(MatchError(MovedError{Slot: Slot(key), Node: n.Slots().Owner(Slot(key))}))
+		})
+		Specify("owned key", func() {
+			release, err := n.Acquire(context.Background(), keyOf(true))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n.inflight).To(HaveLen(1))
+			release()
+			Expect(n.inflight).To(BeEmpty())
+		})
+		Specify("waits for migration", func() {
+			key := keyOf(true)
+			done, err := n.startMigration(Slot(key), Slot(key), "b")
+			Expect(err).ToNot(HaveOccurred())
+
+			By("until context is done")
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			_, err = n.Acquire(ctx, key)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+
+			By("until migration is over")
+			acquired := make(chan error, 1)
+			go func() {
+				_, err := n.Acquire(context.Background(), key)
+				acquired <- err
+			}()
+			Consistently(acquired).ShouldNot(Receive())
+			n.mutex.Lock()
+			n.slots = n.slots.Assign(Slot(key), Slot(key), "b")
+			n.mutex.Unlock()
+			n.finishMigration(Slot(key), Slot(key), done)
+			Eventually(acquired).Should(Receive(MatchError(MovedError{Slot: Slot(key), Node: "b"})))
+		})
+	})
+	Describe("UpdateSlots", func() {
+		Specify("invalid slot map error", func() {
+			Expect(n.UpdateSlots(SlotMap{Epoch: 1})).To(MatchError("slots from 0 are not assigned"))
+		})
+		Specify("stale slot map error", func() {
+			Expect(n.UpdateSlots(n.Slots())).To(MatchError(ErrStaleSlotMap))
+		})
+		Specify("migration in progress error", func() {
+			m := n.Slots().Migrating(SlotMigration{From: 0, To: 10, Source: "a", Target: "b"})
+			Expect(n.UpdateSlots(m)).To(Succeed())
+			Expect(n.UpdateSlots(m.Migrating(SlotMigration{From: 0, To: 10, Source: "a", Target: "c"}))).
+				To(MatchError(ErrMigrationInProgress))
+			Expect(n.UpdateSlots(m.Released())).To(Succeed())
+		})
+		Specify("success", func() {
+			m := n.Slots().Assign(0, 10, "b")
+			Expect(n.UpdateSlots(m)).To(Succeed())
+			Expect(n.Slots()).To(Equal(m))
+		})
+	})
+	Describe("Sync", func() {
+		Specify("takes slot map of greatest epoch", func() {
+			peers["b"].slots = n.Slots().Assign(0, 10, "b")
+			peers["c"].slots = peers["b"].slots.Assign(0, 10, "c")
+			Expect(n.Sync()).To(Succeed())
+			Expect(n.Slots()).To(Equal(peers["c"].slots))
+		})
+		Specify("peer error", func() {
+			peers["b"].slots = n.Slots().Assign(0, 10, "b")
+			delete(peers, "c")
+			Expect(n.Sync()).To(MatchError("unknown peer c"))
+			Expect(n.Slots()).To(Equal(peers["b"].slots))
+		})
+	})
+	Describe("Migrate", func() {
+		Specify("invalid slot range error", func() {
+			_, err := n.Migrate(10, 5, "b")
+			Expect(err).To(MatchError(ErrInvalidSlotRange))
+			_, err = n.Migrate(0, SlotCount, "b")
+			Expect(err).To(MatchError(ErrInvalidSlotRange))
+		})
+		Specify("invalid target error", func() {
+			_, err := n.Migrate(0, 10, "a")
+			Expect(err).To(MatchError(ErrInvalidTarget))
+			_, err = n.Migrate(0, 10, "d")
+			Expect(err).To(MatchError(ErrInvalidTarget))
+		})
+		Specify("not owned slot error", func() {
+			_, err := n.Migrate(0, SlotCount-1, "b")
+			Expect(err).To(MatchError(ErrSlotNotOwned))
+		})
+		Specify("migration in progress error", func() {
+			done, err := n.startMigration(0, 10, "b")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = n.Migrate(10, 20, "c")
+			Expect(err).To(MatchError(ErrMigrationInProgress))
+			n.finishMigration(0, 10, done)
+			Expect(n.migrating).To(BeEmpty())
+		})
+		Specify("locked slot map error", func() {
+			Expect(n.UpdateSlots(n.Slots().Migrating(SlotMigration{From: 6000, To: 6010, Source: "b", Target: "c"}))).
+				To(Succeed())
+			_, err := n.Migrate(0, 10, "b")
+			Expect(err).To(MatchError(ErrMigrationInProgress))
+			Expect(n.migrating).To(BeEmpty())
+		})
+		Specify("concurrent migration error releases lock", func() {
+			Expect(s.Set(keyOf(true), "v", time.Minute)).To(Succeed())
+			peers["b"].slots = n.Slots().Migrating(SlotMigration{From: 6000, To: 6010, Source: "b", Target: "c"})
+			_, err := n.Migrate(0, SlotCount/3-1, "c")
+			Expect(err).To(MatchError("failed to lock slots of node b: stale slot map"))
+			Expect(peers["c"].imported).To(BeEmpty())
+			Expect(s.Keys()).To(HaveLen(1))
+			slots := n.Slots()
+			Expect(slots.Epoch).To(BeEquivalentTo(2))
+			Expect(slots.Migration).To(BeNil())
+			Expect(slots.Owner(0)).To(Equal("a"))
+			Expect(peers["c"].slots.Epoch).To(BeZero())
+		})
+		Specify("import error keeps keys and slots", func() {
+			Expect(s.Set(keyOf(true), "v", time.Minute)).To(Succeed())
+			peers["b"].importErr = errors.New("error")
+			_, err := n.Migrate(0, SlotCount/3-1, "b")
+			Expect(err).To(MatchError("failed to import keys to node b: error"))
+			Expect(s.Keys()).To(HaveLen(1))
+			slots := n.Slots()
+			Expect(slots.Epoch).To(BeEquivalentTo(2))
+			Expect(slots.Migration).To(BeNil())
+			Expect(slots.Owner(0)).To(Equal("a"))
+			Expect(peers["b"].slots).To(Equal(slots))
+			Expect(peers["c"].slots).To(Equal(slots))
+			Expect(n.migrating).To(BeEmpty())
+		})
+		Specify("moves keys and assigns slots", func() {
+			owned, other := keyOf(true), keyOf(false)
+			Expect(s.Set(owned, "v", time.Minute)).To(Succeed())
+			Expect(s.Set(other, "v", time.Minute)).To(Succeed())
+			count, err := n.Migrate(0, SlotCount/3-1, "b")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(1))
+			Expect(peers["b"].imported).To(HaveLen(1))
+			Expect(peers["b"].imported[0]).To(ContainSubstring(`"key":"` + owned + `"`))
+			Expect(s.Keys()).To(ConsistOf(other))
+			slots := n.Slots()
+			Expect(slots.Epoch).To(BeEquivalentTo(2))
+			Expect(slots.Migration).To(BeNil())
+			Expect(slots.Owner(Slot(owned))).To(Equal("b"))
+			Expect(peers["b"].slots).To(Equal(slots))
+			Expect(peers["c"].slots).To(Equal(slots))
+		})
+		Specify("moves keys by batches", func() {
+			keys := keysOf("k", 3)
+			value := strings.Repeat("v", migrationBatchBytes/2)
+			for _, key := range keys {
+				Expect(s.Set(key, value, time.Minute)).To(Succeed())
+			}
+			count, err := n.Migrate(0, SlotCount/3-1, "b")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(3))
+			Expect(peers["b"].imports).To(Equal(2))
+			Expect(peers["b"].imported).To(HaveLen(3))
+			Expect(s.Keys()).To(BeEmpty())
+		})
+		Specify("moves keys not valid UTF-8", func() {
+			key := keysOf("\xff", 1)[0]
+			Expect(s.Set(key, "v", time.Minute)).To(Succeed())
+			count, err := n.Migrate(0, SlotCount/3-1, "b")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(1))
+			Expect(peers["b"].imported[0]).To(ContainSubstring(`"encoding":"base64"`))
+			Expect(s.Keys()).To(BeEmpty())
+		})
+		Specify("waits for in-flight requests", func() {
+			release, err := n.Acquire(context.Background(), keyOf(true))
+			Expect(err).ToNot(HaveOccurred())
+			migrated := make(chan error, 1)
+			go func() {
+				_, err := n.Migrate(0, SlotCount/3-1, "b")
+				migrated <- err
+			}()
+			Consistently(migrated).ShouldNot(Receive())
+			release()
+			Eventually(migrated).Should(Receive(BeNil()))
+		})
+	})
+})
+
+type testPeer struct {
+	mutex     sync.Mutex
+	slots     SlotMap
+	imported  []string
+	imports   int
+	err       error
+	importErr error
+}
+
+func (p *testPeer) Slots() (SlotMap, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.slots, p.err
+}
+
+func (p *testPeer) UpdateSlots(m SlotMap) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	if m.Epoch <= p.slots.Epoch {
+		return ErrStaleSlotMap
+	}
+	if m.Migration != nil && p.slots.Migration != nil && *m.Migration != *p.slots.Migration {
+		return ErrMigrationInProgress
+	}
+	p.slots = m
+	return nil
+}
+
+func (p *testPeer) Import(r io.Reader) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.importErr != nil {
+		return 0, p.importErr
+	}
+	records, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	imported := strings.Split(strings.TrimSpace(string(records)), "\n")
+	p.imported = append(p.imported, imported...)
+	p.imports++
+	return len(imported), nil
+}
@@ -0,0 +1,164 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SlotCount is a count of hash slots keys are distributed between
+const SlotCount = 16384
+
+// Slot returns hash slot of key
+func Slot(key string) int {
+	return int(crc32.ChecksumIEEE([]byte(key)) % SlotCount)
+}
+
+// ParseSlotRange parses slot range formatted as single slot "slot" or inclusive range "from-to"
+func ParseSlotRange(s string) (from int, to int, err error) {
+	fromStr, toStr := s, s
+	if i := strings.Index(s, "-"); i >= 0 {
+		fromStr, toStr = s[:i], s[i+1:]
+	}
+	if from, err = strconv.Atoi(fromStr); err != nil {
+		return 0, 0, err
+	}
+	if to, err = strconv.Atoi(toStr); err != nil {
+		return 0, 0, err
+	}
+	if from < 0 || to >= SlotCount || from > to {
+		return 0, 0, fmt.Errorf("invalid slot range %d-%d, must be within 0-%d", from, to, SlotCount-1)
+	}
+	return from, to, nil
+}
+
+// SlotRange is a range of slots from From to To inclusive owned by node with URL Node
+type SlotRange struct {
+	From int    `yaml:"from" json:"from" msgpack:"from"`
+	To   int    `yaml:"to" json:"to" msgpack:"to"`
+	Node string `yaml:"node" json:"node" msgpack:"node"`
+}
+
+// SlotMigration is a migration of slots from From to To inclusive from node with URL Source to node with URL Target
+type SlotMigration struct {
+	From   int    `yaml:"from" json:"from" msgpack:"from"`
+	To     int    `yaml:"to" json:"to" msgpack:"to"`
+	Source string `yaml:"source" json:"source" msgpack:"source"`
+	Target string `yaml:"target" json:"target" msgpack:"target"`
+}
+
+// SlotMap is a slots assignment to cluster nodes. Map with greater epoch replaces map with lower one. Map with
+// migration is a cluster-wide migration lock: other migrations are rejected until map without migration replaces it
+type SlotMap struct {
+	Epoch     uint64         `yaml:"epoch" json:"epoch" msgpack:"epoch"`
+	Ranges    []SlotRange    `yaml:"ranges" json:"ranges" msgpack:"ranges"`
+	Migration *SlotMigration `yaml:"migration,omitempty" json:"migration,omitempty" msgpack:"migration,omitempty"`
+}
+
+// NewSlotMap constructs slot map of zero epoch with slots evenly split between nodes in order of sorted urls
+func NewSlotMap(nodes []string) (SlotMap, error) {
+	if len(nodes) == 0 {
+		return SlotMap{}, errors.New("no nodes")
+	}
+	sorted := append([]string(nil), nodes...)
+	sort.Strings(sorted)
+	var m SlotMap
+	for i, node := range sorted {
+		if i > 0 && node == sorted[i-1] {
+			return SlotMap{}, errors.New("duplicated node " + node)
+		}
+		m.Ranges = append(m.Ranges, SlotRange{
+			From: i * SlotCount / len(sorted),
+			To:   (i+1)*SlotCount/len(sorted) - 1,
+			Node: node,
+		})
+	}
+	return m, nil
+}
+
+// Validate validates slot map: ranges must be sorted and cover all slots without gaps
+func (m SlotMap) Validate() error {
+	next := 0
+	for _, r := range m.Ranges {
+		if r.From != next || r.To < r.From || r.To >= SlotCount {
+			return fmt.Errorf("invalid slot range %d-%d, expected range from %d", r.From, r.To, next)
+		}
+		if r.Node == "" {
+			return fmt.Errorf("no node of slot range %d-%d", r.From, r.To)
+		}
+		next = r.To + 1
+	}
+	if next != SlotCount {
+		return fmt.Errorf("slots from %d are not assigned", next)
+	}
+	if mg := m.Migration; mg != nil {
+		if mg.From < 0 || mg.To >= SlotCount || mg.From > mg.To {
+			return fmt.Errorf("invalid migrated slot range %d-%d", mg.From, mg.To)
+		}
+		if mg.Source == "" || mg.Target == "" {
+			return errors.New("no source or target node of migration")
+		}
+	}
+	return nil
+}
+
+// Owner returns URL of node owning slot. Slot map must be valid
+func (m SlotMap) Owner(slot int) string {
+	i := sort.Search(len(m.Ranges), func(i int) bool {
+		return m.Ranges[i].To >= slot
+	})
+	if i == len(m.Ranges) || m.Ranges[i].From > slot {
+		return ""
+	}
+	return m.Ranges[i].Node
+}
+
+// Nodes returns sorted URLs of nodes owning at least one slot
+func (m SlotMap) Nodes() []string {
+	set := map[string]bool{}
+	for _, r := range m.Ranges {
+		set[r.Node] = true
+	}
+	nodes := make([]string, 0, len(set))
+	for node := range set {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Migrating returns copy of slot map of next epoch with migration mg and same slots assignment
+func (m SlotMap) Migrating(mg SlotMigration) SlotMap {
+	return SlotMap{Epoch: m.Epoch + 1, Ranges: m.Ranges, Migration: &mg}
+}
+
+// Released returns copy of slot map of next epoch without migration and with same slots assignment
+func (m SlotMap) Released() SlotMap {
+	return SlotMap{Epoch: m.Epoch + 1, Ranges: m.Ranges}
+}
+
+// Assign returns copy of slot map of next epoch without migration with slots from from to to inclusive assigned to
+// node. Slot map must be valid
+func (m SlotMap) Assign(from int, to int, node string) SlotMap {
+	owners := make([]string, SlotCount)
+	for _, r := range m.Ranges {
+		for slot := r.From; slot <= r.To; slot++ {
+			owners[slot] = r.Node
+		}
+	}
+	for slot := from; slot <= to; slot++ {
+		owners[slot] = node
+	}
+	assigned := SlotMap{Epoch: m.Epoch + 1}
+	for slot, owner := range owners {
+		if last := len(assigned.Ranges) - 1; last >= 0 && assigned.Ranges[last].Node == owner {
+			assigned.Ranges[last].To = slot
+			continue
+		}
+		assigned.Ranges = append(assigned.Ranges, SlotRange{From: slot, To: slot, Node: owner})
+	}
+	return assigned
+}
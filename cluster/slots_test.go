@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("slots", func() {
+	Specify("Slot", func() {
+		Expect(Slot("a")).To(Equal(Slot("a")))
+		for _, key := range []string{"", "a", "b", "long key"} {
+			Expect(Slot(key)).To(BeNumerically(">=", 0))
+			Expect(Slot(key)).To(BeNumerically("<", SlotCount))
+		}
+	})
+	Describe("ParseSlotRange", func() {
+		Specify("single slot", func() {
+			from, to, err := ParseSlotRange("5")
+			Expect(err).ToNot(HaveOccurred())
+			Expect([]int{from, to}).To(Equal([]int{5, 5}))
+		})
+		Specify("range", func() {
+			from, to, err := ParseSlotRange("5-10")
+			Expect(err).ToNot(HaveOccurred())
+			Expect([]int{from, to}).To(Equal([]int{5, 10}))
+		})
+		Specify("errors", func() {
+			for _, s := range []string{"", "a", "5-", "-5", "10-5", "0-16384"} {
+				_, _, err := ParseSlotRange(s)
+				Expect(err).To(HaveOccurred(), s)
+			}
+		})
+	})
+	Describe("NewSlotMap", func() {
+		Specify("no nodes error", func() {
+			_, err := NewSlotMap(nil)
+			Expect(err).To(MatchError("no nodes"))
+		})
+		Specify("duplicated node error", func() {
+			_, err := NewSlotMap([]string{"a", "b", "a"})
+			Expect(err).To(MatchError("duplicated node a"))
+		})
+		Specify("splits slots evenly between sorted nodes", func() {
+			m, err := NewSlotMap([]string{"c", "a", "b"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).To(Equal(SlotMap{Ranges: []SlotRange{
+				{From: 0, To: 5460, Node: "a"},
+				{From: 5461, To: 10921, Node: "b"},
+				{From: 10922, To: 16383, Node: "c"},
+			}}))
+			Expect(m.Validate()).To(Succeed())
+			Expect(m.Nodes()).To(Equal([]string{"a", "b", "c"}))
+		})
+	})
+	Specify("Validate", func() {
+		Expect(SlotMap{}.Validate()).To(MatchError("slots from 0 are not assigned"))
+		Expect(SlotMap{Ranges: []SlotRange{{From: 1, To: 16383, Node: "a"}}}.Validate()).
+			To(MatchError("invalid slot range 1-16383, expected range from 0"))
+		Expect(SlotMap{Ranges: []SlotRange{{From: 0, To: 16384, Node: "a"}}}.Validate()).
+			To(MatchError("invalid slot range 0-16384, expected range from 0"))
+		Expect(SlotMap{Ranges: []SlotRange{{From: 0, To: 16383}}}.Validate()).
+			To(MatchError("no node of slot range 0-16383"))
+		Expect(SlotMap{Ranges: []SlotRange{{From: 0, To: 100, Node: "a"}}}.Validate()).
+			To(MatchError("slots from 101 are not assigned"))
+		all := []SlotRange{{From: 0, To: 16383, Node: "a"}}
+		Expect(SlotMap{Ranges: all, Migration: &SlotMigration{From: 10, To: 5, Source: "a", Target: "b"}}.Validate()).
+			To(MatchError("invalid migrated slot range 10-5"))
+		Expect(SlotMap{Ranges: all, Migration: &SlotMigration{From: 0, To: 5, Source: "a"}}.Validate()).
+			To(MatchError("no source or target node of migration"))
+	})
+	Specify("Migrating and Released", func() {
+		m, err := NewSlotMap([]string{"a", "b"})
+		Expect(err).ToNot(HaveOccurred())
+		mg := SlotMigration{From: 0, To: 10, Source: "a", Target: "b"}
+		claim := m.Migrating(mg)
+		Expect(claim).To(Equal(SlotMap{Epoch: 1, Ranges: m.Ranges, Migration: &mg}))
+		Expect(claim.Validate()).To(Succeed())
+		Expect(claim.Released()).To(Equal(SlotMap{Epoch: 2, Ranges: m.Ranges}))
+		Expect(claim.Assign(0, 10, "b").Migration).To(BeNil())
+	})
+	Specify("Owner", func() {
+		m, err := NewSlotMap([]string{"a", "b"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m.Owner(0)).To(Equal("a"))
+		Expect(m.Owner(8191)).To(Equal("a"))
+		Expect(m.Owner(8192)).To(Equal("b"))
+		Expect(m.Owner(16383)).To(Equal("b"))
+		Expect(m.Owner(16384)).To(BeEmpty())
+		Expect(m.Owner(-1)).To(BeEmpty())
+	})
+	Specify("Assign", func() {
+		m, err := NewSlotMap([]string{"a", "b"})
+		Expect(err).ToNot(HaveOccurred())
+		assigned := m.Assign(100, 200, "b")
+		Expect(assigned).To(Equal(SlotMap{Epoch: 1, Ranges: []SlotRange{
+			{From: 0, To: 99, Node: "a"},
+			{From: 100, To: 200, Node: "b"},
+			{From: 201, To: 8191, Node: "a"},
+			{From: 8192, To: 16383, Node: "b"},
+		}}))
+		Expect(assigned.Validate()).To(Succeed())
+		Expect(m.Owner(150)).To(Equal("a"))
+
+		By("merging ranges")
+		Expect(assigned.Assign(201, 8191, "b")).To(Equal(SlotMap{Epoch: 2, Ranges: []SlotRange{
+			{From: 0, To: 99, Node: "a"},
+			{From: 100, To: 16383, Node: "b"},
+		}}))
+
+		By("assigning to new node")
+		Expect(assigned.Assign(0, 99, "c").Nodes()).To(Equal([]string{"a", "b", "c"}))
+	})
+})
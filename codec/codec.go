@@ -0,0 +1,148 @@
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v2"
+)
+
+// Codec is a structured HTTP body encoding
+type Codec string
+
+const (
+	// YAML is default human readable encoding
+	YAML Codec = "yaml"
+
+	// JSON is strict human readable encoding without YAML types coercion
+	JSON Codec = "json"
+
+	// MessagePack is compact binary encoding
+	MessagePack Codec = "msgpack"
+)
+
+var (
+	// ErrUnsupportedMediaType is returned if content type has no codec
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+	// ErrNotAcceptable is returned if no codec is acceptable
+	ErrNotAcceptable = errors.New("not acceptable")
+)
+
+// contentTypes are content types of codecs
+var contentTypes = map[Codec]string{
+	YAML:        "application/yaml",
+	JSON:        "application/json",
+	MessagePack: "application/msgpack",
+}
+
+// mediaTypes are codecs of media types including widespread unofficial ones
+var mediaTypes = map[string]Codec{
+	"application/yaml":        YAML,
+	"application/x-yaml":      YAML,
+	"text/yaml":               YAML,
+	"text/x-yaml":             YAML,
+	"application/json":        JSON,
+	"application/msgpack":     MessagePack,
+	"application/x-msgpack":   MessagePack,
+	"application/vnd.msgpack": MessagePack,
+}
+
+// Validate validates codec
+func (c Codec) Validate() error {
+	switch c {
+	case YAML, JSON, MessagePack:
+		return nil
+	}
+	return fmt.Errorf(`unknown codec "%s", must be one of: yaml, json, msgpack`, c)
+}
+
+// ContentType returns codec's content type
+func (c Codec) ContentType() string {
+	return contentTypes[c]
+}
+
+// Marshal encodes v
+func (c Codec) Marshal(v interface{}) ([]byte, error) {
+	switch c {
+	case YAML:
+		return yaml.Marshal(v)
+	case JSON:
+		return json.Marshal(v)
+	case MessagePack:
+		return msgpack.Marshal(v)
+	}
+	return nil, c.Validate()
+}
+
+// Unmarshal decodes data into v. Empty data is decoded as null
+func (c Codec) Unmarshal(data []byte, v interface{}) error {
+	if len(data) == 0 && c != YAML {
+		// keeping YAML behaviour, where empty document is null
+		return nil
+	}
+	switch c {
+	case YAML:
+		return yaml.Unmarshal(data, v)
+	case JSON:
+		return json.Unmarshal(data, v)
+	case MessagePack:
+		return msgpack.Unmarshal(data, v)
+	}
+	return c.Validate()
+}
+
+// ForContentType returns codec of Content-Type header value. Empty content type is YAML. Errors with
+// ErrUnsupportedMediaType if content type has no codec
+func ForContentType(contentType string) (Codec, error) {
+	if strings.TrimSpace(contentType) == "" {
+		return YAML, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", ErrUnsupportedMediaType
+	}
+	c, exists := mediaTypes[mediaType]
+	if !exists {
+		return "", ErrUnsupportedMediaType
+	}
+	return c, nil
+}
+
+// Negotiate returns codec most preferred by Accept header value. Empty header and wildcards are YAML. Errors with
+// ErrNotAcceptable if no codec is acceptable
+func Negotiate(accept string) (Codec, error) {
+	if strings.TrimSpace(accept) == "" {
+		return YAML, nil
+	}
+	var best Codec
+	bestQ := 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qStr, exists := params["q"]; exists {
+			if q, err = strconv.ParseFloat(qStr, 64); err != nil {
+				continue
+			}
+		}
+		c, exists := mediaTypes[mediaType]
+		if mediaType == "*/*" || mediaType == "application/*" {
+			c, exists = YAML, true
+		}
+		if exists && q > bestQ {
+			best, bestQ = c, q
+		}
+	}
+	if best == "" {
+		return "", ErrNotAcceptable
+	}
+	return best, nil
+}
@@ -0,0 +1,77 @@
+package codec
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Codec", func() {
+	Specify("unknown codec error", func() {
+		Expect(Codec("xml").Validate()).To(MatchError(`unknown codec "xml", must be one of: yaml, json, msgpack`))
+		_, err := Codec("xml").Marshal("a")
+		Expect(err).To(HaveOccurred())
+		Expect(Codec("xml").Unmarshal([]byte("a"), new(string))).ToNot(Succeed())
+	})
+	Specify("content types", func() {
+		Expect(YAML.ContentType()).To(Equal("application/yaml"))
+		Expect(JSON.ContentType()).To(Equal("application/json"))
+		Expect(MessagePack.ContentType()).To(Equal("application/msgpack"))
+	})
+	Specify("values are not coerced", func() {
+		for _, c := range []Codec{YAML, JSON, MessagePack} {
+			data, err := c.Marshal(map[string]string{"a": "yes", "b": "1.0"})
+			Expect(err).ToNot(HaveOccurred())
+			var dict map[string]string
+			Expect(c.Unmarshal(data, &dict)).To(Succeed())
+			Expect(dict).To(Equal(map[string]string{"a": "yes", "b": "1.0"}), string(c))
+		}
+	})
+	Specify("empty data is null", func() {
+		for _, c := range []Codec{YAML, JSON, MessagePack} {
+			var list []string
+			Expect(c.Unmarshal(nil, &list)).To(Succeed())
+			Expect(list).To(BeNil())
+		}
+	})
+	Specify("JSON syntax error", func() {
+		var list []string
+		Expect(JSON.Unmarshal([]byte("- a"), &list)).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("ForContentType", func() {
+	Specify("YAML if content type is empty", func() {
+		Expect(ForContentType("")).To(Equal(YAML))
+	})
+	Specify("codecs of media types", func() {
+		Expect(ForContentType("application/x-yaml")).To(Equal(YAML))
+		Expect(ForContentType("application/json; charset=utf-8")).To(Equal(JSON))
+		Expect(ForContentType("application/vnd.msgpack")).To(Equal(MessagePack))
+	})
+	Specify("unsupported media type error", func() {
+		_, err := ForContentType("text/plain")
+		Expect(err).To(MatchError(ErrUnsupportedMediaType))
+		_, err = ForContentType("application/")
+		Expect(err).To(MatchError(ErrUnsupportedMediaType))
+	})
+})
+
+var _ = Describe("Negotiate", func() {
+	Specify("YAML if header is empty or has wildcards", func() {
+		Expect(Negotiate("")).To(Equal(YAML))
+		Expect(Negotiate("*/*")).To(Equal(YAML))
+		Expect(Negotiate("text/html, application/*;q=0.1")).To(Equal(YAML))
+	})
+	Specify("most preferred codec", func() {
+		Expect(Negotiate("application/json")).To(Equal(JSON))
+		Expect(Negotiate("application/json;q=0.5, application/msgpack")).To(Equal(MessagePack))
+		Expect(Negotiate("application/yaml, application/json")).To(Equal(YAML))
+		Expect(Negotiate("*/*;q=0.1, application/json;q=0.2")).To(Equal(JSON))
+	})
+	Specify("not acceptable error", func() {
+		_, err := Negotiate("text/html")
+		Expect(err).To(MatchError(ErrNotAcceptable))
+		_, err = Negotiate("application/json;q=0")
+		Expect(err).To(MatchError(ErrNotAcceptable))
+	})
+})
@@ -0,0 +1,13 @@
+package consensus
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestConsensus(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Consensus Suite")
+}
@@ -0,0 +1,192 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/someanon/yamc/store"
+)
+
+// commandOp is a replicated command operation
+type commandOp string
+
+const (
+	setOp        commandOp = "set"
+	addOp        commandOp = "add"
+	replaceOp    commandOp = "replace"
+	appendOp     commandOp = "append"
+	prependOp    commandOp = "prepend"
+	casOp        commandOp = "cas"
+	incrOp       commandOp = "incr"
+	decrOp       commandOp = "decr"
+	listSetOp    commandOp = "list_set"
+	dictSetOp    commandOp = "dict_set"
+	listPushOp   commandOp = "list_push"
+	dictUpdateOp commandOp = "dict_update"
+	expireOp     commandOp = "expire"
+	removeOp     commandOp = "remove"
+	keyRemoveOp  commandOp = "key_remove"
+	listRemoveOp commandOp = "list_remove"
+	dictRemoveOp commandOp = "dict_remove"
+	importOp     commandOp = "import"
+	flushOp      commandOp = "flush"
+	cleanOp      commandOp = "clean"
+)
+
+// command is a raft log entry of store mutation. Command is gob encoded, so binary keys and values are kept as is.
+// Command is applied at its Time, which is leader's time of commit, and Expiry is absolute, so all members check
+// expiry and set same expiry regardless of when command is applied. Command of account is applied by store's view of
+// Owner limited by Quota
+type command struct {
+	Op      commandOp
+	Key     string
+	Value   string
+	List    []string
+	Dict    map[string]string
+	Time    time.Time
+	Expiry  time.Time
+	Version uint64
+	Delta   uint64
+	Data    []byte
+	Owner   string
+	Quota   store.Quota
+}
+
+// result is a result of applied command
+type result struct {
+	count  int
+	number uint64
+	err    error
+}
+
+// fsm is a raft finite state machine applying commands to store. Commands are applied at time of last applied command,
+// which never goes back even if leader's clock does, so applying is same on all members. Snapshots are written by
+// store's dumper prefixed with time of last applied command
+type fsm struct {
+	store store.TimedStore
+	last  time.Time
+}
+
+// Apply applies committed command to store
+func (f *fsm) Apply(l *raft.Log) interface{} {
+	var cmd command
+	if err := gob.NewDecoder(bytes.NewReader(l.Data)).Decode(&cmd); err != nil {
+		return result{err: errors.New("invalid command: " + err.Error())}
+	}
+	if cmd.Time.After(f.last) {
+		f.last = cmd.Time
+	}
+	ts := f.store.At(f.last)
+	var s store.Store = ts
+	if cmd.Owner != "" {
+		s = ts.As(cmd.Owner, cmd.Quota)
+	}
+	ttl := cmd.Expiry.Sub(f.last)
+	switch cmd.Op {
+	case setOp:
+		return result{err: s.Set(cmd.Key, cmd.Value, ttl)}
+	case addOp:
+		return result{err: s.Add(cmd.Key, cmd.Value, ttl)}
+	case replaceOp:
+		return result{err: s.Replace(cmd.Key, cmd.Value, ttl)}
+	case appendOp:
+		return result{err: s.Append(cmd.Key, cmd.Value)}
+	case prependOp:
+		return result{err: s.Prepend(cmd.Key, cmd.Value)}
+	case casOp:
+		return result{err: s.CompareAndSet(cmd.Key, cmd.Value, ttl, cmd.Version)}
+	case incrOp:
+		n, err := s.Incr(cmd.Key, cmd.Delta)
+		return result{number: n, err: err}
+	case decrOp:
+		n, err := s.Decr(cmd.Key, cmd.Delta)
+		return result{number: n, err: err}
+	case listSetOp:
+		return result{err: s.ListSet(cmd.Key, cmd.List, ttl)}
+	case dictSetOp:
+		return result{err: s.DictSet(cmd.Key, cmd.Dict, ttl)}
+	case listPushOp:
+		n, err := s.ListPush(cmd.Key, cmd.List, ttl)
+		return result{count: n, err: err}
+	case dictUpdateOp:
+		n, err := s.DictUpdate(cmd.Key, cmd.Dict, ttl)
+		return result{count: n, err: err}
+	case expireOp:
+		return result{err: s.Expire(cmd.Key, ttl)}
+	case removeOp:
+		return result{err: s.Remove(cmd.Key)}
+	case keyRemoveOp:
+		return result{err: s.KeyRemove(cmd.Key)}
+	case listRemoveOp:
+		return result{err: s.ListRemove(cmd.Key)}
+	case dictRemoveOp:
+		return result{err: s.DictRemove(cmd.Key)}
+	case importOp:
+		n, err := s.Import(bytes.NewReader(cmd.Data))
+		return result{count: n, err: err}
+	case flushOp:
+		return result{err: s.Flush()}
+	case cleanOp:
+		ts.Clean()
+		return result{}
+	}
+	return result{err: errors.New(`unknown command operation "` + string(cmd.Op) + `"`)}
+}
+
+// Snapshot returns snapshot of store's point-in-time checkpoint at time of last applied command
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	checkpoint, err := f.store.At(f.last).Checkpoint()
+	if err != nil {
+		return nil, err
+	}
+	return snapshot{last: f.last, checkpoint: checkpoint}, nil
+}
+
+// Restore replaces all store items with snapshot read from rc
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	var nanos int64
+	if err := binary.Read(rc, binary.BigEndian, &nanos); err != nil {
+		return errors.New("failed to read snapshot time: " + err.Error())
+	}
+	var last time.Time
+	if nanos != 0 {
+		last = time.Unix(0, nanos)
+	}
+	if err := f.store.At(last).Load(rc); err != nil {
+		return err
+	}
+	f.last = last
+	return nil
+}
+
+// snapshot is a raft snapshot of store's checkpoint at time of last applied command
+type snapshot struct {
+	last       time.Time
+	checkpoint store.Checkpoint
+}
+
+// Persist writes time of last applied command and checkpoint to sink
+func (s snapshot) Persist(sink raft.SnapshotSink) error {
+	var nanos int64
+	if !s.last.IsZero() {
+		nanos = s.last.UnixNano()
+	}
+	if err := binary.Write(sink, binary.BigEndian, nanos); err != nil {
+		sink.Cancel()
+		return err
+	}
+	if err := s.checkpoint.Write(sink); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+// Release does nothing, checkpoint is garbage collected
+func (s snapshot) Release() {}
@@ -0,0 +1,105 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"time"
+
+	"github.com/hashicorp/raft"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/someanon/yamc/store"
+)
+
+var _ = Describe("fsm", func() {
+	var f *fsm
+	newTimedStore := func() store.TimedStore {
+		d, err := store.NewStreamDumper(store.GobFormat, store.NoCompression)
+		Expect(err).ToNot(HaveOccurred())
+		s, err := store.NewStore(store.Params{CleaningPeriod: time.Second, DumpingPeriod: time.Minute},
+			store.SystemClock{}, d)
+		Expect(err).ToNot(HaveOccurred())
+		return s.(store.TimedStore)
+	}
+	apply := func(cmd command) result {
+		var data bytes.Buffer
+		Expect(gob.NewEncoder(&data).Encode(cmd)).To(Succeed())
+		return f.Apply(&raft.Log{Data: data.Bytes()}).(result)
+	}
+	BeforeEach(func() {
+		f = &fsm{store: newTimedStore()}
+	})
+	AfterEach(func() {
+		f.store.Close(false)
+	})
+	Specify("invalid command error", func() {
+		res := f.Apply(&raft.Log{Data: []byte(`{`)})
+		Expect(res.(result).err).To(MatchError(HavePrefix("invalid command: ")))
+	})
+	Specify("unknown operation error", func() {
+		Expect(apply(command{Op: "unknown"}).err).To(MatchError(`unknown command operation "unknown"`))
+	})
+	Specify("applies commands at time of command", func() {
+		started := time.Now().Add(-time.Hour).Round(0)
+		Expect(apply(command{Op: setOp, Key: "a", Value: "v", Time: started, Expiry: started.Add(time.Minute)}).err).
+			To(Succeed())
+		Expect(apply(command{Op: addOp, Key: "a", Value: "v2", Time: started.Add(time.Second),
+			Expiry: started.Add(time.Minute)}).err).To(MatchError(store.ErrKeyExists))
+		Expect(f.last).To(Equal(started.Add(time.Second)))
+
+		By("not going back in time")
+		Expect(apply(command{Op: incrOp, Key: "a", Delta: 1, Time: started}).err).To(MatchError(store.ErrNotNumber))
+		Expect(f.last).To(Equal(started.Add(time.Second)))
+
+		By("expiring at command time")
+		Expect(apply(command{Op: addOp, Key: "a", Value: "v2", Time: started.Add(2 * time.Minute),
+			Expiry: started.Add(3 * time.Minute)}).err).To(Succeed())
+
+		By("cleaning at command time")
+		Expect(apply(command{Op: cleanOp, Time: started.Add(4 * time.Minute)}).err).To(Succeed())
+		Expect(f.store.Usage().Items).To(BeZero())
+	})
+	Specify("applies command of account by store's view of account", func() {
+		now := time.Now()
+		q := store.Quota{MaxKeys: 1}
+		Expect(apply(command{Op: setOp, Key: "a", Value: "v", Time: now, Expiry: now.Add(time.Minute), Owner: "o",
+			Quota: q}).err).To(Succeed())
+		Expect(apply(command{Op: setOp, Key: "b", Value: "v", Time: now, Expiry: now.Add(time.Minute), Owner: "o",
+			Quota: q}).err).To(MatchError(store.ErrKeysQuotaExceeded))
+		Expect(f.store.UsageOf("o").Items).To(Equal(1))
+	})
+	Specify("restores snapshot at time of last applied command", func() {
+		started := time.Now().Add(-time.Hour).Round(0)
+		Expect(apply(command{Op: setOp, Key: "\xff", Value: "\x00\xfe", Time: started,
+			Expiry: started.Add(time.Minute)}).err).To(Succeed())
+		snap, err := f.Snapshot()
+		Expect(err).ToNot(HaveOccurred())
+		sink := &testSink{}
+		Expect(snap.Persist(sink)).To(Succeed())
+
+		restored := &fsm{store: newTimedStore()}
+		defer restored.store.Close(false)
+		Expect(restored.Restore(ioutil.NopCloser(&sink.Buffer))).To(Succeed())
+		Expect(restored.last).To(Equal(started))
+		Expect(restored.store.Usage().Items).To(Equal(1))
+		Expect(restored.store.At(started).Get("\xff")).To(Equal("\x00\xfe"))
+	})
+})
+
+// testSink is a raft snapshot sink writing to buffer
+type testSink struct {
+	bytes.Buffer
+}
+
+func (s *testSink) ID() string {
+	return "test"
+}
+
+func (s *testSink) Cancel() error {
+	return nil
+}
+
+func (s *testSink) Close() error {
+	return nil
+}
@@ -0,0 +1,448 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/someanon/yamc/store"
+)
+
+const (
+	// applyTimeout is max time of command enqueuing on leader
+	applyTimeout = 10 * time.Second
+
+	// snapshotsRetain is count of kept raft snapshots
+	snapshotsRetain = 2
+
+	// transport params
+	transportMaxPool = 3
+	transportTimeout = 10 * time.Second
+)
+
+var (
+	// consensus errors
+	ErrNotLeader       = errors.New("not leader")
+	ErrNoSelf          = errors.New("self is not a peer")
+	ErrNotTimedStore   = errors.New("store can't apply mutations at given time")
+	ErrInvalidCleaning = errors.New("invalid cleaning period")
+)
+
+// Peer is a consensus cluster member
+type Peer struct {
+	// URL is member's HTTP API URL. It is used as raft server ID, so followers know where to forward requests to
+	URL string
+
+	// Addr is member's raft transport address, host:port
+	Addr string
+}
+
+// Config is a consensus store config
+type Config struct {
+	// Self is this member's URL
+	Self string
+
+	// Peers is all cluster members including this one. Cluster is bootstrapped with peers on first start
+	Peers []Peer
+
+	// Dir is a directory of raft log and snapshots
+	Dir string
+
+	// CleaningPeriod is a period of expired items cleaning commands committed by leader
+	CleaningPeriod time.Duration
+
+	// LogOutput is raft logs output, os.Stderr if nil
+	LogOutput io.Writer
+}
+
+// Store is a consensus replicated store. Mutations are committed to raft log by leader and applied to local stores
+// of all members, so committed mutations survive loss of cluster minority. Mutations are accepted by leader only,
+// followers error with ErrNotLeader. Reads are served by local store, so follower reads may be stale. Raft snapshots
+// are the only persisted state of local store, so local store must not load or dump file. Views of store made by As
+// share member and commit mutations owned by account
+type Store struct {
+	*member
+	owner string
+	quota store.Quota
+}
+
+// member is a consensus cluster member state
+type member struct {
+	store.Store
+	raft           *raft.Raft
+	closers        []io.Closer
+	cleaningPeriod time.Duration
+
+	mutex      sync.Mutex
+	cleaning   chan struct{}
+	cleaned    chan struct{}
+	dumpStatus store.DumpStatus
+}
+
+// NewStore constructs consensus store of member with config c applying mutations to s, which must be constructed by
+// store.NewStore with store.StreamDumper. Raft log is kept in Dir, members communicate over TCP
+func NewStore(c Config, s store.Store) (*Store, error) {
+	self, err := c.self()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return nil, errors.New("failed to create raft dir: " + err.Error())
+	}
+	logs, err := raftboltdb.NewBoltStore(filepath.Join(c.Dir, "raft.db"))
+	if err != nil {
+		return nil, errors.New("failed to open raft log: " + err.Error())
+	}
+	snaps, err := raft.NewFileSnapshotStore(c.Dir, snapshotsRetain, c.logOutput())
+	if err != nil {
+		logs.Close()
+		return nil, errors.New("failed to open raft snapshots: " + err.Error())
+	}
+	addr, err := net.ResolveTCPAddr("tcp", self.Addr)
+	if err != nil {
+		logs.Close()
+		return nil, errors.New("failed to resolve raft address: " + err.Error())
+	}
+	trans, err := raft.NewTCPTransport(self.Addr, addr, transportMaxPool, transportTimeout, c.logOutput())
+	if err != nil {
+		logs.Close()
+		return nil, errors.New("failed to listen raft address: " + err.Error())
+	}
+	cs, err := newStore(c, raft.DefaultConfig(), s, logs, logs, snaps, trans)
+	if err != nil {
+		trans.Close()
+		logs.Close()
+		return nil, err
+	}
+	cs.closers = append(cs.closers, trans, logs)
+	return cs, nil
+}
+
+// newStore constructs consensus store with raft config rc, log, stable and snapshot stores and transport. Cluster is
+// bootstrapped if there is no raft state yet
+func newStore(c Config, rc *raft.Config, s store.Store, logs raft.LogStore, stable raft.StableStore,
+	snaps raft.SnapshotStore, trans raft.Transport) (*Store, error) {
+	if _, err := c.self(); err != nil {
+		return nil, err
+	}
+	ts, ok := s.(store.TimedStore)
+	if !ok {
+		return nil, ErrNotTimedStore
+	}
+	rc.LocalID = raft.ServerID(c.Self)
+	rc.LogOutput = c.logOutput()
+	bootstrapped, err := raft.HasExistingState(logs, stable, snaps)
+	if err != nil {
+		return nil, errors.New("failed to read raft state: " + err.Error())
+	}
+	r, err := raft.NewRaft(rc, &fsm{store: ts}, logs, stable, snaps, trans)
+	if err != nil {
+		return nil, errors.New("failed to start raft: " + err.Error())
+	}
+	if !bootstrapped {
+		var configuration raft.Configuration
+		for _, p := range c.Peers {
+			configuration.Servers = append(configuration.Servers, raft.Server{
+				ID:      raft.ServerID(p.URL),
+				Address: raft.ServerAddress(p.Addr),
+			})
+		}
+		// every member bootstraps same configuration, so one of them is elected regardless of start order
+		if err := r.BootstrapCluster(configuration).Error(); err != nil && err != raft.ErrCantBootstrap {
+			r.Shutdown()
+			return nil, errors.New("failed to bootstrap raft cluster: " + err.Error())
+		}
+	}
+	return &Store{member: &member{Store: s, raft: r, cleaningPeriod: c.CleaningPeriod}}, nil
+}
+
+// self returns config's peer of this member
+func (c Config) self() (Peer, error) {
+	for _, p := range c.Peers {
+		if p.URL == c.Self {
+			return p, nil
+		}
+	}
+	return Peer{}, ErrNoSelf
+}
+
+// logOutput returns raft logs output
+func (c Config) logOutput() io.Writer {
+	if c.LogOutput == nil {
+		return os.Stderr
+	}
+	return c.LogOutput
+}
+
+// Leader returns leader's URL, empty if leader is unknown, and true if this member is leader
+func (s *Store) Leader() (string, bool) {
+	_, id := s.raft.LeaderWithID()
+	return string(id), s.raft.State() == raft.Leader
+}
+
+// Set sets key to value with time to live ttl
+func (s *Store) Set(key string, value string, ttl time.Duration) error {
+	_, err := s.apply(command{Op: setOp, Key: key, Value: value, Expiry: time.Now().Add(ttl)})
+	return err
+}
+
+// Add sets key to value with time to live ttl if key is not exists
+func (s *Store) Add(key string, value string, ttl time.Duration) error {
+	_, err := s.apply(command{Op: addOp, Key: key, Value: value, Expiry: time.Now().Add(ttl)})
+	return err
+}
+
+// Replace sets key to value with time to live ttl if key exists
+func (s *Store) Replace(key string, value string, ttl time.Duration) error {
+	_, err := s.apply(command{Op: replaceOp, Key: key, Value: value, Expiry: time.Now().Add(ttl)})
+	return err
+}
+
+// Append appends value to value of key
+func (s *Store) Append(key string, value string) error {
+	_, err := s.apply(command{Op: appendOp, Key: key, Value: value})
+	return err
+}
+
+// Prepend prepends value to value of key
+func (s *Store) Prepend(key string, value string) error {
+	_, err := s.apply(command{Op: prependOp, Key: key, Value: value})
+	return err
+}
+
+// CompareAndSet sets key to value with time to live ttl if key's version equals version
+func (s *Store) CompareAndSet(key string, value string, ttl time.Duration, version uint64) error {
+	_, err := s.apply(command{Op: casOp, Key: key, Value: value, Expiry: time.Now().Add(ttl), Version: version})
+	return err
+}
+
+// Incr increments numeric value of key by delta. Returns incremented value
+func (s *Store) Incr(key string, delta uint64) (uint64, error) {
+	res, err := s.applyResult(command{Op: incrOp, Key: key, Delta: delta})
+	return res.number, err
+}
+
+// Decr decrements numeric value of key by delta. Returns decremented value
+func (s *Store) Decr(key string, delta uint64) (uint64, error) {
+	res, err := s.applyResult(command{Op: decrOp, Key: key, Delta: delta})
+	return res.number, err
+}
+
+// ListSet sets list to the key with time to live ttl
+func (s *Store) ListSet(key string, list []string, ttl time.Duration) error {
+	_, err := s.apply(command{Op: listSetOp, Key: key, List: list, Expiry: time.Now().Add(ttl)})
+	return err
+}
+
+// DictSet sets dict to the key with time to live ttl
+func (s *Store) DictSet(key string, dict map[string]string, ttl time.Duration) error {
+	_, err := s.apply(command{Op: dictSetOp, Key: key, Dict: dict, Expiry: time.Now().Add(ttl)})
+	return err
+}
+
+// ListPush inserts values to the head of list by key. Returns list length
+func (s *Store) ListPush(key string, values []string, ttl time.Duration) (int, error) {
+	return s.apply(command{Op: listPushOp, Key: key, List: values, Expiry: time.Now().Add(ttl)})
+}
+
+// DictUpdate sets fields of dict to dict by key. Returns count of added fields
+func (s *Store) DictUpdate(key string, dict map[string]string, ttl time.Duration) (int, error) {
+	return s.apply(command{Op: dictUpdateOp, Key: key, Dict: dict, Expiry: time.Now().Add(ttl)})
+}
+
+// Expire sets time to live ttl of item by key
+func (s *Store) Expire(key string, ttl time.Duration) error {
+	_, err := s.apply(command{Op: expireOp, Key: key, Expiry: time.Now().Add(ttl)})
+	return err
+}
+
+// Remove removes item by key
+func (s *Store) Remove(key string) error {
+	_, err := s.apply(command{Op: removeOp, Key: key})
+	return err
+}
+
+// KeyRemove removes key item by key
+func (s *Store) KeyRemove(key string) error {
+	_, err := s.apply(command{Op: keyRemoveOp, Key: key})
+	return err
+}
+
+// ListRemove removes list item by key
+func (s *Store) ListRemove(key string) error {
+	_, err := s.apply(command{Op: listRemoveOp, Key: key})
+	return err
+}
+
+// DictRemove removes dict item by key
+func (s *Store) DictRemove(key string) error {
+	_, err := s.apply(command{Op: dictRemoveOp, Key: key})
+	return err
+}
+
+// As returns view of store which commits mutations making items owned by account of login and limited by quota q
+func (s *Store) As(login string, q store.Quota) store.Store {
+	return &Store{member: s.member, owner: login, quota: q}
+}
+
+// Import reads newline delimited JSON records from r and commits them as single command. Returns imported items
+// count
+func (s *Store) Import(r io.Reader) (int, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, errors.New("failed to read records: " + err.Error())
+	}
+	return s.apply(command{Op: importOp, Data: data})
+}
+
+// Flush removes all items
+func (s *Store) Flush() error {
+	_, err := s.apply(command{Op: flushOp})
+	return err
+}
+
+// StartCleaning starts periodical committing of expired items cleaning command while member is leader, so items are
+// cleaned at same time on all members. Can be called multiple times
+func (s *Store) StartCleaning() error {
+	if s.cleaningPeriod <= 0 {
+		return ErrInvalidCleaning
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.cleaning != nil {
+		return nil
+	}
+	s.cleaning, s.cleaned = make(chan struct{}), make(chan struct{})
+	go s.clean(s.cleaning, s.cleaned)
+	return nil
+}
+
+// StopCleaning stops periodical cleaning and waits for in-progress cleaning command. Can be called multiple times
+func (s *Store) StopCleaning() error {
+	s.mutex.Lock()
+	cleaning, cleaned := s.cleaning, s.cleaned
+	s.cleaning, s.cleaned = nil, nil
+	s.mutex.Unlock()
+	if cleaning == nil {
+		return store.ErrCleaningNotStartedYet
+	}
+	close(cleaning)
+	<-cleaned
+	return nil
+}
+
+// clean commits cleaning command each cleaning period while member is leader until stop is closed, then closes done
+func (s *Store) clean(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	t := time.NewTicker(s.cleaningPeriod)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if s.raft.State() == raft.Leader {
+				s.apply(command{Op: cleanOp})
+			}
+		}
+	}
+}
+
+// Dump takes raft snapshot, which is the only persisted state of member. Returns dumping status after snapshot and
+// snapshot error
+func (s *Store) Dump() (store.DumpStatus, error) {
+	started := time.Now()
+	err := s.snapshot()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	status := s.dumpStatus
+	status.Time = started
+	status.Duration = time.Since(started)
+	if err != nil {
+		status.Error = err.Error()
+		status.Failures++
+	} else {
+		status.Error = ""
+		status.LastSuccess = started
+		status.Failures = 0
+	}
+	s.dumpStatus = status
+	return status, err
+}
+
+// Info returns local store's state summary with status of consensus cleaning and of last snapshot taken by Dump
+func (s *Store) Info() store.Info {
+	info := s.Store.Info()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	info.Cleaning = s.cleaning != nil
+	info.Dump = s.dumpStatus
+	return info
+}
+
+// DumpStatus returns status of last snapshot taken by Dump
+func (s *Store) DumpStatus() store.DumpStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.dumpStatus
+}
+
+// Close stops cleaning and raft member, then closes local store. If dump is true raft snapshot is taken before
+// shutdown, so member restarts from it. Cluster membership is kept, so member catches up on restart
+func (s *Store) Close(dump bool) error {
+	s.StopCleaning()
+	var dumpErr error
+	if dump {
+		dumpErr = s.snapshot()
+	}
+	if err := s.raft.Shutdown().Error(); err != nil {
+		return errors.New("failed to shutdown raft: " + err.Error())
+	}
+	for _, c := range s.closers {
+		c.Close()
+	}
+	if err := s.Store.Close(false); err != nil {
+		return err
+	}
+	return dumpErr
+}
+
+// snapshot takes raft snapshot of local store
+func (s *Store) snapshot() error {
+	if err := s.raft.Snapshot().Error(); err != nil && err != raft.ErrNothingNewToSnapshot {
+		return errors.New("failed to take raft snapshot: " + err.Error())
+	}
+	return nil
+}
+
+// apply commits command cmd and waits until it is applied to local store. Returns applied command count result
+func (s *Store) apply(cmd command) (int, error) {
+	res, err := s.applyResult(cmd)
+	return res.count, err
+}
+
+// applyResult commits command cmd and waits until it is applied to local store. Returns applied command result
+func (s *Store) applyResult(cmd command) (result, error) {
+	cmd.Time, cmd.Owner, cmd.Quota = time.Now(), s.owner, s.quota
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(cmd); err != nil {
+		return result{}, errors.New("failed to encode command: " + err.Error())
+	}
+	f := s.raft.Apply(data.Bytes(), applyTimeout)
+	if err := f.Error(); err == raft.ErrNotLeader {
+		return result{}, ErrNotLeader
+	} else if err != nil {
+		return result{}, errors.New("failed to commit command: " + err.Error())
+	}
+	res := f.Response().(result)
+	return res, res.err
+}
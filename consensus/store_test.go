@@ -0,0 +1,302 @@
+package consensus
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/someanon/yamc/store"
+)
+
+var _ = Describe("Store", func() {
+	var (
+		dir     string
+		members []*testMember
+		peers   []Peer
+	)
+	newLocalStore := func() store.Store {
+		d, err := store.NewStreamDumper(store.GobFormat, store.NoCompression)
+		Expect(err).ToNot(HaveOccurred())
+		s, err := store.NewStore(store.Params{CleaningPeriod: time.Second, DumpingPeriod: time.Minute},
+			store.SystemClock{}, d)
+		Expect(err).ToNot(HaveOccurred())
+		return s
+	}
+	// start starts member i with its raft state kept between restarts
+	start := func(i int) {
+		m := members[i]
+		rc := raft.DefaultConfig()
+		rc.HeartbeatTimeout = 50 * time.Millisecond
+		rc.ElectionTimeout = 50 * time.Millisecond
+		rc.LeaderLeaseTimeout = 50 * time.Millisecond
+		rc.CommitTimeout = 5 * time.Millisecond
+		rc.TrailingLogs = 1
+		var err error
+		m.local = newLocalStore()
+		m.store, err = newStore(Config{Self: peers[i].URL, Peers: peers, CleaningPeriod: 100 * time.Millisecond,
+			LogOutput: ioutil.Discard}, rc, m.local, m.logs, m.stable, m.snaps, m.trans)
+		Expect(err).ToNot(HaveOccurred())
+	}
+	// stop stops member i and disconnects it from others, taking raft snapshot if dump is true
+	stop := func(i int, dump bool) {
+		Expect(members[i].store.Close(dump)).To(Succeed())
+		members[i].store = nil
+	}
+	// connect connects transports of all members, which are disconnected when all members are stopped
+	connect := func() {
+		for _, m := range members {
+			for j, other := range members {
+				m.trans.Connect(raft.ServerAddress(peers[j].Addr), other.trans)
+			}
+		}
+	}
+	// leader waits for single leader elected among running members and returns its index
+	leader := func() int {
+		index := -1
+		EventuallyWithOffset(1, func() int {
+			count := 0
+			for i, m := range members {
+				if m.store == nil {
+					continue
+				}
+				if _, isLeader := m.store.Leader(); isLeader {
+					index = i
+					count++
+				}
+			}
+			return count
+		}, 5*time.Second).Should(Equal(1))
+		return index
+	}
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "yamc-consensus")
+		Expect(err).ToNot(HaveOccurred())
+		members, peers = nil, nil
+		for i := 0; i < 3; i++ {
+			addr, trans := raft.NewInmemTransport("")
+			members = append(members, &testMember{
+				logs:   raft.NewInmemStore(),
+				stable: raft.NewInmemStore(),
+				snaps:  raft.NewInmemSnapshotStore(),
+				trans:  trans,
+			})
+			peers = append(peers, Peer{URL: fmt.Sprintf("http://node%d", i), Addr: string(addr)})
+		}
+		connect()
+		for i := range members {
+			start(i)
+		}
+	})
+	AfterEach(func() {
+		for _, m := range members {
+			if m.store != nil {
+				m.store.Close(false)
+			}
+		}
+		os.RemoveAll(dir)
+	})
+	Specify("no self peer error", func() {
+		_, err := NewStore(Config{Self: "http://other", Peers: peers}, newLocalStore())
+		Expect(err).To(MatchError(ErrNoSelf))
+	})
+	Specify("not timed store error", func() {
+		_, err := newStore(Config{Self: peers[0].URL, Peers: peers}, raft.DefaultConfig(),
+			struct{ store.Store }{newLocalStore()}, members[0].logs, members[0].stable, members[0].snaps,
+			members[0].trans)
+		Expect(err).To(MatchError(ErrNotTimedStore))
+	})
+	Specify("mutations are applied on all members", func() {
+		l := members[leader()].store
+		Expect(l.Set("a", "v", time.Minute)).To(Succeed())
+		Expect(l.ListSet("b", []string{"l1", "l2"}, time.Minute)).To(Succeed())
+		Expect(l.DictSet("c", map[string]string{"dk": "dv"}, time.Minute)).To(Succeed())
+		Expect(l.Set("d", "v", time.Minute)).To(Succeed())
+		Expect(l.Remove("d")).To(Succeed())
+		Expect(l.Remove("d")).To(MatchError(store.ErrKeyNotExists))
+		Expect(l.ListRemove("a")).To(MatchError(store.ErrNotListItem))
+		Expect(l.Set("h", "v", time.Minute)).To(Succeed())
+		Expect(l.KeyRemove("h")).To(Succeed())
+		Expect(l.ListSet("h", []string{"l"}, time.Minute)).To(Succeed())
+		Expect(l.ListRemove("h")).To(Succeed())
+		Expect(l.DictSet("h", map[string]string{"dk": "dv"}, time.Minute)).To(Succeed())
+		Expect(l.DictRemove("h")).To(Succeed())
+		Expect(l.Import(strings.NewReader(`{"key":"e","type":"key","value":"v","expiry":"2100-01-01T00:00:00Z"}`))).
+			To(Equal(1))
+		Expect(l.ListPush("b", []string{"l0"}, time.Minute)).To(Equal(3))
+		Expect(l.DictUpdate("c", map[string]string{"dk2": "dv2"}, time.Minute)).To(Equal(1))
+		Expect(l.Expire("a", time.Hour)).To(Succeed())
+		Expect(l.Add("a", "v2", time.Minute)).To(MatchError(store.ErrKeyExists))
+		Expect(l.Add("f", "1", time.Minute)).To(Succeed())
+		Expect(l.Incr("f", 10)).To(BeEquivalentTo(11))
+		Expect(l.Decr("f", 2)).To(BeEquivalentTo(9))
+		Expect(l.Append("f", "0")).To(Succeed())
+		Expect(l.Prepend("f", "1")).To(Succeed())
+		_, version, err := l.GetVersion("f")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.CompareAndSet("f", "x", time.Minute, version+1)).To(MatchError(store.ErrVersionMismatch))
+		Expect(l.CompareAndSet("f", "cas", time.Minute, version)).To(Succeed())
+		Expect(l.Replace("g", "v", time.Minute)).To(MatchError(store.ErrKeyNotExists))
+		Expect(l.Replace("f", "replaced", time.Minute)).To(Succeed())
+		for _, m := range members {
+			Eventually(m.local.Keys).Should(ConsistOf("a", "b", "c", "e", "f"))
+			Expect(m.store.Get("a")).To(Equal("v"))
+			Eventually(func() (string, error) { return m.store.Get("f") }).Should(Equal("replaced"))
+			Eventually(func() ([]string, error) { return m.store.ListGetAll("b") }).
+				Should(Equal([]string{"l0", "l1", "l2"}))
+			Eventually(func() (map[string]string, error) { return m.store.DictGetAll("c") }).
+				Should(Equal(map[string]string{"dk": "dv", "dk2": "dv2"}))
+			Eventually(func() (time.Duration, error) { return m.store.TTL("a") }).
+				Should(BeNumerically(">", time.Minute))
+		}
+
+		By("flushing")
+		Expect(l.Flush()).To(Succeed())
+		for _, m := range members {
+			Eventually(m.local.Keys).Should(BeEmpty())
+		}
+	})
+	Specify("expiry is same on all members", func() {
+		Expect(members[leader()].store.Set("a", "v", 200*time.Millisecond)).To(Succeed())
+		for _, m := range members {
+			Eventually(m.local.Keys).Should(ConsistOf("a"))
+		}
+		time.Sleep(200 * time.Millisecond)
+		for _, m := range members {
+			Expect(m.local.Keys()).To(BeEmpty())
+		}
+	})
+	Specify("binary keys and values are kept", func() {
+		Expect(members[leader()].store.Set("\xff", "\x00\xfe", time.Minute)).To(Succeed())
+		for _, m := range members {
+			Eventually(func() (string, error) { return m.store.Get("\xff") }).Should(Equal("\x00\xfe"))
+		}
+	})
+	Specify("account's quota is enforced on all members", func() {
+		a := members[leader()].store.As("o", store.Quota{MaxKeys: 1})
+		Expect(a.Set("a", "v", time.Minute)).To(Succeed())
+		Expect(a.Set("b", "v", time.Minute)).To(MatchError(store.ErrKeysQuotaExceeded))
+		for _, m := range members {
+			Eventually(m.local.Keys).Should(ConsistOf("a"))
+			Expect(m.local.UsageOf("o")).To(Equal(m.local.Usage()))
+		}
+	})
+	Specify("expired items are cleaned on all members", func() {
+		Expect(members[leader()].store.Set("a", "v", 100*time.Millisecond)).To(Succeed())
+		for _, m := range members {
+			Expect(m.store.StartCleaning()).To(Succeed())
+			Expect(m.store.Info().Cleaning).To(BeTrue())
+		}
+		for _, m := range members {
+			Eventually(func() int { return m.local.Usage().Items }, 2*time.Second).Should(BeZero())
+		}
+		for _, m := range members {
+			Expect(m.store.StopCleaning()).To(Succeed())
+			Expect(m.store.StopCleaning()).To(MatchError(store.ErrCleaningNotStartedYet))
+		}
+	})
+	Specify("restarts from snapshots taken on close", func() {
+		l := members[leader()].store
+		Expect(l.Set("a", "1", time.Minute)).To(Succeed())
+		Expect(l.Incr("a", 10)).To(BeEquivalentTo(11))
+		Expect(l.ListSet("b", []string{"l1"}, time.Minute)).To(Succeed())
+		Expect(l.ListPush("b", []string{"l0"}, time.Minute)).To(Equal(2))
+		for _, m := range members {
+			Eventually(m.local.Keys).Should(ConsistOf("a", "b"))
+		}
+		status, err := l.Dump()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status.LastSuccess).ToNot(BeZero())
+		Expect(l.DumpStatus()).To(Equal(status))
+		for i := range members {
+			stop(i, true)
+		}
+		for _, m := range members {
+			snapshots, err := m.snaps.List()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(snapshots).ToNot(BeEmpty())
+		}
+
+		By("restoring each member once")
+		connect()
+		for i := range members {
+			start(i)
+		}
+		leader()
+		for _, m := range members {
+			Eventually(func() (string, error) { return m.store.Get("a") }).Should(Equal("11"))
+			Eventually(func() ([]string, error) { return m.store.ListGetAll("b") }).
+				Should(Equal([]string{"l0", "l1"}))
+		}
+		files, err := ioutil.ReadDir(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(BeEmpty())
+	})
+	Specify("followers error with not leader", func() {
+		index := leader()
+		for i, m := range members {
+			if i == index {
+				continue
+			}
+			url, isLeader := m.store.Leader()
+			Expect(isLeader).To(BeFalse())
+			Eventually(func() string {
+				url, _ = m.store.Leader()
+				return url
+			}).Should(Equal(peers[index].URL))
+			Expect(m.store.Set("a", "v", time.Minute)).To(MatchError(ErrNotLeader))
+			_, err := m.store.Import(strings.NewReader(""))
+			Expect(err).To(MatchError(ErrNotLeader))
+			Expect(m.store.Flush()).To(MatchError(ErrNotLeader))
+		}
+		Expect(members[index].local.Keys()).To(BeEmpty())
+	})
+	Specify("survives leader loss", func() {
+		old := leader()
+		Expect(members[old].store.Set("a", "v", time.Minute)).To(Succeed())
+		stop(old, false)
+
+		By("electing new leader")
+		index := leader()
+		Expect(index).ToNot(Equal(old))
+		Eventually(members[index].local.Keys).Should(ConsistOf("a"))
+		Expect(members[index].store.Set("b", "v", time.Minute)).To(Succeed())
+
+		By("catching up restarted member")
+		start(old)
+		Eventually(members[old].local.Keys).Should(ConsistOf("a", "b"))
+	})
+	Specify("restores snapshot", func() {
+		index := leader()
+		l := members[index].store
+		Expect(l.Set("a", "v", time.Minute)).To(Succeed())
+		Expect(l.ListSet("b", []string{"l"}, time.Minute)).To(Succeed())
+		follower := (index + 1) % len(members)
+		Eventually(members[follower].local.Keys).Should(ConsistOf("a", "b"))
+
+		By("restarting member with lost log after leader compacted log")
+		stop(follower, false)
+		members[follower].logs = raft.NewInmemStore()
+		Expect(l.raft.Snapshot().Error()).To(Succeed())
+		Expect(l.Set("c", "v", time.Minute)).To(Succeed())
+		start(follower)
+		Eventually(members[follower].local.Keys, 5*time.Second).Should(ConsistOf("a", "b", "c"))
+		snapshots, err := members[follower].snaps.List()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshots).To(HaveLen(1))
+	})
+})
+
+type testMember struct {
+	logs   *raft.InmemStore
+	stable *raft.InmemStore
+	snaps  *raft.InmemSnapshotStore
+	trans  *raft.InmemTransport
+	local  store.Store
+	store  *Store
+}
@@ -1,65 +1,365 @@
 package main
 
 import (
-	"io/ioutil"
-	"time"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/alexflint/go-arg"
 	"github.com/gin-gonic/gin"
+	"github.com/someanon/yamc/auth"
+	"github.com/someanon/yamc/client"
+	"github.com/someanon/yamc/cluster"
+	"github.com/someanon/yamc/consensus"
+	"github.com/someanon/yamc/memcached"
+	"github.com/someanon/yamc/replica"
+	"github.com/someanon/yamc/resp"
 	"github.com/someanon/yamc/server"
 	"github.com/someanon/yamc/store"
-	"gopkg.in/yaml.v2"
 )
 
+// hashPasswordCmd is a hash-password subcommand args
+type hashPasswordCmd struct {
+	Algorithm string `arg:"--algorithm" help:"password hashing algorithm: bcrypt or argon2id"`
+}
+
 func main() {
 
-	var args struct {
-		AccountsPath   string        `arg:"--accounts-path" help:"accounts file path"`
-		CleaningPeriod time.Duration `arg:"--cleaning-period" help:"store cleaning period, must be >= 100ms"`
-		DumpingPeriod  time.Duration `arg:"--dumping-period" help:"store dumping period, must be >= 60s"`
-		DumpPath       string        `arg:"--dump-path" help:"store dump file path"`
+	args := defaultConfig()
+	parser := arg.MustParse(&args)
+	if args.Config != "" {
+		// config file options are overridden by environment variables and flags, so they are parsed again by parser
+		// taking config file options as defaults. Parser can't take slices as defaults, so they are set after it is
+		// constructed
+		path := args.Config
+		args = defaultConfig()
+		if err := args.load(path); err != nil {
+			parser.Fail(err.Error())
+		}
+		clusterNodes, consensusPeers := args.ClusterNodes, args.ConsensusPeers
+		args.ClusterNodes, args.ConsensusPeers = nil, nil
+		p, err := arg.NewParser(arg.Config{}, &args)
+		if err != nil {
+			parser.Fail("failed to construct args parser: " + err.Error())
+		}
+		parser = p
+		args.ClusterNodes, args.ConsensusPeers = clusterNodes, consensusPeers
+		if err := parser.Parse(os.Args[1:]); err != nil {
+			parser.Fail(err.Error())
+		}
 	}
 
-	args.AccountsPath = "./accounts"
-	args.CleaningPeriod = 60 * time.Second
-	args.DumpingPeriod = 60 * time.Second
-	args.DumpPath = "./dump"
+	if args.HashPassword != nil {
+		if args.HashPassword.Algorithm == "" {
+			args.HashPassword.Algorithm = string(auth.Bcrypt)
+		}
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			parser.Fail("failed to read password: " + err.Error())
+		}
+		hash, err := auth.HashPassword(strings.TrimRight(password, "\r\n"), auth.Algorithm(args.HashPassword.Algorithm))
+		if err != nil {
+			parser.Fail(err.Error())
+		}
+		fmt.Println(hash)
+		return
+	}
 
-	arg.MustParse(&args)
+	if err := args.validate(); err != nil {
+		parser.Fail(err.Error())
+	}
 
-	accountsYAML, err := ioutil.ReadFile(args.AccountsPath)
+	accounts, err := auth.Load(args.AccountsPath)
 	if err != nil {
-		panic("failed to read accounts file: " + err.Error())
+		parser.Fail("failed to load accounts file: " + err.Error())
 	}
 
-	var a gin.Accounts
-	if err := yaml.Unmarshal(accountsYAML, &a); err != nil {
-		panic("failed to parse accounts YAML: " + err.Error())
+	for _, namespace := range accounts.Namespaces() {
+		if args.ReplicaOf != "" || len(args.ConsensusPeers) > 0 || len(args.ClusterNodes) > 0 {
+			parser.Fail("account namespaces can't be combined with --replica-of, --consensus-peers and --cluster-nodes")
+		}
+		if err := store.ValidateNamespace(namespace); err != nil {
+			parser.Fail("invalid account namespace: " + err.Error())
+		}
 	}
 
-	p := store.Params{
-		CleaningPeriod: args.CleaningPeriod,
-		DumpingPeriod:  args.DumpingPeriod,
+	tokens, err := auth.NewTokens(args.TokensPath)
+	if err != nil {
+		parser.Fail("failed to load tokens file: " + err.Error())
 	}
 
-	s, err := store.NewStore(p, store.SystemClock{}, store.FileDumper(args.DumpPath))
-	if err != nil {
-		panic("unexpected store.NewStore() error: " + err.Error())
+	a := auth.NewTokensAuthenticator(accounts, tokens)
+
+	p := args.storeParams()
+
+	// openStore opens store of namespace dumped to its own dump file, starting cleaning and dumping
+	openStore := func(namespace string) store.Store {
+		d, err := store.NewFileDumper(store.NamespaceDumpPath(args.DumpPath, namespace),
+			store.Format(args.DumpFormat), store.Compression(args.DumpCompression))
+		if err != nil {
+			parser.Fail("failed to create dumper of namespace \"" + namespace + "\": " + err.Error())
+		}
+
+		s, err := store.NewStore(p, store.SystemClock{}, d)
+		if err != nil {
+			parser.Fail("failed to open store of namespace \"" + namespace + "\": " + err.Error())
+		}
+
+		s.OnDumpError(func(err error) {
+			log.Println("failed to dump store of namespace \"" + namespace + "\": " + err.Error())
+		})
+
+		if err := s.StartCleaning(); err != nil {
+			parser.Fail("failed to start cleaning: " + err.Error())
+		}
+
+		if err := s.StartDumping(); err != nil {
+			parser.Fail("failed to start dumping: " + err.Error())
+		}
+		return s
+	}
+
+	var s store.Store
+	if len(args.ConsensusPeers) > 0 {
+		// raft snapshots are the only persisted state of consensus member, so local store neither loads nor dumps
+		// file, otherwise raft log would be applied on top of loaded dump
+		d, err := store.NewStreamDumper(store.Format(args.DumpFormat), store.Compression(args.DumpCompression))
+		if err != nil {
+			parser.Fail("failed to create dumper: " + err.Error())
+		}
+
+		local, err := store.NewStore(p, store.SystemClock{}, d)
+		if err != nil {
+			parser.Fail("failed to open store: " + err.Error())
+		}
+
+		c := consensus.Config{Self: args.ConsensusSelf, Dir: args.ConsensusDir, CleaningPeriod: p.CleaningPeriod}
+		for _, peer := range args.ConsensusPeers {
+			i := strings.LastIndex(peer, "=")
+			c.Peers = append(c.Peers, consensus.Peer{URL: peer[:i], Addr: peer[i+1:]})
+		}
+		cs, err := consensus.NewStore(c, local)
+		if err != nil {
+			parser.Fail("failed to start consensus member: " + err.Error())
+		}
+
+		if err := cs.StartCleaning(); err != nil {
+			parser.Fail("failed to start consensus cleaning: " + err.Error())
+		}
+		s = cs
+	} else {
+		s = openStore(store.DefaultNamespace)
 	}
 
-	if err := s.StartCleaning(); err != nil {
-		panic("unexpected store.Store.StartCleaning() error: " + err.Error())
+	namespaces := store.Namespaces{store.DefaultNamespace: s}
+	for _, namespace := range accounts.Namespaces() {
+		namespaces[namespace] = openStore(namespace)
+	}
+
+	// streams context is canceled on shutdown, so endless streaming requests don't block in-flight requests draining
+	streams, cancelStreams := context.WithCancel(context.Background())
+	defer cancelStreams()
+
+	if args.ReplicaOf != "" {
+		primary, err := url.Parse(args.ReplicaOf)
+		if err != nil {
+			parser.Fail("failed to parse primary server URL: " + err.Error())
+		}
+		login := primary.User.Username()
+		password, _ := primary.User.Password()
+		primary.User = nil
+		c, err := client.NewClient(primary.String(), login, password)
+		if err != nil {
+			parser.Fail("failed to create primary server client: " + err.Error())
+		}
+		r := replica.NewReplica(c, s)
+		r.OnError(func(err error) {
+			log.Println("failed to replicate from primary: " + err.Error())
+		})
+		go r.Run(streams)
 	}
 
-	if err := s.StartDumping(); err != nil {
-		panic("unexpected store.Store.StartDumping() error: " + err.Error())
+	var node *cluster.Node
+	if len(args.ClusterNodes) > 0 {
+		node, err = cluster.NewNode(args.ClusterSelf, args.ClusterNodes, s, func(url string) (cluster.Peer, error) {
+			return client.NewClient(url, args.ClusterLogin, args.ClusterPassword)
+		})
+		if err != nil {
+			parser.Fail("failed to create cluster node: " + err.Error())
+		}
+		go func() {
+			if err := node.Sync(); err != nil {
+				log.Println("failed to sync cluster slot map: " + err.Error())
+			}
+		}()
 	}
 
 	gin.SetMode("release")
 
-	r := server.NewRouter(a, s)
+	handler := server.NewNamespacesRouter(a, namespaces)
+	if node != nil {
+		handler = server.NewClusterRouter(a, s, node)
+	}
+
+	srv := &http.Server{
+		Handler: handler,
+		BaseContext: func(net.Listener) context.Context {
+			return streams
+		},
+	}
+	srv.RegisterOnShutdown(cancelStreams)
 
-	if err := r.Run(); err != nil {
-		panic("failed to gin.Engine.Run(): " + err.Error())
+	var t *server.TLS
+	if args.TLSCert != "" {
+		t, err = server.NewTLS(args.TLSCert, args.TLSKey, args.TLSClientCA)
+		if err != nil {
+			parser.Fail(err.Error())
+		}
+		srv.TLSConfig = t.Config()
+	}
+
+	l, err := listen(args.Listen)
+	if err != nil {
+		parser.Fail("failed to listen address: " + err.Error())
 	}
+
+	serveErrs := make(chan error, 3)
+	go func() {
+		serve := srv.Serve
+		if t != nil {
+			serve = func(l net.Listener) error {
+				return srv.ServeTLS(l, "", "")
+			}
+		}
+		if err := serve(l); err != http.ErrServerClosed {
+			serveErrs <- err
+		}
+	}()
+
+	var rs *resp.Server
+	if args.RESPListen != "" {
+		rs, err = resp.NewServer(a, namespaces, resp.Params{DefaultTTL: args.RESPDefaultTTL})
+		if err != nil {
+			parser.Fail("invalid Redis protocol params: " + err.Error())
+		}
+		l, err := listen(args.RESPListen)
+		if err != nil {
+			parser.Fail("failed to listen Redis protocol address: " + err.Error())
+		}
+		go func() {
+			if err := rs.Serve(l); err != resp.ErrServerClosed {
+				serveErrs <- err
+			}
+		}()
+	}
+
+	var ms *memcached.Server
+	if args.MemcachedListen != "" {
+		ms, err = memcached.NewServer(a, namespaces, memcached.Params{DefaultTTL: args.MemcachedDefaultTTL})
+		if err != nil {
+			parser.Fail("invalid memcached protocol params: " + err.Error())
+		}
+		l, err := listen(args.MemcachedListen)
+		if err != nil {
+			parser.Fail("failed to listen memcached protocol address: " + err.Error())
+		}
+		go func() {
+			if err := ms.Serve(l); err != memcached.ErrServerClosed {
+				serveErrs <- err
+			}
+		}()
+	}
+
+	// reload replaces accounts by accounts file. Namespaces are opened on start only, so accounts of new namespaces
+	// are rejected until restart
+	var reloadMutex sync.Mutex
+	reload := func() {
+		reloadMutex.Lock()
+		defer reloadMutex.Unlock()
+		reloaded, err := auth.Load(args.AccountsPath)
+		if err != nil {
+			log.Println("failed to reload accounts file: " + err.Error())
+			return
+		}
+		for _, namespace := range reloaded.Namespaces() {
+			if _, exists := namespaces[namespace]; !exists {
+				log.Println("failed to reload accounts file: namespace \"" + namespace + "\" requires restart")
+				return
+			}
+		}
+		a.Set(reloaded)
+		log.Println("accounts file is reloaded")
+	}
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			reload()
+			if t != nil {
+				if err := t.Reload(); err != nil {
+					log.Println("failed to reload TLS certificates: " + err.Error())
+				} else {
+					log.Println("TLS certificates are reloaded")
+				}
+			}
+		}
+	}()
+
+	if args.AccountsWatchPeriod > 0 {
+		go auth.WatchFile(streams, args.AccountsPath, args.AccountsWatchPeriod, reload)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	exitCode := 0
+
+	select {
+	case sig := <-signals:
+		log.Println("received " + sig.String() + " signal, shutting down")
+	case err := <-serveErrs:
+		log.Println("failed to serve: " + err.Error())
+		exitCode = 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), args.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("failed to drain in-flight requests: " + err.Error())
+		exitCode = 1
+	}
+
+	if rs != nil {
+		rs.Close()
+	}
+
+	if ms != nil {
+		ms.Close()
+	}
+
+	if err := namespaces.Close(true); err != nil {
+		log.Println("failed to make final dump of namespaces: " + err.Error())
+		exitCode = 1
+	}
+
+	if err := s.Close(true); err != nil {
+		log.Println("failed to make final dump: " + err.Error())
+		exitCode = 1
+	} else {
+		log.Println("final dump is done")
+	}
+
+	cancel()
+	os.Exit(exitCode)
 }
@@ -0,0 +1,453 @@
+package memcached
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/someanon/yamc/auth"
+	"github.com/someanon/yamc/store"
+)
+
+const (
+	// maxKeyLength is max key length
+	maxKeyLength = 250
+
+	// maxRelativeExptime is max exptime treated as seconds to live, greater exptime is unix time
+	maxRelativeExptime = 60 * 60 * 24 * 30
+
+	// noreply is an argument suppressing command reply
+	noreply = "noreply"
+)
+
+// replies
+const (
+	replyStored    = "STORED"
+	replyNotStored = "NOT_STORED"
+	replyExists    = "EXISTS"
+	replyNotFound  = "NOT_FOUND"
+	replyDeleted   = "DELETED"
+	replyTouched   = "TOUCHED"
+	replyOK        = "OK"
+	replyEnd       = "END"
+	replyVersion   = "VERSION yamc"
+)
+
+// exec parses and executes command line. Commands except set and quit require authentication, set authenticates
+// unauthenticated connection. Commands are limited by account's role, keys and limits
+func (c *conn) exec(line string) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		c.reply(errUnknownCommand)
+		return
+	}
+	name, args := fields[0], fields[1:]
+	if len(args) > 0 && args[len(args)-1] == noreply {
+		args = args[:len(args)-1]
+		c.silent = true
+		defer func() {
+			c.silent = false
+		}()
+	}
+	if c.authenticated {
+		c.reauthenticate()
+	}
+	if !c.authenticated && name != "set" && name != "quit" {
+		c.reply(errUnauthenticated)
+		return
+	}
+	// storage commands are limited after data block is read, so connection stays in sync
+	storing := name == "set" || name == "add" || name == "replace" || name == "append" || name == "prepend" ||
+		name == "cas"
+	if c.authenticated && !storing && name != "quit" && !c.allowed() {
+		return
+	}
+	switch name {
+	case "get":
+		c.get(args, false)
+	case "gets":
+		c.get(args, true)
+	case "set", "add", "replace", "append", "prepend", "cas":
+		c.storage(name, args)
+	case "delete":
+		c.delete(args)
+	case "incr", "decr":
+		c.incr(name, args)
+	case "touch":
+		c.touch(args)
+	case "flush_all":
+		c.flushAll(args)
+	case "stats":
+		c.stats(args)
+	case "version":
+		c.reply(replyVersion)
+	case "verbosity":
+		c.reply(replyOK)
+	case "quit":
+		c.quit = true
+	default:
+		c.reply(errUnknownCommand)
+	}
+}
+
+// get handles get and gets <key>*, gets replies with cas unique. Missed keys and items of other types are skipped
+func (c *conn) get(keys []string, withVersion bool) {
+	if len(keys) == 0 {
+		c.reply(errUnknownCommand)
+		return
+	}
+	for _, key := range keys {
+		if len(key) > maxKeyLength {
+			c.reply(errBadFormat)
+			return
+		}
+	}
+	if !c.permitted(auth.Read, keys...) {
+		return
+	}
+	for _, key := range keys {
+		v, version, err := c.store.GetVersion(key)
+		if err == store.ErrKeyNotExists || err == store.ErrNotKeyItem {
+			continue
+		} else if err != nil {
+			c.storeError(err)
+			return
+		}
+		header := "VALUE " + key + " 0 " + strconv.Itoa(len(v))
+		if withVersion {
+			header += " " + strconv.FormatUint(version, 10)
+		}
+		c.reply(header)
+		c.reply(v)
+	}
+	c.reply(replyEnd)
+}
+
+// storage handles storage commands <command> <key> <flags> <exptime> <bytes> and cas <key> <flags> <exptime> <bytes>
+// <cas unique> followed by data block. Flags are not stored. Unauthenticated set authenticates connection by
+// "login password" data
+func (c *conn) storage(name string, args []string) {
+	argsCount := 4
+	if name == "cas" {
+		argsCount = 5
+	}
+	if len(args) != argsCount {
+		c.reply(errBadFormat)
+		return
+	}
+	length, err := strconv.Atoi(args[3])
+	if err != nil || length < 0 {
+		c.reply(errBadFormat)
+		return
+	}
+	data, errReply := c.readData(length)
+	if errReply != "" {
+		c.reply(errReply)
+		return
+	}
+	if !c.authenticated {
+		c.authenticate(data)
+		return
+	}
+	if !c.allowed() {
+		return
+	}
+	if max := c.account.Limits.MaxValueSize; max > 0 && int64(length) > max {
+		c.server.accounts.Limiter().Reject(c.login)
+		c.reply(errTooLarge)
+		return
+	}
+	key := args[0]
+	_, flagsErr := strconv.ParseUint(args[1], 10, 32)
+	exptime, exptimeErr := strconv.ParseInt(args[2], 10, 64)
+	if len(key) > maxKeyLength || flagsErr != nil || exptimeErr != nil {
+		c.reply(errBadFormat)
+		return
+	}
+	if !c.permitted(auth.Write, key) {
+		return
+	}
+	ttl := c.ttl(exptime)
+	s := c.store
+	switch name {
+	case "set":
+		err = s.Set(key, data, ttl)
+	case "add":
+		err = s.Add(key, data, ttl)
+	case "replace":
+		err = s.Replace(key, data, ttl)
+	case "append":
+		err = s.Append(key, data)
+	case "prepend":
+		err = s.Prepend(key, data)
+	case "cas":
+		version, verr := strconv.ParseUint(args[4], 10, 64)
+		if verr != nil {
+			c.reply(errBadFormat)
+			return
+		}
+		err = s.CompareAndSet(key, data, ttl, version)
+	}
+	switch {
+	case err == nil:
+		c.reply(replyStored)
+	case err == store.ErrVersionMismatch:
+		c.reply(replyExists)
+	case err == store.ErrKeyNotExists && name == "cas":
+		c.reply(replyNotFound)
+	case err == store.ErrKeyExists, err == store.ErrKeyNotExists:
+		c.reply(replyNotStored)
+	default:
+		c.storeError(err)
+	}
+}
+
+// readData reads data block of length bytes terminated by CRLF. Returns error reply if data is too large or
+// malformed, rest of malformed line is skipped. Closes connection on read error
+func (c *conn) readData(length int) (string, string) {
+	if length > maxValueLength {
+		if _, err := c.r.Discard(length + 2); err != nil {
+			c.quit = true
+		}
+		return "", errTooLarge
+	}
+	data := make([]byte, length+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		c.quit = true
+		return "", errBadDataChunk
+	}
+	if string(data[length:]) != "\r\n" {
+		// skipping rest of malformed data line
+		if data[length+1] != '\n' {
+			if _, err := c.r.ReadSlice('\n'); err != nil {
+				c.quit = true
+			}
+		}
+		return "", errBadDataChunk
+	}
+	return string(data[:length]), ""
+}
+
+// authenticate authenticates connection by "login password" data, binding it to store of account's namespace
+func (c *conn) authenticate(data string) {
+	credentials := strings.SplitN(data, " ", 2)
+	if len(credentials) != 2 {
+		c.reply(errAuthFailure)
+		return
+	}
+	account, ok := c.server.accounts.Authenticate(credentials[0], credentials[1])
+	if !ok {
+		c.reply(errAuthFailure)
+		return
+	}
+	if !c.bind(credentials[0], account) {
+		c.reply(errAuthFailure)
+		return
+	}
+	c.reply(replyStored)
+}
+
+// bind binds connection to store's view of account of login in account's namespace, so items set by connection are
+// owned by account and limited by account's keys and bytes quotas. Returns false if namespace is not exists
+func (c *conn) bind(login string, account auth.Account) bool {
+	st, err := c.server.namespaces.Namespace(account.Namespace)
+	if err != nil {
+		return false
+	}
+	q := store.Quota{MaxKeys: account.Limits.MaxKeys, MaxBytes: account.Limits.MaxBytes}
+	c.authenticated, c.login, c.account, c.store = true, login, account, st.As(login, q)
+	return true
+}
+
+// reauthenticate refreshes account of authenticated connection, so accounts reload applies to next command.
+// Unauthenticates connection if account was removed or its password or namespace was changed
+func (c *conn) reauthenticate() {
+	account, ok := c.server.accounts.Reauthenticate(c.login, c.account)
+	if !ok || !c.bind(c.login, account) {
+		c.authenticated, c.login, c.account, c.store = false, "", auth.Account{}, nil
+	}
+}
+
+// allowed reports whether account's requests rate limit allows command, replies with error otherwise
+func (c *conn) allowed() bool {
+	if allowed, _ := c.server.accounts.Limiter().Allow(c.login, c.account.Limits); !allowed {
+		c.reply(errRateLimit)
+		return false
+	}
+	return true
+}
+
+// storeError replies with error of store error err. Quota errors are counted as rejected requests of account
+func (c *conn) storeError(err error) {
+	if err == store.ErrKeysQuotaExceeded || err == store.ErrBytesQuotaExceeded {
+		c.server.accounts.Limiter().Reject(c.login)
+	}
+	c.reply(errStore(err))
+}
+
+// permitted reports whether account has permission p and is allowed to access keys, replies with error otherwise
+func (c *conn) permitted(p auth.Permission, keys ...string) bool {
+	if !c.account.Allows(p) {
+		c.reply(errNoPermission)
+		return false
+	}
+	for _, key := range keys {
+		if !c.account.AllowsKey(key) {
+			c.reply(errNoPermission)
+			return false
+		}
+	}
+	return true
+}
+
+// ttl converts exptime to time to live: zero is default ttl, up to 30 days is seconds to live, greater is unix time,
+// negative is immediately expired
+func (c *conn) ttl(exptime int64) time.Duration {
+	switch {
+	case exptime == 0:
+		return c.server.params.DefaultTTL
+	case exptime < 0:
+		return 0
+	case exptime > maxRelativeExptime:
+		return time.Until(time.Unix(exptime, 0))
+	}
+	return time.Duration(exptime) * time.Second
+}
+
+// delete handles delete <key>. Legacy zero time argument is allowed
+func (c *conn) delete(args []string) {
+	if len(args) == 0 || len(args) > 2 || len(args) == 2 && args[1] != "0" {
+		c.reply(errBadFormat)
+		return
+	}
+	if !c.permitted(auth.Write, args[0]) {
+		return
+	}
+	err := c.store.Remove(args[0])
+	if err == store.ErrKeyNotExists {
+		c.reply(replyNotFound)
+		return
+	} else if err != nil {
+		c.storeError(err)
+		return
+	}
+	c.reply(replyDeleted)
+}
+
+// incr handles incr and decr <key> <value>
+func (c *conn) incr(name string, args []string) {
+	if len(args) != 2 {
+		c.reply(errUnknownCommand)
+		return
+	}
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		c.reply(errInvalidDelta)
+		return
+	}
+	if !c.permitted(auth.Write, args[0]) {
+		return
+	}
+	var n uint64
+	if name == "incr" {
+		n, err = c.store.Incr(args[0], delta)
+	} else {
+		n, err = c.store.Decr(args[0], delta)
+	}
+	if err == store.ErrKeyNotExists {
+		c.reply(replyNotFound)
+		return
+	} else if err != nil {
+		c.storeError(err)
+		return
+	}
+	c.reply(strconv.FormatUint(n, 10))
+}
+
+// touch handles touch <key> <exptime>
+func (c *conn) touch(args []string) {
+	if len(args) != 2 {
+		c.reply(errUnknownCommand)
+		return
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		c.reply(errBadFormat)
+		return
+	}
+	if !c.permitted(auth.Write, args[0]) {
+		return
+	}
+	err = c.store.Expire(args[0], c.ttl(exptime))
+	if err == store.ErrKeyNotExists {
+		c.reply(replyNotFound)
+		return
+	} else if err != nil {
+		c.storeError(err)
+		return
+	}
+	c.reply(replyTouched)
+}
+
+// flushAll handles flush_all [delay], removing all items after delay, which is seconds up to 30 days or unix time.
+// Delayed flush replaces previous delayed flush of namespace
+func (c *conn) flushAll(args []string) {
+	if len(args) > 1 {
+		c.reply(errUnknownCommand)
+		return
+	}
+	delay := int64(0)
+	if len(args) == 1 {
+		var err error
+		if delay, err = strconv.ParseInt(args[0], 10, 64); err != nil || delay < 0 {
+			c.reply(errBadFormat)
+			return
+		}
+	}
+	if !c.permitted(auth.Administer) {
+		return
+	}
+	wait := time.Duration(delay) * time.Second
+	if delay > maxRelativeExptime {
+		wait = time.Until(time.Unix(delay, 0))
+	}
+	c.server.flushAfter(c.account.Namespace, c.store, wait)
+	if wait > 0 {
+		c.reply(replyOK)
+		return
+	}
+	if err := c.store.Flush(); err != nil {
+		c.storeError(err)
+		return
+	}
+	c.reply(replyOK)
+}
+
+// stats handles stats, replying with general statistics. Statistics groups are not supported and are empty
+func (c *conn) stats(args []string) {
+	if len(args) > 0 {
+		c.reply(replyEnd)
+		return
+	}
+	info := c.store.Info()
+	current, total := c.server.connections()
+	now := time.Now()
+	for _, stat := range []struct {
+		name  string
+		value int64
+	}{
+		{"pid", int64(os.Getpid())},
+		{"uptime", int64(now.Sub(c.server.started) / time.Second)},
+		{"time", now.Unix()},
+		{"curr_connections", int64(current)},
+		{"total_connections", int64(total)},
+		{"curr_items", int64(info.Keys + info.Lists + info.Dicts)},
+		{"bytes", info.Memory},
+	} {
+		c.reply("STAT " + stat.name + " " + strconv.FormatInt(stat.value, 10))
+	}
+	c.reply("STAT version yamc")
+	c.reply(replyEnd)
+}
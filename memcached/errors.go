@@ -0,0 +1,45 @@
+package memcached
+
+import (
+	"errors"
+
+	"github.com/someanon/yamc/consensus"
+	"github.com/someanon/yamc/store"
+)
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("memcached server closed")
+
+// error replies
+const (
+	errUnknownCommand  = "ERROR"
+	errBadFormat       = "CLIENT_ERROR bad command line format"
+	errBadDataChunk    = "CLIENT_ERROR bad data chunk"
+	errLineTooLong     = "CLIENT_ERROR line too long"
+	errInvalidDelta    = "CLIENT_ERROR invalid numeric delta argument"
+	errNotNumber       = "CLIENT_ERROR cannot increment or decrement non-numeric value"
+	errUnauthenticated = "CLIENT_ERROR unauthenticated"
+	errAuthFailure     = "CLIENT_ERROR authentication failure"
+	errNoPermission    = "CLIENT_ERROR permission denied"
+	errWrongType       = "CLIENT_ERROR not key item"
+	errTooLarge        = "SERVER_ERROR object too large for cache"
+	errReadOnly        = "SERVER_ERROR read only replica"
+	errNotLeader       = "SERVER_ERROR not consensus leader"
+	errRateLimit       = "SERVER_ERROR rate limit exceeded"
+	errServerPrefix    = "SERVER_ERROR "
+)
+
+// errStore returns error reply of store error
+func errStore(err error) string {
+	switch err {
+	case store.ErrNotKeyItem:
+		return errWrongType
+	case store.ErrNotNumber:
+		return errNotNumber
+	case store.ErrReadOnly:
+		return errReadOnly
+	case consensus.ErrNotLeader:
+		return errNotLeader
+	}
+	return errServerPrefix + err.Error()
+}
@@ -0,0 +1,13 @@
+package memcached
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestMemcached(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Memcached Suite")
+}
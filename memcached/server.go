@@ -0,0 +1,222 @@
+package memcached
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/someanon/yamc/auth"
+	"github.com/someanon/yamc/store"
+)
+
+const (
+	// maxLineLength is max length of command line
+	maxLineLength = 64 * 1024
+
+	// maxValueLength is max length of stored value, same as memcached default item size
+	maxValueLength = 1024 * 1024
+)
+
+// Params is a memcached server parameters
+type Params struct {
+	// DefaultTTL is time to live of items set with zero exptime, memcached items live forever by default
+	DefaultTTL time.Duration
+}
+
+// Validate validates memcached server parameters
+func (p Params) Validate() error {
+	if p.DefaultTTL <= 0 {
+		return errors.New("default ttl must be positive")
+	}
+	return nil
+}
+
+// Server is a memcached ASCII protocol server of store key items. Clients authenticate by set command with
+// "login password" data, as memcached does with authentication file
+type Server struct {
+	mutex      sync.Mutex
+	wg         sync.WaitGroup
+	closed     bool
+	accounts   *auth.Authenticator
+	namespaces store.Namespaces
+	params     Params
+	started    time.Time
+	listeners  map[net.Listener]struct{}
+	conns      map[net.Conn]struct{}
+	flushes    map[string]*time.Timer
+	total      int
+}
+
+// NewServer constructs memcached server of namespaces ns authenticating by authenticator a. Connection is served by
+// store of account's namespace, authenticated account is looked up on each command, so accounts reload applies to
+// open connections. Errors if params p are invalid
+func NewServer(a *auth.Authenticator, ns store.Namespaces, p Params) (*Server, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &Server{
+		accounts:   a,
+		namespaces: ns,
+		params:     p,
+		started:    time.Now(),
+		listeners:  map[net.Listener]struct{}{},
+		conns:      map[net.Conn]struct{}{},
+		flushes:    map[string]*time.Timer{},
+	}, nil
+}
+
+// Serve accepts connections on listener l and serves each in its own goroutine. Always returns non nil error,
+// ErrServerClosed after Close
+func (s *Server) Serve(l net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mutex.Unlock()
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			delete(s.listeners, l)
+			if s.closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(nc) {
+			nc.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.untrack(nc)
+			newConn(s, nc).serve()
+		}()
+	}
+}
+
+// Close closes all listeners and connections and stops delayed flushes, then waits for in-progress commands
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	for namespace, t := range s.flushes {
+		t.Stop()
+		delete(s.flushes, namespace)
+	}
+	for l := range s.listeners {
+		l.Close()
+	}
+	for nc := range s.conns {
+		nc.Close()
+	}
+	s.mutex.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// flushAfter schedules flush of store st of namespace after d, replacing namespace's scheduled flush. Non positive d
+// only cancels scheduled flush
+func (s *Server) flushAfter(namespace string, st store.Store, d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if t, exists := s.flushes[namespace]; exists {
+		t.Stop()
+		delete(s.flushes, namespace)
+	}
+	if d <= 0 || s.closed {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		s.mutex.Lock()
+		if s.flushes[namespace] != t {
+			s.mutex.Unlock()
+			return
+		}
+		delete(s.flushes, namespace)
+		s.mutex.Unlock()
+		st.Flush()
+	})
+	s.flushes[namespace] = t
+}
+
+// connections returns current and total connections count
+func (s *Server) connections() (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.conns), s.total
+}
+
+// track registers connection nc. Returns false if server is closed
+func (s *Server) track(nc net.Conn) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[nc] = struct{}{}
+	s.total++
+	s.wg.Add(1)
+	return true
+}
+
+// untrack closes and unregisters connection nc
+func (s *Server) untrack(nc net.Conn) {
+	nc.Close()
+	s.mutex.Lock()
+	delete(s.conns, nc)
+	s.mutex.Unlock()
+	s.wg.Done()
+}
+
+// conn is a client connection
+type conn struct {
+	server        *Server
+	r             *bufio.Reader
+	w             *bufio.Writer
+	authenticated bool
+	login         string
+	account       auth.Account
+	store         store.Store
+	silent        bool
+	quit          bool
+}
+
+// newConn constructs client connection nc of server s
+func newConn(s *Server, nc net.Conn) *conn {
+	return &conn{server: s, r: bufio.NewReaderSize(nc, maxLineLength), w: bufio.NewWriter(nc)}
+}
+
+// serve reads and executes commands until connection is closed, quit command or too long line. Replies are flushed
+// when there are no more pipelined commands
+func (c *conn) serve() {
+	for !c.quit {
+		line, err := c.r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			c.reply(errLineTooLong)
+			c.w.Flush()
+			return
+		} else if err != nil {
+			return
+		}
+		c.exec(string(line))
+		if c.r.Buffered() == 0 || c.quit {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// reply writes reply line unless command is silent
+func (c *conn) reply(line string) {
+	if c.silent {
+		return
+	}
+	c.w.WriteString(line + "\r\n")
+}
@@ -0,0 +1,292 @@
+package memcached
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/someanon/yamc/auth"
+	"github.com/someanon/yamc/store"
+)
+
+// testClient is a raw memcached text protocol client
+type testClient struct {
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+// do sends request and returns n reply lines without terminators
+func (c *testClient) do(request string, n int) []string {
+	_, err := c.nc.Write([]byte(request))
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	var lines []string
+	for i := 0; i < n; i++ {
+		line, err := c.r.ReadString('\n')
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		lines = append(lines, strings.TrimSuffix(line, "\r\n"))
+	}
+	return lines
+}
+
+var _ = Describe("Server", func() {
+	var (
+		dir string
+		st  store.Store
+		a   *auth.Authenticator
+		s   *Server
+		l   net.Listener
+		c   *testClient
+	)
+	dial := func() *testClient {
+		nc, err := net.Dial("tcp", l.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		return &testClient{nc: nc, r: bufio.NewReader(nc)}
+	}
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "memcached")
+		Expect(err).ToNot(HaveOccurred())
+		d, err := store.NewFileDumper(filepath.Join(dir, "dump"), store.GobFormat, store.NoCompression)
+		Expect(err).ToNot(HaveOccurred())
+		st, err = store.NewStore(store.Params{CleaningPeriod: time.Second, DumpingPeriod: time.Minute},
+			store.SystemClock{}, d)
+		Expect(err).ToNot(HaveOccurred())
+		a = auth.NewAuthenticator(auth.Accounts{
+			"test":   {Password: "test", Role: auth.Admin},
+			"reader": {Password: "test", Role: auth.ReadOnly, Keys: []string{"user:*"}},
+		})
+		s, err = NewServer(a, store.Namespaces{store.DefaultNamespace: st}, Params{DefaultTTL: time.Hour})
+		Expect(err).ToNot(HaveOccurred())
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		go s.Serve(l)
+		c = dial()
+		Expect(c.do("set auth 0 0 9\r\ntest test\r\n", 1)).To(Equal([]string{"STORED"}))
+	})
+	AfterEach(func() {
+		c.nc.Close()
+		s.Close()
+		st.Close(false)
+		os.RemoveAll(dir)
+	})
+	Specify("invalid params error", func() {
+		_, err := NewServer(nil, nil, Params{})
+		Expect(err).To(MatchError("default ttl must be positive"))
+	})
+	Describe("authentication", func() {
+		BeforeEach(func() {
+			c = dial()
+		})
+		Specify("commands require authentication", func() {
+			Expect(c.do("get a\r\n", 1)).To(Equal([]string{"CLIENT_ERROR unauthenticated"}))
+		})
+		Specify("wrong credentials error", func() {
+			Expect(c.do("set auth 0 0 10\r\ntest wrong\r\n", 1)).To(Equal([]string{"CLIENT_ERROR authentication failure"}))
+			Expect(c.do("set auth 0 0 4\r\ntest\r\n", 1)).To(Equal([]string{"CLIENT_ERROR authentication failure"}))
+			Expect(c.do("get a\r\n", 1)).To(Equal([]string{"CLIENT_ERROR unauthenticated"}))
+		})
+		Specify("commands are limited by account", func() {
+			Expect(st.Set("user:1", "v", time.Minute)).To(Succeed())
+			Expect(c.do("set auth 0 0 11\r\nreader test\r\n", 1)).To(Equal([]string{"STORED"}))
+			Expect(c.do("get user:1\r\n", 3)).To(Equal([]string{"VALUE user:1 0 1", "v", "END"}))
+			Expect(c.do("get user:1 a\r\n", 1)).To(Equal([]string{"CLIENT_ERROR permission denied"}))
+			Expect(c.do("set user:1 0 0 1\r\nw\r\n", 1)).To(Equal([]string{"CLIENT_ERROR permission denied"}))
+			Expect(c.do("delete user:1\r\n", 1)).To(Equal([]string{"CLIENT_ERROR permission denied"}))
+			Expect(c.do("flush_all\r\n", 1)).To(Equal([]string{"CLIENT_ERROR permission denied"}))
+			Expect(st.Get("user:1")).To(Equal("v"))
+		})
+		Specify("accounts reload applies to authenticated connection", func() {
+			Expect(c.do("set auth 0 0 9\r\ntest test\r\n", 1)).To(Equal([]string{"STORED"}))
+			Expect(c.do("set a 0 0 1\r\nv\r\n", 1)).To(Equal([]string{"STORED"}))
+			a.Set(auth.Accounts{"test": {Password: "test", Role: auth.ReadOnly}})
+			Expect(c.do("set a 0 0 1\r\nw\r\n", 1)).To(Equal([]string{"CLIENT_ERROR permission denied"}))
+			Expect(c.do("get a\r\n", 3)).To(Equal([]string{"VALUE a 0 1", "v", "END"}))
+
+			By("revoking account with changed password")
+			a.Set(auth.Accounts{"test": {Password: "changed", Role: auth.Admin}})
+			Expect(c.do("get a\r\n", 1)).To(Equal([]string{"CLIENT_ERROR unauthenticated"}))
+			Expect(c.do("set auth 0 0 12\r\ntest changed\r\n", 1)).To(Equal([]string{"STORED"}))
+			Expect(c.do("get a\r\n", 3)).To(Equal([]string{"VALUE a 0 1", "v", "END"}))
+		})
+	})
+	Describe("limits", func() {
+		BeforeEach(func() {
+			a.Set(auth.Accounts{
+				"limited":   {Password: "test", Role: auth.ReadWrite, Limits: auth.Limits{MaxKeys: 1, MaxValueSize: 3}},
+				"throttled": {Password: "test", Role: auth.ReadWrite, Limits: auth.Limits{RequestsPerSecond: 0.001}},
+			})
+			c = dial()
+		})
+		Specify("quotas", func() {
+			Expect(st.Set("b", "v", time.Minute)).To(Succeed())
+			Expect(c.do("set auth 0 0 12\r\nlimited test\r\n", 1)).To(Equal([]string{"STORED"}))
+			Expect(c.do("set a 0 0 3\r\nvvv\r\n", 1)).To(Equal([]string{"STORED"}))
+			Expect(c.do("set a 0 0 2\r\nvv\r\n", 1)).To(Equal([]string{"STORED"}))
+			Expect(c.do("set b 0 0 1\r\nv\r\n", 1)).To(Equal([]string{"SERVER_ERROR keys quota exceeded"}))
+			Expect(c.do("append a 0 0 4\r\nvvvv\r\n", 1)).To(Equal([]string{"SERVER_ERROR object too large for cache"}))
+			Expect(c.do("get a\r\n", 3)).To(Equal([]string{"VALUE a 0 2", "vv", "END"}))
+			Expect(st.UsageOf("limited").Items).To(Equal(1))
+			Expect(a.Limiter().Rejected("limited")).To(Equal(uint64(2)))
+		})
+		Specify("rate limit", func() {
+			Expect(c.do("set auth 0 0 14\r\nthrottled test\r\n", 1)).To(Equal([]string{"STORED"}))
+			Expect(c.do("set a 0 0 1\r\nv\r\n", 1)).To(Equal([]string{"STORED"}))
+			Expect(c.do("set a 0 0 1\r\nw\r\n", 1)).To(Equal([]string{"SERVER_ERROR rate limit exceeded"}))
+			Expect(c.do("get a\r\n", 1)).To(Equal([]string{"SERVER_ERROR rate limit exceeded"}))
+			Expect(st.Get("a")).To(Equal("v"))
+		})
+	})
+	Specify("set and get", func() {
+		Expect(c.do("get a b\r\n", 1)).To(Equal([]string{"END"}))
+		Expect(c.do("set a 5 0 3\r\nv v\r\n", 1)).To(Equal([]string{"STORED"}))
+		Expect(c.do("set b 0 100 1\r\nw\r\n", 1)).To(Equal([]string{"STORED"}))
+		Expect(st.TTL("a")).To(BeNumerically("~", time.Hour, time.Second))
+		Expect(st.TTL("b")).To(BeNumerically("~", 100*time.Second, time.Second))
+		Expect(c.do("get a c b\r\n", 5)).To(Equal([]string{"VALUE a 0 3", "v v", "VALUE b 0 1", "w", "END"}))
+	})
+	Specify("exptime semantics", func() {
+		Expect(c.do("set a 0 "+strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)+" 1\r\nv\r\n", 1)).
+			To(Equal([]string{"STORED"}))
+		Expect(st.TTL("a")).To(BeNumerically("~", time.Hour, 2*time.Second))
+		Expect(c.do("set a 0 -1 1\r\nv\r\n", 1)).To(Equal([]string{"STORED"}))
+		Expect(c.do("get a\r\n", 1)).To(Equal([]string{"END"}))
+	})
+	Specify("gets and cas", func() {
+		Expect(c.do("cas a 0 0 1 1\r\nv\r\n", 1)).To(Equal([]string{"NOT_FOUND"}))
+		Expect(st.Set("a", "v", time.Minute)).To(Succeed())
+		_, version, err := st.GetVersion("a")
+		Expect(err).ToNot(HaveOccurred())
+		cas := strconv.FormatUint(version, 10)
+		Expect(c.do("gets a\r\n", 3)).To(Equal([]string{"VALUE a 0 1 " + cas, "v", "END"}))
+		Expect(c.do("cas a 0 0 1 "+strconv.FormatUint(version+1, 10)+"\r\nw\r\n", 1)).To(Equal([]string{"EXISTS"}))
+		Expect(c.do("cas a 0 0 1 "+cas+"\r\nw\r\n", 1)).To(Equal([]string{"STORED"}))
+		Expect(c.do("cas a 0 0 1 "+cas+"\r\nx\r\n", 1)).To(Equal([]string{"EXISTS"}))
+		Expect(st.Get("a")).To(Equal("w"))
+	})
+	Specify("add, replace, append and prepend", func() {
+		Expect(c.do("replace a 0 0 1\r\nv\r\n", 1)).To(Equal([]string{"NOT_STORED"}))
+		Expect(c.do("append a 0 0 1\r\nv\r\n", 1)).To(Equal([]string{"NOT_STORED"}))
+		Expect(c.do("add a 0 0 1\r\nb\r\n", 1)).To(Equal([]string{"STORED"}))
+		Expect(c.do("add a 0 0 1\r\nv\r\n", 1)).To(Equal([]string{"NOT_STORED"}))
+		Expect(c.do("append a 0 0 1\r\nc\r\n", 1)).To(Equal([]string{"STORED"}))
+		Expect(c.do("prepend a 0 0 1\r\na\r\n", 1)).To(Equal([]string{"STORED"}))
+		Expect(st.Get("a")).To(Equal("abc"))
+		Expect(c.do("replace a 0 0 1\r\nv\r\n", 1)).To(Equal([]string{"STORED"}))
+		Expect(st.Get("a")).To(Equal("v"))
+	})
+	Specify("delete", func() {
+		Expect(c.do("delete a\r\n", 1)).To(Equal([]string{"NOT_FOUND"}))
+		Expect(st.Set("a", "v", time.Minute)).To(Succeed())
+		Expect(c.do("delete a 0\r\n", 1)).To(Equal([]string{"DELETED"}))
+		Expect(c.do("delete a 1\r\n", 1)).To(Equal([]string{"CLIENT_ERROR bad command line format"}))
+		Expect(st.Keys()).To(BeEmpty())
+	})
+	Specify("incr and decr", func() {
+		Expect(c.do("incr a 1\r\n", 1)).To(Equal([]string{"NOT_FOUND"}))
+		Expect(st.Set("a", "10", time.Minute)).To(Succeed())
+		Expect(c.do("incr a 5\r\n", 1)).To(Equal([]string{"15"}))
+		Expect(c.do("decr a 20\r\n", 1)).To(Equal([]string{"0"}))
+		Expect(c.do("incr a x\r\n", 1)).To(Equal([]string{"CLIENT_ERROR invalid numeric delta argument"}))
+		Expect(st.Set("a", "v", time.Minute)).To(Succeed())
+		Expect(c.do("incr a 1\r\n", 1)).To(Equal([]string{
+			"CLIENT_ERROR cannot increment or decrement non-numeric value"}))
+	})
+	Specify("touch", func() {
+		Expect(c.do("touch a 10\r\n", 1)).To(Equal([]string{"NOT_FOUND"}))
+		Expect(st.Set("a", "v", time.Minute)).To(Succeed())
+		Expect(c.do("touch a 10\r\n", 1)).To(Equal([]string{"TOUCHED"}))
+		Expect(st.TTL("a")).To(BeNumerically("~", 10*time.Second, time.Second))
+	})
+	Specify("flush_all", func() {
+		Expect(st.Set("a", "v", time.Minute)).To(Succeed())
+		Expect(c.do("flush_all\r\n", 1)).To(Equal([]string{"OK"}))
+		Expect(st.Keys()).To(BeEmpty())
+		Expect(st.Set("a", "v", time.Minute)).To(Succeed())
+		Expect(c.do("flush_all 1\r\n", 1)).To(Equal([]string{"OK"}))
+		Expect(st.Keys()).To(ConsistOf("a"))
+		Eventually(st.Keys, 2*time.Second).Should(BeEmpty())
+	})
+	Specify("flush_all delay replaced", func() {
+		Expect(st.Set("a", "v", time.Minute)).To(Succeed())
+		Expect(c.do("flush_all 9999999999999\r\n", 1)).To(Equal([]string{"OK"}))
+		Expect(c.do("flush_all 1\r\n", 1)).To(Equal([]string{"OK"}))
+		Expect(c.do("flush_all 3600\r\n", 1)).To(Equal([]string{"OK"}))
+		Consistently(st.Keys, 1500*time.Millisecond).Should(ConsistOf("a"))
+		past := strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)
+		Expect(c.do("flush_all "+past+"\r\n", 1)).To(Equal([]string{"OK"}))
+		Expect(st.Keys()).To(BeEmpty())
+		Expect(st.Set("a", "v", time.Minute)).To(Succeed())
+		Expect(c.do("flush_all 1\r\n", 1)).To(Equal([]string{"OK"}))
+		Expect(s.Close()).To(Succeed())
+		Consistently(st.Keys, 1500*time.Millisecond).Should(ConsistOf("a"))
+	})
+	Specify("stats and version", func() {
+		Expect(st.Set("a", "v", time.Minute)).To(Succeed())
+		Expect(c.do("stats\r\n", 9)).To(And(
+			ContainElement(MatchRegexp(`^STAT pid \d+$`)),
+			ContainElement("STAT curr_items 1"),
+			ContainElement("STAT curr_connections 1"),
+			ContainElement("STAT version yamc"),
+			HaveLen(9),
+		))
+		Expect(c.do("stats items\r\n", 1)).To(Equal([]string{"END"}))
+		Expect(c.do("version\r\n", 1)).To(Equal([]string{"VERSION yamc"}))
+	})
+	Specify("noreply", func() {
+		Expect(c.do("set a 0 0 1 noreply\r\nv\r\ndelete b noreply\r\nget a\r\n", 3)).
+			To(Equal([]string{"VALUE a 0 1", "v", "END"}))
+	})
+	Specify("items of other types", func() {
+		Expect(st.ListSet("a", []string{"v"}, time.Minute)).To(Succeed())
+		Expect(c.do("get a\r\n", 1)).To(Equal([]string{"END"}))
+		Expect(c.do("append a 0 0 1\r\nv\r\n", 1)).To(Equal([]string{"CLIENT_ERROR not key item"}))
+	})
+	Specify("command errors", func() {
+		Expect(c.do("unknown\r\n", 1)).To(Equal([]string{"ERROR"}))
+		Expect(c.do("\r\n", 1)).To(Equal([]string{"ERROR"}))
+		Expect(c.do("set a 0 0\r\n", 1)).To(Equal([]string{"CLIENT_ERROR bad command line format"}))
+		Expect(c.do("set a x 0 1\r\nv\r\n", 1)).To(Equal([]string{"CLIENT_ERROR bad command line format"}))
+		Expect(c.do("set "+strings.Repeat("a", 251)+" 0 0 1\r\nv\r\n", 1)).
+			To(Equal([]string{"CLIENT_ERROR bad command line format"}))
+		Expect(c.do("set a 0 0 1\r\nvv\r\n", 1)).To(Equal([]string{"CLIENT_ERROR bad data chunk"}))
+		Expect(c.do("set a 0 0 2000000\r\n"+strings.Repeat("v", 2000000)+"\r\n", 1)).
+			To(Equal([]string{"SERVER_ERROR object too large for cache"}))
+		Expect(st.Keys()).To(BeEmpty())
+		Expect(c.do("version\r\n", 1)).To(Equal([]string{"VERSION yamc"}))
+	})
+	Specify("too long line error closes connection", func() {
+		Expect(c.do("get "+strings.Repeat("a", maxLineLength)+"\r\n", 1)).To(Equal([]string{"CLIENT_ERROR line too long"}))
+		_, err := c.r.ReadByte()
+		Expect(err).To(HaveOccurred())
+	})
+	Specify("read only store error", func() {
+		d, err := store.NewFileDumper(filepath.Join(dir, "replica"), store.GobFormat, store.NoCompression)
+		Expect(err).ToNot(HaveOccurred())
+		replica, err := store.NewStore(store.Params{CleaningPeriod: time.Second, DumpingPeriod: time.Minute,
+			ReadOnly: true}, store.SystemClock{}, d)
+		Expect(err).ToNot(HaveOccurred())
+		defer replica.Close(false)
+		s.namespaces[store.DefaultNamespace] = replica
+		c = dial()
+		Expect(c.do("set auth 0 0 9\r\ntest test\r\n", 1)).To(Equal([]string{"STORED"}))
+		Expect(c.do("set a 0 0 1\r\nv\r\n", 1)).To(Equal([]string{"SERVER_ERROR read only replica"}))
+	})
+	Specify("quit and Close", func() {
+		c.do("quit\r\n", 0)
+		_, err := c.r.ReadByte()
+		Expect(err).To(Equal(io.EOF))
+		c = dial()
+		Expect(s.Close()).To(Succeed())
+		_, err = c.r.ReadByte()
+		Expect(err).To(Equal(io.EOF))
+		Expect(s.Serve(l)).To(Equal(ErrServerClosed))
+	})
+})
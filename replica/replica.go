@@ -0,0 +1,131 @@
+package replica
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/someanon/yamc/client"
+	"github.com/someanon/yamc/store"
+)
+
+const (
+	// retryDelay is a delay before reconnecting to primary after replication error or stream end
+	retryDelay = time.Second
+
+	// streamTimeout is max time without any data from primary, after which stream is considered broken. Primary sends
+	// heartbeats every second when idle
+	streamTimeout = 5 * time.Second
+)
+
+// Replica keeps store in sync with primary server: restores store from primary's snapshot, then applies streamed
+// mutations. Store should be read only, so it is changed by replication only
+type Replica struct {
+	mutex         sync.Mutex
+	client        client.Client
+	store         store.Store
+	errorHooks    []func(err error)
+	retryDelay    time.Duration
+	streamTimeout time.Duration
+}
+
+// NewReplica constructs replica of primary server available by client c to store s
+func NewReplica(c client.Client, s store.Store) *Replica {
+	return &Replica{
+		client:        c,
+		store:         s,
+		retryDelay:    retryDelay,
+		streamTimeout: streamTimeout,
+	}
+}
+
+// OnError registers hook called with error after each failed replication attempt
+func (r *Replica) OnError(hook func(err error)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.errorHooks = append(r.errorHooks, hook)
+}
+
+// Run replicates until ctx is done. Replication is restarted after delay when it fails or stream ends
+func (r *Replica) Run(ctx context.Context) {
+	for {
+		err := r.replicate(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			r.mutex.Lock()
+			hooks := r.errorHooks
+			r.mutex.Unlock()
+			for _, hook := range hooks {
+				hook(err)
+			}
+		}
+		select {
+		case <-time.After(r.retryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// replicate applies primary's mutations stream following store's replication position. If position is lost on
+// primary, store is restored from primary's snapshot first
+func (r *Replica) replicate(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pos := r.store.ReplicationStatus().Position()
+	stream, err := r.client.ReplicationStream(ctx, pos.ID, pos.Seq)
+	if errors.Is(err, client.ErrReplicationLogTruncated) {
+		if err := r.restore(ctx); err != nil {
+			return err
+		}
+		pos = r.store.ReplicationStatus().Position()
+		stream, err = r.client.ReplicationStream(ctx, pos.ID, pos.Seq)
+	}
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	return r.store.Apply(newTimeoutReader(stream, r.streamTimeout, cancel))
+}
+
+// restore restores store from primary's snapshot
+func (r *Replica) restore(ctx context.Context) error {
+	snapshot, err := r.client.ReplicationSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+	return r.store.Restore(snapshot)
+}
+
+// timeoutReader is a reader calling cancel if there was no data read during timeout
+type timeoutReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+// newTimeoutReader constructs timeoutReader from r, which calls cancel after timeout without data
+func newTimeoutReader(r io.Reader, timeout time.Duration, cancel func()) timeoutReader {
+	return timeoutReader{
+		r:       r,
+		timeout: timeout,
+		timer:   time.AfterFunc(timeout, cancel),
+	}
+}
+
+// Read reads data and restarts timeout
+func (tr timeoutReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	if n > 0 {
+		tr.timer.Reset(tr.timeout)
+	}
+	if err != nil {
+		tr.timer.Stop()
+	}
+	return n, err
+}
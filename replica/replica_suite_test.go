@@ -0,0 +1,17 @@
+package replica
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestReplica(t *testing.T) {
+	gin.SetMode("test")
+	gin.DefaultWriter = ioutil.Discard
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Replica Suite")
+}
@@ -0,0 +1,179 @@
+package replica
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/someanon/yamc/auth"
+	"github.com/someanon/yamc/client"
+	"github.com/someanon/yamc/server"
+	"github.com/someanon/yamc/store"
+)
+
+var _ = Describe("Replica", func() {
+	var (
+		dir      string
+		primary  store.Store
+		accounts *auth.Authenticator
+		handler  *swappableHandler
+		ts       *httptest.Server
+		rs       store.Store
+		r        *Replica
+		errs     *errorsHook
+		cancel   context.CancelFunc
+		done     chan struct{}
+	)
+	newStore := func(name string, readOnly bool) store.Store {
+		d, err := store.NewFileDumper(filepath.Join(dir, name), store.GobFormat, store.NoCompression)
+		Expect(err).ToNot(HaveOccurred())
+		s, err := store.NewStore(store.Params{
+			CleaningPeriod: time.Second,
+			DumpingPeriod:  time.Minute,
+			ReadOnly:       readOnly,
+		}, store.SystemClock{}, d)
+		Expect(err).ToNot(HaveOccurred())
+		return s
+	}
+	newReplica := func(password string) {
+		c, err := client.NewClient(ts.URL, "test", password)
+		Expect(err).ToNot(HaveOccurred())
+		r = NewReplica(c, rs)
+		r.retryDelay = 10 * time.Millisecond
+		errs = &errorsHook{}
+		r.OnError(errs.hook)
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan struct{})
+		go func() {
+			defer close(done)
+			r.Run(ctx)
+		}()
+	}
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "yamc-replica")
+		Expect(err).ToNot(HaveOccurred())
+		primary = newStore("primary", false)
+		accounts = auth.NewAuthenticator(auth.Accounts{"test": {Password: "test", Role: auth.Admin}})
+		handler = &swappableHandler{}
+		handler.set(server.NewRouter(accounts, primary))
+		ts = httptest.NewServer(handler)
+		rs = newStore("replica", true)
+	})
+	AfterEach(func() {
+		if cancel != nil {
+			cancel()
+			Eventually(done).Should(BeClosed())
+			cancel = nil
+		}
+		ts.CloseClientConnections()
+		ts.Close()
+		primary.Close(false)
+		rs.Close(false)
+		os.RemoveAll(dir)
+	})
+	Specify("restores snapshot and applies mutations", func() {
+		Expect(primary.Set("a", "v", time.Minute)).To(Succeed())
+		Expect(primary.ListSet("b", []string{"l1", "l2"}, time.Minute)).To(Succeed())
+		newReplica("test")
+
+		By("restoring snapshot")
+		Eventually(func() (string, error) { return rs.Get("a") }).Should(Equal("v"))
+		Expect(rs.ListGet("b", 1)).To(Equal("l2"))
+
+		By("applying mutations")
+		Expect(primary.DictSet("c", map[string]string{"dk": "dv"}, time.Minute)).To(Succeed())
+		Expect(primary.Remove("a")).To(Succeed())
+		Eventually(func() (string, error) { return rs.DictGet("c", "dk") }).Should(Equal("dv"))
+		Eventually(func() error { _, err := rs.Get("a"); return err }).Should(MatchError(store.ErrKeyNotExists))
+		status := rs.ReplicationStatus()
+		Expect(status.Position()).To(Equal(primary.ReplicationStatus().Position()))
+		Expect(status.Lag).To(BeZero())
+		Expect(errs.get()).To(BeEmpty())
+
+		By("rejecting writes to replica")
+		Expect(rs.Set("d", "v", time.Minute)).To(MatchError(store.ErrReadOnly))
+	})
+	Specify("restores snapshot again when primary history changes", func() {
+		Expect(primary.Set("a", "v", time.Minute)).To(Succeed())
+		newReplica("test")
+		Eventually(func() (string, error) { return rs.Get("a") }).Should(Equal("v"))
+
+		By("restarting primary")
+		primary.Close(false)
+		primary = newStore("restarted", false)
+		Expect(primary.Set("b", "v", time.Minute)).To(Succeed())
+		handler.set(server.NewRouter(accounts, primary))
+		ts.CloseClientConnections()
+
+		Eventually(func() (string, error) { return rs.Get("b") }).Should(Equal("v"))
+		Expect(rs.Keys()).To(ConsistOf("b"))
+		Expect(rs.ReplicationStatus().ID).To(Equal(primary.ReplicationStatus().ID))
+	})
+	Specify("reports errors and retries", func() {
+		newReplica("wrong")
+		Eventually(func() int { return len(errs.get()) }).Should(BeNumerically(">=", 2))
+		Expect(errs.get()[0]).To(MatchError(client.ErrUnauthorized))
+	})
+	Specify("breaks stream without data", func() {
+		handler.set(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-req.Context().Done()
+		}))
+		c, err := client.NewClient(ts.URL, "test", "test")
+		Expect(err).ToNot(HaveOccurred())
+		r = NewReplica(c, rs)
+		r.streamTimeout = 10 * time.Millisecond
+		replicated := make(chan error, 1)
+		go func() {
+			replicated <- r.replicate(context.Background())
+		}()
+		Eventually(replicated).Should(Receive(HaveOccurred()))
+	})
+})
+
+// swappableHandler is http handler which can be replaced while serving
+type swappableHandler struct {
+	mutex   sync.Mutex
+	handler http.Handler
+}
+
+func (sh *swappableHandler) set(h http.Handler) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	sh.handler = h
+}
+
+func (sh *swappableHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	sh.mutex.Lock()
+	h := sh.handler
+	sh.mutex.Unlock()
+	h.ServeHTTP(w, req)
+}
+
+// errorsHook collects errors passed to hook
+type errorsHook struct {
+	mutex sync.Mutex
+	errs  []error
+}
+
+func (eh *errorsHook) hook(err error) {
+	eh.mutex.Lock()
+	defer eh.mutex.Unlock()
+	eh.errs = append(eh.errs, err)
+}
+
+func (eh *errorsHook) get() []error {
+	eh.mutex.Lock()
+	defer eh.mutex.Unlock()
+	return append([]error(nil), eh.errs...)
+}
@@ -0,0 +1,472 @@
+package resp
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/someanon/yamc/auth"
+	"github.com/someanon/yamc/store"
+)
+
+// command is a RESP command. Arity is arguments count including command name, negative arity is minimal count.
+// Permission is required by command, none if zero. Keys are arguments from first to last key, last key is negative if
+// counted from the end, none if first key is zero. Values are arguments limited by account's value size limit, same
+// way as keys
+type command struct {
+	arity    int
+	noAuth   bool
+	perm     auth.Permission
+	firstKey int
+	lastKey  int
+	firstVal int
+	lastVal  int
+	handler  func(c *conn, args []string)
+}
+
+// commands is a supported commands by lowercase name
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"auth":    {arity: -2, noAuth: true, handler: (*conn).auth},
+		"hello":   {arity: -1, noAuth: true, handler: (*conn).hello},
+		"quit":    {arity: 1, noAuth: true, handler: (*conn).quitCmd},
+		"ping":    {arity: -1, handler: (*conn).ping},
+		"echo":    {arity: 2, handler: (*conn).echo},
+		"get":     {arity: 2, perm: auth.Read, firstKey: 1, lastKey: 1, handler: (*conn).get},
+		"set":     {arity: -3, perm: auth.Write, firstKey: 1, lastKey: 1, firstVal: 2, lastVal: 2, handler: (*conn).set},
+		"del":     {arity: -2, perm: auth.Write, firstKey: 1, lastKey: -1, handler: (*conn).del},
+		"expire":  {arity: 3, perm: auth.Write, firstKey: 1, lastKey: 1, handler: (*conn).expire},
+		"ttl":     {arity: 2, perm: auth.Read, firstKey: 1, lastKey: 1, handler: (*conn).ttl},
+		"pttl":    {arity: 2, perm: auth.Read, firstKey: 1, lastKey: 1, handler: (*conn).pttl},
+		"keys":    {arity: 2, perm: auth.Read, handler: (*conn).keys},
+		"lpush":   {arity: -3, perm: auth.Write, firstKey: 1, lastKey: 1, firstVal: 2, lastVal: -1, handler: (*conn).lpush},
+		"lrange":  {arity: 4, perm: auth.Read, firstKey: 1, lastKey: 1, handler: (*conn).lrange},
+		"hget":    {arity: 3, perm: auth.Read, firstKey: 1, lastKey: 1, handler: (*conn).hget},
+		"hset":    {arity: -4, perm: auth.Write, firstKey: 1, lastKey: 1, firstVal: 2, lastVal: -1, handler: (*conn).hset},
+		"hgetall": {arity: 2, perm: auth.Read, firstKey: 1, lastKey: 1, handler: (*conn).hgetall},
+	}
+}
+
+// exec validates and executes command args
+func (c *conn) exec(args []string) {
+	name := strings.ToLower(args[0])
+	cmd, exists := commands[name]
+	if !exists {
+		c.w.error(errUnknownCommand(args[0]))
+		return
+	}
+	if cmd.arity > 0 && len(args) != cmd.arity || cmd.arity < 0 && len(args) < -cmd.arity {
+		c.w.error(errArgsCount(name))
+		return
+	}
+	if !cmd.noAuth && !c.reauthenticate() {
+		c.w.error(errNoAuth)
+		return
+	}
+	if !cmd.noAuth {
+		if allowed, _ := c.server.accounts.Limiter().Allow(c.login, c.account.Limits); !allowed {
+			c.w.error(errRateLimit)
+			return
+		}
+	}
+	if cmd.perm != 0 && !c.account.Allows(cmd.perm) {
+		c.w.error(errNoPermCommand(name))
+		return
+	}
+	if cmd.firstKey > 0 {
+		last := cmd.lastKey
+		if last < 0 {
+			last += len(args)
+		}
+		for _, key := range args[cmd.firstKey : last+1] {
+			if !c.account.AllowsKey(key) {
+				c.w.error(errNoPermKey)
+				return
+			}
+		}
+	}
+	if max := c.account.Limits.MaxValueSize; cmd.firstVal > 0 && max > 0 {
+		last := cmd.lastVal
+		if last < 0 {
+			last += len(args)
+		}
+		size := int64(0)
+		for _, v := range args[cmd.firstVal : last+1] {
+			size += int64(len(v))
+		}
+		if size > max {
+			c.server.accounts.Limiter().Reject(c.login)
+			c.w.error(errValueTooLarge)
+			return
+		}
+	}
+	cmd.handler(c, args)
+}
+
+// authenticate authenticates connection by login and password, binding it to store of account's namespace. Returns
+// false if credentials are wrong
+func (c *conn) authenticate(login string, password string) bool {
+	account, ok := c.server.accounts.Authenticate(login, password)
+	if !ok {
+		return false
+	}
+	return c.bind(login, account)
+}
+
+// bind binds connection to store's view of account of login in account's namespace, so items set by connection are
+// owned by account and limited by account's keys and bytes quotas. Returns false if namespace is not exists
+func (c *conn) bind(login string, account auth.Account) bool {
+	st, err := c.server.namespaces.Namespace(account.Namespace)
+	if err != nil {
+		return false
+	}
+	q := store.Quota{MaxKeys: account.Limits.MaxKeys, MaxBytes: account.Limits.MaxBytes}
+	c.authenticated, c.login, c.account, c.store = true, login, account, st.As(login, q)
+	return true
+}
+
+// reauthenticate refreshes account of authenticated connection, so accounts reload applies to next command.
+// Unauthenticates connection and returns false if account was removed or its password or namespace was changed
+func (c *conn) reauthenticate() bool {
+	if !c.authenticated {
+		return false
+	}
+	account, ok := c.server.accounts.Reauthenticate(c.login, c.account)
+	if !ok || !c.bind(c.login, account) {
+		c.authenticated, c.login, c.account, c.store = false, "", auth.Account{}, nil
+		return false
+	}
+	return true
+}
+
+// storeError replies with error of store error err. Quota errors are counted as rejected requests of account
+func (c *conn) storeError(err error) {
+	if err == store.ErrKeysQuotaExceeded || err == store.ErrBytesQuotaExceeded {
+		c.server.accounts.Limiter().Reject(c.login)
+	}
+	c.w.error(errStore(err))
+}
+
+// auth handles AUTH [login] password. Login is "default" if omitted
+func (c *conn) auth(args []string) {
+	login, password := "default", args[1]
+	switch len(args) {
+	case 2:
+	case 3:
+		login, password = args[1], args[2]
+	default:
+		c.w.error(errSyntax)
+		return
+	}
+	if !c.authenticate(login, password) {
+		c.w.error(errWrongPass)
+		return
+	}
+	c.w.status("OK")
+}
+
+// hello handles HELLO [protover [AUTH login password] [SETNAME name]], switching protocol version
+func (c *conn) hello(args []string) {
+	proto := c.w.proto
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil {
+			c.w.error(errProtoVersion)
+			return
+		}
+		if v != resp2 && v != resp3 {
+			c.w.error(errNoProto)
+			return
+		}
+		proto = v
+	}
+	var login, password string
+	withAuth := false
+	for i := 2; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "auth") && i+2 < len(args):
+			login, password, withAuth = args[i+1], args[i+2], true
+			i += 2
+		case strings.EqualFold(args[i], "setname") && i+1 < len(args):
+			i++
+		default:
+			c.w.error(errSyntax)
+			return
+		}
+	}
+	if withAuth && !c.authenticate(login, password) {
+		c.w.error(errWrongPass)
+		return
+	}
+	if !c.authenticated {
+		c.w.error(errNoAuthHello)
+		return
+	}
+	c.w.proto = proto
+	c.w.mapHeader(5)
+	c.w.bulk("server")
+	c.w.bulk("yamc")
+	c.w.bulk("proto")
+	c.w.int(int64(proto))
+	c.w.bulk("mode")
+	c.w.bulk("standalone")
+	c.w.bulk("role")
+	c.w.bulk("master")
+	c.w.bulk("modules")
+	c.w.array(0)
+}
+
+// quitCmd handles QUIT, closing connection after reply
+func (c *conn) quitCmd(_ []string) {
+	c.w.status("OK")
+	c.quit = true
+}
+
+// ping handles PING [message]
+func (c *conn) ping(args []string) {
+	switch len(args) {
+	case 1:
+		c.w.status("PONG")
+	case 2:
+		c.w.bulk(args[1])
+	default:
+		c.w.error(errArgsCount("ping"))
+	}
+}
+
+// echo handles ECHO message
+func (c *conn) echo(args []string) {
+	c.w.bulk(args[1])
+}
+
+// get handles GET key
+func (c *conn) get(args []string) {
+	v, err := c.store.Get(args[1])
+	if err == store.ErrKeyNotExists {
+		c.w.null()
+		return
+	} else if err != nil {
+		c.storeError(err)
+		return
+	}
+	c.w.bulk(v)
+}
+
+// set handles SET key value [EX seconds | PX milliseconds]. Value without expiration lives default ttl
+func (c *conn) set(args []string) {
+	ttl := c.server.params.DefaultTTL
+	opts := args[3:]
+	if len(opts) > 0 {
+		if len(opts) != 2 {
+			c.w.error(errSyntax)
+			return
+		}
+		var unit time.Duration
+		switch strings.ToLower(opts[0]) {
+		case "ex":
+			unit = time.Second
+		case "px":
+			unit = time.Millisecond
+		default:
+			c.w.error(errSyntax)
+			return
+		}
+		n, err := strconv.ParseInt(opts[1], 10, 64)
+		if err != nil {
+			c.w.error(errNotInteger)
+			return
+		}
+		if n <= 0 || n > math.MaxInt64/int64(unit) {
+			c.w.error(errInvalidExpire("set"))
+			return
+		}
+		ttl = time.Duration(n) * unit
+	}
+	if err := c.store.Set(args[1], args[2], ttl); err != nil {
+		c.storeError(err)
+		return
+	}
+	c.w.status("OK")
+}
+
+// del handles DEL key [key ...]. Replies with count of removed keys
+func (c *conn) del(args []string) {
+	removed := int64(0)
+	for _, key := range args[1:] {
+		if err := c.store.Remove(key); err == nil {
+			removed++
+		} else if err != store.ErrKeyNotExists {
+			c.storeError(err)
+			return
+		}
+	}
+	c.w.int(removed)
+}
+
+// expire handles EXPIRE key seconds. Non positive seconds remove key. Replies 1 if key exists, otherwise 0
+func (c *conn) expire(args []string) {
+	n, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		c.w.error(errNotInteger)
+		return
+	}
+	if n > math.MaxInt64/int64(time.Second) {
+		c.w.error(errInvalidExpire("expire"))
+		return
+	}
+	if n <= 0 {
+		err = c.store.Remove(args[1])
+	} else {
+		err = c.store.Expire(args[1], time.Duration(n)*time.Second)
+	}
+	if err == store.ErrKeyNotExists {
+		c.w.int(0)
+		return
+	} else if err != nil {
+		c.storeError(err)
+		return
+	}
+	c.w.int(1)
+}
+
+// ttl handles TTL key. Replies with rounded seconds to live, -2 if key not exists
+func (c *conn) ttl(args []string) {
+	c.replyTTL(args[1], time.Second)
+}
+
+// pttl handles PTTL key. Replies with milliseconds to live, -2 if key not exists
+func (c *conn) pttl(args []string) {
+	c.replyTTL(args[1], time.Millisecond)
+}
+
+// replyTTL replies with time to live of key rounded to unit, -2 if key not exists
+func (c *conn) replyTTL(key string, unit time.Duration) {
+	ttl, err := c.store.TTL(key)
+	if err == store.ErrKeyNotExists {
+		c.w.int(-2)
+		return
+	} else if err != nil {
+		c.storeError(err)
+		return
+	}
+	c.w.int(int64((ttl + unit/2) / unit))
+}
+
+// keys handles KEYS pattern. Replies with sorted keys matching glob-style pattern and allowed to account
+func (c *conn) keys(args []string) {
+	keys, err := c.store.Keys()
+	if err != nil {
+		c.storeError(err)
+		return
+	}
+	matched := []string{}
+	for _, key := range keys {
+		if auth.Match(args[1], key) && c.account.AllowsKey(key) {
+			matched = append(matched, key)
+		}
+	}
+	sort.Strings(matched)
+	c.w.strings(matched)
+}
+
+// lpush handles LPUSH key value [value ...]. New list lives default ttl. Replies with list length
+func (c *conn) lpush(args []string) {
+	n, err := c.store.ListPush(args[1], args[2:], c.server.params.DefaultTTL)
+	if err != nil {
+		c.storeError(err)
+		return
+	}
+	c.w.int(int64(n))
+}
+
+// lrange handles LRANGE key start stop. Negative indexes are counted from the end of list
+func (c *conn) lrange(args []string) {
+	start, err := strconv.Atoi(args[2])
+	if err != nil {
+		c.w.error(errNotInteger)
+		return
+	}
+	stop, err := strconv.Atoi(args[3])
+	if err != nil {
+		c.w.error(errNotInteger)
+		return
+	}
+	list, err := c.store.ListGetAll(args[1])
+	if err == store.ErrKeyNotExists {
+		c.w.array(0)
+		return
+	} else if err != nil {
+		c.storeError(err)
+		return
+	}
+	if start < 0 {
+		start += len(list)
+	}
+	if stop < 0 {
+		stop += len(list)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= len(list) {
+		stop = len(list) - 1
+	}
+	if start > stop {
+		c.w.array(0)
+		return
+	}
+	c.w.strings(list[start : stop+1])
+}
+
+// hget handles HGET key field
+func (c *conn) hget(args []string) {
+	v, err := c.store.DictGet(args[1], args[2])
+	if err == store.ErrKeyNotExists || err == store.ErrDictKeyNotExists {
+		c.w.null()
+		return
+	} else if err != nil {
+		c.storeError(err)
+		return
+	}
+	c.w.bulk(v)
+}
+
+// hset handles HSET key field value [field value ...]. New dict lives default ttl. Replies with count of added fields
+func (c *conn) hset(args []string) {
+	if len(args)%2 != 0 {
+		c.w.error(errArgsCount("hset"))
+		return
+	}
+	dict := map[string]string{}
+	for i := 2; i < len(args); i += 2 {
+		dict[args[i]] = args[i+1]
+	}
+	n, err := c.store.DictUpdate(args[1], dict, c.server.params.DefaultTTL)
+	if err != nil {
+		c.storeError(err)
+		return
+	}
+	c.w.int(int64(n))
+}
+
+// hgetall handles HGETALL key. Replies with fields sorted
+func (c *conn) hgetall(args []string) {
+	dict, err := c.store.DictGetAll(args[1])
+	if err == store.ErrKeyNotExists {
+		c.w.mapHeader(0)
+		return
+	} else if err != nil {
+		c.storeError(err)
+		return
+	}
+	keys := make([]string, 0, len(dict))
+	for k := range dict {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	c.w.dict(keys, dict)
+}
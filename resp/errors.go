@@ -0,0 +1,62 @@
+package resp
+
+import (
+	"errors"
+
+	"github.com/someanon/yamc/consensus"
+	"github.com/someanon/yamc/store"
+)
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("resp server closed")
+
+// error replies
+const (
+	errSyntax         = "ERR syntax error"
+	errNotInteger     = "ERR value is not an integer or out of range"
+	errNoAuth         = "NOAUTH Authentication required."
+	errNoAuthHello    = "NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time"
+	errWrongPass      = "WRONGPASS invalid username-password pair or user is disabled."
+	errNoProto        = "NOPROTO unsupported protocol version"
+	errProtoVersion   = "ERR Protocol version is not an integer or out of range"
+	errWrongType      = "WRONGTYPE Operation against a key holding the wrong kind of value"
+	errReadOnly       = "READONLY You can't write against a read only replica."
+	errNotLeader      = "READONLY You can't write against a consensus follower, write to leader."
+	errNoPermKey      = "NOPERM this user has no permissions to access one of the keys used as arguments"
+	errRateLimit      = "ERR rate limit exceeded"
+	errValueTooLarge  = "ERR value too large"
+	errProtocolPrefix = "ERR Protocol error: "
+)
+
+// errUnknownCommand returns unknown command error reply
+func errUnknownCommand(name string) string {
+	return "ERR unknown command '" + name + "'"
+}
+
+// errNoPermCommand returns no permission to run command error reply
+func errNoPermCommand(name string) string {
+	return "NOPERM this user has no permissions to run the '" + name + "' command"
+}
+
+// errArgsCount returns wrong number of arguments error reply
+func errArgsCount(name string) string {
+	return "ERR wrong number of arguments for '" + name + "' command"
+}
+
+// errInvalidExpire returns invalid expire time error reply
+func errInvalidExpire(name string) string {
+	return "ERR invalid expire time in '" + name + "' command"
+}
+
+// errStore returns error reply of store error
+func errStore(err error) string {
+	switch err {
+	case store.ErrNotKeyItem, store.ErrNotListItem, store.ErrNotDictItem:
+		return errWrongType
+	case store.ErrReadOnly:
+		return errReadOnly
+	case consensus.ErrNotLeader:
+		return errNotLeader
+	}
+	return "ERR " + err.Error()
+}
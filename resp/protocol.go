@@ -0,0 +1,167 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// request limits
+	maxArgs         = 1024 * 1024
+	maxBulkLength   = 64 * 1024 * 1024
+	maxInlineLength = 64 * 1024
+
+	// protocol versions
+	resp2 = 2
+	resp3 = 3
+)
+
+// protocolError is an error of malformed request. Connection is closed after protocol error reply
+type protocolError string
+
+// Error returns error's string representation
+func (e protocolError) Error() string {
+	return string(e)
+}
+
+// reader reads commands sent as RESP arrays of bulk strings or as inline commands
+type reader struct {
+	*bufio.Reader
+}
+
+// newReader constructs commands reader of r
+func newReader(r io.Reader) *reader {
+	return &reader{bufio.NewReaderSize(r, maxInlineLength)}
+}
+
+// readCommand reads command arguments. Returns empty arguments on empty inline command
+func (r *reader) readCommand() ([]string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArgs {
+		return nil, protocolError("invalid multibulk length")
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		arg, err := r.readBulk()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readBulk reads bulk string
+func (r *reader) readBulk() (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "$") {
+		return "", protocolError("expected '$', got '" + line + "'")
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxBulkLength {
+		return "", protocolError("invalid bulk length")
+	}
+	data := make([]byte, n+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	if string(data[n:]) != "\r\n" {
+		return "", protocolError("invalid bulk terminator")
+	}
+	return string(data[:n]), nil
+}
+
+// readLine reads line terminated by CRLF or LF, without terminator
+func (r *reader) readLine() (string, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", protocolError("too big inline request")
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+// writer writes replies of negotiated protocol version. Write errors are kept by bufio.Writer and returned on Flush
+type writer struct {
+	*bufio.Writer
+	proto int
+}
+
+// newWriter constructs RESP2 replies writer of w
+func newWriter(w io.Writer) *writer {
+	return &writer{Writer: bufio.NewWriter(w), proto: resp2}
+}
+
+// status writes simple string reply
+func (w *writer) status(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+// error writes error reply. Error message starts with error code, e.g. "ERR"
+func (w *writer) error(msg string) {
+	w.WriteString("-" + msg + "\r\n")
+}
+
+// int writes integer reply
+func (w *writer) int(n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+// bulk writes bulk string reply
+func (w *writer) bulk(s string) {
+	w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+// null writes null reply
+func (w *writer) null() {
+	if w.proto == resp3 {
+		w.WriteString("_\r\n")
+		return
+	}
+	w.WriteString("$-1\r\n")
+}
+
+// array writes header of array reply of n elements
+func (w *writer) array(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// strings writes array reply of bulk strings
+func (w *writer) strings(list []string) {
+	w.array(len(list))
+	for _, s := range list {
+		w.bulk(s)
+	}
+}
+
+// dict writes map reply of bulk strings. RESP2 map is an array of keys and values
+func (w *writer) dict(keys []string, dict map[string]string) {
+	w.mapHeader(len(keys))
+	for _, k := range keys {
+		w.bulk(k)
+		w.bulk(dict[k])
+	}
+}
+
+// mapHeader writes header of map reply of n entries
+func (w *writer) mapHeader(n int) {
+	if w.proto == resp3 {
+		w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+		return
+	}
+	w.array(2 * n)
+}
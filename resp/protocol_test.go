@@ -0,0 +1,85 @@
+package resp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("reader", func() {
+	read := func(data string) ([]string, error) {
+		return newReader(strings.NewReader(data)).readCommand()
+	}
+	Specify("multibulk command", func() {
+		Expect(read("*3\r\n$3\r\nSET\r\n$1\r\na\r\n$4\r\nv\r\nv\r\n")).To(Equal([]string{"SET", "a", "v\r\nv"}))
+	})
+	Specify("empty multibulk command", func() {
+		Expect(read("*0\r\n")).To(BeEmpty())
+	})
+	Specify("inline command", func() {
+		Expect(read("GET  a\n")).To(Equal([]string{"GET", "a"}))
+		Expect(read("PING\r\n")).To(Equal([]string{"PING"}))
+	})
+	Specify("empty inline command", func() {
+		Expect(read("\r\n")).To(BeEmpty())
+	})
+	Specify("pipelined commands", func() {
+		r := newReader(strings.NewReader("*1\r\n$4\r\nPING\r\nECHO a\r\n"))
+		Expect(r.readCommand()).To(Equal([]string{"PING"}))
+		Expect(r.readCommand()).To(Equal([]string{"ECHO", "a"}))
+		_, err := r.readCommand()
+		Expect(err).To(Equal(io.EOF))
+	})
+	Specify("protocol errors", func() {
+		for data, msg := range map[string]string{
+			"*x\r\n":                 "invalid multibulk length",
+			"*1\r\n+a\r\n":           "expected '$', got '+a'",
+			"*1\r\n$-1\r\n":          "invalid bulk length",
+			"*1\r\n$100000000\r\n":   "invalid bulk length",
+			"*1\r\n$1\r\nab\r\n":     "invalid bulk terminator",
+			strings.Repeat("a", 1e5): "too big inline request",
+		} {
+			_, err := read(data)
+			Expect(err).To(MatchError(protocolError(msg)), data)
+		}
+	})
+	Specify("unexpected end of data", func() {
+		_, err := read("*1\r\n$3\r\nab")
+		Expect(err).To(Equal(io.ErrUnexpectedEOF))
+	})
+})
+
+var _ = Describe("writer", func() {
+	var (
+		b *bytes.Buffer
+		w *writer
+	)
+	BeforeEach(func() {
+		b = &bytes.Buffer{}
+		w = newWriter(b)
+	})
+	written := func() string {
+		Expect(w.Flush()).To(Succeed())
+		return b.String()
+	}
+	Specify("RESP2 replies", func() {
+		w.status("OK")
+		w.error("ERR e")
+		w.int(-2)
+		w.bulk("a\r\n")
+		w.null()
+		w.strings([]string{"a", ""})
+		w.dict([]string{"a"}, map[string]string{"a": "b"})
+		Expect(written()).To(Equal("+OK\r\n-ERR e\r\n:-2\r\n$3\r\na\r\n\r\n$-1\r\n*2\r\n$1\r\na\r\n$0\r\n\r\n" +
+			"*2\r\n$1\r\na\r\n$1\r\nb\r\n"))
+	})
+	Specify("RESP3 replies", func() {
+		w.proto = resp3
+		w.null()
+		w.dict([]string{"a"}, map[string]string{"a": "b"})
+		Expect(written()).To(Equal("_\r\n%1\r\n$1\r\na\r\n$1\r\nb\r\n"))
+	})
+})
@@ -0,0 +1,13 @@
+package resp
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestRESP(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RESP Suite")
+}
@@ -0,0 +1,166 @@
+package resp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/someanon/yamc/auth"
+	"github.com/someanon/yamc/store"
+)
+
+// Params is a RESP server parameters
+type Params struct {
+	// DefaultTTL is time to live of items set without expiration, Redis items live forever by default
+	DefaultTTL time.Duration
+}
+
+// Validate validates RESP server parameters
+func (p Params) Validate() error {
+	if p.DefaultTTL <= 0 {
+		return errors.New("default ttl must be positive")
+	}
+	return nil
+}
+
+// Server is a Redis RESP2/RESP3 protocol server of store. Clients authenticate by AUTH or HELLO command with
+// accounts' login and password, commands and keys are limited by account's role, keys and limits
+type Server struct {
+	mutex      sync.Mutex
+	wg         sync.WaitGroup
+	closed     bool
+	accounts   *auth.Authenticator
+	namespaces store.Namespaces
+	params     Params
+	listeners  map[net.Listener]struct{}
+	conns      map[net.Conn]struct{}
+}
+
+// NewServer constructs RESP server of namespaces ns authenticating by authenticator a. Connection is served by
+// store of account's namespace, authenticated account is looked up on each command, so accounts reload applies to
+// open connections. Errors if params p are invalid
+func NewServer(a *auth.Authenticator, ns store.Namespaces, p Params) (*Server, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &Server{
+		accounts:   a,
+		namespaces: ns,
+		params:     p,
+		listeners:  map[net.Listener]struct{}{},
+		conns:      map[net.Conn]struct{}{},
+	}, nil
+}
+
+// Serve accepts connections on listener l and serves each in its own goroutine. Always returns non nil error,
+// ErrServerClosed after Close
+func (s *Server) Serve(l net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mutex.Unlock()
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			delete(s.listeners, l)
+			if s.closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(nc) {
+			nc.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.untrack(nc)
+			newConn(s, nc).serve()
+		}()
+	}
+}
+
+// Close closes all listeners and connections, then waits for in-progress commands
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for nc := range s.conns {
+		nc.Close()
+	}
+	s.mutex.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// track registers connection nc. Returns false if server is closed
+func (s *Server) track(nc net.Conn) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[nc] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+// untrack closes and unregisters connection nc
+func (s *Server) untrack(nc net.Conn) {
+	nc.Close()
+	s.mutex.Lock()
+	delete(s.conns, nc)
+	s.mutex.Unlock()
+	s.wg.Done()
+}
+
+// conn is a client connection
+type conn struct {
+	server        *Server
+	nc            net.Conn
+	r             *reader
+	w             *writer
+	authenticated bool
+	login         string
+	account       auth.Account
+	store         store.Store
+	quit          bool
+}
+
+// newConn constructs client connection nc of server s
+func newConn(s *Server, nc net.Conn) *conn {
+	return &conn{server: s, nc: nc, r: newReader(nc), w: newWriter(nc)}
+}
+
+// serve reads and executes commands until connection is closed, QUIT command or protocol error. Replies are flushed
+// when there are no more pipelined commands
+func (c *conn) serve() {
+	for !c.quit {
+		args, err := c.r.readCommand()
+		var perr protocolError
+		if errors.As(err, &perr) {
+			c.w.error(errProtocolPrefix + perr.Error())
+			c.w.Flush()
+			return
+		} else if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		c.exec(args)
+		if c.r.Buffered() == 0 || c.quit {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
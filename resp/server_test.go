@@ -0,0 +1,352 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/someanon/yamc/auth"
+	"github.com/someanon/yamc/store"
+)
+
+// testClient is a raw RESP client returning replies as is
+type testClient struct {
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+// do sends command args and returns raw reply
+func (c *testClient) do(args ...string) string {
+	cmd := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		cmd += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+	_, err := c.nc.Write([]byte(cmd))
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	return c.read()
+}
+
+// read reads single raw reply
+func (c *testClient) read() string {
+	line, err := c.r.ReadString('\n')
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	switch line[0] {
+	case '$':
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if n < 0 {
+			return line
+		}
+		data := make([]byte, n+2)
+		_, err := io.ReadFull(c.r, data)
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		return line + string(data)
+	case '*', '%':
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if line[0] == '%' {
+			n *= 2
+		}
+		for i := 0; i < n; i++ {
+			line += c.read()
+		}
+	}
+	return line
+}
+
+var _ = Describe("Server", func() {
+	var (
+		dir string
+		st  store.Store
+		tst store.Store
+		a   *auth.Authenticator
+		s   *Server
+		l   net.Listener
+		c   *testClient
+	)
+	dial := func() *testClient {
+		nc, err := net.Dial("tcp", l.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		return &testClient{nc: nc, r: bufio.NewReader(nc)}
+	}
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "resp")
+		Expect(err).ToNot(HaveOccurred())
+		d, err := store.NewFileDumper(filepath.Join(dir, "dump"), store.GobFormat, store.NoCompression)
+		Expect(err).ToNot(HaveOccurred())
+		st, err = store.NewStore(store.Params{CleaningPeriod: time.Second, DumpingPeriod: time.Minute},
+			store.SystemClock{}, d)
+		Expect(err).ToNot(HaveOccurred())
+		td, err := store.NewFileDumper(filepath.Join(dir, "dump.t"), store.GobFormat, store.NoCompression)
+		Expect(err).ToNot(HaveOccurred())
+		tst, err = store.NewStore(store.Params{CleaningPeriod: time.Second, DumpingPeriod: time.Minute},
+			store.SystemClock{}, td)
+		Expect(err).ToNot(HaveOccurred())
+		a = auth.NewAuthenticator(auth.Accounts{
+			"test":    {Password: "test", Role: auth.Admin},
+			"default": {Password: "secret", Role: auth.Admin},
+			"reader":  {Password: "test", Role: auth.ReadOnly, Keys: []string{"user:*"}},
+			"tenant":  {Password: "test", Role: auth.ReadWrite, Namespace: "t"},
+		})
+		s, err = NewServer(a, store.Namespaces{store.DefaultNamespace: st, "t": tst}, Params{DefaultTTL: time.Hour})
+		Expect(err).ToNot(HaveOccurred())
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		go s.Serve(l)
+		c = dial()
+		Expect(c.do("AUTH", "test", "test")).To(Equal("+OK\r\n"))
+	})
+	AfterEach(func() {
+		c.nc.Close()
+		s.Close()
+		st.Close(false)
+		tst.Close(false)
+		os.RemoveAll(dir)
+	})
+	Specify("invalid params error", func() {
+		_, err := NewServer(nil, nil, Params{})
+		Expect(err).To(MatchError("default ttl must be positive"))
+	})
+	Describe("authentication", func() {
+		BeforeEach(func() {
+			c = dial()
+		})
+		Specify("commands require authentication", func() {
+			Expect(c.do("GET", "a")).To(Equal("-NOAUTH Authentication required.\r\n"))
+			Expect(c.do("HELLO", "3")).To(HavePrefix("-NOAUTH HELLO must be called"))
+		})
+		Specify("wrong password error", func() {
+			Expect(c.do("AUTH", "test", "wrong")).To(Equal("-WRONGPASS invalid username-password pair or user is " +
+				"disabled.\r\n"))
+			Expect(c.do("AUTH", "unknown", "test")).To(HavePrefix("-WRONGPASS"))
+			Expect(c.do("AUTH", "test")).To(HavePrefix("-WRONGPASS"))
+			Expect(c.do("HELLO", "3", "AUTH", "test", "wrong")).To(HavePrefix("-WRONGPASS"))
+			Expect(c.do("GET", "a")).To(HavePrefix("-NOAUTH"))
+		})
+		Specify("default account", func() {
+			Expect(c.do("AUTH", "secret")).To(Equal("+OK\r\n"))
+			Expect(c.do("GET", "a")).To(Equal("$-1\r\n"))
+		})
+		Specify("HELLO with AUTH switches to RESP3", func() {
+			Expect(c.do("HELLO", "3", "AUTH", "test", "test", "SETNAME", "client")).To(Equal("%5\r\n" +
+				"$6\r\nserver\r\n$4\r\nyamc\r\n$5\r\nproto\r\n:3\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n" +
+				"$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n"))
+			Expect(c.do("GET", "a")).To(Equal("_\r\n"))
+		})
+		Specify("connection is bound to account's namespace", func() {
+			Expect(st.Set("a", "default", time.Minute)).To(Succeed())
+			Expect(c.do("AUTH", "tenant", "test")).To(Equal("+OK\r\n"))
+			Expect(c.do("GET", "a")).To(Equal("$-1\r\n"))
+			Expect(c.do("SET", "a", "tenant")).To(Equal("+OK\r\n"))
+			Expect(tst.Get("a")).To(Equal("tenant"))
+			Expect(st.Get("a")).To(Equal("default"))
+		})
+		Specify("commands are limited by account", func() {
+			Expect(st.Set("user:1", "v", time.Minute)).To(Succeed())
+			Expect(st.Set("a", "v", time.Minute)).To(Succeed())
+			Expect(c.do("AUTH", "reader", "test")).To(Equal("+OK\r\n"))
+			Expect(c.do("GET", "user:1")).To(Equal("$1\r\nv\r\n"))
+			Expect(c.do("GET", "a")).To(Equal("-NOPERM this user has no permissions to access one of the keys used as " +
+				"arguments\r\n"))
+			Expect(c.do("SET", "user:1", "w")).To(Equal("-NOPERM this user has no permissions to run the 'set' " +
+				"command\r\n"))
+			Expect(c.do("KEYS", "*")).To(Equal("*1\r\n$6\r\nuser:1\r\n"))
+		})
+		Specify("accounts reload applies to authenticated connection", func() {
+			Expect(c.do("AUTH", "tenant", "test")).To(Equal("+OK\r\n"))
+			Expect(c.do("SET", "a", "v")).To(Equal("+OK\r\n"))
+			accounts := a.Accounts()
+			accounts["tenant"] = auth.Account{Password: "test", Role: auth.ReadOnly, Namespace: "t"}
+			a.Set(accounts)
+			Expect(c.do("SET", "a", "w")).To(HavePrefix("-NOPERM"))
+			Expect(c.do("GET", "a")).To(Equal("$1\r\nv\r\n"))
+
+			By("revoking removed account")
+			delete(accounts, "tenant")
+			a.Set(accounts)
+			Expect(c.do("GET", "a")).To(HavePrefix("-NOAUTH"))
+			Expect(c.do("GET", "a")).To(HavePrefix("-NOAUTH"))
+		})
+	})
+	Describe("limits", func() {
+		BeforeEach(func() {
+			accounts := a.Accounts()
+			accounts["limited"] = auth.Account{Password: "test", Role: auth.ReadWrite,
+				Limits: auth.Limits{MaxKeys: 1, MaxValueSize: 3}}
+			accounts["throttled"] = auth.Account{Password: "test", Role: auth.ReadWrite,
+				Limits: auth.Limits{RequestsPerSecond: 0.001}}
+			a.Set(accounts)
+		})
+		Specify("quotas", func() {
+			Expect(st.Set("b", "v", time.Minute)).To(Succeed())
+			Expect(c.do("AUTH", "limited", "test")).To(Equal("+OK\r\n"))
+			Expect(c.do("SET", "a", "vvv")).To(Equal("+OK\r\n"))
+			Expect(c.do("SET", "a", "vv")).To(Equal("+OK\r\n"))
+			Expect(c.do("SET", "b", "v")).To(Equal("-ERR keys quota exceeded\r\n"))
+			Expect(c.do("SET", "a", "vvvv")).To(Equal("-ERR value too large\r\n"))
+			Expect(c.do("HSET", "h", "ff", "vv")).To(Equal("-ERR value too large\r\n"))
+			Expect(st.UsageOf("limited").Items).To(Equal(1))
+			Expect(a.Limiter().Rejected("limited")).To(Equal(uint64(3)))
+		})
+		Specify("rate limit", func() {
+			Expect(c.do("AUTH", "throttled", "test")).To(Equal("+OK\r\n"))
+			Expect(c.do("PING")).To(Equal("+PONG\r\n"))
+			Expect(c.do("PING")).To(Equal("-ERR rate limit exceeded\r\n"))
+			Expect(c.do("AUTH", "test", "test")).To(Equal("+OK\r\n"))
+			Expect(c.do("PING")).To(Equal("+PONG\r\n"))
+		})
+	})
+	Specify("HELLO errors", func() {
+		Expect(c.do("HELLO", "x")).To(Equal("-ERR Protocol version is not an integer or out of range\r\n"))
+		Expect(c.do("HELLO", "4")).To(Equal("-NOPROTO unsupported protocol version\r\n"))
+		Expect(c.do("HELLO", "3", "AUTH", "test")).To(Equal("-ERR syntax error\r\n"))
+		Expect(c.do("HELLO")).To(HavePrefix("*10\r\n"))
+	})
+	Specify("command errors", func() {
+		Expect(c.do("UNKNOWN", "a")).To(Equal("-ERR unknown command 'UNKNOWN'\r\n"))
+		Expect(c.do("GET")).To(Equal("-ERR wrong number of arguments for 'get' command\r\n"))
+		Expect(c.do("HSET", "a", "f")).To(Equal("-ERR wrong number of arguments for 'hset' command\r\n"))
+		Expect(c.do("HSET", "a", "f", "v", "f2")).To(Equal("-ERR wrong number of arguments for 'hset' command\r\n"))
+	})
+	Specify("PING, ECHO and QUIT", func() {
+		Expect(c.do("PING")).To(Equal("+PONG\r\n"))
+		Expect(c.do("ping", "a")).To(Equal("$1\r\na\r\n"))
+		Expect(c.do("ECHO", "a")).To(Equal("$1\r\na\r\n"))
+		Expect(c.do("QUIT")).To(Equal("+OK\r\n"))
+		_, err := c.r.ReadByte()
+		Expect(err).To(Equal(io.EOF))
+	})
+	Specify("inline and pipelined commands", func() {
+		_, err := c.nc.Write([]byte("SET a v\r\nGET a\r\nPING\r\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.read()).To(Equal("+OK\r\n"))
+		Expect(c.read()).To(Equal("$1\r\nv\r\n"))
+		Expect(c.read()).To(Equal("+PONG\r\n"))
+	})
+	Specify("protocol error closes connection", func() {
+		_, err := c.nc.Write([]byte("*1\r\n+a\r\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.read()).To(Equal("-ERR Protocol error: expected '$', got '+a'\r\n"))
+		_, err = c.r.ReadByte()
+		Expect(err).To(Equal(io.EOF))
+	})
+	Describe("keys", func() {
+		Specify("GET and SET", func() {
+			Expect(c.do("GET", "a")).To(Equal("$-1\r\n"))
+			Expect(c.do("SET", "a", "v")).To(Equal("+OK\r\n"))
+			Expect(c.do("GET", "a")).To(Equal("$1\r\nv\r\n"))
+			Expect(st.TTL("a")).To(BeNumerically("~", time.Hour, time.Second))
+		})
+		Specify("SET with expiration", func() {
+			Expect(c.do("SET", "a", "v", "EX", "10")).To(Equal("+OK\r\n"))
+			Expect(st.TTL("a")).To(BeNumerically("~", 10*time.Second, time.Second))
+			Expect(c.do("SET", "a", "v", "px", "100")).To(Equal("+OK\r\n"))
+			Expect(st.TTL("a")).To(BeNumerically("~", 100*time.Millisecond, 50*time.Millisecond))
+		})
+		Specify("SET errors", func() {
+			Expect(c.do("SET", "a", "v", "EX")).To(Equal("-ERR syntax error\r\n"))
+			Expect(c.do("SET", "a", "v", "NX", "1")).To(Equal("-ERR syntax error\r\n"))
+			Expect(c.do("SET", "a", "v", "EX", "x")).To(Equal("-ERR value is not an integer or out of range\r\n"))
+			Expect(c.do("SET", "a", "v", "EX", "0")).To(Equal("-ERR invalid expire time in 'set' command\r\n"))
+			Expect(c.do("SET", "a", "v", "EX", "9223372036854775807")).
+				To(Equal("-ERR invalid expire time in 'set' command\r\n"))
+			Expect(st.Keys()).To(BeEmpty())
+		})
+		Specify("DEL", func() {
+			Expect(st.Set("a", "v", time.Minute)).To(Succeed())
+			Expect(st.ListSet("b", nil, time.Minute)).To(Succeed())
+			Expect(c.do("DEL", "a", "b", "c")).To(Equal(":2\r\n"))
+			Expect(st.Keys()).To(BeEmpty())
+		})
+		Specify("EXPIRE, TTL and PTTL", func() {
+			Expect(c.do("TTL", "a")).To(Equal(":-2\r\n"))
+			Expect(c.do("PTTL", "a")).To(Equal(":-2\r\n"))
+			Expect(c.do("EXPIRE", "a", "10")).To(Equal(":0\r\n"))
+			Expect(st.Set("a", "v", time.Minute)).To(Succeed())
+			Expect(c.do("EXPIRE", "a", "10")).To(Equal(":1\r\n"))
+			Expect(c.do("TTL", "a")).To(Equal(":10\r\n"))
+			Expect(c.do("PTTL", "a")).To(MatchRegexp(`^:(9\d{3}|10000)\r\n$`))
+			Expect(c.do("EXPIRE", "a", "x")).To(Equal("-ERR value is not an integer or out of range\r\n"))
+			Expect(c.do("EXPIRE", "a", "0")).To(Equal(":1\r\n"))
+			Expect(st.Keys()).To(BeEmpty())
+		})
+		Specify("KEYS", func() {
+			Expect(c.do("KEYS", "*")).To(Equal("*0\r\n"))
+			Expect(st.Set("user:1", "v", time.Minute)).To(Succeed())
+			Expect(st.Set("user:2", "v", time.Minute)).To(Succeed())
+			Expect(st.Set("other", "v", time.Minute)).To(Succeed())
+			Expect(c.do("KEYS", "user:*")).To(Equal("*2\r\n$6\r\nuser:1\r\n$6\r\nuser:2\r\n"))
+		})
+		Specify("wrong type error", func() {
+			Expect(st.ListSet("a", nil, time.Minute)).To(Succeed())
+			Expect(c.do("GET", "a")).To(Equal("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"))
+		})
+	})
+	Describe("lists", func() {
+		Specify("LPUSH and LRANGE", func() {
+			Expect(c.do("LRANGE", "a", "0", "-1")).To(Equal("*0\r\n"))
+			Expect(c.do("LPUSH", "a", "1", "2")).To(Equal(":2\r\n"))
+			Expect(c.do("LPUSH", "a", "3")).To(Equal(":3\r\n"))
+			Expect(st.ListGetAll("a")).To(Equal([]string{"3", "2", "1"}))
+			Expect(st.TTL("a")).To(BeNumerically("~", time.Hour, time.Second))
+			Expect(c.do("LRANGE", "a", "0", "-1")).To(Equal("*3\r\n$1\r\n3\r\n$1\r\n2\r\n$1\r\n1\r\n"))
+			Expect(c.do("LRANGE", "a", "1", "1")).To(Equal("*1\r\n$1\r\n2\r\n"))
+			Expect(c.do("LRANGE", "a", "-2", "100")).To(Equal("*2\r\n$1\r\n2\r\n$1\r\n1\r\n"))
+			Expect(c.do("LRANGE", "a", "-100", "0")).To(Equal("*1\r\n$1\r\n3\r\n"))
+			Expect(c.do("LRANGE", "a", "2", "1")).To(Equal("*0\r\n"))
+			Expect(c.do("LRANGE", "a", "5", "10")).To(Equal("*0\r\n"))
+			Expect(c.do("LRANGE", "a", "x", "1")).To(Equal("-ERR value is not an integer or out of range\r\n"))
+		})
+		Specify("wrong type error", func() {
+			Expect(st.Set("a", "v", time.Minute)).To(Succeed())
+			Expect(c.do("LPUSH", "a", "1")).To(HavePrefix("-WRONGTYPE"))
+			Expect(c.do("LRANGE", "a", "0", "1")).To(HavePrefix("-WRONGTYPE"))
+		})
+	})
+	Describe("dicts", func() {
+		Specify("HSET, HGET and HGETALL", func() {
+			Expect(c.do("HGET", "a", "f")).To(Equal("$-1\r\n"))
+			Expect(c.do("HGETALL", "a")).To(Equal("*0\r\n"))
+			Expect(c.do("HSET", "a", "f1", "v1", "f2", "v2")).To(Equal(":2\r\n"))
+			Expect(c.do("HSET", "a", "f1", "v3", "f3", "v3")).To(Equal(":1\r\n"))
+			Expect(st.TTL("a")).To(BeNumerically("~", time.Hour, time.Second))
+			Expect(c.do("HGET", "a", "f1")).To(Equal("$2\r\nv3\r\n"))
+			Expect(c.do("HGET", "a", "f4")).To(Equal("$-1\r\n"))
+			Expect(c.do("HGETALL", "a")).To(Equal("*6\r\n$2\r\nf1\r\n$2\r\nv3\r\n$2\r\nf2\r\n$2\r\nv2\r\n" +
+				"$2\r\nf3\r\n$2\r\nv3\r\n"))
+			c.do("HELLO", "3")
+			Expect(c.do("HGETALL", "a")).To(HavePrefix("%3\r\n"))
+			Expect(c.do("HGETALL", "b")).To(Equal("%0\r\n"))
+		})
+		Specify("wrong type error", func() {
+			Expect(st.Set("a", "v", time.Minute)).To(Succeed())
+			Expect(c.do("HSET", "a", "f", "v")).To(HavePrefix("-WRONGTYPE"))
+			Expect(c.do("HGET", "a", "f")).To(HavePrefix("-WRONGTYPE"))
+			Expect(c.do("HGETALL", "a")).To(HavePrefix("-WRONGTYPE"))
+		})
+	})
+	Specify("read only store error", func() {
+		d, err := store.NewFileDumper(filepath.Join(dir, "replica"), store.GobFormat, store.NoCompression)
+		Expect(err).ToNot(HaveOccurred())
+		replica, err := store.NewStore(store.Params{CleaningPeriod: time.Second, DumpingPeriod: time.Minute,
+			ReadOnly: true}, store.SystemClock{}, d)
+		Expect(err).ToNot(HaveOccurred())
+		defer replica.Close(false)
+		s.namespaces[store.DefaultNamespace] = replica
+		Expect(c.do("AUTH", "test", "test")).To(Equal("+OK\r\n"))
+		Expect(c.do("SET", "a", "v")).To(Equal("-READONLY You can't write against a read only replica.\r\n"))
+	})
+	Specify("Close closes connections and rejects serving", func() {
+		Expect(s.Close()).To(Succeed())
+		_, err := c.r.ReadByte()
+		Expect(err).To(Equal(io.EOF))
+		Expect(s.Serve(l)).To(Equal(ErrServerClosed))
+	})
+})
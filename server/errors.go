@@ -1,8 +1,19 @@
 package server
 
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/someanon/yamc/store"
+)
+
+// ServerError is a server error with stable code. Server error codes start from 100, lower codes are store error
+// codes
 type ServerError struct {
 	Err     string
+	Code    int
 	Details string
+	cause   error
 }
 
 // detailed adds details to error
@@ -11,10 +22,14 @@ func (e ServerError) detailed(details string) ServerError {
 	return e
 }
 
+// causedBy adds cause error to error
 func (e ServerError) causedBy(err error) ServerError {
-	return e.detailed(err.Error())
+	e = e.detailed(err.Error())
+	e.cause = err
+	return e
 }
 
+// Error returns error's string representation
 func (e ServerError) Error() string {
 	err := e.Err
 	if e.Details != "" {
@@ -23,24 +38,95 @@ func (e ServerError) Error() string {
 	return err
 }
 
+// Unwrap returns cause error
+func (e ServerError) Unwrap() error {
+	return e.cause
+}
+
 // e is a shortcut for string error constructor
-func e(s string) ServerError {
-	return ServerError{Err: s}
+func e(code int, s string) ServerError {
+	return ServerError{Err: s, Code: code}
 }
 
 var (
-	errKeyRequired   = e("key query param required")
-	errTTLRequired   = e("ttl query param required")
-	errIndexRequired = e("index query param required")
-	errDKeyRequired  = e("dkey query param required")
+	errKeyRequired   = e(100, "key query param required")
+	errTTLRequired   = e(101, "ttl query param required")
+	errIDRequired    = e(102, "id query param required")
+	errSeqRequired   = e(103, "seq query param required")
+	errSlotsRequired = e(104, "slots query param required")
+	errNodeRequired  = e(105, "node query param required")
+	errScopeRequired = e(106, "scope query param required")
+
+	errInvalidTTL   = e(110, "invalid ttl")
+	errInvalidIndex = e(111, "invalid index")
+	errInvalidStart = e(112, "invalid start")
+	errInvalidStop  = e(113, "invalid stop")
+	errInvalidSeq   = e(114, "invalid seq")
+	errInvalidSlots = e(115, "invalid slots")
+	errInvalidScope = e(116, "invalid scope")
+
+	errFailToReadAllBody = e(120, "fail to read all body")
+
+	errInvalidList = e(121, "invalid list")
+	errInvalidDict = e(122, "invalid dict")
+
+	errInvalidImportData = e(123, "invalid import data")
+
+	errSignedBodyTooLarge = e(124, "signed body too large")
 
-	errInvalidTTL   = e("invalid ttl")
-	errInvalidIndex = e("invalid index")
+	errUnsupportedMediaType = e(130, "unsupported media type")
+	errNotAcceptable        = e(131, "not acceptable")
+	errFailToMarshal        = e(132, "fail to marshal response")
 
-	errFailToReadAllBody = e("fail to read all body")
+	errUnauthorized     = e(140, "unauthorized")
+	errForbidden        = e(141, "forbidden")
+	errAccountNotExists = e(142, "account not exists")
+	errTokenNotExists   = e(143, "token not exists")
+	errTokensError      = e(144, "tokens error")
 
-	errInvalidListYAML = e("invalid list yaml")
-	errInvalidDictYAML = e("invalid dict yaml")
+	errReadOnly = e(150, "read only replica")
 
-	errStoreError = e("store error")
+	errNotLeader     = e(151, "not leader")
+	errNoLeader      = e(152, "no leader")
+	errFailToForward = e(153, "fail to forward request to leader")
+
+	errMalformedSlotMap = e(160, "malformed slot map")
+	errInvalidSlotMap   = e(161, "invalid slot map")
+	errClusterError     = e(162, "cluster error")
+
+	errStoreError    = e(170, "store error")
+	errRouteNotFound = e(171, "route not found")
+	errInternalError = e(172, "internal error")
+
+	errRateLimitExceeded = e(180, "rate limit exceeded")
+	errValueTooLarge     = e(181, "value too large")
 )
+
+// problem is a JSON error response body
+type problem struct {
+	Code    int    `json:"code"`
+	Error   string `json:"error"`
+	Details string `json:"details,omitempty"`
+}
+
+// problemOf returns error response body of err. Store errors keep their codes, even if they are causes of server
+// errors
+func problemOf(err error) problem {
+	var se store.StoreError
+	if errors.As(err, &se) {
+		return problem{Code: se.Code, Error: se.Err, Details: se.Details}
+	}
+	var sre ServerError
+	if errors.As(err, &sre) {
+		return problem{Code: sre.Code, Error: sre.Err, Details: sre.Details}
+	}
+	return problem{Code: errInternalError.Code, Error: errInternalError.Err, Details: err.Error()}
+}
+
+// abort aborts request with status and JSON error response body of err. Error is attached to context for logging.
+// Content type set for streamed response is replaced
+func abort(c *gin.Context, status int, err error) {
+	c.Error(err)
+	c.Writer.Header().Del("Content-Type")
+	c.AbortWithStatusJSON(status, problemOf(err))
+}
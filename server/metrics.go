@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/someanon/yamc/auth"
+	"github.com/someanon/yamc/store"
+)
+
+// metricsContentType is a content type of Prometheus text format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// latencyBuckets are upper bounds of request latency histogram buckets in seconds
+var latencyBuckets = [...]float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// route is a request method and route pattern
+type route struct {
+	method string
+	path   string
+}
+
+// routeMetrics are requests counts by status and latency histogram of route. Counters are atomic
+type routeMetrics struct {
+	buckets [len(latencyBuckets)]uint64
+	count   uint64
+	nanos   uint64
+	codes   sync.Map // int status code to *uint64 count
+}
+
+// metrics are requests metrics of routes. Routes are added once and counted atomically, so requests don't contend
+// on lock
+type metrics struct {
+	routes sync.Map // route to *routeMetrics
+}
+
+// observe is a middleware counting request and its latency by route after request is handled. Requests not matching
+// any route are counted by empty method and path
+func (m *metrics) observe(c *gin.Context) {
+	started := time.Now()
+	c.Next()
+	elapsed := time.Since(started)
+	r := route{method: c.Request.Method, path: c.FullPath()}
+	if r.path == "" {
+		r = route{}
+	}
+	v, exists := m.routes.Load(r)
+	if !exists {
+		v, _ = m.routes.LoadOrStore(r, &routeMetrics{})
+	}
+	rm := v.(*routeMetrics)
+	code, exists := rm.codes.Load(c.Writer.Status())
+	if !exists {
+		code, _ = rm.codes.LoadOrStore(c.Writer.Status(), new(uint64))
+	}
+	atomic.AddUint64(code.(*uint64), 1)
+	for i, bound := range latencyBuckets {
+		if elapsed.Seconds() <= bound {
+			atomic.AddUint64(&rm.buckets[i], 1)
+			break
+		}
+	}
+	atomic.AddUint64(&rm.count, 1)
+	atomic.AddUint64(&rm.nanos, uint64(elapsed))
+}
+
+// write writes requests metrics in Prometheus text format to b sorted by route
+func (m *metrics) write(b *bytes.Buffer) {
+	var routes []route
+	m.routes.Range(func(r, _ interface{}) bool {
+		routes = append(routes, r.(route))
+		return true
+	})
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].path != routes[j].path {
+			return routes[i].path < routes[j].path
+		}
+		return routes[i].method < routes[j].method
+	})
+	b.WriteString("# HELP yamc_http_requests_total Count of HTTP requests by route and status code.\n")
+	b.WriteString("# TYPE yamc_http_requests_total counter\n")
+	for _, r := range routes {
+		v, _ := m.routes.Load(r)
+		var codes []int
+		v.(*routeMetrics).codes.Range(func(code, _ interface{}) bool {
+			codes = append(codes, code.(int))
+			return true
+		})
+		sort.Ints(codes)
+		for _, code := range codes {
+			count, _ := v.(*routeMetrics).codes.Load(code)
+			fmt.Fprintf(b, "yamc_http_requests_total{method=%q,route=%q,code=\"%d\"} %d\n", r.method, r.path, code,
+				atomic.LoadUint64(count.(*uint64)))
+		}
+	}
+	b.WriteString("# HELP yamc_http_request_duration_seconds Latency of HTTP requests by route.\n")
+	b.WriteString("# TYPE yamc_http_request_duration_seconds histogram\n")
+	for _, r := range routes {
+		v, _ := m.routes.Load(r)
+		rm := v.(*routeMetrics)
+		labels := fmt.Sprintf("method=%q,route=%q", r.method, r.path)
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += atomic.LoadUint64(&rm.buckets[i])
+			fmt.Fprintf(b, "yamc_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels,
+				strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		count := atomic.LoadUint64(&rm.count)
+		fmt.Fprintf(b, "yamc_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, count)
+		fmt.Fprintf(b, "yamc_http_request_duration_seconds_sum{%s} %s\n", labels,
+			seconds(time.Duration(atomic.LoadUint64(&rm.nanos))))
+		fmt.Fprintf(b, "yamc_http_request_duration_seconds_count{%s} %d\n", labels, count)
+	}
+}
+
+// sample is a metric sample with metric name suffix and labels besides namespace
+type sample struct {
+	suffix string
+	labels string
+	value  string
+}
+
+// storeMetric is a store metric with samples of namespace's store metrics
+type storeMetric struct {
+	name    string
+	kind    string
+	help    string
+	samples func(m store.Metrics) []sample
+}
+
+// storeMetrics are store metrics written by writeStoreMetrics
+var storeMetrics = []storeMetric{
+	{"yamc_store_items", "gauge", "Count of items by type, expired items are counted until cleaned.",
+		func(m store.Metrics) []sample {
+			return typed(m, func(tm store.TypeMetrics) string { return strconv.Itoa(tm.Items) })
+		}},
+	{"yamc_store_memory_bytes", "gauge", "Rough estimate of memory used by items.",
+		func(m store.Metrics) []sample { return []sample{{value: strconv.FormatInt(m.Bytes, 10)}} }},
+	{"yamc_store_hits_total", "counter", "Count of reads finding item of type.",
+		func(m store.Metrics) []sample {
+			return typed(m, func(tm store.TypeMetrics) string { return strconv.FormatUint(tm.Hits, 10) })
+		}},
+	{"yamc_store_misses_total", "counter", "Count of reads not finding item of type.",
+		func(m store.Metrics) []sample {
+			return typed(m, func(tm store.TypeMetrics) string { return strconv.FormatUint(tm.Misses, 10) })
+		}},
+	{"yamc_store_expired_total", "counter",
+		"Count of expired items removed by cleaning. Items are never evicted, writes exceeding quotas are rejected.",
+		func(m store.Metrics) []sample { return []sample{{value: strconv.FormatUint(m.Expired, 10)}} }},
+	{"yamc_store_cleaning_duration_seconds", "summary", "Duration of cleaning sweeps.",
+		func(m store.Metrics) []sample {
+			return []sample{{suffix: "_sum", value: seconds(m.CleaningTime)},
+				{suffix: "_count", value: strconv.FormatUint(m.Cleanings, 10)}}
+		}},
+	{"yamc_store_dump_duration_seconds", "summary", "Duration of dumps.",
+		func(m store.Metrics) []sample {
+			return []sample{{suffix: "_sum", value: seconds(m.DumpTime)},
+				{suffix: "_count", value: strconv.FormatUint(m.Dumps, 10)}}
+		}},
+	{"yamc_store_dump_failures_total", "counter", "Count of failed dumps.",
+		func(m store.Metrics) []sample { return []sample{{value: strconv.FormatUint(m.DumpFailures, 10)}} }},
+	{"yamc_store_dump_consecutive_failures", "gauge", "Count of consecutive failed dumps.",
+		func(m store.Metrics) []sample { return []sample{{value: strconv.Itoa(m.Dump.Failures)}} }},
+	{"yamc_store_last_dump_duration_seconds", "gauge", "Duration of last dump.",
+		func(m store.Metrics) []sample { return []sample{{value: seconds(m.Dump.Duration)}} }},
+}
+
+// typed returns samples of type metrics values by f labeled by item type
+func typed(m store.Metrics, f func(tm store.TypeMetrics) string) []sample {
+	return []sample{
+		{labels: `,type="key"`, value: f(m.Keys)},
+		{labels: `,type="list"`, value: f(m.Lists)},
+		{labels: `,type="dict"`, value: f(m.Dicts)},
+	}
+}
+
+// writeStoreMetrics writes store metrics of namespaces ns in Prometheus text format to b sorted by namespace
+func writeStoreMetrics(b *bytes.Buffer, ns store.Namespaces) {
+	names := make([]string, 0, len(ns))
+	for name := range ns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	all := make([]store.Metrics, len(names))
+	for i, name := range names {
+		all[i] = ns[name].Metrics()
+	}
+	for _, sm := range storeMetrics {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", sm.name, sm.help, sm.name, sm.kind)
+		for i, name := range names {
+			for _, smp := range sm.samples(all[i]) {
+				fmt.Fprintf(b, "%s%s{namespace=%q%s} %s\n", sm.name, smp.suffix, name, smp.labels, smp.value)
+			}
+		}
+	}
+}
+
+// seconds formats duration d as seconds
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// getMetrics handles GET /metrics request. Returns requests metrics and store metrics of all namespaces in
+// Prometheus text format. Permitted to admin and monitor accounts of default namespace only, since metrics cover all
+// namespaces
+func (s *server) getMetrics(c *gin.Context) {
+	if c.MustGet(accountKey).(auth.Account).Namespace != store.DefaultNamespace {
+		abort(c, http.StatusForbidden, errForbidden.detailed("metrics are permitted to accounts of default namespace"))
+		return
+	}
+	var b bytes.Buffer
+	s.metrics.write(&b)
+	writeStoreMetrics(&b, s.namespaces)
+	c.Data(http.StatusOK, metricsContentType, b.Bytes())
+}
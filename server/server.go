@@ -1,48 +1,393 @@
 package server
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"net/http/httputil"
+	gourl "net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/someanon/yamc/auth"
+	"github.com/someanon/yamc/cluster"
+	"github.com/someanon/yamc/codec"
+	"github.com/someanon/yamc/consensus"
 	"github.com/someanon/yamc/store"
-	"gopkg.in/yaml.v2"
 )
 
-// NewRouter creates gin router with server with binded routes and handlers
-func NewRouter(a gin.Accounts, st store.Store) *gin.Engine {
-	s := &server{store: st}
+const (
+	// slotHeader is a header with key's slot of redirect response
+	slotHeader = "X-Yamc-Slot"
+
+	// forwardedHeader is a header of request forwarded to leader, so it is not forwarded again
+	forwardedHeader = "X-Yamc-Forwarded"
+
+	// codecKey is a context key of negotiated response codec
+	codecKey = "codec"
+
+	// accountKey is a context key of authenticated account
+	accountKey = "account"
+
+	// tokenKey is a context key of token of request authenticated by token
+	tokenKey = "token"
+
+	// storeKey is a context key of store of request's namespace
+	storeKey = "store"
+
+	// namespaceKey is a context key of request's namespace
+	namespaceKey = "namespace"
+
+	// maxSignedBodySize is a max body size of signed request, since body is read in memory to verify signature.
+	// Account's value size limit lowers it
+	maxSignedBodySize = 32 << 20
+)
+
+// Leader is implemented by consensus replicated stores, which accept mutations on leader only. Server forwards
+// mutating requests to leader if its store implements Leader
+type Leader interface {
+	// Leader returns leader's URL, empty if leader is unknown, and true if this server is leader
+	Leader() (string, bool)
+}
+
+// NewRouter creates gin router with server with binded routes and handlers. Every route requires account of
+// authenticator a with permission of route
+func NewRouter(a *auth.Authenticator, st store.Store) *gin.Engine {
+	return NewClusterRouter(a, st, nil)
+}
+
+// NewNamespacesRouter creates gin router with server of namespaces ns with binded routes and handlers. Requests are
+// served by store of account's namespace, admins of default namespace may choose namespace by db query param
+func NewNamespacesRouter(a *auth.Authenticator, ns store.Namespaces) *gin.Engine {
+	return newRouter(a, ns, nil)
+}
+
+// NewClusterRouter creates gin router with server of cluster node n with binded routes and handlers. Requests of keys
+// owned by other nodes are redirected to them. Node n may be nil if server is not clustered
+func NewClusterRouter(a *auth.Authenticator, st store.Store, n *cluster.Node) *gin.Engine {
+	return newRouter(a, store.Namespaces{store.DefaultNamespace: st}, n)
+}
+
+// newRouter creates gin router with server of namespaces ns and cluster node n with binded routes and handlers
+func newRouter(a *auth.Authenticator, ns store.Namespaces, n *cluster.Node) *gin.Engine {
+	s := &server{accounts: a, namespaces: ns, node: n, metrics: &metrics{}}
+	if l, ok := ns[store.DefaultNamespace].(Leader); ok {
+		s.leader = l
+	}
 
 	r := gin.Default()
+	r.Use(s.metrics.observe)
 
-	ar := r.Group("/", gin.BasicAuth(a))
+	ar := r.Group("/", authenticate(a), s.limit, s.namespace)
 
 	r.NoRoute(func(c *gin.Context) {
-		c.AbortWithStatus(http.StatusNotFound)
+		abort(c, http.StatusNotFound, errRouteNotFound)
 	})
 
-	ar.GET("/key", s.getKey)
-	ar.PUT("/key", s.putKey)
-	ar.DELETE("/key", s.delete)
+	kr := ar
+	if n != nil {
+		kr = ar.Group("/", s.redirect)
+	}
+
+	rr := kr.Group("/", permit(auth.Read))
+	wr := kr.Group("/", permit(auth.Write))
+
+	rr.GET("/key", s.getKey)
+	wr.PUT("/key", s.forward, s.quota, s.putKey)
+	wr.DELETE("/key", s.forward, s.delete(store.Store.KeyRemove))
+
+	rr.GET("/list", s.getList)
+	wr.PUT("/list", s.forward, s.quota, s.putList)
+	wr.DELETE("/list", s.forward, s.delete(store.Store.ListRemove))
+
+	rr.GET("/dict", s.getDict)
+	wr.PUT("/dict", s.forward, s.quota, s.putDict)
+	wr.DELETE("/dict", s.forward, s.delete(store.Store.DictRemove))
+
+	ar.GET("/keys", permit(auth.Read), negotiate, s.getKeys)
+	ar.GET("/metrics", permit(auth.ReadMetrics), s.getMetrics)
 
-	ar.GET("/list", s.getList)
-	ar.PUT("/list", s.putList)
-	ar.DELETE("/list", s.delete)
+	adm := ar.Group("/admin", permit(auth.Administer))
 
-	ar.GET("/dict", s.getDict)
-	ar.PUT("/dict", s.putDict)
-	ar.DELETE("/dict", s.delete)
+	adm.GET("/export", s.getExport)
+	adm.POST("/import", s.forward, s.postImport)
+	adm.POST("/dump", negotiate, s.postDump)
+	adm.POST("/flush", s.forward, s.postFlush)
+	adm.GET("/info", negotiate, s.getInfo)
 
-	ar.GET("/keys", s.getKeys)
+	adm.POST("/tokens", negotiate, s.postToken)
+	adm.GET("/tokens", negotiate, s.getTokens)
+	adm.DELETE("/tokens", s.deleteToken)
+
+	adm.GET("/replication/snapshot", s.getReplicationSnapshot)
+	adm.GET("/replication/stream", s.getReplicationStream)
+
+	if n != nil {
+		ar.GET("/cluster/slots", permit(auth.Read), negotiate, s.getClusterSlots)
+		adm.PUT("/cluster/slots", s.putClusterSlots)
+		adm.POST("/cluster/migrate", s.postClusterMigrate)
+	}
 
 	return r
 }
 
 // server is memory cache server
 type server struct {
-	store store.Store
+	accounts   *auth.Authenticator
+	namespaces store.Namespaces
+	node       *cluster.Node
+	leader     Leader
+	metrics    *metrics
+}
+
+// authenticate is an authentication middleware checking HTTP Basic Authorization credentials, Bearer token or HMAC
+// signature of token by authenticator a. Requests without Authorization header having verified TLS client certificate
+// are authenticated by certificate. Sets gin.AuthUserKey to login, accountKey to account and tokenKey to token if
+// request is authenticated by token
+func authenticate(a *auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authorization := c.GetHeader("Authorization")
+		var (
+			account auth.Account
+			token   auth.Token
+			err     error
+		)
+		switch {
+		case strings.HasPrefix(authorization, "Bearer "):
+			account, token, err = a.AuthenticateToken(strings.TrimPrefix(authorization, "Bearer "))
+		case strings.HasPrefix(authorization, auth.SignatureScheme+" "):
+			if account, err = a.SignedAccount(authorization); err != nil {
+				abort(c, http.StatusUnauthorized, errUnauthorized.causedBy(err))
+				return
+			}
+			limit := int64(maxSignedBodySize)
+			if max := account.Limits.MaxValueSize; max > 0 && max < limit {
+				limit = max
+			}
+			var body []byte
+			if body, err = ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, limit)); err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					abort(c, http.StatusRequestEntityTooLarge,
+						errSignedBodyTooLarge.detailed(fmt.Sprintf("limit is %d bytes", limit)))
+					return
+				}
+				abort(c, http.StatusInternalServerError, errFailToReadAllBody.causedBy(err))
+				return
+			}
+			c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+			account, token, err = a.AuthenticateSigned(authorization, c.Request.Method, c.Request.URL.RequestURI(), body)
+		case authorization == "" && c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0:
+			account, login, ok := a.AuthenticateCertificate(c.Request.TLS.VerifiedChains[0][0])
+			if !ok {
+				abort(c, http.StatusUnauthorized, errUnauthorized.detailed("client certificate has no account"))
+				return
+			}
+			c.Set(gin.AuthUserKey, login)
+			c.Set(accountKey, account)
+			return
+		default:
+			login, password, ok := c.Request.BasicAuth()
+			var authenticated bool
+			account, authenticated = a.Authenticate(login, password)
+			if !ok || !authenticated {
+				c.Header("WWW-Authenticate", `Basic realm="Authorization Required"`)
+				abort(c, http.StatusUnauthorized, errUnauthorized)
+				return
+			}
+			c.Set(gin.AuthUserKey, login)
+			c.Set(accountKey, account)
+			return
+		}
+		if err != nil {
+			abort(c, http.StatusUnauthorized, errUnauthorized.causedBy(err))
+			return
+		}
+		c.Set(gin.AuthUserKey, token.Login)
+		c.Set(accountKey, account)
+		c.Set(tokenKey, token)
+	}
+}
+
+// permit is an authorization middleware. Aborts with 403 if account's role or scopes of request's token don't grant
+// permission p or account is not allowed to access key query param
+func permit(p auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a := c.MustGet(accountKey).(auth.Account)
+		if !a.Allows(p) {
+			abort(c, http.StatusForbidden, errForbidden.detailed(`role "`+string(a.Role)+`" is not permitted`))
+			return
+		}
+		if t, exists := c.Get(tokenKey); exists && !t.(auth.Token).Allows(p) {
+			abort(c, http.StatusForbidden, errForbidden.detailed("token scopes are not permitted"))
+			return
+		}
+		if key, exists := c.GetQuery("key"); exists && !a.AllowsKey(key) {
+			abort(c, http.StatusForbidden, errForbidden.detailed(`key "`+key+`" is not allowed`))
+		}
+	}
+}
+
+// limit is a requests rate limiting middleware. Aborts with 429 and Retry-After header if account's requests rate
+// limit is exceeded
+func (s *server) limit(c *gin.Context) {
+	a := c.MustGet(accountKey).(auth.Account)
+	allowed, wait := s.accounts.Limiter().Allow(c.GetString(gin.AuthUserKey), a.Limits)
+	if !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		abort(c, http.StatusTooManyRequests, errRateLimitExceeded)
+	}
+}
+
+// quota is a set requests middleware. Aborts with 507 if request body is larger than account's value size limit. Body
+// is read up to limit only. Keys and bytes quotas are enforced by store's view of account
+func (s *server) quota(c *gin.Context) {
+	l := c.MustGet(accountKey).(auth.Account).Limits
+	if l.MaxValueSize == 0 {
+		return
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, l.MaxValueSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		s.exceeded(c, errValueTooLarge.detailed(fmt.Sprintf("limit is %d bytes", l.MaxValueSize)))
+		return
+	} else if err != nil {
+		abort(c, http.StatusInternalServerError, errFailToReadAllBody.causedBy(err))
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(data))
+}
+
+// exceeded aborts request with 507 and limit error err. Request is counted as rejected request of account
+func (s *server) exceeded(c *gin.Context, err error) {
+	s.accounts.Limiter().Reject(c.GetString(gin.AuthUserKey))
+	abort(c, http.StatusInsufficientStorage, err)
+}
+
+// namespace is a namespace middleware. Sets namespaceKey to account's namespace or db query param and storeKey to its
+// store's view of account, which owns items set by account and enforces account's keys and bytes quotas. Aborts with
+// 403 if account is not allowed to access namespace and with 404 if namespace is not exists
+func (s *server) namespace(c *gin.Context) {
+	a := c.MustGet(accountKey).(auth.Account)
+	name := a.Namespace
+	if db, exists := c.GetQuery("db"); exists {
+		name = db
+	}
+	if !a.AllowsNamespace(name) {
+		abort(c, http.StatusForbidden, errForbidden.detailed(`namespace "`+name+`" is not allowed`))
+		return
+	}
+	st, err := s.namespaces.Namespace(name)
+	if err != nil {
+		abort(c, http.StatusNotFound, err)
+		return
+	}
+	c.Set(namespaceKey, name)
+	q := store.Quota{MaxKeys: a.Limits.MaxKeys, MaxBytes: a.Limits.MaxBytes}
+	c.Set(storeKey, st.As(c.GetString(gin.AuthUserKey), q))
+}
+
+// storeOf returns store of request's namespace picked by namespace middleware
+func storeOf(c *gin.Context) store.Store {
+	return c.MustGet(storeKey).(store.Store)
+}
+
+// negotiate is a structured response middleware. Picks response codec by Accept header, aborts with 406 if no codec
+// is acceptable
+func negotiate(c *gin.Context) {
+	cd, err := codec.Negotiate(c.GetHeader("Accept"))
+	if err != nil {
+		abort(c, http.StatusNotAcceptable, errNotAcceptable)
+		return
+	}
+	c.Set(codecKey, cd)
+}
+
+// render responds with status and v encoded by codec picked by negotiate middleware
+func render(c *gin.Context, status int, v interface{}) {
+	cd := c.MustGet(codecKey).(codec.Codec)
+	data, err := cd.Marshal(v)
+	if err != nil {
+		abort(c, http.StatusInternalServerError, errFailToMarshal.causedBy(err))
+		return
+	}
+	c.Data(status, cd.ContentType(), data)
+}
+
+// bind decodes request body into v by codec of Content-Type header. Aborts with 415 if content type is not supported
+// or with 400 and invalid error if body is malformed. Returns false if aborted
+func bind(c *gin.Context, v interface{}, invalid ServerError) bool {
+	cd, err := codec.ForContentType(c.GetHeader("Content-Type"))
+	if err != nil {
+		abort(c, http.StatusUnsupportedMediaType, errUnsupportedMediaType)
+		return false
+	}
+	data, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		abort(c, http.StatusInternalServerError, errFailToReadAllBody.causedBy(err))
+		return false
+	}
+	if err := cd.Unmarshal(data, v); err != nil {
+		abort(c, http.StatusBadRequest, invalid.causedBy(err))
+		return false
+	}
+	return true
+}
+
+// forward is a mutating requests middleware of consensus replicated store. Proxies request to leader if server is
+// not leader
+func (s *server) forward(c *gin.Context) {
+	if s.leader == nil {
+		return
+	}
+	url, isLeader := s.leader.Leader()
+	if isLeader {
+		return
+	}
+	if url == "" || c.GetHeader(forwardedHeader) != "" {
+		abort(c, http.StatusServiceUnavailable, errNoLeader)
+		return
+	}
+	target, err := gourl.Parse(url)
+	if err != nil {
+		abort(c, http.StatusInternalServerError, errFailToForward.causedBy(err))
+		return
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(_ http.ResponseWriter, _ *http.Request, err error) {
+		abort(c, http.StatusBadGateway, errFailToForward.causedBy(err))
+	}
+	c.Request.Header.Set(forwardedHeader, "true")
+	proxy.ServeHTTP(c.Writer, c.Request)
+	c.Abort()
+}
+
+// redirect is a key requests middleware of cluster node. Redirects request with 307 to node owning key's slot, or
+// holds slot from migration until request is handled
+func (s *server) redirect(c *gin.Context) {
+	key, exists := c.GetQuery("key")
+	if !exists {
+		// handler responds with missing key error
+		return
+	}
+	release, err := s.node.Acquire(c.Request.Context(), key)
+	if err != nil {
+		if moved, ok := err.(cluster.MovedError); ok {
+			c.Header(slotHeader, strconv.Itoa(moved.Slot))
+			c.Redirect(http.StatusTemporaryRedirect, moved.Node+c.Request.URL.RequestURI())
+			c.Abort()
+			return
+		}
+		abort(c, http.StatusServiceUnavailable, errClusterError.causedBy(err))
+		return
+	}
+	defer release()
+	c.Next()
 }
 
 // getKey handles GET /key request. This request corresponds to store's Get method. Required params: key.
@@ -50,16 +395,18 @@ type server struct {
 func (s *server) getKey(c *gin.Context) {
 	key, exists := c.GetQuery("key")
 	if !exists {
-		c.AbortWithError(http.StatusBadRequest, errKeyRequired)
+		abort(c, http.StatusBadRequest, errKeyRequired)
 		return
 	}
-	value, err := s.store.Get(key)
+	value, err := storeOf(c).Get(key)
 	if err != nil {
 		switch err {
-		case store.ErrKeyNotExists, store.ErrNotKeyItem:
-			c.AbortWithStatus(http.StatusNotFound)
+		case store.ErrKeyNotExists:
+			abort(c, http.StatusNotFound, err)
+		case store.ErrNotKeyItem:
+			abort(c, http.StatusConflict, err)
 		default:
-			c.AbortWithError(http.StatusInternalServerError, errStoreError.causedBy(err))
+			abort(c, http.StatusInternalServerError, errStoreError.causedBy(err))
 		}
 		return
 	}
@@ -70,168 +417,542 @@ func (s *server) getKey(c *gin.Context) {
 func (s *server) putKey(c *gin.Context) {
 	key, exists := c.GetQuery("key")
 	if !exists {
-		c.AbortWithError(http.StatusBadRequest, errKeyRequired)
+		abort(c, http.StatusBadRequest, errKeyRequired)
 		return
 	}
 	ttlStr, exists := c.GetQuery("ttl")
 	if !exists {
-		c.AbortWithError(http.StatusBadRequest, errTTLRequired)
+		abort(c, http.StatusBadRequest, errTTLRequired)
 		return
 	}
 	ttl, err := time.ParseDuration(ttlStr)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, errInvalidTTL.causedBy(err))
+		abort(c, http.StatusBadRequest, errInvalidTTL.causedBy(err))
 		return
 	}
 	valueBts, err := ioutil.ReadAll(c.Request.Body)
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, errFailToReadAllBody.causedBy(err))
+		abort(c, http.StatusInternalServerError, errFailToReadAllBody.causedBy(err))
+		return
+	}
+	if err := storeOf(c).Set(key, string(valueBts), ttl); err != nil {
+		switch err {
+		case store.ErrReadOnly:
+			abort(c, http.StatusForbidden, errReadOnly)
+		case store.ErrKeysQuotaExceeded, store.ErrBytesQuotaExceeded:
+			s.exceeded(c, err)
+		case consensus.ErrNotLeader:
+			abort(c, http.StatusServiceUnavailable, errNotLeader)
+		default:
+			abort(c, http.StatusInternalServerError, errStoreError.causedBy(err))
+		}
 		return
 	}
-	s.store.Set(key, string(valueBts), ttl)
 	c.Status(http.StatusOK)
 }
 
-// getList handles GET /list request. This request corresponds to store's ListGet method. Required params: key, index.
-// Returns value corresponded to key and index
+// getList handles GET /list request. This request corresponds to store's ListGet method. Required params: key.
+// Optional params: index. Returns value corresponded to key and index, or whole list if index is absent
 func (s *server) getList(c *gin.Context) {
 	key, exists := c.GetQuery("key")
 	if !exists {
-		c.AbortWithError(http.StatusBadRequest, errKeyRequired)
+		abort(c, http.StatusBadRequest, errKeyRequired)
 		return
 	}
 	indexStr, exists := c.GetQuery("index")
 	if !exists {
-		c.AbortWithError(http.StatusBadRequest, errIndexRequired)
+		s.getListRange(c, key)
 		return
 	}
 	index, err := strconv.Atoi(indexStr)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, errInvalidIndex.causedBy(err))
+		abort(c, http.StatusBadRequest, errInvalidIndex.causedBy(err))
 		return
 	}
-	value, err := s.store.ListGet(key, index)
+	value, err := storeOf(c).ListGet(key, index)
 	if err != nil {
 		switch err {
-		case store.ErrKeyNotExists, store.ErrNotListItem, store.ErrListIndexNotExists:
-			c.AbortWithStatus(http.StatusNotFound)
+		case store.ErrKeyNotExists, store.ErrListIndexNotExists:
+			abort(c, http.StatusNotFound, err)
+		case store.ErrNotListItem:
+			abort(c, http.StatusConflict, err)
 		case store.ErrInvalidListIndex:
-			c.AbortWithError(http.StatusBadRequest, errStoreError.causedBy(err))
+			abort(c, http.StatusBadRequest, errStoreError.causedBy(err))
 		default:
-			c.AbortWithError(http.StatusInternalServerError, errStoreError.causedBy(err))
+			abort(c, http.StatusInternalServerError, errStoreError.causedBy(err))
 		}
+		return
 	}
 	c.String(http.StatusOK, value)
 }
 
-// putKey handles PUT /list request. This request corresponds to store's ListSet method. Required params: key, ttl
-// and YAML formatted list in body
+// getListRange handles GET /list request without index. This request corresponds to store's ListGetAll method.
+// Optional params: start, stop of inclusive range, negative ones are counted from the end of list. Returns list range
+// encoded according Accept header
+func (s *server) getListRange(c *gin.Context, key string) {
+	start, stop := 0, -1
+	var err error
+	if startStr, exists := c.GetQuery("start"); exists {
+		if start, err = strconv.Atoi(startStr); err != nil {
+			abort(c, http.StatusBadRequest, errInvalidStart.causedBy(err))
+			return
+		}
+	}
+	if stopStr, exists := c.GetQuery("stop"); exists {
+		if stop, err = strconv.Atoi(stopStr); err != nil {
+			abort(c, http.StatusBadRequest, errInvalidStop.causedBy(err))
+			return
+		}
+	}
+	negotiate(c)
+	if c.IsAborted() {
+		return
+	}
+	list, err := storeOf(c).ListGetAll(key)
+	if err != nil {
+		switch err {
+		case store.ErrKeyNotExists:
+			abort(c, http.StatusNotFound, err)
+		case store.ErrNotListItem:
+			abort(c, http.StatusConflict, err)
+		default:
+			abort(c, http.StatusInternalServerError, errStoreError.causedBy(err))
+		}
+		return
+	}
+	render(c, http.StatusOK, listRange(list, start, stop))
+}
+
+// listRange returns list elements from start to stop inclusive. Negative indexes are counted from the end of list
+func listRange(list []string, start int, stop int) []string {
+	if start < 0 {
+		start += len(list)
+	}
+	if stop < 0 {
+		stop += len(list)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= len(list) {
+		stop = len(list) - 1
+	}
+	if start > stop {
+		return []string{}
+	}
+	return list[start : stop+1]
+}
+
+// putList handles PUT /list request. This request corresponds to store's ListSet method. Required params: key, ttl
+// and list in body encoded according Content-Type
 func (s *server) putList(c *gin.Context) {
 	key, exists := c.GetQuery("key")
 	if !exists {
-		c.AbortWithError(http.StatusBadRequest, errKeyRequired)
+		abort(c, http.StatusBadRequest, errKeyRequired)
 		return
 	}
 	ttlStr, exists := c.GetQuery("ttl")
 	if !exists {
-		c.AbortWithError(http.StatusBadRequest, errTTLRequired)
+		abort(c, http.StatusBadRequest, errTTLRequired)
 		return
 	}
 	ttl, err := time.ParseDuration(ttlStr)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, errInvalidTTL.causedBy(err))
+		abort(c, http.StatusBadRequest, errInvalidTTL.causedBy(err))
 		return
 	}
-	listYAML, err := ioutil.ReadAll(c.Request.Body)
-	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, errFailToReadAllBody.causedBy(err))
+	var list []string
+	if !bind(c, &list, errInvalidList) {
 		return
 	}
-	var list []string
-	if err := yaml.Unmarshal(listYAML, &list); err != nil {
-		c.AbortWithError(http.StatusBadRequest, errInvalidListYAML.causedBy(err))
+	if err := storeOf(c).ListSet(key, list, ttl); err != nil {
+		switch err {
+		case store.ErrReadOnly:
+			abort(c, http.StatusForbidden, errReadOnly)
+		case store.ErrKeysQuotaExceeded, store.ErrBytesQuotaExceeded:
+			s.exceeded(c, err)
+		case consensus.ErrNotLeader:
+			abort(c, http.StatusServiceUnavailable, errNotLeader)
+		default:
+			abort(c, http.StatusInternalServerError, errStoreError.causedBy(err))
+		}
 		return
 	}
-	s.store.ListSet(key, list, ttl)
 	c.Status(http.StatusOK)
 }
 
-// getDict handles GET /dict request. This request corresponds to store's DictGet method. Required params: key, dkey.
-// Returns value corresponded to key and dkey
+// getDict handles GET /dict request. This request corresponds to store's DictGet method. Required params: key.
+// Optional params: dkey. Returns value corresponded to key and dkey, or whole dict if dkey is absent
 func (s *server) getDict(c *gin.Context) {
 	key, exists := c.GetQuery("key")
 	if !exists {
-		c.AbortWithError(http.StatusBadRequest, errKeyRequired)
+		abort(c, http.StatusBadRequest, errKeyRequired)
 		return
 	}
 	dkey, exists := c.GetQuery("dkey")
 	if !exists {
-		c.AbortWithError(http.StatusBadRequest, errDKeyRequired)
+		s.getDictFields(c, key)
 		return
 	}
-	value, err := s.store.DictGet(key, dkey)
+	value, err := storeOf(c).DictGet(key, dkey)
 	if err != nil {
 		switch err {
-		case store.ErrKeyNotExists, store.ErrNotDictItem, store.ErrDictKeyNotExists:
-			c.AbortWithStatus(http.StatusNotFound)
+		case store.ErrKeyNotExists, store.ErrDictKeyNotExists:
+			abort(c, http.StatusNotFound, err)
+		case store.ErrNotDictItem:
+			abort(c, http.StatusConflict, err)
 		default:
-			c.AbortWithError(http.StatusInternalServerError, errStoreError.causedBy(err))
+			abort(c, http.StatusInternalServerError, errStoreError.causedBy(err))
 		}
+		return
 	}
 	c.String(http.StatusOK, value)
 }
 
+// getDictFields handles GET /dict request without dkey. This request corresponds to store's DictGetAll method.
+// Optional params: field, may be repeated. Returns whole dict or its fields encoded according Accept header, missing
+// fields are omitted
+func (s *server) getDictFields(c *gin.Context, key string) {
+	negotiate(c)
+	if c.IsAborted() {
+		return
+	}
+	dict, err := storeOf(c).DictGetAll(key)
+	if err != nil {
+		switch err {
+		case store.ErrKeyNotExists:
+			abort(c, http.StatusNotFound, err)
+		case store.ErrNotDictItem:
+			abort(c, http.StatusConflict, err)
+		default:
+			abort(c, http.StatusInternalServerError, errStoreError.causedBy(err))
+		}
+		return
+	}
+	if fields, exists := c.GetQueryArray("field"); exists {
+		selected := make(map[string]string, len(fields))
+		for _, field := range fields {
+			if v, exists := dict[field]; exists {
+				selected[field] = v
+			}
+		}
+		dict = selected
+	}
+	if dict == nil {
+		dict = map[string]string{}
+	}
+	render(c, http.StatusOK, dict)
+}
+
 // putDict handles PUT /dict request. This request corresponds to store's DictSet method. Required params: key, ttl
-// and YAML formatted dict in body
+// and dict in body encoded according Content-Type
 func (s *server) putDict(c *gin.Context) {
 	key, exists := c.GetQuery("key")
 	if !exists {
-		c.AbortWithError(http.StatusBadRequest, errKeyRequired)
+		abort(c, http.StatusBadRequest, errKeyRequired)
 		return
 	}
 	ttlStr, exists := c.GetQuery("ttl")
 	if !exists {
-		c.AbortWithError(http.StatusBadRequest, errTTLRequired)
+		abort(c, http.StatusBadRequest, errTTLRequired)
 		return
 	}
 	ttl, err := time.ParseDuration(ttlStr)
 	if err != nil {
-		c.AbortWithError(http.StatusBadRequest, errInvalidTTL.causedBy(err))
+		abort(c, http.StatusBadRequest, errInvalidTTL.causedBy(err))
 		return
 	}
-	dictYAML, err := ioutil.ReadAll(c.Request.Body)
+	var dict map[string]string
+	if !bind(c, &dict, errInvalidDict) {
+		return
+	}
+	if err := storeOf(c).DictSet(key, dict, ttl); err != nil {
+		switch err {
+		case store.ErrReadOnly:
+			abort(c, http.StatusForbidden, errReadOnly)
+		case store.ErrKeysQuotaExceeded, store.ErrBytesQuotaExceeded:
+			s.exceeded(c, err)
+		case consensus.ErrNotLeader:
+			abort(c, http.StatusServiceUnavailable, errNotLeader)
+		default:
+			abort(c, http.StatusInternalServerError, errStoreError.causedBy(err))
+		}
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// delete returns handler of DELETE /key, DELETE /list, DELETE /dict requests removing item by remove, which is one of
+// store's KeyRemove, ListRemove and DictRemove methods. Required params: key. Removing item of other type is a
+// conflict
+func (s *server) delete(remove func(st store.Store, key string) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, exists := c.GetQuery("key")
+		if !exists {
+			abort(c, http.StatusBadRequest, errKeyRequired)
+			return
+		}
+		if err := remove(storeOf(c), key); err != nil {
+			switch err {
+			case store.ErrKeyNotExists:
+			case store.ErrNotKeyItem, store.ErrNotListItem, store.ErrNotDictItem:
+				abort(c, http.StatusConflict, err)
+				return
+			case store.ErrReadOnly:
+				abort(c, http.StatusForbidden, errReadOnly)
+				return
+			case consensus.ErrNotLeader:
+				abort(c, http.StatusServiceUnavailable, errNotLeader)
+				return
+			default:
+				abort(c, http.StatusInternalServerError, errStoreError.causedBy(err))
+				return
+			}
+		}
+		c.Status(http.StatusOK)
+	}
+}
+
+// getKeys handles GET /keys request. This request corresponds to store's Keys method.
+// Returns keys list allowed to account encoded according Accept header
+func (s *server) getKeys(c *gin.Context) {
+	keys, err := storeOf(c).Keys()
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, errFailToReadAllBody.causedBy(err))
+		abort(c, http.StatusInternalServerError, errStoreError.causedBy(err))
 		return
 	}
-	var dict map[string]string
-	if err := yaml.Unmarshal(dictYAML, &dict); err != nil {
-		c.AbortWithError(http.StatusBadRequest, errInvalidDictYAML.causedBy(err))
+	a := c.MustGet(accountKey).(auth.Account)
+	allowed := []string{}
+	for _, key := range keys {
+		if a.AllowsKey(key) {
+			allowed = append(allowed, key)
+		}
+	}
+	render(c, http.StatusOK, allowed)
+}
+
+// getExport handles GET /admin/export request. This request corresponds to store's Export method.
+// Streams newline delimited JSON body with all not expired items
+func (s *server) getExport(c *gin.Context) {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	if err := storeOf(c).Export(c.Writer); err != nil {
+		if c.Writer.Written() {
+			// response is already partially sent, so only breaking it is possible
+			c.Error(errStoreError.causedBy(err))
+			c.Abort()
+			return
+		}
+		abort(c, http.StatusInternalServerError, errStoreError.causedBy(err))
+	}
+}
+
+// postImport handles POST /admin/import request. This request corresponds to store's Import method. Required
+// newline delimited JSON items in body. Returns imported items count
+func (s *server) postImport(c *gin.Context) {
+	n, err := storeOf(c).Import(c.Request.Body)
+	if err != nil {
+		if se, ok := err.(store.StoreError); ok && se.Code == store.ErrFailToImportItems.Code {
+			abort(c, http.StatusBadRequest, errInvalidImportData.causedBy(err))
+			return
+		}
+		if err == store.ErrReadOnly {
+			abort(c, http.StatusForbidden, errReadOnly)
+			return
+		}
+		if err == consensus.ErrNotLeader {
+			abort(c, http.StatusServiceUnavailable, errNotLeader)
+			return
+		}
+		abort(c, http.StatusInternalServerError, errStoreError.causedBy(err))
+		return
+	}
+	c.String(http.StatusOK, "%d", n)
+}
+
+// postDump handles POST /admin/dump request. This request corresponds to store's Dump method.
+// Synchronously dumps store and returns dump summary encoded according Accept header
+func (s *server) postDump(c *gin.Context) {
+	status := http.StatusOK
+	info, err := storeOf(c).Dump()
+	if err != nil {
+		c.Error(errStoreError.causedBy(err))
+		status = http.StatusInternalServerError
+	}
+	render(c, status, info)
+}
+
+// postFlush handles POST /admin/flush request. This request corresponds to store's Flush method
+func (s *server) postFlush(c *gin.Context) {
+	if err := storeOf(c).Flush(); err != nil {
+		switch err {
+		case store.ErrReadOnly:
+			abort(c, http.StatusForbidden, errReadOnly)
+		case consensus.ErrNotLeader:
+			abort(c, http.StatusServiceUnavailable, errNotLeader)
+		default:
+			abort(c, http.StatusInternalServerError, errStoreError.causedBy(err))
+		}
 		return
 	}
-	s.store.DictSet(key, dict, ttl)
 	c.Status(http.StatusOK)
 }
 
-// delete handles DELETE /key, DELETE /list, DELETE /dict requests. This request corresponds store's Remove method.
-// Required params: key
-func (s *server) delete(c *gin.Context) {
-	key, exists := c.GetQuery("key")
+// getInfo handles GET /admin/info request. This request corresponds to store's Info and Usage methods.
+// Returns store summary and limits, usage and rejected requests of limited accounts of namespace encoded according
+// Accept header
+func (s *server) getInfo(c *gin.Context) {
+	st := storeOf(c)
+	i := info{Info: st.Info()}
+	namespace := c.GetString(namespaceKey)
+	for login, a := range s.accounts.Accounts() {
+		if a.Namespace != namespace || a.Limits == (auth.Limits{}) {
+			continue
+		}
+		if i.Accounts == nil {
+			i.Accounts = map[string]accountInfo{}
+		}
+		i.Accounts[login] = accountInfo{
+			Limits:   a.Limits,
+			Usage:    st.UsageOf(login),
+			Rejected: s.accounts.Limiter().Rejected(login),
+		}
+	}
+	render(c, http.StatusOK, i)
+}
+
+// info is an info response: store summary and limits, usage and rejected requests count of limited accounts of
+// namespace
+type info struct {
+	store.Info `yaml:",inline" msgpack:",inline"`
+	Accounts   map[string]accountInfo `yaml:"accounts,omitempty" json:"accounts,omitempty" msgpack:"accounts,omitempty"`
+}
+
+// accountInfo is an account's limits, usage of items owned by account and count of requests rejected by limits
+type accountInfo struct {
+	Limits   auth.Limits `yaml:"limits" json:"limits" msgpack:"limits"`
+	Usage    store.Usage `yaml:"usage" json:"usage" msgpack:"usage"`
+	Rejected uint64      `yaml:"rejected" json:"rejected" msgpack:"rejected"`
+}
+
+// getReplicationSnapshot handles GET /admin/replication/snapshot request. This request corresponds to store's Snapshot
+// method. Streams newline delimited JSON body with replication position and all not expired items
+func (s *server) getReplicationSnapshot(c *gin.Context) {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	if err := storeOf(c).Snapshot(c.Writer); err != nil {
+		if c.Writer.Written() {
+			// response is already partially sent, so only breaking it is possible
+			c.Error(errStoreError.causedBy(err))
+			c.Abort()
+			return
+		}
+		abort(c, http.StatusInternalServerError, errStoreError.causedBy(err))
+	}
+}
+
+// getReplicationStream handles GET /admin/replication/stream request. This request corresponds to store's Replicate
+// method. Required params: id, seq of replica's replication position. Streams newline delimited JSON mutations until
+// client disconnects. Returns 410 if replica must be restored from snapshot first
+func (s *server) getReplicationStream(c *gin.Context) {
+	id, exists := c.GetQuery("id")
 	if !exists {
-		c.AbortWithError(http.StatusBadRequest, errKeyRequired)
+		abort(c, http.StatusBadRequest, errIDRequired)
 		return
 	}
-	s.store.Remove(key)
+	seqStr, exists := c.GetQuery("seq")
+	if !exists {
+		abort(c, http.StatusBadRequest, errSeqRequired)
+		return
+	}
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil {
+		abort(c, http.StatusBadRequest, errInvalidSeq.causedBy(err))
+		return
+	}
+	c.Header("Content-Type", "application/x-ndjson")
 	c.Status(http.StatusOK)
+	from := store.ReplicationPosition{ID: id, Seq: seq}
+	if err := storeOf(c).Replicate(c.Request.Context(), flushWriter{c.Writer}, from); err != nil {
+		if c.Writer.Written() {
+			// response is already partially sent, so only breaking it is possible
+			c.Error(errStoreError.causedBy(err))
+			c.Abort()
+			return
+		}
+		if err == store.ErrReplicationLogTruncated {
+			abort(c, http.StatusGone, errStoreError.causedBy(err))
+			return
+		}
+		abort(c, http.StatusInternalServerError, errStoreError.causedBy(err))
+	}
 }
 
-// getKeys handles GET /keys request. This request corresponds to store's Keys method.
-// Returns YAML formatted body with keys list
-func (s *server) getKeys(c *gin.Context) {
-	keysBytes, err := yaml.Marshal(s.store.Keys())
+// flushWriter is a response writer flushing each write, so streamed data is not delayed by buffering
+type flushWriter struct {
+	w gin.ResponseWriter
+}
+
+// Write writes p and flushes response
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.w.Flush()
+	return n, err
+}
+
+// getClusterSlots handles GET /cluster/slots request. Returns node's slot map encoded according Accept header
+func (s *server) getClusterSlots(c *gin.Context) {
+	render(c, http.StatusOK, s.node.Slots())
+}
+
+// putClusterSlots handles PUT /admin/cluster/slots request. Required slot map in body encoded according Content-Type.
+// Returns 409 if slot map epoch is not greater than node's one or slot map has migration other than node's one
+func (s *server) putClusterSlots(c *gin.Context) {
+	var slots cluster.SlotMap
+	if !bind(c, &slots, errMalformedSlotMap) {
+		return
+	}
+	if err := s.node.UpdateSlots(slots); err != nil {
+		switch err {
+		case cluster.ErrStaleSlotMap, cluster.ErrMigrationInProgress:
+			abort(c, http.StatusConflict, errClusterError.causedBy(err))
+		default:
+			abort(c, http.StatusBadRequest, errInvalidSlotMap.causedBy(err))
+		}
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// postClusterMigrate handles POST /admin/cluster/migrate request. This request corresponds to node's Migrate method.
+// Required params: slots, node. Returns moved keys count
+func (s *server) postClusterMigrate(c *gin.Context) {
+	slotsStr, exists := c.GetQuery("slots")
+	if !exists {
+		abort(c, http.StatusBadRequest, errSlotsRequired)
+		return
+	}
+	from, to, err := cluster.ParseSlotRange(slotsStr)
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
+		abort(c, http.StatusBadRequest, errInvalidSlots.causedBy(err))
+		return
+	}
+	node, exists := c.GetQuery("node")
+	if !exists {
+		abort(c, http.StatusBadRequest, errNodeRequired)
+		return
+	}
+	n, err := s.node.Migrate(from, to, node)
+	if err != nil {
+		switch err {
+		case cluster.ErrInvalidTarget:
+			abort(c, http.StatusBadRequest, errClusterError.causedBy(err))
+		case cluster.ErrSlotNotOwned, cluster.ErrMigrationInProgress:
+			abort(c, http.StatusConflict, errClusterError.causedBy(err))
+		default:
+			abort(c, http.StatusInternalServerError, errClusterError.causedBy(err))
+		}
 		return
 	}
-	c.String(http.StatusOK, "%s", keysBytes)
+	c.String(http.StatusOK, "%d", n)
 }
@@ -1,7 +1,9 @@
 package server
 
 import (
+	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
@@ -9,13 +11,19 @@ import (
 	"net/http/httptest"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
+	"testing/iotest"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/types"
+	"github.com/someanon/yamc/auth"
+	"github.com/someanon/yamc/cluster"
+	"github.com/someanon/yamc/codec"
+	"github.com/someanon/yamc/consensus"
 	"github.com/someanon/yamc/store"
 )
 
@@ -41,7 +49,7 @@ var _ = Describe("Router", func() {
 	}
 	BeforeEach(func() {
 		s = &testStore{}
-		r = NewRouter(gin.Accounts{"test": "test"}, s)
+		r = NewRouter(auth.NewAuthenticator(auth.Accounts{"test": {Password: "test", Role: auth.Admin}}), s)
 		res = httptest.NewRecorder()
 	})
 	Describe("access without authorization returns 401 error", func() {
@@ -56,7 +64,7 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, req())
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errKeyRequired.Code)
 		})
 		Specify("store key not exists error", func() {
 			s.error = store.ErrKeyNotExists
@@ -64,15 +72,15 @@ var _ = Describe("Router", func() {
 			s.expectGet("a")
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusNotFound))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, store.ErrKeyNotExists.Code)
 		})
 		Specify("store not key item error", func() {
 			s.error = store.ErrNotKeyItem
 			r.ServeHTTP(res, req("key=a"))
 			s.expectGet("a")
 			s.expectNoCalls()
-			Expect(res.Code).To(Equal(http.StatusNotFound))
-			Expect(res.Body.String()).To(BeEmpty())
+			Expect(res.Code).To(Equal(http.StatusConflict))
+			expectProblem(res, store.ErrNotKeyItem.Code)
 		})
 		Specify("other store error", func() {
 			s.error = errors.New("error")
@@ -80,7 +88,7 @@ var _ = Describe("Router", func() {
 			s.expectGet("a")
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusInternalServerError))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errStoreError.Code)
 		})
 		Specify("authorization error", func() {
 			s.value = "v"
@@ -89,7 +97,7 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, rq)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusUnauthorized))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errUnauthorized.Code)
 		})
 		Specify("success", func() {
 			s.value = "v"
@@ -117,25 +125,25 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, req())
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errKeyRequired.Code)
 		})
 		Specify("no ttl query param error", func() {
 			r.ServeHTTP(res, req("key=a"))
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errTTLRequired.Code)
 		})
 		Specify("empty ttl error", func() {
 			r.ServeHTTP(res, req("key=a", "ttl="))
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errInvalidTTL.Code)
 		})
 		Specify("ttl parse error", func() {
 			r.ServeHTTP(res, req("key=a", "ttl=asd"))
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errInvalidTTL.Code)
 		})
 		Specify("body read error", func() {
 			rq := req("key=a", "ttl=10s")
@@ -143,7 +151,7 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, rq)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusInternalServerError))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errFailToReadAllBody.Code)
 		})
 		Specify("authorization error", func() {
 			rq := req("key=a", "ttl=10s")
@@ -152,7 +160,37 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, rq)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusUnauthorized))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errUnauthorized.Code)
+		})
+		Specify("store error", func() {
+			s.error = store.ErrStoreClosed
+			rq := req("key=a", "ttl=10s")
+			rq.Body = body("v")
+			r.ServeHTTP(res, rq)
+			s.expectSet("a", "v", 10*time.Second)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusInternalServerError))
+			expectProblem(res, store.ErrStoreClosed.Code)
+		})
+		Specify("read only store error", func() {
+			s.error = store.ErrReadOnly
+			rq := req("key=a", "ttl=10s")
+			rq.Body = body("v")
+			r.ServeHTTP(res, rq)
+			s.expectSet("a", "v", 10*time.Second)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusForbidden))
+			expectProblem(res, errReadOnly.Code)
+		})
+		Specify("not leader error", func() {
+			s.error = consensus.ErrNotLeader
+			rq := req("key=a", "ttl=10s")
+			rq.Body = body("v")
+			r.ServeHTTP(res, rq)
+			s.expectSet("a", "v", 10*time.Second)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusServiceUnavailable))
+			expectProblem(res, errNotLeader.Code)
 		})
 		Specify("success with empty key", func() {
 			rq := req("key=", "ttl=10s")
@@ -189,19 +227,70 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, req())
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errKeyRequired.Code)
 		})
-		Specify("no index query param error", func() {
+		Specify("whole list without index", func() {
+			s.list = []string{"a", "b", "c"}
 			r.ServeHTTP(res, req("key=a"))
+			s.expectListGetAll("a")
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Header().Get("Content-Type")).To(Equal("application/yaml"))
+			Expect(res.Body.String()).To(Equal("- a\n- b\n- c\n"))
+		})
+		Specify("list range", func() {
+			s.list = []string{"a", "b", "c", "d"}
+			rq := req("key=a", "start=1", "stop=-2")
+			rq.Header.Set("Accept", "application/json")
+			r.ServeHTTP(res, rq)
+			s.expectListGetAll("a")
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(Equal(`["b","c"]`))
+		})
+		Specify("empty list range", func() {
+			s.list = []string{"a", "b"}
+			rq := req("key=a", "start=5")
+			rq.Header.Set("Accept", "application/json")
+			r.ServeHTTP(res, rq)
+			s.expectListGetAll("a")
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(Equal(`[]`))
+		})
+		Specify("range parse error", func() {
+			r.ServeHTTP(res, req("key=a", "start=asd"))
+			r.ServeHTTP(res, req("key=a", "stop=asd"))
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+		})
+		Specify("whole list not found error", func() {
+			s.error = store.ErrKeyNotExists
+			r.ServeHTTP(res, req("key=a"))
+			s.expectListGetAll("a")
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusNotFound))
+		})
+		Specify("whole list not list item error", func() {
+			s.error = store.ErrNotListItem
+			r.ServeHTTP(res, req("key=a"))
+			s.expectListGetAll("a")
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusConflict))
+			expectProblem(res, store.ErrNotListItem.Code)
+		})
+		Specify("whole list not acceptable error", func() {
+			rq := req("key=a")
+			rq.Header.Set("Accept", "text/plain")
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusNotAcceptable))
 		})
 		Specify("index parse error", func() {
 			r.ServeHTTP(res, req("key=a", "index=asd"))
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errInvalidIndex.Code)
 		})
 		Specify("store key not exists error", func() {
 			s.error = store.ErrKeyNotExists
@@ -209,15 +298,15 @@ var _ = Describe("Router", func() {
 			s.expectListGet("a", 0)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusNotFound))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, store.ErrKeyNotExists.Code)
 		})
 		Specify("store not list item error", func() {
 			s.error = store.ErrNotListItem
 			r.ServeHTTP(res, req("key=a", "index=0"))
 			s.expectListGet("a", 0)
 			s.expectNoCalls()
-			Expect(res.Code).To(Equal(http.StatusNotFound))
-			Expect(res.Body.String()).To(BeEmpty())
+			Expect(res.Code).To(Equal(http.StatusConflict))
+			expectProblem(res, store.ErrNotListItem.Code)
 		})
 		Specify("store list index not exists error", func() {
 			s.error = store.ErrListIndexNotExists
@@ -225,7 +314,7 @@ var _ = Describe("Router", func() {
 			s.expectListGet("a", 1)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusNotFound))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, store.ErrListIndexNotExists.Code)
 		})
 		Specify("store invalid list index error", func() {
 			s.error = store.ErrInvalidListIndex
@@ -233,7 +322,7 @@ var _ = Describe("Router", func() {
 			s.expectListGet("a", -1)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, store.ErrInvalidListIndex.Code)
 		})
 		Specify("other store error", func() {
 			s.error = errors.New("error")
@@ -241,7 +330,7 @@ var _ = Describe("Router", func() {
 			s.expectListGet("a", 5)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusInternalServerError))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errStoreError.Code)
 		})
 		Specify("authorization error", func() {
 			s.value = "v"
@@ -250,7 +339,7 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, rq)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusUnauthorized))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errUnauthorized.Code)
 		})
 		Specify("success", func() {
 			s.value = "v"
@@ -278,25 +367,25 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, req())
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errKeyRequired.Code)
 		})
 		Specify("no ttl query param error", func() {
 			r.ServeHTTP(res, req("key=a"))
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errTTLRequired.Code)
 		})
 		Specify("empty ttl error", func() {
 			r.ServeHTTP(res, req("key=a", "ttl="))
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errInvalidTTL.Code)
 		})
 		Specify("ttl parse error", func() {
 			r.ServeHTTP(res, req("key=a", "ttl=asd"))
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errInvalidTTL.Code)
 		})
 		Specify("body read error", func() {
 			rq := req("key=a", "ttl=10s")
@@ -304,7 +393,7 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, rq)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusInternalServerError))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errFailToReadAllBody.Code)
 		})
 		Specify("body YAML list parse error", func() {
 			rq := req("key=", "ttl=10s")
@@ -312,7 +401,7 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, rq)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errInvalidList.Code)
 		})
 		Specify("body YAML list parse error", func() {
 			rq := req("key=", "ttl=10s")
@@ -320,7 +409,7 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, rq)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errInvalidList.Code)
 		})
 		Specify("body YAML list parse error", func() {
 			rq := req("key=", "ttl=10s")
@@ -328,7 +417,7 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, rq)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errInvalidList.Code)
 		})
 		Specify("authorization error", func() {
 			rq := req("key=a", "ttl=10s")
@@ -337,7 +426,7 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, rq)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusUnauthorized))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errUnauthorized.Code)
 		})
 		Specify("success with empty key", func() {
 			rq := req("key=", "ttl=10s")
@@ -375,13 +464,49 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, req())
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errKeyRequired.Code)
 		})
-		Specify("no dkey query param error", func() {
+		Specify("whole dict without dkey", func() {
+			s.dict = map[string]string{"a": "1", "b": "2"}
 			r.ServeHTTP(res, req("key=a"))
+			s.expectDictGetAll("a")
 			s.expectNoCalls()
-			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(Equal("a: \"1\"\nb: \"2\"\n"))
+		})
+		Specify("dict fields", func() {
+			s.dict = map[string]string{"a": "1", "b": "2", "c": "3"}
+			rq := req("key=a", "field=a", "field=c", "field=d")
+			rq.Header.Set("Accept", "application/json")
+			r.ServeHTTP(res, rq)
+			s.expectDictGetAll("a")
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(Equal(`{"a":"1","c":"3"}`))
+		})
+		Specify("empty dict", func() {
+			rq := req("key=a")
+			rq.Header.Set("Accept", "application/json")
+			r.ServeHTTP(res, rq)
+			s.expectDictGetAll("a")
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(Equal(`{}`))
+		})
+		Specify("whole dict not found error", func() {
+			s.error = store.ErrKeyNotExists
+			r.ServeHTTP(res, req("key=a"))
+			s.expectDictGetAll("a")
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusNotFound))
+		})
+		Specify("whole dict not dict item error", func() {
+			s.error = store.ErrNotDictItem
+			r.ServeHTTP(res, req("key=a"))
+			s.expectDictGetAll("a")
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusConflict))
+			expectProblem(res, store.ErrNotDictItem.Code)
 		})
 		Specify("store key not exists error", func() {
 			s.error = store.ErrKeyNotExists
@@ -389,15 +514,15 @@ var _ = Describe("Router", func() {
 			s.expectDictGet("a", "b")
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusNotFound))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, store.ErrKeyNotExists.Code)
 		})
 		Specify("store not dict item error", func() {
 			s.error = store.ErrNotDictItem
 			r.ServeHTTP(res, req("key=a", "dkey=b"))
 			s.expectDictGet("a", "b")
 			s.expectNoCalls()
-			Expect(res.Code).To(Equal(http.StatusNotFound))
-			Expect(res.Body.String()).To(BeEmpty())
+			Expect(res.Code).To(Equal(http.StatusConflict))
+			expectProblem(res, store.ErrNotDictItem.Code)
 		})
 		Specify("store dict key not exists error", func() {
 			s.error = store.ErrDictKeyNotExists
@@ -405,7 +530,7 @@ var _ = Describe("Router", func() {
 			s.expectDictGet("a", "b")
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusNotFound))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, store.ErrDictKeyNotExists.Code)
 		})
 		Specify("other store error", func() {
 			s.error = errors.New("error")
@@ -413,7 +538,7 @@ var _ = Describe("Router", func() {
 			s.expectDictGet("a", "b")
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusInternalServerError))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errStoreError.Code)
 		})
 		Specify("authorization error", func() {
 			s.value = "v"
@@ -422,7 +547,7 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, rq)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusUnauthorized))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errUnauthorized.Code)
 		})
 		Specify("success", func() {
 			s.value = "v"
@@ -458,25 +583,25 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, req())
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errKeyRequired.Code)
 		})
 		Specify("no ttl query param error", func() {
 			r.ServeHTTP(res, req("key=a"))
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errTTLRequired.Code)
 		})
 		Specify("empty ttl error", func() {
 			r.ServeHTTP(res, req("key=a", "ttl="))
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errInvalidTTL.Code)
 		})
 		Specify("ttl parse error", func() {
 			r.ServeHTTP(res, req("key=a", "ttl=asd"))
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errInvalidTTL.Code)
 		})
 		Specify("body read error", func() {
 			rq := req("key=a", "ttl=10s")
@@ -484,7 +609,7 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, rq)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusInternalServerError))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errFailToReadAllBody.Code)
 		})
 		Specify("body YAML dict parse error", func() {
 			rq := req("key=", "ttl=10s")
@@ -492,7 +617,7 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, rq)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errInvalidDict.Code)
 		})
 		Specify("body YAML dict parse error", func() {
 			rq := req("key=", "ttl=10s")
@@ -500,7 +625,7 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, rq)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errInvalidDict.Code)
 		})
 		Specify("body YAML dict parse error", func() {
 			rq := req("key=", "ttl=10s")
@@ -508,7 +633,7 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, rq)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusBadRequest))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errInvalidDict.Code)
 		})
 		Specify("authorization error", func() {
 			rq := req("key=a", "ttl=10s")
@@ -517,7 +642,7 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, rq)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusUnauthorized))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errUnauthorized.Code)
 		})
 		Specify("success with empty key", func() {
 			rq := req("key=", "ttl=10s")
@@ -567,7 +692,7 @@ var _ = Describe("Router", func() {
 				r.ServeHTTP(res, req())
 				s.expectNoCalls()
 				Expect(res.Code).To(Equal(http.StatusBadRequest))
-				Expect(res.Body.String()).To(BeEmpty())
+				expectProblem(res, errKeyRequired.Code)
 			})
 			Specify("authorization error", func() {
 				rq := req("key=a")
@@ -575,15 +700,47 @@ var _ = Describe("Router", func() {
 				r.ServeHTTP(res, rq)
 				s.expectNoCalls()
 				Expect(res.Code).To(Equal(http.StatusUnauthorized))
-				Expect(res.Body.String()).To(BeEmpty())
+				expectProblem(res, errUnauthorized.Code)
 			})
 			Specify("success", func() {
 				r.ServeHTTP(res, req("key=a"))
-				s.expectRemove("a")
+				s.expectKeyRemove("a")
+				s.expectNoCalls()
+				Expect(res.Code).To(Equal(http.StatusOK))
+				Expect(res.Body.String()).To(BeEmpty())
+			})
+			Specify("success when key not exists", func() {
+				s.error = store.ErrKeyNotExists
+				r.ServeHTTP(res, req("key=a"))
+				s.expectKeyRemove("a")
 				s.expectNoCalls()
 				Expect(res.Code).To(Equal(http.StatusOK))
 				Expect(res.Body.String()).To(BeEmpty())
 			})
+			Specify("not key item error", func() {
+				s.error = store.ErrNotKeyItem
+				r.ServeHTTP(res, req("key=a"))
+				s.expectKeyRemove("a")
+				s.expectNoCalls()
+				Expect(res.Code).To(Equal(http.StatusConflict))
+				expectProblem(res, store.ErrNotKeyItem.Code)
+			})
+			Specify("read only store error", func() {
+				s.error = store.ErrReadOnly
+				r.ServeHTTP(res, req("key=a"))
+				s.expectKeyRemove("a")
+				s.expectNoCalls()
+				Expect(res.Code).To(Equal(http.StatusForbidden))
+				expectProblem(res, errReadOnly.Code)
+			})
+			Specify("not leader error", func() {
+				s.error = consensus.ErrNotLeader
+				r.ServeHTTP(res, req("key=a"))
+				s.expectKeyRemove("a")
+				s.expectNoCalls()
+				Expect(res.Code).To(Equal(http.StatusServiceUnavailable))
+				expectProblem(res, errNotLeader.Code)
+			})
 		})
 		Describe("list", func() {
 			BeforeEach(func() {
@@ -593,7 +750,7 @@ var _ = Describe("Router", func() {
 				r.ServeHTTP(res, req())
 				s.expectNoCalls()
 				Expect(res.Code).To(Equal(http.StatusBadRequest))
-				Expect(res.Body.String()).To(BeEmpty())
+				expectProblem(res, errKeyRequired.Code)
 			})
 			Specify("authorization error", func() {
 				rq := req("key=a")
@@ -601,15 +758,23 @@ var _ = Describe("Router", func() {
 				r.ServeHTTP(res, rq)
 				s.expectNoCalls()
 				Expect(res.Code).To(Equal(http.StatusUnauthorized))
-				Expect(res.Body.String()).To(BeEmpty())
+				expectProblem(res, errUnauthorized.Code)
 			})
 			Specify("success", func() {
 				r.ServeHTTP(res, req("key=a"))
-				s.expectRemove("a")
+				s.expectListRemove("a")
 				s.expectNoCalls()
 				Expect(res.Code).To(Equal(http.StatusOK))
 				Expect(res.Body.String()).To(BeEmpty())
 			})
+			Specify("not list item error", func() {
+				s.error = store.ErrNotListItem
+				r.ServeHTTP(res, req("key=a"))
+				s.expectListRemove("a")
+				s.expectNoCalls()
+				Expect(res.Code).To(Equal(http.StatusConflict))
+				expectProblem(res, store.ErrNotListItem.Code)
+			})
 		})
 		Describe("dict", func() {
 			BeforeEach(func() {
@@ -619,7 +784,7 @@ var _ = Describe("Router", func() {
 				r.ServeHTTP(res, req())
 				s.expectNoCalls()
 				Expect(res.Code).To(Equal(http.StatusBadRequest))
-				Expect(res.Body.String()).To(BeEmpty())
+				expectProblem(res, errKeyRequired.Code)
 			})
 			Specify("authorization error", func() {
 				rq := req("key=a")
@@ -627,15 +792,23 @@ var _ = Describe("Router", func() {
 				r.ServeHTTP(res, rq)
 				s.expectNoCalls()
 				Expect(res.Code).To(Equal(http.StatusUnauthorized))
-				Expect(res.Body.String()).To(BeEmpty())
+				expectProblem(res, errUnauthorized.Code)
 			})
 			Specify("success", func() {
 				r.ServeHTTP(res, req("key=a"))
-				s.expectRemove("a")
+				s.expectDictRemove("a")
 				s.expectNoCalls()
 				Expect(res.Code).To(Equal(http.StatusOK))
 				Expect(res.Body.String()).To(BeEmpty())
 			})
+			Specify("not dict item error", func() {
+				s.error = store.ErrNotDictItem
+				r.ServeHTTP(res, req("key=a"))
+				s.expectDictRemove("a")
+				s.expectNoCalls()
+				Expect(res.Code).To(Equal(http.StatusConflict))
+				expectProblem(res, store.ErrNotDictItem.Code)
+			})
 		})
 	})
 	Describe("getKeys", func() {
@@ -650,7 +823,15 @@ var _ = Describe("Router", func() {
 			r.ServeHTTP(res, rq)
 			s.expectNoCalls()
 			Expect(res.Code).To(Equal(http.StatusUnauthorized))
-			Expect(res.Body.String()).To(BeEmpty())
+			expectProblem(res, errUnauthorized.Code)
+		})
+		Specify("store error", func() {
+			s.error = store.ErrStoreClosed
+			r.ServeHTTP(res, req())
+			s.expectKeys()
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusInternalServerError))
+			expectProblem(res, store.ErrStoreClosed.Code)
 		})
 		Specify("success when no keys", func() {
 			r.ServeHTTP(res, req())
@@ -668,112 +849,1534 @@ var _ = Describe("Router", func() {
 			Expect(res.Body.String()).To(Equal("- a\n- b\n- c\n"))
 		})
 	})
-})
-
-type testStore struct {
-	calls []call
-	value string
-	keys  []string
-	error error
-}
-
-func (s *testStore) newCall(f interface{}, args ...interface{}) {
-	s.calls = append(s.calls, call{
-		method: funcToName(f),
-		args:   args,
+	Describe("getExport", func() {
+		BeforeEach(func() {
+			method = http.MethodGet
+			path = "/admin/export"
+		})
+		Specify("authorization error", func() {
+			rq := req()
+			rq.Header.Del("Authorization")
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusUnauthorized))
+			expectProblem(res, errUnauthorized.Code)
+		})
+		Specify("store error before streaming", func() {
+			s.error = errors.New("error")
+			r.ServeHTTP(res, req())
+			s.expectExport()
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusInternalServerError))
+			expectProblem(res, errStoreError.Code)
+		})
+		Specify("store error while streaming", func() {
+			s.value = `{"key":"a"}`
+			s.error = errors.New("error")
+			r.ServeHTTP(res, req())
+			s.expectExport()
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(Equal(`{"key":"a"}`))
+		})
+		Specify("success", func() {
+			s.value = `{"key":"a"}` + "\n"
+			r.ServeHTTP(res, req())
+			s.expectExport()
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Header().Get("Content-Type")).To(Equal("application/x-ndjson"))
+			Expect(res.Body.String()).To(Equal(`{"key":"a"}` + "\n"))
+		})
 	})
+	Describe("postImport", func() {
+		BeforeEach(func() {
+			method = http.MethodPost
+			path = "/admin/import"
+		})
+		Specify("authorization error", func() {
+			rq := req()
+			rq.Header.Del("Authorization")
+			rq.Body = body(`{"key":"a"}`)
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusUnauthorized))
+			expectProblem(res, errUnauthorized.Code)
+		})
+		Specify("invalid import data error", func() {
+			s.error = store.StoreError{Err: "fail to import items", Code: store.ErrFailToImportItems.Code, Details: "unexpected EOF"}
+			rq := req()
+			rq.Body = body(`{"key":"a"`)
+			r.ServeHTTP(res, rq)
+			s.expectImport(`{"key":"a"`)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusBadRequest))
+			expectProblem(res, store.ErrFailToImportItems.Code)
+		})
+		Specify("other store error", func() {
+			s.error = errors.New("error")
+			rq := req()
+			rq.Body = body(`{"key":"a"}`)
+			r.ServeHTTP(res, rq)
+			s.expectImport(`{"key":"a"}`)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusInternalServerError))
+			expectProblem(res, errStoreError.Code)
+		})
+		Specify("success", func() {
+			s.count = 2
+			rq := req()
+			rq.Body = body(`{"key":"a"}` + "\n" + `{"key":"b"}`)
+			r.ServeHTTP(res, rq)
+			s.expectImport(`{"key":"a"}` + "\n" + `{"key":"b"}`)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(Equal("2"))
+		})
+	})
+	Describe("postDump", func() {
+		BeforeEach(func() {
+			method = http.MethodPost
+			path = "/admin/dump"
+		})
+		Specify("authorization error", func() {
+			rq := req()
+			rq.Header.Del("Authorization")
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusUnauthorized))
+			expectProblem(res, errUnauthorized.Code)
+		})
+		Specify("store error", func() {
+			s.dumpStatus = store.DumpStatus{
+				Time:        time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+				Duration:    time.Second,
+				Error:       "error",
+				LastSuccess: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+				Failures:    1,
+			}
+			s.error = errors.New("error")
+			r.ServeHTTP(res, req())
+			s.expectDump()
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusInternalServerError))
+			Expect(res.Body.String()).To(Equal("time: 2020-01-01T00:00:00Z\nduration: 1s\nerror: error\n" +
+				"last_success: 2019-01-01T00:00:00Z\nfailures: 1\n"))
+		})
+		Specify("success", func() {
+			s.dumpStatus = store.DumpStatus{
+				Time:        time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+				Duration:    time.Second,
+				LastSuccess: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			}
+			r.ServeHTTP(res, req())
+			s.expectDump()
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(Equal("time: 2020-01-01T00:00:00Z\nduration: 1s\n" +
+				"last_success: 2020-01-01T00:00:00Z\nfailures: 0\n"))
+		})
+	})
+	Describe("postFlush", func() {
+		BeforeEach(func() {
+			method = http.MethodPost
+			path = "/admin/flush"
+		})
+		Specify("authorization error", func() {
+			rq := req()
+			rq.Header.Del("Authorization")
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusUnauthorized))
+			expectProblem(res, errUnauthorized.Code)
+		})
+		Specify("success", func() {
+			r.ServeHTTP(res, req())
+			s.expectFlush()
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(BeEmpty())
+		})
+	})
+	Describe("getInfo", func() {
+		BeforeEach(func() {
+			method = http.MethodGet
+			path = "/admin/info"
+		})
+		Specify("authorization error", func() {
+			rq := req()
+			rq.Header.Del("Authorization")
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusUnauthorized))
+			expectProblem(res, errUnauthorized.Code)
+		})
+		Specify("success", func() {
+			s.info = store.Info{
+				Keys:     1,
+				Lists:    2,
+				Dicts:    3,
+				Memory:   100,
+				Uptime:   time.Hour,
+				Cleaning: true,
+				Dump: store.DumpStatus{
+					Time:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+					Duration: time.Second,
+				},
+				Replication: store.ReplicationStatus{ID: "id", Seq: 10, PrimarySeq: 12, Lag: 2,
+					LastContact: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+			}
+			r.ServeHTTP(res, req())
+			s.expectInfo()
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(Equal("keys: 1\nlists: 2\ndicts: 3\nmemory: 100\nuptime: 1h0m0s\n" +
+				"cleaning: true\ndumping: false\ndump:\n  time: 2020-01-01T00:00:00Z\n  duration: 1s\n" +
+				"  last_success: 0001-01-01T00:00:00Z\n  failures: 0\nreplication:\n  id: id\n  seq: 10\n" +
+				"  primary_seq: 12\n  lag: 2\n  last_contact: 2020-01-01T00:00:00Z\n"))
+		})
+	})
+	Describe("error responses", func() {
+		Specify("store error code", func() {
+			method = http.MethodGet
+			path = "/list"
+			s.error = store.ErrListIndexNotExists
+			r.ServeHTTP(res, req("key=a", "index=1"))
+			Expect(res.Code).To(Equal(http.StatusNotFound))
+			Expect(res.Body.String()).To(MatchJSON(`{"code":21,"error":"list index not exists"}`))
+		})
+		Specify("store error code and details", func() {
+			method = http.MethodPost
+			path = "/admin/import"
+			s.error = store.StoreError{Err: "fail to import items", Code: store.ErrFailToImportItems.Code,
+				Details: "unexpected EOF"}
+			r.ServeHTTP(res, req())
+			Expect(res.Code).To(Equal(http.StatusBadRequest))
+			Expect(res.Body.String()).To(MatchJSON(`{"code":81,"error":"fail to import items","details":"unexpected EOF"}`))
+		})
+		Specify("server error code and details", func() {
+			method = http.MethodPut
+			path = "/key"
+			r.ServeHTTP(res, req("key=a", "ttl=asd"))
+			Expect(res.Code).To(Equal(http.StatusBadRequest))
+			Expect(res.Body.String()).To(MatchJSON(`{"code":110,"error":"invalid ttl",` +
+				`"details":"time: invalid duration \"asd\""}`))
+		})
+		Specify("unknown route error", func() {
+			method = http.MethodGet
+			path = "/unknown"
+			r.ServeHTTP(res, req())
+			Expect(res.Code).To(Equal(http.StatusNotFound))
+			Expect(res.Body.String()).To(MatchJSON(`{"code":171,"error":"route not found"}`))
+		})
+		Specify("authorization error has authenticate header", func() {
+			method = http.MethodGet
+			path = "/keys"
+			rq := req()
+			rq.SetBasicAuth("test", "wrong")
+			r.ServeHTTP(res, rq)
+			Expect(res.Code).To(Equal(http.StatusUnauthorized))
+			Expect(res.Header().Get("WWW-Authenticate")).To(Equal(`Basic realm="Authorization Required"`))
+			expectProblem(res, errUnauthorized.Code)
+		})
+	})
+	Describe("access control", func() {
+		BeforeEach(func() {
+			r = NewRouter(auth.NewAuthenticator(auth.Accounts{
+				"reader": {Password: "test", Role: auth.ReadOnly, Keys: []string{"user:*"}},
+				"writer": {Password: "test", Role: auth.ReadWrite},
+			}), s)
+		})
+		as := func(login string, params ...string) *http.Request {
+			rq := req(params...)
+			rq.SetBasicAuth(login, "test")
+			return rq
+		}
+		Specify("read of allowed key", func() {
+			method = http.MethodGet
+			path = "/key"
+			r.ServeHTTP(res, as("reader", "key=user:1"))
+			s.expectGet("user:1")
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+		})
+		Specify("read of not allowed key error", func() {
+			method = http.MethodGet
+			path = "/list"
+			r.ServeHTTP(res, as("reader", "key=admin", "index=0"))
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusForbidden))
+			expectProblem(res, errForbidden.Code)
+		})
+		Specify("write by read only account error", func() {
+			method = http.MethodDelete
+			path = "/key"
+			r.ServeHTTP(res, as("reader", "key=user:1"))
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusForbidden))
+			expectProblem(res, errForbidden.Code)
+		})
+		Specify("write by read write account", func() {
+			method = http.MethodDelete
+			path = "/key"
+			r.ServeHTTP(res, as("writer", "key=a"))
+			s.expectKeyRemove("a")
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+		})
+		Specify("admin routes by not admin account error", func() {
+			for _, route := range []struct{ method, path string }{
+				{http.MethodGet, "/admin/export"},
+				{http.MethodPost, "/admin/import"},
+				{http.MethodPost, "/admin/dump"},
+				{http.MethodPost, "/admin/flush"},
+				{http.MethodGet, "/admin/info"},
+				{http.MethodGet, "/admin/replication/snapshot"},
+				{http.MethodGet, "/admin/replication/stream"},
+			} {
+				method, path = route.method, route.path
+				res = httptest.NewRecorder()
+				r.ServeHTTP(res, as("writer"))
+				Expect(res.Code).To(Equal(http.StatusForbidden), path)
+				expectProblem(res, errForbidden.Code)
+			}
+			s.expectNoCalls()
+		})
+		Specify("keys are filtered by account keys", func() {
+			method = http.MethodGet
+			path = "/keys"
+			s.keys = []string{"user:1", "admin", "user:2"}
+			r.ServeHTTP(res, as("reader"))
+			s.expectKeys()
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(Equal("- user:1\n- user:2\n"))
+		})
+	})
+	Describe("namespaces", func() {
+		var ts *testStore
+		BeforeEach(func() {
+			ts = &testStore{}
+			r = NewNamespacesRouter(auth.NewAuthenticator(auth.Accounts{
+				"test":   {Password: "test", Role: auth.Admin},
+				"tenant": {Password: "test", Role: auth.ReadWrite, Namespace: "t"},
+				"user":   {Password: "test", Role: auth.ReadWrite},
+			}), store.Namespaces{store.DefaultNamespace: s, "t": ts})
+			method = http.MethodGet
+			path = "/key"
+		})
+		as := func(login string, params ...string) *http.Request {
+			rq := req(params...)
+			rq.SetBasicAuth(login, "test")
+			return rq
+		}
+		Specify("account's namespace", func() {
+			r.ServeHTTP(res, as("tenant", "key=a"))
+			ts.expectGet("a")
+			ts.expectNoCalls()
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+		})
+		Specify("default namespace", func() {
+			r.ServeHTTP(res, as("user", "key=a"))
+			s.expectGet("a")
+			s.expectNoCalls()
+			ts.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+		})
+		Specify("db chosen by admin", func() {
+			r.ServeHTTP(res, as("test", "key=a", "db=t"))
+			ts.expectGet("a")
+			ts.expectNoCalls()
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+		})
+		Specify("own db", func() {
+			r.ServeHTTP(res, as("tenant", "key=a", "db=t"))
+			ts.expectGet("a")
+			ts.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+		})
+		Specify("other namespace error", func() {
+			for _, rq := range []*http.Request{as("tenant", "key=a", "db="), as("user", "key=a", "db=t")} {
+				res = httptest.NewRecorder()
+				r.ServeHTTP(res, rq)
+				Expect(res.Code).To(Equal(http.StatusForbidden))
+				expectProblem(res, errForbidden.Code)
+			}
+			s.expectNoCalls()
+			ts.expectNoCalls()
+		})
+		Specify("namespace not exists error", func() {
+			r.ServeHTTP(res, as("test", "key=a", "db=x"))
+			s.expectNoCalls()
+			ts.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusNotFound))
+			expectProblem(res, store.ErrNamespaceNotExists.Code)
+		})
+	})
+	Describe("metrics", func() {
+		BeforeEach(func() {
+			method = http.MethodGet
+			path = "/metrics"
+		})
+		Specify("success", func() {
+			path = "/key"
+			r.ServeHTTP(res, req("key=a"))
+			s.expectGet("a")
+			res = httptest.NewRecorder()
+			r.ServeHTTP(res, req())
+			s.expectNoCalls()
+			s.metrics = store.Metrics{
+				Keys:         store.TypeMetrics{Items: 2, Hits: 5, Misses: 1},
+				Bytes:        100,
+				Expired:      3,
+				Cleanings:    2,
+				CleaningTime: 1500 * time.Millisecond,
+				Dumps:        1,
+				DumpTime:     time.Second,
+				Dump:         store.DumpStatus{Duration: time.Second},
+			}
+			path = "/metrics"
+			res = httptest.NewRecorder()
+			r.ServeHTTP(res, req())
+			s.expectMetrics()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Header().Get("Content-Type")).To(Equal(metricsContentType))
+			lines := strings.Split(res.Body.String(), "\n")
+			for _, line := range []string{
+				"# TYPE yamc_http_requests_total counter",
+				`yamc_http_requests_total{method="GET",route="/key",code="200"} 1`,
+				`yamc_http_requests_total{method="GET",route="/key",code="400"} 1`,
+				"# TYPE yamc_http_request_duration_seconds histogram",
+				`yamc_http_request_duration_seconds_bucket{method="GET",route="/key",le="+Inf"} 2`,
+				`yamc_http_request_duration_seconds_count{method="GET",route="/key"} 2`,
+				`yamc_store_items{namespace="",type="key"} 2`,
+				`yamc_store_items{namespace="",type="list"} 0`,
+				`yamc_store_memory_bytes{namespace=""} 100`,
+				`yamc_store_hits_total{namespace="",type="key"} 5`,
+				`yamc_store_misses_total{namespace="",type="key"} 1`,
+				"# HELP yamc_store_expired_total Count of expired items removed by cleaning. Items are never evicted, " +
+					"writes exceeding quotas are rejected.",
+				`yamc_store_expired_total{namespace=""} 3`,
+				`yamc_store_cleaning_duration_seconds_sum{namespace=""} 1.5`,
+				`yamc_store_cleaning_duration_seconds_count{namespace=""} 2`,
+				`yamc_store_dump_duration_seconds_sum{namespace=""} 1`,
+				`yamc_store_dump_failures_total{namespace=""} 0`,
+				`yamc_store_last_dump_duration_seconds{namespace=""} 1`,
+			} {
+				Expect(lines).To(ContainElement(line))
+			}
+		})
+		Specify("namespace admin forbidden", func() {
+			r = NewNamespacesRouter(auth.NewAuthenticator(auth.Accounts{
+				"test": {Password: "test", Role: auth.Admin, Namespace: "t"},
+			}), store.Namespaces{store.DefaultNamespace: &testStore{}, "t": s})
+			r.ServeHTTP(res, req())
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusForbidden))
+			expectProblem(res, errForbidden.Code)
+		})
+		Specify("read-write account forbidden", func() {
+			r = NewRouter(auth.NewAuthenticator(auth.Accounts{
+				"test": {Password: "test", Role: auth.ReadWrite},
+			}), s)
+			r.ServeHTTP(res, req())
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusForbidden))
+		})
+		Specify("monitor account", func() {
+			r = NewRouter(auth.NewAuthenticator(auth.Accounts{"test": {Password: "test", Role: auth.Monitor}}), s)
+			r.ServeHTTP(res, req())
+			s.expectMetrics()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			path = "/admin/info"
+			res = httptest.NewRecorder()
+			r.ServeHTTP(res, req())
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusForbidden))
+			path = "/key"
+			res = httptest.NewRecorder()
+			r.ServeHTTP(res, req("key=a"))
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusForbidden))
+		})
+		Specify("metrics token", func() {
+			a := auth.NewAuthenticator(auth.Accounts{"test": {Password: "test", Role: auth.Admin}})
+			_, secret, err := a.Tokens().Issue("test", []auth.Scope{auth.MetricsScope}, 0)
+			Expect(err).ToNot(HaveOccurred())
+			r = NewRouter(a, s)
+			rq := httptest.NewRequest(method, path, nil)
+			rq.Header.Set("Authorization", "Bearer "+secret)
+			r.ServeHTTP(res, rq)
+			s.expectMetrics()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			rq = httptest.NewRequest(http.MethodGet, "/admin/info", nil)
+			rq.Header.Set("Authorization", "Bearer "+secret)
+			res = httptest.NewRecorder()
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusForbidden))
+			expectProblem(res, errForbidden.Code)
+		})
+	})
+	Describe("tokens", func() {
+		var (
+			a  *auth.Authenticator
+			ts *testStore
+		)
+		BeforeEach(func() {
+			ts = &testStore{}
+			a = auth.NewAuthenticator(auth.Accounts{
+				"test":   {Password: "test", Role: auth.Admin},
+				"app":    {Password: "test", Role: auth.ReadWrite},
+				"tenant": {Password: "test", Role: auth.Admin, Namespace: "t"},
+			})
+			r = NewNamespacesRouter(a, store.Namespaces{store.DefaultNamespace: s, "t": ts})
+			method = http.MethodPost
+			path = "/admin/tokens"
+		})
+		bearer := func(secret string, params ...string) *http.Request {
+			rq := req(params...)
+			rq.Header.Set("Authorization", "Bearer "+secret)
+			return rq
+		}
+		Specify("issue", func() {
+			rq := req("login=app", "scope=read,write", "ttl=1h")
+			rq.Header.Set("Accept", "application/json")
+			r.ServeHTTP(res, rq)
+			Expect(res.Code).To(Equal(http.StatusOK))
+			var t issuedToken
+			Expect(json.Unmarshal(res.Body.Bytes(), &t)).To(Succeed())
+			Expect(t.Login).To(Equal("app"))
+			Expect(t.Scopes).To(Equal([]auth.Scope{auth.ReadScope, auth.WriteScope}))
+			Expect(t.Expiry.Sub(t.Created)).To(Equal(time.Hour))
+			Expect(a.Tokens().Verify(t.Secret)).To(Equal(t.Token))
+		})
+		Specify("issue errors", func() {
+			for _, c := range []struct {
+				params []string
+				status int
+				code   int
+			}{
+				{[]string{"login=app"}, http.StatusBadRequest, errScopeRequired.Code},
+				{[]string{"scope=read,root"}, http.StatusBadRequest, errInvalidScope.Code},
+				{[]string{"scope=read", "ttl=-1h"}, http.StatusBadRequest, errInvalidTTL.Code},
+				{[]string{"scope=read", "login=x"}, http.StatusNotFound, errAccountNotExists.Code},
+			} {
+				res = httptest.NewRecorder()
+				r.ServeHTTP(res, req(c.params...))
+				Expect(res.Code).To(Equal(c.status))
+				expectProblem(res, c.code)
+			}
+			Expect(a.Tokens().List()).To(BeEmpty())
+		})
+		Specify("issue by namespace admin", func() {
+			rq := req("scope=read", "login=app")
+			rq.SetBasicAuth("tenant", "test")
+			r.ServeHTTP(res, rq)
+			Expect(res.Code).To(Equal(http.StatusForbidden))
+			expectProblem(res, errForbidden.Code)
+			res = httptest.NewRecorder()
+			rq = req("scope=read")
+			rq.SetBasicAuth("tenant", "test")
+			r.ServeHTTP(res, rq)
+			Expect(res.Code).To(Equal(http.StatusOK))
+		})
+		Specify("list and revoke", func() {
+			t, _, err := a.Tokens().Issue("app", []auth.Scope{auth.ReadScope}, 0)
+			Expect(err).ToNot(HaveOccurred())
+			_, _, err = a.Tokens().Issue("tenant", []auth.Scope{auth.ReadScope}, 0)
+			Expect(err).ToNot(HaveOccurred())
+
+			By("namespace admin lists own namespace tokens only")
+			method = http.MethodGet
+			rq := req()
+			rq.SetBasicAuth("tenant", "test")
+			r.ServeHTTP(res, rq)
+			Expect(res.Code).To(Equal(http.StatusOK))
+			var tokens []auth.Token
+			Expect(codec.YAML.Unmarshal(res.Body.Bytes(), &tokens)).To(Succeed())
+			Expect(tokens).To(HaveLen(1))
+			Expect(tokens[0].Login).To(Equal("tenant"))
+
+			By("namespace admin can't revoke other namespace tokens")
+			method = http.MethodDelete
+			res = httptest.NewRecorder()
+			rq = req("id=" + t.ID)
+			rq.SetBasicAuth("tenant", "test")
+			r.ServeHTTP(res, rq)
+			Expect(res.Code).To(Equal(http.StatusNotFound))
+			expectProblem(res, errTokenNotExists.Code)
+
+			By("revoke")
+			res = httptest.NewRecorder()
+			r.ServeHTTP(res, req("id="+t.ID))
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(a.Tokens().List()).To(HaveLen(1))
+			res = httptest.NewRecorder()
+			r.ServeHTTP(res, req())
+			Expect(res.Code).To(Equal(http.StatusBadRequest))
+			expectProblem(res, errIDRequired.Code)
+		})
+		Specify("bearer token", func() {
+			_, secret, err := a.Tokens().Issue("app", []auth.Scope{auth.ReadScope}, 0)
+			Expect(err).ToNot(HaveOccurred())
+			method = http.MethodGet
+			path = "/key"
+			r.ServeHTTP(res, bearer(secret, "key=a"))
+			s.expectGet("a")
+			Expect(res.Code).To(Equal(http.StatusOK))
+
+			By("not permitted scope")
+			method = http.MethodPut
+			res = httptest.NewRecorder()
+			r.ServeHTTP(res, bearer(secret, "key=a", "ttl=1s"))
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusForbidden))
+			expectProblem(res, errForbidden.Code)
+
+			By("invalid token")
+			method = http.MethodGet
+			res = httptest.NewRecorder()
+			r.ServeHTTP(res, bearer(secret+"x", "key=a"))
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusUnauthorized))
+			expectProblem(res, errUnauthorized.Code)
+
+			By("token of removed account")
+			a.Set(auth.Accounts{"test": {Password: "test", Role: auth.Admin}})
+			res = httptest.NewRecorder()
+			r.ServeHTTP(res, bearer(secret, "key=a"))
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusUnauthorized))
+		})
+		Specify("signed token", func() {
+			_, secret, err := a.Tokens().Issue("app", []auth.Scope{auth.ReadScope, auth.WriteScope}, 0)
+			Expect(err).ToNot(HaveOccurred())
+			method = http.MethodPut
+			path = "/key"
+			rq := req("key=a", "ttl=10s")
+			rq.Body = body("v")
+			authorization, err := auth.SignatureAuthorization(secret, method, "/key?key=a&ttl=10s", time.Now(),
+				[]byte("v"))
+			Expect(err).ToNot(HaveOccurred())
+			rq.Header.Set("Authorization", authorization)
+			r.ServeHTTP(res, rq)
+			s.expectSet("a", "v", 10*time.Second)
+			Expect(res.Code).To(Equal(http.StatusOK))
+
+			By("replayed")
+			rq = req("key=a", "ttl=10s")
+			rq.Body = body("v")
+			rq.Header.Set("Authorization", authorization)
+			res = httptest.NewRecorder()
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusUnauthorized))
+			Expect(res.Body.String()).To(MatchJSON(`{"code":140,"error":"unauthorized","details":"signature reused"}`))
+		})
+		Specify("signed body too large", func() {
+			_, secret, err := a.Tokens().Issue("app", []auth.Scope{auth.ReadScope, auth.WriteScope}, 0)
+			Expect(err).ToNot(HaveOccurred())
+			a.Set(auth.Accounts{"app": {Password: "test", Role: auth.ReadWrite, Limits: auth.Limits{MaxValueSize: 3}}})
+			method = http.MethodPut
+			path = "/key"
+			authorization, err := auth.SignatureAuthorization(secret, method, "/key?key=a", time.Now(), []byte("vvvv"))
+			Expect(err).ToNot(HaveOccurred())
+
+			By("unknown token rejected before body is read")
+			rq := req("key=a")
+			rq.Body = ioutil.NopCloser(iotest.ErrReader(errors.New("body read")))
+			rq.Header.Set("Authorization", strings.Replace(authorization, "Credential=", "Credential=x", 1))
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusUnauthorized))
+			expectProblem(res, errUnauthorized.Code)
+
+			By("body over account's value size limit")
+			rq = req("key=a")
+			rq.Body = body("vvvv")
+			rq.Header.Set("Authorization", authorization)
+			res = httptest.NewRecorder()
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusRequestEntityTooLarge))
+			expectProblem(res, errSignedBodyTooLarge.Code)
+		})
+	})
+	Describe("limits", func() {
+		BeforeEach(func() {
+			r = NewRouter(auth.NewAuthenticator(auth.Accounts{
+				"test": {Password: "test", Role: auth.Admin},
+				"limited": {Password: "test", Role: auth.ReadWrite, Limits: auth.Limits{MaxKeys: 2, MaxBytes: 100,
+					MaxValueSize: 3, RequestsPerSecond: 0.001, Burst: 2}},
+			}), s)
+			method = http.MethodPut
+			path = "/key"
+		})
+		limited := func(params ...string) *http.Request {
+			rq := req(params...)
+			rq.SetBasicAuth("limited", "test")
+			return rq
+		}
+		Specify("rate limit exceeded error", func() {
+			method = http.MethodGet
+			for i := 0; i < 2; i++ {
+				res = httptest.NewRecorder()
+				r.ServeHTTP(res, limited("key=a"))
+				s.expectGet("a")
+				Expect(res.Code).To(Equal(http.StatusOK))
+			}
+			res = httptest.NewRecorder()
+			r.ServeHTTP(res, limited("key=a"))
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(res.Header().Get("Retry-After")).To(Equal("1000"))
+			expectProblem(res, errRateLimitExceeded.Code)
+
+			By("not limited account")
+			res = httptest.NewRecorder()
+			r.ServeHTTP(res, req("key=a"))
+			s.expectGet("a")
+			Expect(res.Code).To(Equal(http.StatusOK))
+		})
+		Specify("value too large error", func() {
+			rq := limited("key=a", "ttl=10s")
+			rq.Body = body("vvvv")
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusInsufficientStorage))
+			expectProblem(res, errValueTooLarge.Code)
+		})
+		Specify("keys quota exceeded error", func() {
+			s.error = store.ErrKeysQuotaExceeded
+			rq := limited("key=a", "ttl=10s")
+			rq.Body = body("vvv")
+			r.ServeHTTP(res, rq)
+			s.expectSet("a", "vvv", 10*time.Second)
+			Expect(s.owner).To(Equal("limited"))
+			Expect(s.quota).To(Equal(store.Quota{MaxKeys: 2, MaxBytes: 100}))
+			Expect(res.Code).To(Equal(http.StatusInsufficientStorage))
+			expectProblem(res, store.ErrKeysQuotaExceeded.Code)
+		})
+		Specify("bytes quota exceeded error", func() {
+			s.error = store.ErrBytesQuotaExceeded
+			rq := limited("key=a", "ttl=10s")
+			rq.Body = body("vvv")
+			r.ServeHTTP(res, rq)
+			s.expectSet("a", "vvv", 10*time.Second)
+			Expect(res.Code).To(Equal(http.StatusInsufficientStorage))
+			expectProblem(res, store.ErrBytesQuotaExceeded.Code)
+		})
+		Specify("success within limits", func() {
+			rq := limited("key=a", "ttl=10s")
+			rq.Body = body("vvv")
+			r.ServeHTTP(res, rq)
+			s.expectSet("a", "vvv", 10*time.Second)
+			s.expectNoCalls()
+			Expect(s.owner).To(Equal("limited"))
+			Expect(res.Code).To(Equal(http.StatusOK))
+		})
+		Specify("info reports limited accounts", func() {
+			rq := limited("key=a", "ttl=10s")
+			rq.Body = body("vvvv")
+			r.ServeHTTP(res, rq)
+			Expect(res.Code).To(Equal(http.StatusInsufficientStorage))
+			s.usage = store.Usage{Items: 2, Bytes: 90}
+			method = http.MethodGet
+			path = "/admin/info"
+			rq = req()
+			rq.Header.Set("Accept", "application/json")
+			res = httptest.NewRecorder()
+			r.ServeHTTP(res, rq)
+			s.expectInfo()
+			s.expectUsageOf("limited")
+			Expect(res.Code).To(Equal(http.StatusOK))
+			var i info
+			Expect(json.Unmarshal(res.Body.Bytes(), &i)).To(Succeed())
+			Expect(i.Accounts).To(Equal(map[string]accountInfo{"limited": {
+				Limits:   auth.Limits{MaxKeys: 2, MaxBytes: 100, MaxValueSize: 3, RequestsPerSecond: 0.001, Burst: 2},
+				Usage:    store.Usage{Items: 2, Bytes: 90},
+				Rejected: 1,
+			}}))
+		})
+	})
+	Describe("content negotiation", func() {
+		Specify("YAML response by default", func() {
+			method = http.MethodGet
+			path = "/keys"
+			s.keys = []string{"a"}
+			r.ServeHTTP(res, req())
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Header().Get("Content-Type")).To(Equal("application/yaml"))
+			Expect(res.Body.String()).To(Equal("- a\n"))
+		})
+		Specify("JSON response", func() {
+			method = http.MethodGet
+			path = "/keys"
+			rq := req()
+			rq.Header.Set("Accept", "text/html, application/json;q=0.9, application/yaml;q=0.5")
+			r.ServeHTTP(res, rq)
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(res.Body.String()).To(Equal("[]"))
+		})
+		Specify("MessagePack response", func() {
+			method = http.MethodGet
+			path = "/admin/info"
+			s.info = store.Info{Keys: 1, Uptime: time.Hour}
+			rq := req()
+			rq.Header.Set("Accept", "application/x-msgpack")
+			r.ServeHTTP(res, rq)
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Header().Get("Content-Type")).To(Equal("application/msgpack"))
+			var info store.Info
+			Expect(codec.MessagePack.Unmarshal(res.Body.Bytes(), &info)).To(Succeed())
+			Expect(info.Keys).To(Equal(1))
+			Expect(info.Uptime).To(Equal(time.Hour))
+		})
+		Specify("not acceptable error", func() {
+			method = http.MethodPost
+			path = "/admin/dump"
+			rq := req()
+			rq.Header.Set("Accept", "text/html")
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusNotAcceptable))
+		})
+		Specify("JSON request body", func() {
+			method = http.MethodPut
+			path = "/list"
+			rq := req("key=a", "ttl=10s")
+			rq.Header.Set("Content-Type", "application/json; charset=utf-8")
+			rq.Body = body(`["yes", "1.0"]`)
+			r.ServeHTTP(res, rq)
+			s.expectListSet("a", []string{"yes", "1.0"}, 10*time.Second)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+		})
+		Specify("JSON request body parse error", func() {
+			method = http.MethodPut
+			path = "/dict"
+			rq := req("key=a", "ttl=10s")
+			rq.Header.Set("Content-Type", "application/json")
+			rq.Body = body(`a: b`)
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusBadRequest))
+		})
+		Specify("MessagePack request body", func() {
+			method = http.MethodPut
+			path = "/dict"
+			data, err := codec.MessagePack.Marshal(map[string]string{"a": "b"})
+			Expect(err).ToNot(HaveOccurred())
+			rq := req("key=a", "ttl=10s")
+			rq.Header.Set("Content-Type", "application/msgpack")
+			rq.Body = body(string(data))
+			r.ServeHTTP(res, rq)
+			s.expectDictSet("a", map[string]string{"a": "b"}, 10*time.Second)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+		})
+		Specify("unsupported media type error", func() {
+			method = http.MethodPut
+			path = "/list"
+			rq := req("key=a", "ttl=10s")
+			rq.Header.Set("Content-Type", "text/csv")
+			rq.Body = body("a,b")
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusUnsupportedMediaType))
+		})
+	})
+	Describe("getReplicationSnapshot", func() {
+		BeforeEach(func() {
+			method = http.MethodGet
+			path = "/admin/replication/snapshot"
+		})
+		Specify("authorization error", func() {
+			rq := req()
+			rq.Header.Del("Authorization")
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusUnauthorized))
+		})
+		Specify("store error", func() {
+			s.error = store.ErrStoreClosed
+			r.ServeHTTP(res, req())
+			s.expectSnapshot()
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusInternalServerError))
+		})
+		Specify("success", func() {
+			s.value = `{"id":"id","seq":1}` + "\n"
+			r.ServeHTTP(res, req())
+			s.expectSnapshot()
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Header().Get("Content-Type")).To(Equal("application/x-ndjson"))
+			Expect(res.Body.String()).To(Equal(`{"id":"id","seq":1}` + "\n"))
+		})
+	})
+	Describe("getReplicationStream", func() {
+		BeforeEach(func() {
+			method = http.MethodGet
+			path = "/admin/replication/stream"
+		})
+		Specify("authorization error", func() {
+			rq := req("id=id", "seq=1")
+			rq.Header.Del("Authorization")
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusUnauthorized))
+		})
+		Specify("no id query param error", func() {
+			r.ServeHTTP(res, req("seq=1"))
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusBadRequest))
+		})
+		Specify("no seq query param error", func() {
+			r.ServeHTTP(res, req("id=id"))
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusBadRequest))
+		})
+		Specify("invalid seq error", func() {
+			r.ServeHTTP(res, req("id=id", "seq=-1"))
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusBadRequest))
+		})
+		Specify("truncated log error", func() {
+			s.error = store.ErrReplicationLogTruncated
+			r.ServeHTTP(res, req("id=id", "seq=1"))
+			s.expectReplicate(store.ReplicationPosition{ID: "id", Seq: 1})
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusGone))
+			expectProblem(res, store.ErrReplicationLogTruncated.Code)
+		})
+		Specify("other store error", func() {
+			s.error = store.ErrStoreClosed
+			r.ServeHTTP(res, req("id=id", "seq=1"))
+			s.expectReplicate(store.ReplicationPosition{ID: "id", Seq: 1})
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusInternalServerError))
+		})
+		Specify("success", func() {
+			s.value = `{"seq":2,"op":"flush"}` + "\n"
+			r.ServeHTTP(res, req("id=id", "seq=1"))
+			s.expectReplicate(store.ReplicationPosition{ID: "id", Seq: 1})
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Flushed).To(BeTrue())
+			Expect(res.Body.String()).To(Equal(`{"seq":2,"op":"flush"}` + "\n"))
+		})
+	})
+})
+
+var _ = Describe("leader forwarding", func() {
+	var (
+		s        *testStore
+		l        *leaderStore
+		r        *gin.Engine
+		res      *notifyingRecorder
+		leader   *httptest.Server
+		received chan *http.Request
+	)
+	req := func(method string, uri string, body string) *http.Request {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("test:test")))
+		return req
+	}
+	BeforeEach(func() {
+		s = &testStore{}
+		l = &leaderStore{testStore: s}
+		r = NewRouter(auth.NewAuthenticator(auth.Accounts{"test": {Password: "test", Role: auth.Admin}}), l)
+		res = newNotifyingRecorder()
+		received = make(chan *http.Request, 1)
+		leader = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			r.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+			received <- r
+			w.WriteHeader(http.StatusOK)
+		}))
+		l.url = leader.URL
+	})
+	AfterEach(func() {
+		leader.Close()
+	})
+	Specify("leader handles request", func() {
+		l.isLeader = true
+		r.ServeHTTP(res, req(http.MethodPut, "/key?key=a&ttl=10s", "v"))
+		s.expectSet("a", "v", 10*time.Second)
+		s.expectNoCalls()
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(received).ToNot(Receive())
+	})
+	Specify("follower handles reads", func() {
+		s.value = "v"
+		r.ServeHTTP(res, req(http.MethodGet, "/key?key=a", ""))
+		s.expectGet("a")
+		s.expectNoCalls()
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(received).ToNot(Receive())
+	})
+	Specify("follower forwards mutating requests to leader", func() {
+		for _, rq := range []*http.Request{
+			req(http.MethodPut, "/key?key=a&ttl=10s", "v"),
+			req(http.MethodPut, "/list?key=a&ttl=10s", "- v"),
+			req(http.MethodPut, "/dict?key=a&ttl=10s", "k: v"),
+			req(http.MethodDelete, "/key?key=a", ""),
+			req(http.MethodDelete, "/list?key=a", ""),
+			req(http.MethodDelete, "/dict?key=a", ""),
+			req(http.MethodPost, "/admin/import", `{"key":"a"}`),
+			req(http.MethodPost, "/admin/flush", ""),
+		} {
+			res = newNotifyingRecorder()
+			r.ServeHTTP(res, rq)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			var forwarded *http.Request
+			Expect(received).To(Receive(&forwarded))
+			Expect(forwarded.Method).To(Equal(rq.Method))
+			Expect(forwarded.URL.RequestURI()).To(Equal(rq.URL.RequestURI()))
+			Expect(forwarded.Header.Get("Authorization")).To(Equal(rq.Header.Get("Authorization")))
+			Expect(forwarded.Header.Get("X-Yamc-Forwarded")).To(Equal("true"))
+		}
+		By("forwarding body")
+		var forwarded *http.Request
+		r.ServeHTTP(newNotifyingRecorder(), req(http.MethodPut, "/key?key=a&ttl=10s", "v"))
+		Expect(received).To(Receive(&forwarded))
+		Expect(ioutil.ReadAll(forwarded.Body)).To(Equal([]byte("v")))
+	})
+	Specify("unknown leader error", func() {
+		l.url = ""
+		r.ServeHTTP(res, req(http.MethodPut, "/key?key=a&ttl=10s", "v"))
+		s.expectNoCalls()
+		Expect(res.Code).To(Equal(http.StatusServiceUnavailable))
+	})
+	Specify("already forwarded request error", func() {
+		rq := req(http.MethodPut, "/key?key=a&ttl=10s", "v")
+		rq.Header.Set("X-Yamc-Forwarded", "true")
+		r.ServeHTTP(res, rq)
+		s.expectNoCalls()
+		Expect(res.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(received).ToNot(Receive())
+	})
+	Specify("unavailable leader error", func() {
+		leader.Close()
+		r.ServeHTTP(res, req(http.MethodPut, "/key?key=a&ttl=10s", "v"))
+		s.expectNoCalls()
+		Expect(res.Code).To(Equal(http.StatusBadGateway))
+	})
+	Specify("authorization error", func() {
+		rq := req(http.MethodPut, "/key?key=a&ttl=10s", "v")
+		rq.Header.Del("Authorization")
+		r.ServeHTTP(res, rq)
+		Expect(res.Code).To(Equal(http.StatusUnauthorized))
+		Expect(received).ToNot(Receive())
+	})
+})
+
+var _ = Describe("ClusterRouter", func() {
+	var (
+		s   *testStore
+		n   *cluster.Node
+		r   *gin.Engine
+		res *httptest.ResponseRecorder
+	)
+	req := func(method string, uri string, body string) *http.Request {
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("test:test")))
+		return req
+	}
+	// keyOf returns key owned by node http://a or http://b
+	keyOf := func(ownedByA bool) string {
+		for i := 0; ; i++ {
+			key := strings.Repeat("k", i)
+			if (cluster.Slot(key) < cluster.SlotCount/2) == ownedByA {
+				return key
+			}
+		}
+	}
+	BeforeEach(func() {
+		var err error
+		s = &testStore{}
+		n, err = cluster.NewNode("http://a", []string{"http://a", "http://b"}, s, func(string) (cluster.Peer, error) {
+			return nil, errors.New("no peers")
+		})
+		Expect(err).ToNot(HaveOccurred())
+		r = NewClusterRouter(auth.NewAuthenticator(auth.Accounts{"test": {Password: "test", Role: auth.Admin}}), s, n)
+		res = httptest.NewRecorder()
+	})
+	Describe("redirect", func() {
+		Specify("no key query param error", func() {
+			r.ServeHTTP(res, req(http.MethodGet, "/key", ""))
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusBadRequest))
+		})
+		Specify("owned key", func() {
+			s.value = "v"
+			key := keyOf(true)
+			r.ServeHTTP(res, req(http.MethodGet, "/key?key="+key, ""))
+			s.expectGet(key)
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(Equal("v"))
+		})
+		Specify("key of other node", func() {
+			key := keyOf(false)
+			for _, uri := range []string{"/key?key=" + key, "/list?key=" + key + "&index=0", "/dict?key=" + key} {
+				for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+					res = httptest.NewRecorder()
+					r.ServeHTTP(res, req(method, uri, "v"))
+					s.expectNoCalls()
+					Expect(res.Code).To(Equal(http.StatusTemporaryRedirect))
+					Expect(res.Header().Get("Location")).To(Equal("http://b" + uri))
+					Expect(res.Header().Get("X-Yamc-Slot")).To(Equal(strconv.Itoa(cluster.Slot(key))))
+				}
+			}
+		})
+		Specify("authorization error", func() {
+			rq := req(http.MethodGet, "/key?key="+keyOf(false), "")
+			rq.Header.Del("Authorization")
+			r.ServeHTTP(res, rq)
+			Expect(res.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+	Specify("not clustered router has no cluster routes", func() {
+		r = NewRouter(auth.NewAuthenticator(auth.Accounts{"test": {Password: "test", Role: auth.Admin}}), s)
+		r.ServeHTTP(res, req(http.MethodGet, "/cluster/slots", ""))
+		Expect(res.Code).To(Equal(http.StatusNotFound))
+	})
+	Specify("getClusterSlots", func() {
+		r.ServeHTTP(res, req(http.MethodGet, "/cluster/slots", ""))
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(Equal(`epoch: 0
+ranges:
+- from: 0
+  to: 8191
+  node: http://a
+- from: 8192
+  to: 16383
+  node: http://b
+`))
+	})
+	Describe("putClusterSlots", func() {
+		Specify("invalid yaml error", func() {
+			r.ServeHTTP(res, req(http.MethodPut, "/admin/cluster/slots", "{"))
+			Expect(res.Code).To(Equal(http.StatusBadRequest))
+		})
+		Specify("invalid slot map error", func() {
+			r.ServeHTTP(res, req(http.MethodPut, "/admin/cluster/slots", "epoch: 1"))
+			Expect(res.Code).To(Equal(http.StatusBadRequest))
+		})
+		Specify("stale slot map error", func() {
+			r.ServeHTTP(res, req(http.MethodPut, "/admin/cluster/slots", `epoch: 0
+ranges:
+- {from: 0, to: 16383, node: http://b}
+`))
+			Expect(res.Code).To(Equal(http.StatusConflict))
+		})
+		Specify("migration in progress error", func() {
+			Expect(n.UpdateSlots(n.Slots().Migrating(cluster.SlotMigration{From: 0, To: 10, Source: "http://a",
+				Target: "http://b"}))).To(Succeed())
+			r.ServeHTTP(res, req(http.MethodPut, "/admin/cluster/slots", `epoch: 2
+ranges:
+- {from: 0, to: 16383, node: http://b}
+migration: {from: 0, to: 10, source: http://b, target: http://a}
+`))
+			Expect(res.Code).To(Equal(http.StatusConflict))
+		})
+		Specify("success", func() {
+			r.ServeHTTP(res, req(http.MethodPut, "/admin/cluster/slots", `epoch: 1
+ranges:
+- {from: 0, to: 16383, node: http://b}
+`))
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(n.Slots()).To(Equal(cluster.SlotMap{Epoch: 1, Ranges: []cluster.SlotRange{
+				{From: 0, To: 16383, Node: "http://b"},
+			}}))
+		})
+	})
+	Describe("postClusterMigrate", func() {
+		Specify("no slots query param error", func() {
+			r.ServeHTTP(res, req(http.MethodPost, "/admin/cluster/migrate?node=http://b", ""))
+			Expect(res.Code).To(Equal(http.StatusBadRequest))
+		})
+		Specify("invalid slots error", func() {
+			r.ServeHTTP(res, req(http.MethodPost, "/admin/cluster/migrate?slots=a&node=http://b", ""))
+			Expect(res.Code).To(Equal(http.StatusBadRequest))
+		})
+		Specify("no node query param error", func() {
+			r.ServeHTTP(res, req(http.MethodPost, "/admin/cluster/migrate?slots=0-10", ""))
+			Expect(res.Code).To(Equal(http.StatusBadRequest))
+		})
+		Specify("invalid target error", func() {
+			r.ServeHTTP(res, req(http.MethodPost, "/admin/cluster/migrate?slots=0-10&node=http://c", ""))
+			Expect(res.Code).To(Equal(http.StatusBadRequest))
+		})
+		Specify("not owned slots error", func() {
+			r.ServeHTTP(res, req(http.MethodPost, "/admin/cluster/migrate?slots=8192&node=http://b", ""))
+			Expect(res.Code).To(Equal(http.StatusConflict))
+		})
+		Specify("migration error", func() {
+			r.ServeHTTP(res, req(http.MethodPost, "/admin/cluster/migrate?slots=0-10&node=http://b", ""))
+			s.expectNoCalls()
+			Expect(res.Code).To(Equal(http.StatusInternalServerError))
+		})
+	})
+})
+
+// notifyingRecorder is a response recorder implementing http.CloseNotifier required by gin's proxied writer
+type notifyingRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func newNotifyingRecorder() *notifyingRecorder {
+	return &notifyingRecorder{httptest.NewRecorder()}
+}
+
+func (r *notifyingRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+// leaderStore is a consensus replicated test store
+type leaderStore struct {
+	*testStore
+	url      string
+	isLeader bool
+}
+
+func (s *leaderStore) Leader() (string, bool) {
+	return s.url, s.isLeader
+}
+
+type testStore struct {
+	calls      []call
+	value      string
+	list       []string
+	dict       map[string]string
+	keys       []string
+	count      int
+	dumpStatus store.DumpStatus
+	info       store.Info
+	usage      store.Usage
+	metrics    store.Metrics
+	error      error
+	owner      string
+	quota      store.Quota
+}
+
+// expectProblem expects JSON error response body with code
+func expectProblem(res *httptest.ResponseRecorder, code int) {
+	ExpectWithOffset(1, res.Header().Get("Content-Type")).To(HavePrefix("application/json"))
+	var p problem
+	ExpectWithOffset(1, json.Unmarshal(res.Body.Bytes(), &p)).To(Succeed())
+	ExpectWithOffset(1, p.Code).To(Equal(code))
+}
+
+func (s *testStore) newCall(f interface{}, args ...interface{}) {
+	s.calls = append(s.calls, call{
+		method: funcToName(f),
+		args:   args,
+	})
+}
+
+func (s *testStore) popCall() call {
+	if len(s.calls) == 0 {
+		panic("no calls are left")
+	}
+	var c call
+	c, s.calls = s.calls[0], s.calls[1:]
+	return c
+}
+
+func (s *testStore) expectNoCalls() {
+	ExpectWithOffset(1, s.calls).To(BeEmpty())
+}
+
+func (s *testStore) Get(key string) (string, error) {
+	s.newCall(s.Get, key)
+	return s.value, s.error
+}
+
+func (s *testStore) expectGet(key string) {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.Get, key))
+}
+
+func (s *testStore) Set(key string, value string, ttl time.Duration) error {
+	s.newCall(s.Set, key, value, ttl)
+	return s.error
+}
+
+func (s *testStore) expectSet(key string, value string, ttl time.Duration) {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.Set, key, value, ttl))
+}
+
+func (s *testStore) ListGet(key string, index int) (string, error) {
+	s.newCall(s.ListGet, key, index)
+	return s.value, s.error
+}
+
+func (s *testStore) expectListGet(key string, index int) {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.ListGet, key, index))
+}
+
+func (s *testStore) ListSet(key string, list []string, ttl time.Duration) error {
+	s.newCall(s.ListSet, key, list, ttl)
+	return s.error
+}
+
+func (s *testStore) expectListSet(key string, list []string, ttl time.Duration) {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.ListSet, key, list, ttl))
+}
+
+func (s *testStore) DictGet(key string, dkey string) (string, error) {
+	s.newCall(s.DictGet, key, dkey)
+	return s.value, s.error
+}
+
+func (s *testStore) expectDictGet(key string, dkey string) {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.DictGet, key, dkey))
+}
+
+func (s *testStore) DictSet(key string, dict map[string]string, ttl time.Duration) error {
+	s.newCall(s.DictSet, key, dict, ttl)
+	return s.error
+}
+
+func (s *testStore) expectDictSet(key string, dict map[string]string, ttl time.Duration) {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.DictSet, key, dict, ttl))
+}
+
+func (s *testStore) GetVersion(key string) (string, uint64, error) {
+	s.newCall(s.GetVersion, key)
+	return s.value, 0, s.error
+}
+
+func (s *testStore) Add(key string, value string, ttl time.Duration) error {
+	s.newCall(s.Add, key, value, ttl)
+	return s.error
+}
+
+func (s *testStore) Replace(key string, value string, ttl time.Duration) error {
+	s.newCall(s.Replace, key, value, ttl)
+	return s.error
+}
+
+func (s *testStore) Append(key string, value string) error {
+	s.newCall(s.Append, key, value)
+	return s.error
+}
+
+func (s *testStore) Prepend(key string, value string) error {
+	s.newCall(s.Prepend, key, value)
+	return s.error
+}
+
+func (s *testStore) CompareAndSet(key string, value string, ttl time.Duration, version uint64) error {
+	s.newCall(s.CompareAndSet, key, value, ttl, version)
+	return s.error
+}
+
+func (s *testStore) Incr(key string, delta uint64) (uint64, error) {
+	s.newCall(s.Incr, key, delta)
+	return 0, s.error
+}
+
+func (s *testStore) Decr(key string, delta uint64) (uint64, error) {
+	s.newCall(s.Decr, key, delta)
+	return 0, s.error
+}
+
+func (s *testStore) ListGetAll(key string) ([]string, error) {
+	s.newCall(s.ListGetAll, key)
+	return s.list, s.error
+}
+
+func (s *testStore) expectListGetAll(key string) {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.ListGetAll, key))
+}
+
+func (s *testStore) ListPush(key string, values []string, ttl time.Duration) (int, error) {
+	s.newCall(s.ListPush, key, values, ttl)
+	return 0, s.error
+}
+
+func (s *testStore) DictGetAll(key string) (map[string]string, error) {
+	s.newCall(s.DictGetAll, key)
+	return s.dict, s.error
+}
+
+func (s *testStore) expectDictGetAll(key string) {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.DictGetAll, key))
+}
+
+func (s *testStore) DictUpdate(key string, dict map[string]string, ttl time.Duration) (int, error) {
+	s.newCall(s.DictUpdate, key, dict, ttl)
+	return 0, s.error
+}
+
+func (s *testStore) TTL(key string) (time.Duration, error) {
+	s.newCall(s.TTL, key)
+	return 0, s.error
+}
+
+func (s *testStore) Expire(key string, ttl time.Duration) error {
+	s.newCall(s.Expire, key, ttl)
+	return s.error
+}
+
+func (s *testStore) Remove(key string) error {
+	s.newCall(s.Remove, key)
+	return s.error
+}
+
+func (s *testStore) KeyRemove(key string) error {
+	s.newCall(s.KeyRemove, key)
+	return s.error
+}
+
+func (s *testStore) expectKeyRemove(key string) {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.KeyRemove, key))
+}
+
+func (s *testStore) ListRemove(key string) error {
+	s.newCall(s.ListRemove, key)
+	return s.error
+}
+
+func (s *testStore) expectListRemove(key string) {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.ListRemove, key))
+}
+
+func (s *testStore) DictRemove(key string) error {
+	s.newCall(s.DictRemove, key)
+	return s.error
+}
+
+func (s *testStore) expectDictRemove(key string) {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.DictRemove, key))
+}
+
+func (s *testStore) Keys() ([]string, error) {
+	s.newCall(s.Keys)
+	return s.keys, s.error
 }
 
-func (s *testStore) popCall() call {
-	if len(s.calls) == 0 {
-		panic("no calls are left")
-	}
-	var c call
-	c, s.calls = s.calls[0], s.calls[1:]
-	return c
-}
-
-func (s *testStore) expectNoCalls() {
-	ExpectWithOffset(1, s.calls).To(BeEmpty())
-}
-
-func (s *testStore) Get(key string) (string, error) {
-	s.newCall(s.Get, key)
-	return s.value, s.error
-}
-
-func (s *testStore) expectGet(key string) {
-	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
-	ExpectWithOffset(1, s.popCall()).To(beCall(s.Get, key))
-}
-
-func (s *testStore) Set(key string, value string, ttl time.Duration) {
-	s.newCall(s.Set, key, value, ttl)
-}
-
-func (s *testStore) expectSet(key string, value string, ttl time.Duration) {
-	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
-	ExpectWithOffset(1, s.popCall()).To(beCall(s.Set, key, value, ttl))
-}
-
-func (s *testStore) ListGet(key string, index int) (string, error) {
-	s.newCall(s.ListGet, key, index)
-	return s.value, s.error
-}
-
-func (s *testStore) expectListGet(key string, index int) {
-	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
-	ExpectWithOffset(1, s.popCall()).To(beCall(s.ListGet, key, index))
-}
-
-func (s *testStore) ListSet(key string, list []string, ttl time.Duration) {
-	s.newCall(s.ListSet, key, list, ttl)
-}
-
-func (s *testStore) expectListSet(key string, list []string, ttl time.Duration) {
-	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
-	ExpectWithOffset(1, s.popCall()).To(beCall(s.ListSet, key, list, ttl))
-}
-
-func (s *testStore) DictGet(key string, dkey string) (string, error) {
-	s.newCall(s.DictGet, key, dkey)
-	return s.value, s.error
-}
-
-func (s *testStore) expectDictGet(key string, dkey string) {
-	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
-	ExpectWithOffset(1, s.popCall()).To(beCall(s.DictGet, key, dkey))
-}
-
-func (s *testStore) DictSet(key string, dict map[string]string, ttl time.Duration) {
-	s.newCall(s.DictSet, key, dict, ttl)
-}
-
-func (s *testStore) expectDictSet(key string, dict map[string]string, ttl time.Duration) {
-	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
-	ExpectWithOffset(1, s.popCall()).To(beCall(s.DictSet, key, dict, ttl))
-}
-
-func (s *testStore) Remove(key string) error {
-	s.newCall(s.Remove, key)
-	return s.error
-}
-
-func (s *testStore) expectRemove(key string) {
-	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
-	ExpectWithOffset(1, s.popCall()).To(beCall(s.Remove, key))
-}
-
-func (s *testStore) Keys() []string {
-	s.newCall(s.Keys)
-	return s.keys
-}
-
 func (s *testStore) expectKeys() {
 	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
 	ExpectWithOffset(1, s.popCall()).To(beCall(s.Keys))
 }
 
+func (s *testStore) Export(w io.Writer) error {
+	s.newCall(s.Export)
+	if s.value != "" {
+		io.WriteString(w, s.value)
+	}
+	return s.error
+}
+
+func (s *testStore) expectExport() {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.Export))
+}
+
+func (s *testStore) Import(r io.Reader) (int, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	s.newCall(s.Import, string(data))
+	return s.count, s.error
+}
+
+func (s *testStore) expectImport(data string) {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.Import, data))
+}
+
+func (s *testStore) Flush() error {
+	s.newCall(s.Flush)
+	return s.error
+}
+
+func (s *testStore) expectFlush() {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.Flush))
+}
+
+func (s *testStore) Dump() (store.DumpStatus, error) {
+	s.newCall(s.Dump)
+	return s.dumpStatus, s.error
+}
+
+func (s *testStore) expectDump() {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.Dump))
+}
+
+func (s *testStore) DumpStatus() store.DumpStatus {
+	s.newCall(s.DumpStatus)
+	return s.dumpStatus
+}
+
+func (s *testStore) OnDumpError(hook func(err error)) {
+	s.newCall(s.OnDumpError)
+}
+
+func (s *testStore) Info() store.Info {
+	s.newCall(s.Info)
+	return s.info
+}
+
+func (s *testStore) expectInfo() {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.Info))
+}
+
+func (s *testStore) Usage() store.Usage {
+	s.newCall(s.Usage)
+	return s.usage
+}
+
+func (s *testStore) Metrics() store.Metrics {
+	s.newCall(s.Metrics)
+	return s.metrics
+}
+
+func (s *testStore) expectMetrics() {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.Metrics))
+}
+
+func (s *testStore) UsageOf(login string) store.Usage {
+	s.newCall(s.UsageOf, login)
+	return s.usage
+}
+
+func (s *testStore) expectUsageOf(login string) {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.UsageOf, login))
+}
+
+// As records account's login and quota, calls are recorded by store itself
+func (s *testStore) As(login string, q store.Quota) store.Store {
+	s.owner, s.quota = login, q
+	return s
+}
+
 func (s *testStore) StartCleaning() error {
 	s.newCall(s.StartCleaning)
 	return s.error
@@ -794,6 +2397,66 @@ func (s *testStore) StopDumping() error {
 	return s.error
 }
 
+func (s *testStore) Snapshot(w io.Writer) error {
+	s.newCall(s.Snapshot)
+	if s.value != "" {
+		io.WriteString(w, s.value)
+	}
+	return s.error
+}
+
+func (s *testStore) expectSnapshot() {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.Snapshot))
+}
+
+func (s *testStore) Replicate(_ context.Context, w io.Writer, from store.ReplicationPosition) error {
+	s.newCall(s.Replicate, from)
+	if s.value != "" {
+		io.WriteString(w, s.value)
+	}
+	return s.error
+}
+
+func (s *testStore) expectReplicate(from store.ReplicationPosition) {
+	ExpectWithOffset(1, s.calls).ToNot(BeEmpty())
+	ExpectWithOffset(1, s.popCall()).To(beCall(s.Replicate, from))
+}
+
+func (s *testStore) Restore(r io.Reader) error {
+	s.newCall(s.Restore)
+	return s.error
+}
+
+func (s *testStore) Apply(r io.Reader) error {
+	s.newCall(s.Apply)
+	return s.error
+}
+
+func (s *testStore) ReplicationStatus() store.ReplicationStatus {
+	s.newCall(s.ReplicationStatus)
+	return s.info.Replication
+}
+
+func (s *testStore) Checkpoint() (store.Checkpoint, error) {
+	s.newCall(s.Checkpoint)
+	return store.Checkpoint{}, s.error
+}
+
+func (s *testStore) Load(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		panic(err)
+	}
+	s.newCall(s.Load, string(data))
+	return s.error
+}
+
+func (s *testStore) Close(dump bool) error {
+	s.newCall(s.Close, dump)
+	return s.error
+}
+
 type call struct {
 	method string
 	args   []interface{}
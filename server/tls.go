@@ -0,0 +1,72 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"sync/atomic"
+)
+
+// TLS is a server TLS config of certificate and key files and optional client certificates CA file. Files are
+// reloaded by Reload, so certificates may be renewed without restart. Safe for concurrent use
+type TLS struct {
+	certPath     string
+	keyPath      string
+	clientCAPath string
+	config       atomic.Value // *tls.Config
+}
+
+// NewTLS constructs TLS config loading certificate of certPath and key of keyPath. If clientCAPath is not empty,
+// clients are required to present certificate signed by CA of clientCAPath
+func NewTLS(certPath string, keyPath string, clientCAPath string) (*TLS, error) {
+	t := &TLS{certPath: certPath, keyPath: keyPath, clientCAPath: clientCAPath}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload reloads certificate, key and client certificates CA files. Connections accepted before keep previous
+// config, previous config is kept if loading fails
+func (t *TLS) Reload() error {
+	cert, err := tls.LoadX509KeyPair(t.certPath, t.keyPath)
+	if err != nil {
+		return errors.New("failed to load TLS certificate: " + err.Error())
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if t.clientCAPath != "" {
+		if config.ClientCAs, err = LoadCertPool(t.clientCAPath); err != nil {
+			return err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	t.config.Store(config)
+	return nil
+}
+
+// Config returns TLS config serving current certificates
+func (t *TLS) Config() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return t.config.Load().(*tls.Config), nil
+		},
+	}
+}
+
+// LoadCertPool reads PEM encoded certificates file of path into certificates pool
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("failed to read CA file: " + err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("failed to parse CA file: no PEM encoded certificates")
+	}
+	return pool, nil
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/someanon/yamc/auth"
+)
+
+// testCertificate is a locally generated certificate with its key
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCertificate generates certificate of common name signed by parent, self-signed CA if parent is nil
+func newTestCertificate(commonName string, parent *testCertificate) testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer := testCertificate{cert: template, key: key}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer = *parent
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.cert, &key.PublicKey, signer.key)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+	return testCertificate{cert: cert, key: key}
+}
+
+// write writes PEM encoded certificate to certPath and key to keyPath
+func (c testCertificate) write(certPath string, keyPath string) {
+	Expect(ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}),
+		0600)).To(Succeed())
+	der, err := x509.MarshalECPrivateKey(c.key)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}),
+		0600)).To(Succeed())
+}
+
+// tlsCertificate returns certificate as tls.Certificate
+func (c testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+var _ = Describe("TLS", func() {
+	var (
+		dir string
+		ca  testCertificate
+		s   *testStore
+		ts  *httptest.Server
+	)
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "yamc-tls")
+		Expect(err).ToNot(HaveOccurred())
+		ca = newTestCertificate("ca", nil)
+		ca.write(path("ca.pem"), path("ca.key"))
+		newTestCertificate("server", &ca).write(path("cert.pem"), path("key.pem"))
+		s = &testStore{}
+	})
+	AfterEach(func() {
+		if ts != nil {
+			ts.Close()
+			ts = nil
+		}
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+	// serve starts HTTPS server of router with TLS config t
+	serve := func(t *TLS) {
+		ts = httptest.NewUnstartedServer(NewRouter(auth.NewAuthenticator(auth.Accounts{
+			"test": {Password: "test", Role: auth.Admin},
+			"app":  {Password: "app", Role: auth.ReadOnly},
+		}), s))
+		ts.TLS = t.Config()
+		ts.StartTLS()
+	}
+	// get requests key a by HTTP client presenting certs with Basic authorization if login is not empty
+	get := func(login string, certs ...tls.Certificate) (*http.Response, error) {
+		pool, err := LoadCertPool(path("ca.pem"))
+		Expect(err).ToNot(HaveOccurred())
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: certs}}}
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/key?key=a", nil)
+		Expect(err).ToNot(HaveOccurred())
+		if login != "" {
+			req.SetBasicAuth(login, login)
+		}
+		res, err := c.Do(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		return res, err
+	}
+	Specify("new TLS errors", func() {
+		_, err := NewTLS(path("absent.pem"), path("key.pem"), "")
+		Expect(err).To(MatchError(HavePrefix("failed to load TLS certificate: ")))
+		_, err = NewTLS(path("cert.pem"), path("key.pem"), path("absent.pem"))
+		Expect(err).To(MatchError(HavePrefix("failed to read CA file: ")))
+		_, err = NewTLS(path("cert.pem"), path("key.pem"), path("key.pem"))
+		Expect(err).To(MatchError("failed to parse CA file: no PEM encoded certificates"))
+	})
+	Specify("serve HTTPS", func() {
+		t, err := NewTLS(path("cert.pem"), path("key.pem"), "")
+		Expect(err).ToNot(HaveOccurred())
+		serve(t)
+		res, err := get("test")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		s.expectGet("a")
+		res, err = get("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		s.expectNoCalls()
+	})
+	Specify("client certificates", func() {
+		t, err := NewTLS(path("cert.pem"), path("key.pem"), path("ca.pem"))
+		Expect(err).ToNot(HaveOccurred())
+		serve(t)
+
+		By("certificate required")
+		_, err = get("test")
+		Expect(err).To(HaveOccurred())
+
+		By("certificate of other CA")
+		_, err = get("test", newTestCertificate("app", nil).tlsCertificate())
+		Expect(err).To(HaveOccurred())
+
+		By("authenticated by certificate")
+		res, err := get("", newTestCertificate("app", &ca).tlsCertificate())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		s.expectGet("a")
+
+		By("certificate without account")
+		res, err = get("", newTestCertificate("unknown", &ca).tlsCertificate())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		s.expectNoCalls()
+
+		By("authorization header takes precedence")
+		res, err = get("test", newTestCertificate("unknown", &ca).tlsCertificate())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		s.expectGet("a")
+	})
+	Specify("reload", func() {
+		t, err := NewTLS(path("cert.pem"), path("key.pem"), "")
+		Expect(err).ToNot(HaveOccurred())
+		serve(t)
+		// serial returns serial number of certificate served to new connection
+		serial := func() *big.Int {
+			pool, err := LoadCertPool(path("ca.pem"))
+			Expect(err).ToNot(HaveOccurred())
+			conn, err := tls.Dial("tcp", ts.Listener.Addr().String(), &tls.Config{RootCAs: pool})
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			return conn.ConnectionState().PeerCertificates[0].SerialNumber
+		}
+		renewed := newTestCertificate("server", &ca)
+		renewed.write(path("cert.pem"), path("key.pem"))
+		Expect(serial()).ToNot(Equal(renewed.cert.SerialNumber))
+		Expect(t.Reload()).To(Succeed())
+		Expect(serial()).To(Equal(renewed.cert.SerialNumber))
+
+		By("previous config is kept on failure")
+		Expect(os.Remove(path("key.pem"))).To(Succeed())
+		Expect(t.Reload()).ToNot(Succeed())
+		Expect(serial()).To(Equal(renewed.cert.SerialNumber))
+	})
+})
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/someanon/yamc/auth"
+)
+
+// issuedToken is an issued token with its secret
+type issuedToken struct {
+	auth.Token `yaml:",inline" msgpack:",inline"`
+	Secret     string `yaml:"secret" json:"secret" msgpack:"secret"`
+}
+
+// postToken handles POST /admin/tokens request. Required params: scope, comma separated token scopes. Optional
+// params: login of token's account, requesting account if not set, ttl of token, never expiring if not set. Returns
+// issued token with its secret encoded according Accept header
+func (s *server) postToken(c *gin.Context) {
+	scopeStr, exists := c.GetQuery("scope")
+	if !exists {
+		abort(c, http.StatusBadRequest, errScopeRequired)
+		return
+	}
+	var scopes []auth.Scope
+	for _, scope := range strings.Split(scopeStr, ",") {
+		if err := auth.Scope(scope).Validate(); err != nil {
+			abort(c, http.StatusBadRequest, errInvalidScope.causedBy(err))
+			return
+		}
+		scopes = append(scopes, auth.Scope(scope))
+	}
+	var ttl time.Duration
+	if ttlStr, exists := c.GetQuery("ttl"); exists {
+		var err error
+		if ttl, err = time.ParseDuration(ttlStr); err != nil || ttl < 0 {
+			abort(c, http.StatusBadRequest, errInvalidTTL.detailed(`"`+ttlStr+`" must be not negative duration`))
+			return
+		}
+	}
+	login := c.DefaultQuery("login", c.GetString(gin.AuthUserKey))
+	if _, exists := s.accounts.Accounts()[login]; !exists {
+		abort(c, http.StatusNotFound, errAccountNotExists.detailed(`"`+login+`"`))
+		return
+	}
+	if !s.managesTokens(c, login) {
+		abort(c, http.StatusForbidden, errForbidden.detailed(`tokens of "`+login+`" are not allowed`))
+		return
+	}
+	t, secret, err := s.accounts.Tokens().Issue(login, scopes, ttl)
+	if err != nil {
+		abort(c, http.StatusInternalServerError, errTokensError.causedBy(err))
+		return
+	}
+	render(c, http.StatusOK, issuedToken{Token: t, Secret: secret})
+}
+
+// getTokens handles GET /admin/tokens request. Returns not expired tokens of accounts allowed to requesting account
+// without secrets encoded according Accept header
+func (s *server) getTokens(c *gin.Context) {
+	tokens := []auth.Token{}
+	for _, t := range s.accounts.Tokens().List() {
+		if s.managesTokens(c, t.Login) {
+			tokens = append(tokens, t)
+		}
+	}
+	render(c, http.StatusOK, tokens)
+}
+
+// deleteToken handles DELETE /admin/tokens request. Required params: id of token. Revokes token
+func (s *server) deleteToken(c *gin.Context) {
+	id, exists := c.GetQuery("id")
+	if !exists {
+		abort(c, http.StatusBadRequest, errIDRequired)
+		return
+	}
+	t, err := s.accounts.Tokens().Get(id)
+	if err != nil || !s.managesTokens(c, t.Login) {
+		abort(c, http.StatusNotFound, errTokenNotExists)
+		return
+	}
+	if err := s.accounts.Tokens().Revoke(id); err != nil {
+		if err == auth.ErrTokenNotExists {
+			abort(c, http.StatusNotFound, errTokenNotExists)
+			return
+		}
+		abort(c, http.StatusInternalServerError, errTokensError.causedBy(err))
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// managesTokens reports whether requesting account may manage tokens of login, which is if it may access namespace
+// of login's account. Tokens of removed accounts are managed by accounts of default namespace
+func (s *server) managesTokens(c *gin.Context, login string) bool {
+	namespace := ""
+	if a, exists := s.accounts.Accounts()[login]; exists {
+		namespace = a.Namespace
+	}
+	return c.MustGet(accountKey).(auth.Account).AllowsNamespace(namespace)
+}
@@ -3,6 +3,8 @@ package store
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"strconv"
 	"testing"
 	"time"
@@ -12,7 +14,7 @@ func initBenchmarkStore(size int) (*store, error) {
 	if size < 1 {
 		return nil, errors.New("expected size > 0")
 	}
-	s, err := NewStore(Params{CleaningPeriod: 60 * time.Second}, testClock{}, &testDumper{})
+	s, err := NewStore(Params{CleaningPeriod: 60 * time.Second, DumpingPeriod: 60 * time.Second}, testClock{}, &testDumper{})
 	if err != nil {
 		return nil, err
 	}
@@ -211,8 +213,10 @@ func BenchmarkStore_Remove(b *testing.B) {
 	}
 }
 
+// keys is BenchmarkStore_Keys result sink
+var keys []string
+
 func BenchmarkStore_Keys(b *testing.B) {
-	var keys []string
 	for _, size := range []int{
 		1,
 		1000,
@@ -227,8 +231,94 @@ func BenchmarkStore_Keys(b *testing.B) {
 			}
 			b.ResetTimer()
 			for i := 0; i < b.N; i++ {
-				keys = s.Keys()
+				keys, _ = s.Keys()
 			}
 		})
 	}
 }
+
+func BenchmarkFileDumper_dump(b *testing.B) {
+	for _, f := range []Format{GobFormat, JSONFormat} {
+		for _, c := range []Compression{NoCompression, GzipCompression, ZstdCompression} {
+			for _, size := range []int{
+				1,
+				1000,
+				10000,
+				100000,
+			} {
+				b.Run(fmt.Sprintf("in %s format with %s compression and store size %d", f, c, size), func(b *testing.B) {
+					s, err := initBenchmarkStore(size)
+					if err != nil {
+						b.Fatal("failed to init store: " + err.Error())
+						b.FailNow()
+					}
+					d, err := tempFileDumper(f, c)
+					if err != nil {
+						b.Fatal("failed to init dumper: " + err.Error())
+						b.FailNow()
+					}
+					defer os.Remove(d.path)
+					b.ReportAllocs()
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						if err := d.dump(s.items); err != nil {
+							b.Fatal("unexpected error: " + err.Error())
+							b.Fail()
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func BenchmarkFileDumper_load(b *testing.B) {
+	for _, f := range []Format{GobFormat, JSONFormat} {
+		for _, c := range []Compression{NoCompression, GzipCompression, ZstdCompression} {
+			for _, size := range []int{
+				1,
+				1000,
+				10000,
+				100000,
+			} {
+				b.Run(fmt.Sprintf("in %s format with %s compression and store size %d", f, c, size), func(b *testing.B) {
+					s, err := initBenchmarkStore(size)
+					if err != nil {
+						b.Fatal("failed to init store: " + err.Error())
+						b.FailNow()
+					}
+					d, err := tempFileDumper(f, c)
+					if err != nil {
+						b.Fatal("failed to init dumper: " + err.Error())
+						b.FailNow()
+					}
+					defer os.Remove(d.path)
+					if err := d.dump(s.items); err != nil {
+						b.Fatal("failed to dump store: " + err.Error())
+						b.FailNow()
+					}
+					b.ReportAllocs()
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						if _, err := d.load(); err != nil {
+							b.Fatal("unexpected error: " + err.Error())
+							b.Fail()
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// tempFileDumper creates file dumper with new temporary file, format f and compression c
+func tempFileDumper(f Format, c Compression) (FileDumper, error) {
+	file, err := ioutil.TempFile("", "file-dump-")
+	if err != nil {
+		return FileDumper{}, err
+	}
+	if err := file.Close(); err != nil {
+		return FileDumper{}, err
+	}
+	return NewFileDumper(file.Name(), f, c)
+}
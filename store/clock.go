@@ -14,3 +14,11 @@ type SystemClock struct{}
 func (_ SystemClock) now() time.Time {
 	return time.Now()
 }
+
+// fixedClock is clock providing same time
+type fixedClock time.Time
+
+// now returns clock's time
+func (c fixedClock) now() time.Time {
+	return time.Time(c)
+}
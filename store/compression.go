@@ -0,0 +1,84 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is a dump file compression algorithm
+type Compression string
+
+const (
+	NoCompression   Compression = "none"
+	GzipCompression Compression = "gzip"
+	ZstdCompression Compression = "zstd"
+)
+
+var (
+	// compressed streams magic numbers
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Validate validates compression algorithm
+func (c Compression) Validate() error {
+	switch c {
+	case NoCompression, GzipCompression, ZstdCompression:
+		return nil
+	}
+	return fmt.Errorf(`unknown compression "%s", must be one of: none, gzip, zstd`, c)
+}
+
+// writer wraps w with compressing writer. Returned writer must be closed to flush compressed data
+func (c Compression) writer(w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case NoCompression:
+		return nopWriteCloser{w}, nil
+	case GzipCompression:
+		return gzip.NewWriter(w), nil
+	case ZstdCompression:
+		return zstd.NewWriter(w)
+	}
+	return nil, c.Validate()
+}
+
+// decompressingReader detects compression of r by magic number and wraps r with decompressing reader. Returned
+// function releases decompressing reader resources
+func decompressingReader(r *bufio.Reader) (io.Reader, func(), error) {
+	if hasMagic(r, gzipMagic) {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gr, func() { gr.Close() }, nil
+	}
+	if hasMagic(r, zstdMagic) {
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	}
+	return r, func() {}, nil
+}
+
+// hasMagic determines if r starts with magic number
+func hasMagic(r *bufio.Reader, magic []byte) bool {
+	head, err := r.Peek(len(magic))
+	return err == nil && bytes.Equal(head, magic)
+}
+
+// nopWriteCloser is io.Writer with no-op Close method
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -1,55 +1,94 @@
 package store
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/gob"
 	"errors"
-	"fmt"
+	"io"
 	"os"
 	"time"
 )
 
+// legacyTypePrefix is a gob name prefix of item types in dumps of previous versions
+const legacyTypePrefix = "github.com/someanon/yamc/store."
+
 // Dumper is a items dumper
 type Dumper interface {
 	dump(items) error
 	load() (items, error)
+	write(w io.Writer, items items) error
+	read(r io.Reader) (items, error)
 }
 
-// path is file path string
-type path string
+// FileDumper is file dumper. Items are streamed to file record by record, so dumping and loading don't need memory
+// for whole encoded store. Dump is written to temporary file which replaces dump file only when dump succeeds, so
+// failed dump doesn't corrupt previous one
+type FileDumper struct {
+	path        string
+	format      Format
+	compression Compression
+}
 
-// FileDumper is file dumper
-type FileDumper path
+// NewFileDumper constructs file dumper which dumps to file by path in format f using compression c. Returns error if
+// format or compression is unknown
+func NewFileDumper(path string, f Format, c Compression) (FileDumper, error) {
+	if err := f.Validate(); err != nil {
+		return FileDumper{}, ErrUnknownDumpFormat.detailed(err.Error())
+	}
+	if err := c.Validate(); err != nil {
+		return FileDumper{}, ErrUnknownDumpCompression.detailed(err.Error())
+	}
+	return FileDumper{path: path, format: f, compression: c}, nil
+}
 
 // dump dumps items to file
 func (fd FileDumper) dump(items items) error {
-	f, err := os.Create(string(fd))
+	tmpPath := fd.path + ".tmp"
+	f, err := os.Create(tmpPath)
 	if err != nil {
 		return ErrFailOpenDumpFile.detailed(err.Error())
 	}
-	encoder := gob.NewEncoder(f)
-	if err := encoder.Encode(items); err != nil {
+	defer os.Remove(tmpPath)
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+	if err := fd.write(bw, items); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
 		return ErrFailToDumpItems.detailed(err.Error())
 	}
 	if err := f.Close(); err != nil {
 		return ErrFailToCloseDumpFile.detailed(err.Error())
 	}
+	if err := os.Rename(tmpPath, fd.path); err != nil {
+		return ErrFailToReplaceDumpFile.detailed(err.Error())
+	}
 	return nil
 }
 
-// load loads items from file. If file not exists it returns without error
+// load loads items from file. Format and compression are detected from file content, so dump made with any format and
+// compression can be loaded. Dump of previous versions, which is gob encoded whole items map, is loaded too. If file
+// not exists it returns without error
 func (fd FileDumper) load() (items, error) {
-	items := items{}
-	if _, err := os.Stat(string(fd)); os.IsNotExist(err) {
-		return items, nil
+	if _, err := os.Stat(fd.path); os.IsNotExist(err) {
+		return items{}, nil
 	}
-	f, err := os.Open(string(fd))
+	f, err := os.Open(fd.path)
 	if err != nil {
-		return items, ErrFailOpenDumpFile.detailed(err.Error())
+		return items{}, ErrFailOpenDumpFile.detailed(err.Error())
 	}
-	decoder := gob.NewDecoder(f)
-	if err := decoder.Decode(&items); err != nil {
-		return items, ErrFailToDecodeDumpFile.detailed(err.Error())
+	defer f.Close()
+	items, err := fd.read(f)
+	if err != nil {
+		if _, serr := f.Seek(0, io.SeekStart); serr != nil {
+			return items, err
+		}
+		legacy, lerr := readLegacy(bufio.NewReader(f))
+		if lerr != nil {
+			return items, err
+		}
+		items = legacy
 	}
 	if err := f.Close(); err != nil {
 		return items, ErrFailToCloseDumpFile.detailed(err.Error())
@@ -57,99 +96,195 @@ func (fd FileDumper) load() (items, error) {
 	return items, nil
 }
 
-type gobItem struct {
+// write writes items to w in dumper's format using dumper's compression
+func (fd FileDumper) write(w io.Writer, items items) error {
+	cw, err := fd.compression.writer(w)
+	if err != nil {
+		return ErrFailToDumpItems.detailed(err.Error())
+	}
+	rw, err := fd.format.writer(cw)
+	if err != nil {
+		return ErrFailToDumpItems.detailed(err.Error())
+	}
+	for k, i := range items {
+		r, err := newRecord(k, i)
+		if err != nil {
+			return ErrFailToDumpItems.detailed(err.Error())
+		}
+		if err := rw.write(r); err != nil {
+			return ErrFailToDumpItems.detailed(err.Error())
+		}
+	}
+	if err := cw.Close(); err != nil {
+		return ErrFailToDumpItems.detailed(err.Error())
+	}
+	return nil
+}
+
+// read reads items from r. Compression and format are detected from content, so dump made with other format is read
+// too
+func (fd FileDumper) read(r io.Reader) (items, error) {
+	items := items{}
+	dr, release, err := decompressingReader(bufio.NewReader(r))
+	if err != nil {
+		return items, ErrFailToDecodeDumpFile.detailed(err.Error())
+	}
+	defer release()
+	br := bufio.NewReader(dr)
+	rr, err := detectFormat(br, fd.format).reader(br)
+	if err != nil {
+		return items, ErrFailToDecodeDumpFile.detailed(err.Error())
+	}
+	for {
+		rec, err := rr.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return items, ErrFailToDecodeDumpFile.detailed(err.Error())
+		}
+		i, err := rec.item()
+		if err != nil {
+			return items, ErrFailToDecodeDumpFile.detailed(err.Error())
+		}
+		items[rec.Key] = i
+	}
+	return items, nil
+}
+
+// StreamDumper is dumper without dump file. Items are written and read only by Checkpoint and Load, so it is used when
+// items are persisted by other means, e.g. consensus snapshots. Dumps error with ErrDumpingDisabled
+type StreamDumper struct {
+	fd FileDumper
+}
+
+// NewStreamDumper constructs stream dumper which writes items in format f using compression c. Returns error if
+// format or compression is unknown
+func NewStreamDumper(f Format, c Compression) (StreamDumper, error) {
+	fd, err := NewFileDumper("", f, c)
+	if err != nil {
+		return StreamDumper{}, err
+	}
+	return StreamDumper{fd: fd}, nil
+}
+
+// dump errors with ErrDumpingDisabled
+func (sd StreamDumper) dump(items items) error {
+	return ErrDumpingDisabled
+}
+
+// load returns no items
+func (sd StreamDumper) load() (items, error) {
+	return items{}, nil
+}
+
+// write writes items to w in dumper's format using dumper's compression
+func (sd StreamDumper) write(w io.Writer, items items) error {
+	return sd.fd.write(w, items)
+}
+
+// read reads items from r. Compression and format are detected from content
+func (sd StreamDumper) read(r io.Reader) (items, error) {
+	return sd.fd.read(r)
+}
+
+// readLegacy reads items from r in format of previous versions, which is gob encoded whole items map
+func readLegacy(r io.Reader) (items, error) {
+	var decoded map[string]interface{}
+	if err := gob.NewDecoder(r).Decode(&decoded); err != nil {
+		return nil, err
+	}
+	items := make(items, len(decoded))
+	for k, v := range decoded {
+		switch i := v.(type) {
+		case legacyKeyItem:
+			items[k] = i.keyItem
+		case legacyListItem:
+			items[k] = i.listItem
+		case legacyDictItem:
+			items[k] = i.dictItem
+		default:
+			return nil, errors.New(`unknown type of legacy item "` + k + `"`)
+		}
+	}
+	return items, nil
+}
+
+// legacyItem is gob representation of item in dump of previous versions
+type legacyItem struct {
 	Expiry int64
 	Value  interface{}
 }
 
-// MarshalBinary implements gob marshaling for keyItem
-func (ki keyItem) MarshalBinary() ([]byte, error) {
-	var out bytes.Buffer
-	enc := gob.NewEncoder(&out)
-	err := enc.Encode(gobItem{ki.expiry.UnixNano(), ki.value})
-	if err != nil {
-		return nil, fmt.Errorf(`fail to encode key item "%v": %s`, ki, err.Error())
+// decodeLegacyItem decodes gob encoded legacy item from data
+func decodeLegacyItem(data []byte) (legacyItem, error) {
+	var li legacyItem
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&li); err != nil {
+		return li, errors.New("fail to decode legacy item: " + err.Error())
 	}
-	return out.Bytes(), nil
+	return li, nil
+}
+
+// legacyKeyItem is keyItem decoded from dump of previous versions
+type legacyKeyItem struct {
+	keyItem
 }
 
-// UnmarshalBinary implements gob unmarshaling for keyItem
-func (ki *keyItem) UnmarshalBinary(data []byte) error {
-	in := bytes.NewReader(data)
-	dec := gob.NewDecoder(in)
-	var i gobItem
-	if err := dec.Decode(&i); err != nil {
-		return fmt.Errorf("fail to decode key item")
+// UnmarshalBinary implements gob unmarshaling of legacy keyItem
+func (ki *legacyKeyItem) UnmarshalBinary(data []byte) error {
+	li, err := decodeLegacyItem(data)
+	if err != nil {
+		return err
 	}
-	v, ok := i.Value.(string)
+	v, ok := li.Value.(string)
 	if !ok {
 		return errors.New("fail to cast value to string")
 	}
-	ki.value = v
-	ki.expiry = time.Unix(0, i.Expiry)
+	ki.keyItem = newKeyItem(v, time.Unix(0, li.Expiry))
 	return nil
 }
 
-// MarshalBinary implements gob marshaling for listItem
-func (li listItem) MarshalBinary() ([]byte, error) {
-	var out bytes.Buffer
-	enc := gob.NewEncoder(&out)
-	err := enc.Encode(gobItem{li.expiry.UnixNano(), li.list})
-	if err != nil {
-		return nil, fmt.Errorf(`fail to encode list item "%v": %s`, li, err.Error())
-	}
-	return out.Bytes(), nil
+// legacyListItem is listItem decoded from dump of previous versions
+type legacyListItem struct {
+	listItem
 }
 
-// UnmarshalBinary implements gob unmarshaling for listItem
-func (li *listItem) UnmarshalBinary(data []byte) error {
-	in := bytes.NewReader(data)
-	dec := gob.NewDecoder(in)
-	var i gobItem
-	if err := dec.Decode(&i); err != nil {
-		return fmt.Errorf("fail to decode key item")
+// UnmarshalBinary implements gob unmarshaling of legacy listItem
+func (li *legacyListItem) UnmarshalBinary(data []byte) error {
+	i, err := decodeLegacyItem(data)
+	if err != nil {
+		return err
 	}
 	l, ok := i.Value.([]string)
 	if !ok {
 		return errors.New("fail to cast value to []string")
 	}
-	li.list = l
-	li.expiry = time.Unix(0, i.Expiry)
+	li.listItem = newListItem(l, time.Unix(0, i.Expiry))
 	return nil
 }
 
-// MarshalBinary implements gob marshaling for dictItem
-func (di dictItem) MarshalBinary() ([]byte, error) {
-	var out bytes.Buffer
-	enc := gob.NewEncoder(&out)
-	err := enc.Encode(gobItem{di.expiry.UnixNano(), di.dict})
-	if err != nil {
-		return nil, fmt.Errorf(`fail to encode dict item "%v": %s`, di, err.Error())
-	}
-	return out.Bytes(), nil
+// legacyDictItem is dictItem decoded from dump of previous versions
+type legacyDictItem struct {
+	dictItem
 }
 
-// UnmarshalBinary implements gob unmarshaling for dictItem
-func (di *dictItem) UnmarshalBinary(data []byte) error {
-	in := bytes.NewReader(data)
-	dec := gob.NewDecoder(in)
-	var i gobItem
-	if err := dec.Decode(&i); err != nil {
-		return fmt.Errorf("fail to decode key item")
+// UnmarshalBinary implements gob unmarshaling of legacy dictItem
+func (di *legacyDictItem) UnmarshalBinary(data []byte) error {
+	i, err := decodeLegacyItem(data)
+	if err != nil {
+		return err
 	}
 	d, ok := i.Value.(map[string]string)
 	if !ok {
 		return errors.New("fail to cast value to map[string]string")
 	}
-	di.dict = d
-	di.expiry = time.Unix(0, i.Expiry)
+	di.dictItem = newDictItem(d, time.Unix(0, i.Expiry))
 	return nil
 }
 
-// init registers gob structures
+// init registers legacy items by gob names of item types which dumps of previous versions refer to
 func init() {
 	gob.Register(map[string]string{})
-	gob.Register(keyItem{})
-	gob.Register(listItem{})
-	gob.Register(dictItem{})
+	gob.RegisterName(legacyTypePrefix+"keyItem", legacyKeyItem{})
+	gob.RegisterName(legacyTypePrefix+"listItem", legacyListItem{})
+	gob.RegisterName(legacyTypePrefix+"dictItem", legacyDictItem{})
 }
@@ -1,6 +1,8 @@
 package store
 
 import (
+	"bytes"
+	"encoding/base64"
 	"io/ioutil"
 	"os"
 	"time"
@@ -10,6 +12,44 @@ import (
 	"github.com/onsi/gomega/types"
 )
 
+var _ = Describe("NewFileDumper", func() {
+	Specify("unknown format error", func() {
+		_, err := NewFileDumper("dump", "xml", NoCompression)
+		Expect(err).To(MatchError(ErrUnknownDumpFormat.detailed(`unknown format "xml", must be one of: gob, json`)))
+	})
+	Specify("unknown compression error", func() {
+		_, err := NewFileDumper("dump", GobFormat, "lzma")
+		Expect(err).To(MatchError(ErrUnknownDumpCompression.detailed(
+			`unknown compression "lzma", must be one of: none, gzip, zstd`)))
+	})
+	Specify("succeeds", func() {
+		d, err := NewFileDumper("dump", JSONFormat, GzipCompression)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(d).To(Equal(FileDumper{path: "dump", format: JSONFormat, compression: GzipCompression}))
+	})
+})
+
+var _ = Describe("StreamDumper", func() {
+	Specify("unknown format error", func() {
+		_, err := NewStreamDumper("unknown", NoCompression)
+		Expect(err).To(MatchError(HavePrefix(ErrUnknownDumpFormat.Error())))
+	})
+	Specify("writes and reads items without dump file", func() {
+		d, err := NewStreamDumper(JSONFormat, GzipCompression)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(d.dump(items{})).To(MatchError(ErrDumpingDisabled))
+		Expect(d.load()).To(BeEmpty())
+		expiry := time.Now().Add(time.Hour).Round(0)
+		var buf bytes.Buffer
+		Expect(d.write(&buf, items{"a": newKeyItem("v", expiry)})).To(Succeed())
+		read, err := d.read(&buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read).To(HaveKey("a"))
+		Expect(read["a"].keyValue()).To(Equal("v"))
+		Expect(read["a"].ttl(expiry)).To(BeZero())
+	})
+})
+
 var _ = Describe("FileDumper", func() {
 	var (
 		file *os.File
@@ -21,11 +61,14 @@ var _ = Describe("FileDumper", func() {
 		if err != nil {
 			Fail("temp file creating error: " + err.Error())
 		}
-		d = FileDumper(file.Name())
+		d = FileDumper{path: file.Name(), format: GobFormat, compression: NoCompression}
+	})
+	AfterEach(func() {
+		os.Remove(file.Name())
 	})
 	Describe("dump and load", func() {
 		Specify("success empty items if file not exists", func() {
-			d = FileDumper("!--- NOT EXISTS ---!")
+			d.path = "!--- NOT EXISTS ---!"
 			items, err := d.load()
 			Expect(items).To(BeEmpty())
 			Expect(err).ToNot(HaveOccurred())
@@ -37,24 +80,108 @@ var _ = Describe("FileDumper", func() {
 			_, err = d.load()
 			Expect(err).To(MatchError(ErrFailToDecodeDumpFile.detailed("unexpected EOF")))
 		})
-		Specify("success load", func() {
-			exp := items{
-				"key":  newKeyItem("value", time.Now().Add(10*time.Second)),
-				"lkey": newListItem([]string{"l1", "l2", "l3"}, time.Now()),
-				"dkey": newDictItem(map[string]string{"dk1": "dv1", "dk2": "dv2"}, time.Now().Add(-10*time.Second)),
-			}
-			err := d.dump(exp)
+		Specify("fail to decode invalid gzip data error", func() {
+			_, err := file.Write(append(gzipMagic, []byte("!--- INVALID DATA ---!")...))
 			Expect(err).ToNot(HaveOccurred())
+			Expect(file.Sync()).To(Succeed())
+			_, err = d.load()
+			Expect(err).To(MatchError(ErrFailToDecodeDumpFile.detailed("gzip: invalid header")))
+		})
+		Specify("fail to dump unknown item error", func() {
+			err := d.dump(items{"a": baseItem{expiry: time.Now()}})
+			Expect(err).To(MatchError(ErrFailToDumpItems.detailed(`unknown type of item "a"`)))
+		})
+		Specify("failed dump keeps previous dump", func() {
+			exp := items{"key": newKeyItem("value", time.Now())}
+			Expect(d.dump(exp)).To(Succeed())
+			Expect(d.dump(items{"a": baseItem{expiry: time.Now()}})).ToNot(Succeed())
 			got, err := d.load()
 			Expect(err).ToNot(HaveOccurred())
-			Expect(got).To(HaveLen(3))
-			Expect(got).To(HaveKey("key"))
-			Expect(got).To(HaveKey("lkey"))
-			Expect(got).To(HaveKey("dkey"))
+			Expect(got).To(HaveLen(1))
 			Expect(got["key"]).To(beKeyItem(exp["key"].(keyItem)))
-			Expect(got["lkey"]).To(beListItem(exp["lkey"].(listItem)))
-			Expect(got["dkey"]).To(beDictItem(exp["dkey"].(dictItem)))
+			_, err = os.Stat(d.path + ".tmp")
+			Expect(os.IsNotExist(err)).To(BeTrue())
 		})
+		Specify("success load dump of previous versions", func() {
+			// gob encoded whole items map with key, lkey and dkey items expiring at 2100-01-01
+			data, err := base64.StdEncoding.DecodeString("" +
+				"FH8EAQEFaXRlbXMB/4AAAQwBEAAARf+AAAMEbGtleSdnaXRodWIuY29tL3NvbWVhbm9uL3lhbWMvc3RvcmUubGlzdEl0ZW3/gQYBAQhs" +
+				"aXN0SXRlbQH/ggAAAP+h/4JdAFsq/4MDAQEHZ29iSXRlbQH/hAABAgEGRXhwaXJ5AQQAAQVWYWx1ZQEQAAAAIv+EAfhx3Z+erUwAAAEI" +
+				"W11zdHJpbmf/hQIBAv+GAAEMAAAM/4YIAAICbDECbDIABGRrZXknZ2l0aHViLmNvbS9zb21lYW5vbi95YW1jL3N0b3JlLmRpY3RJdGVt" +
+				"/4cGAQEIZGljdEl0ZW0B/4gAAAD/qf+IaABmKv+DAwEBB2dvYkl0ZW0B/4QAAQIBBkV4cGlyeQEEAAEFVmFsdWUBEAAAAC3/hAH4cd2f" +
+				"nq1MAAABEW1hcFtzdHJpbmddc3RyaW5n/4kEAQL/igABDAEMAAAM/4oIAAECZGsCZHYAA2tleSZnaXRodWIuY29tL3NvbWVhbm9uL3lh" +
+				"bWMvc3RvcmUua2V5SXRlbf+LBgEBB2tleUl0ZW0B/4wAAABP/4xMAEoq/4MDAQEHZ29iSXRlbQH/hAABAgEGRXhwaXJ5AQQAAQVWYWx1" +
+				"ZQEQAAAAHv+EAfhx3Z+erUwAAAEGc3RyaW5nDAcABXZhbHVlAA==")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = file.Write(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(file.Sync()).To(Succeed())
+			expiry := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+			for _, f := range []Format{GobFormat, JSONFormat} {
+				d.format = f
+				got, err := d.load()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(got).To(HaveLen(3))
+				Expect(got["key"]).To(beKeyItem(newKeyItem("value", expiry)))
+				Expect(got["lkey"]).To(beListItem(newListItem([]string{"l1", "l2"}, expiry)))
+				Expect(got["dkey"]).To(beDictItem(newDictItem(map[string]string{"dk": "dv"}, expiry)))
+			}
+		})
+		Specify("success load dump of other format", func() {
+			exp := items{"key": newKeyItem("value", time.Now())}
+			for _, f := range []Format{GobFormat, JSONFormat} {
+				d.format = f
+				Expect(d.dump(exp)).To(Succeed())
+				for _, other := range []Format{GobFormat, JSONFormat} {
+					d.format = other
+					got, err := d.load()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(got).To(HaveLen(1))
+					Expect(got["key"]).To(beKeyItem(exp["key"].(keyItem)))
+				}
+			}
+		})
+		Specify("success load empty items", func() {
+			Expect(d.dump(items{})).To(Succeed())
+			got, err := d.load()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(got).To(BeEmpty())
+		})
+		Specify("fail to decode invalid json data error", func() {
+			d.format = JSONFormat
+			_, err := file.Write([]byte(`{"key":"a","type":"set","value":"v","expiry":"2020-01-01T00:00:00Z"}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(file.Sync()).To(Succeed())
+			_, err = d.load()
+			Expect(err).To(MatchError(ErrFailToDecodeDumpFile.detailed(`unknown type "set" of record "a"`)))
+		})
+		for _, f := range []Format{GobFormat, JSONFormat} {
+			for _, c := range []Compression{NoCompression, GzipCompression, ZstdCompression} {
+				f, c := f, c
+				Specify("success load in "+string(f)+" format with "+string(c)+" compression", func() {
+					d.format = f
+					d.compression = c
+					exp := items{
+						"key":  newKeyItem("value", time.Now().Add(10*time.Second)),
+						"lkey": newListItem([]string{"l1", "l2", "l3"}, time.Now()),
+						"dkey": newDictItem(map[string]string{"dk1": "dv1", "dk2": "dv2"}, time.Now().Add(-10*time.Second)),
+					}
+					err := d.dump(exp)
+					Expect(err).ToNot(HaveOccurred())
+					By("loading with other compression set")
+					d.compression = NoCompression
+					got, err := d.load()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(got).To(HaveLen(3))
+					Expect(got).To(HaveKey("key"))
+					Expect(got).To(HaveKey("lkey"))
+					Expect(got).To(HaveKey("dkey"))
+					Expect(got["key"]).To(beKeyItem(exp["key"].(keyItem)))
+					Expect(got["lkey"]).To(beListItem(exp["lkey"].(listItem)))
+					Expect(got["dkey"]).To(beDictItem(exp["dkey"].(dictItem)))
+				})
+			}
+		}
 	})
 })
 
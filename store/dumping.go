@@ -0,0 +1,179 @@
+package store
+
+import (
+	"io"
+	"time"
+)
+
+// dumpRetryDelay is a delay before first retry of failed periodical dump. Each next retry delay is doubled
+const dumpRetryDelay = time.Second
+
+// DumpStatus is a dumping status
+type DumpStatus struct {
+	// Time is last dump start time, zero if there were no dumps yet
+	Time time.Time `yaml:"time" json:"time" msgpack:"time"`
+
+	// Duration is last dump duration
+	Duration time.Duration `yaml:"duration" json:"duration" msgpack:"duration"`
+
+	// Error is last dump error, empty if dump succeeded
+	Error string `yaml:"error,omitempty" json:"error,omitempty" msgpack:"error,omitempty"`
+
+	// LastSuccess is last succeeded dump start time, zero if there were no succeeded dumps yet
+	LastSuccess time.Time `yaml:"last_success" json:"last_success" msgpack:"last_success"`
+
+	// Failures is consecutive failed dumps count
+	Failures int `yaml:"failures" json:"failures" msgpack:"failures"`
+}
+
+// Dump synchronously dumps items. Returns dumping status after dump and dumper error
+func (s *store) Dump() (DumpStatus, error) {
+	s.mutex.RLock()
+	closed := s.closed
+	s.mutex.RUnlock()
+	if closed {
+		return DumpStatus{}, ErrStoreClosed
+	}
+	return s.dump()
+}
+
+// DumpStatus returns dumping status
+func (s *store) DumpStatus() DumpStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.dumpStatus
+}
+
+// OnDumpError registers hook called with error after each failed dump, periodical or requested
+func (s *store) OnDumpError(hook func(err error)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.dumpErrorHooks = append(s.dumpErrorHooks, hook)
+}
+
+// Checkpoint is a point-in-time copy of not expired store items, which is written by store's dumper
+type Checkpoint struct {
+	items  items
+	dumper Dumper
+}
+
+// Write writes checkpoint items to w in dumper's format using dumper's compression
+func (c Checkpoint) Write(w io.Writer) error {
+	return c.dumper.write(w, c.items)
+}
+
+// Checkpoint returns point-in-time copy of not expired items. Copy is shallow and cheap, so items can be written
+// later without blocking store
+func (s *store) Checkpoint() (Checkpoint, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return Checkpoint{}, ErrStoreClosed
+	}
+	now := s.clock.now()
+	checkpoint := make(items, len(s.items))
+	for k, i := range s.items {
+		if !i.expired(now) {
+			checkpoint[k] = i
+		}
+	}
+	return Checkpoint{items: checkpoint, dumper: s.dumper}, nil
+}
+
+// Load reads items written by Checkpoint from r and replaces all items with them, expired items are skipped. Load is
+// atomic: items are not changed on error
+func (s *store) Load(r io.Reader) error {
+	loaded, err := s.dumper.read(r)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.writable(); err != nil {
+		return err
+	}
+	now := s.clock.now()
+	s.reset(items{})
+	s.logFlush()
+	for k, i := range loaded {
+		if i.expired(now) {
+			continue
+		}
+		s.put(k, i)
+		s.logSet(k, i)
+	}
+	return nil
+}
+
+// dump dumps shallow copy of items, so store isn't locked while dump is written, updates dumping status and calls
+// error hooks if dump failed. Only one dump is running at time
+func (s *store) dump() (DumpStatus, error) {
+	s.dumpMutex.Lock()
+	defer s.dumpMutex.Unlock()
+	started := s.clock.now()
+	s.mutex.RLock()
+	snapshot := s.snapshotItems()
+	s.mutex.RUnlock()
+	err := s.dumper.dump(snapshot)
+	s.mutex.Lock()
+	status := s.dumpStatus
+	status.Time = started
+	status.Duration = s.clock.now().Sub(started)
+	if err != nil {
+		status.Error = err.Error()
+		status.Failures++
+	} else {
+		status.Error = ""
+		status.LastSuccess = started
+		status.Failures = 0
+	}
+	s.dumpStatus = status
+	hooks := s.dumpErrorHooks
+	s.mutex.Unlock()
+	s.counters.countDump(status.Duration, err)
+	if err != nil {
+		for _, hook := range hooks {
+			hook(err)
+		}
+	}
+	return status, err
+}
+
+// periodicDump is periodical dumping function. It cancels pending retry of previous failed dump and dumps
+func (s *store) periodicDump() {
+	s.mutex.Lock()
+	s.stopDumpRetry()
+	s.mutex.Unlock()
+	s.dumpOrRetry()
+}
+
+// dumpOrRetry dumps items. If dump fails it schedules retry with exponential backoff by consecutive failures count.
+// Retries are stopped when delay reaches dumping period, since next periodical dump is coming anyway
+func (s *store) dumpOrRetry() {
+	status, err := s.dump()
+	if err == nil {
+		return
+	}
+	delay := s.dumpRetryDelay
+	for i := 1; i < status.Failures && delay < s.params.DumpingPeriod; i++ {
+		delay *= 2
+	}
+	if delay >= s.params.DumpingPeriod {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed || s.dumping == nil || !s.dumping.isRunning() {
+		return
+	}
+	s.stopDumpRetry()
+	s.dumpRetry = time.AfterFunc(delay, s.dumpOrRetry)
+}
+
+// stopDumpRetry stops pending dump retry if any. Must be called under lock
+func (s *store) stopDumpRetry() {
+	if s.dumpRetry != nil {
+		s.dumpRetry.Stop()
+		s.dumpRetry = nil
+	}
+}
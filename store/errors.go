@@ -43,6 +43,18 @@ var (
 
 	// other errors
 	ErrInvalidListIndex = e(30, "invalid list index")
+	ErrStoreClosed      = e(31, "store closed")
+	ErrKeyExists        = e(32, "key exists")
+	ErrVersionMismatch  = e(33, "version mismatch")
+	ErrNotNumber        = e(34, "not number")
+
+	// namespace errors
+	ErrNamespaceNotExists = e(35, "namespace not exists")
+	ErrInvalidNamespace   = e(36, "invalid namespace")
+
+	// quota errors
+	ErrKeysQuotaExceeded  = e(37, "keys quota exceeded")
+	ErrBytesQuotaExceeded = e(38, "bytes quota exceeded")
 
 	// cleaning errors
 	ErrFailToCreateCleaning  = e(40, "fail to create cleaning")
@@ -55,10 +67,27 @@ var (
 	ErrFailToStartDumping  = e(51, "fail to start dumping")
 	ErrDumperNotStartedYet = e(52, "dumping not started yet")
 	ErrFailToStopDumping   = e(53, "fail to stop dumping")
+	ErrDumpingDisabled     = e(54, "dumping disabled")
 
 	// load errors
-	ErrFailOpenDumpFile     = e(60, "fail to open dump file")
-	ErrFailToDumpItems      = e(61, "fail to dump items")
-	ErrFailToDecodeDumpFile = e(61, "fail to decode dump file")
-	ErrFailToCloseDumpFile  = e(62, "fail to close dump file")
+	ErrFailOpenDumpFile      = e(60, "fail to open dump file")
+	ErrFailToDumpItems       = e(61, "fail to dump items")
+	ErrFailToDecodeDumpFile  = e(64, "fail to decode dump file")
+	ErrFailToCloseDumpFile   = e(62, "fail to close dump file")
+	ErrFailToReplaceDumpFile = e(63, "fail to replace dump file")
+
+	// dumper errors
+	ErrUnknownDumpCompression = e(70, "unknown dump compression")
+	ErrUnknownDumpFormat      = e(71, "unknown dump format")
+
+	// export and import errors
+	ErrFailToExportItems = e(80, "fail to export items")
+	ErrFailToImportItems = e(81, "fail to import items")
+
+	// replication errors
+	ErrReadOnly                = e(90, "read only store")
+	ErrReplicationLogTruncated = e(91, "replication log truncated")
+	ErrFailToReplicate         = e(92, "fail to replicate")
+	ErrFailToRestoreItems      = e(93, "fail to restore items")
+	ErrFailToApplyMutations    = e(94, "fail to apply mutations")
 )
@@ -0,0 +1,265 @@
+package store
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// Format is a records stream encoding format
+type Format string
+
+const (
+	// GobFormat is compact go specific binary format
+	GobFormat Format = "gob"
+
+	// JSONFormat is newline delimited JSON format, one record object per line
+	JSONFormat Format = "json"
+)
+
+// Validate validates format
+func (f Format) Validate() error {
+	switch f {
+	case GobFormat, JSONFormat:
+		return nil
+	}
+	return fmt.Errorf(`unknown format "%s", must be one of: gob, json`, f)
+}
+
+// recordWriter is a records stream writer
+type recordWriter interface {
+	write(r record) error
+}
+
+// recordReader is a records stream reader. Returns io.EOF when stream is over
+type recordReader interface {
+	read() (record, error)
+}
+
+// writer constructs records writer to w according format
+func (f Format) writer(w io.Writer) (recordWriter, error) {
+	switch f {
+	case GobFormat:
+		return gobRecordWriter{gob.NewEncoder(w)}, nil
+	case JSONFormat:
+		return jsonRecordWriter{json.NewEncoder(w)}, nil
+	}
+	return nil, f.Validate()
+}
+
+// jsonMagic is a beginning of JSON records stream, which is never a beginning of gob stream, since gob stream starts
+// with length and negative type id
+var jsonMagic = []byte(`{"`)
+
+// detectFormat detects format of records stream r by its beginning. Returns format f if r is empty
+func detectFormat(r *bufio.Reader, f Format) Format {
+	if hasMagic(r, jsonMagic) {
+		return JSONFormat
+	}
+	if _, err := r.Peek(1); err != nil {
+		return f
+	}
+	return GobFormat
+}
+
+// reader constructs records reader from r according format
+func (f Format) reader(r io.Reader) (recordReader, error) {
+	switch f {
+	case GobFormat:
+		return gobRecordReader{gob.NewDecoder(r)}, nil
+	case JSONFormat:
+		return jsonRecordReader{json.NewDecoder(r)}, nil
+	}
+	return nil, f.Validate()
+}
+
+// base64Encoding is a JSON record encoding of key and values, which is set if any of them is not valid UTF-8, since
+// JSON strings can't keep arbitrary bytes
+const base64Encoding = "base64"
+
+// gobRecordWriter writes gob encoded records
+type gobRecordWriter struct {
+	encoder *gob.Encoder
+}
+
+// write writes record r
+func (w gobRecordWriter) write(r record) error {
+	return w.encoder.Encode(r)
+}
+
+// gobRecordReader reads gob encoded records
+type gobRecordReader struct {
+	decoder *gob.Decoder
+}
+
+// read reads next record
+func (r gobRecordReader) read() (record, error) {
+	var rec record
+	err := r.decoder.Decode(&rec)
+	return rec, err
+}
+
+// jsonRecord is record JSON representation. Key and values are base64 encoded if encoding is base64
+type jsonRecord struct {
+	Key      string          `json:"key"`
+	Type     string          `json:"type"`
+	Encoding string          `json:"encoding,omitempty"`
+	Value    json.RawMessage `json:"value"`
+	Expiry   time.Time       `json:"expiry"`
+	Owner    string          `json:"owner,omitempty"`
+	Version  uint64          `json:"version,omitempty"`
+}
+
+// jsonRecordWriter writes newline delimited JSON encoded records
+type jsonRecordWriter struct {
+	encoder *json.Encoder
+}
+
+// write writes record r
+func (w jsonRecordWriter) write(r record) error {
+	jr, err := newJSONRecord(r)
+	if err != nil {
+		return err
+	}
+	return w.encoder.Encode(jr)
+}
+
+// jsonRecordReader reads newline delimited JSON encoded records
+type jsonRecordReader struct {
+	decoder *json.Decoder
+}
+
+// read reads next record
+func (r jsonRecordReader) read() (record, error) {
+	var jr jsonRecord
+	if err := r.decoder.Decode(&jr); err != nil {
+		return record{}, err
+	}
+	return jr.record()
+}
+
+// newJSONRecord constructs JSON representation of record r. Key and values are base64 encoded if any of them is not
+// valid UTF-8. Returns error if record type is unknown
+func newJSONRecord(r record) (jsonRecord, error) {
+	encoding := ""
+	if !r.validUTF8() {
+		encoding = base64Encoding
+		r, _ = r.mapped(func(s string) (string, error) {
+			return base64.StdEncoding.EncodeToString([]byte(s)), nil
+		})
+	}
+	var value interface{}
+	switch r.Type {
+	case keyItemType:
+		value = r.Value
+	case listItemType:
+		value = r.List
+	case dictItemType:
+		value = r.Dict
+	default:
+		return jsonRecord{}, fmt.Errorf(`unknown type %d of record "%s"`, r.Type, r.Key)
+	}
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return jsonRecord{}, err
+	}
+	return jsonRecord{
+		Key:      r.Key,
+		Type:     r.Type.String(),
+		Encoding: encoding,
+		Value:    valueJSON,
+		Expiry:   time.Unix(0, r.Expiry).UTC(),
+		Owner:    r.Owner,
+		Version:  r.Version,
+	}, nil
+}
+
+// record constructs record from its JSON representation. Returns error if type or encoding is unknown or value is
+// invalid
+func (jr jsonRecord) record() (record, error) {
+	rec := record{Key: jr.Key, Expiry: jr.Expiry.UnixNano(), Owner: jr.Owner, Version: jr.Version}
+	var value interface{}
+	switch jr.Type {
+	case keyItemType.String():
+		rec.Type, value = keyItemType, &rec.Value
+	case listItemType.String():
+		rec.Type, value = listItemType, &rec.List
+	case dictItemType.String():
+		rec.Type, value = dictItemType, &rec.Dict
+	default:
+		return record{}, fmt.Errorf(`unknown type "%s" of record "%s"`, jr.Type, jr.Key)
+	}
+	if err := json.Unmarshal(jr.Value, value); err != nil {
+		return record{}, fmt.Errorf(`invalid value of record "%s": %s`, jr.Key, err.Error())
+	}
+	switch jr.Encoding {
+	case "":
+		return rec, nil
+	case base64Encoding:
+		rec, err := rec.mapped(func(s string) (string, error) {
+			b, err := base64.StdEncoding.DecodeString(s)
+			return string(b), err
+		})
+		if err != nil {
+			return record{}, fmt.Errorf(`invalid base64 value of record "%s": %s`, jr.Key, err.Error())
+		}
+		return rec, nil
+	}
+	return record{}, fmt.Errorf(`unknown encoding "%s" of record "%s"`, jr.Encoding, jr.Key)
+}
+
+// validUTF8 determines if record's key and values are valid UTF-8
+func (r record) validUTF8() bool {
+	if !utf8.ValidString(r.Key) || !utf8.ValidString(r.Value) {
+		return false
+	}
+	for _, v := range r.List {
+		if !utf8.ValidString(v) {
+			return false
+		}
+	}
+	for k, v := range r.Dict {
+		if !utf8.ValidString(k) || !utf8.ValidString(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// mapped returns copy of record with key and values mapped by f. Returns first error of f
+func (r record) mapped(f func(s string) (string, error)) (record, error) {
+	var err error
+	m := record{Type: r.Type, Expiry: r.Expiry, Owner: r.Owner, Version: r.Version}
+	if m.Key, err = f(r.Key); err != nil {
+		return record{}, err
+	}
+	if m.Value, err = f(r.Value); err != nil {
+		return record{}, err
+	}
+	if r.List != nil {
+		m.List = make([]string, len(r.List))
+		for i, v := range r.List {
+			if m.List[i], err = f(v); err != nil {
+				return record{}, err
+			}
+		}
+	}
+	if r.Dict != nil {
+		m.Dict = make(map[string]string, len(r.Dict))
+		for k, v := range r.Dict {
+			mk, err := f(k)
+			if err != nil {
+				return record{}, err
+			}
+			if m.Dict[mk], err = f(v); err != nil {
+				return record{}, err
+			}
+		}
+	}
+	return m, nil
+}
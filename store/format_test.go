@@ -0,0 +1,87 @@
+package store
+
+import (
+	"bytes"
+	"io"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Format", func() {
+	Specify("Validate", func() {
+		Expect(GobFormat.Validate()).To(Succeed())
+		Expect(JSONFormat.Validate()).To(Succeed())
+		Expect(Format("xml").Validate()).To(MatchError(`unknown format "xml", must be one of: gob, json`))
+	})
+	Specify("unknown format writer and reader errors", func() {
+		_, err := Format("xml").writer(&bytes.Buffer{})
+		Expect(err).To(HaveOccurred())
+		_, err = Format("xml").reader(&bytes.Buffer{})
+		Expect(err).To(HaveOccurred())
+	})
+	Describe("JSON records", func() {
+		Specify("unknown record type error", func() {
+			w, err := JSONFormat.writer(&bytes.Buffer{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(w.write(record{Key: "a"})).To(MatchError(`unknown type 0 of record "a"`))
+		})
+		Specify("writes and reads records", func() {
+			exp := []record{
+				{Key: "a", Type: keyItemType, Expiry: 1, Value: "v"},
+				{Key: "b", Type: listItemType, Expiry: 2, List: []string{"l1", "l2"}},
+				{Key: "c", Type: dictItemType, Expiry: 3, Dict: map[string]string{"dk": "dv"}},
+			}
+			var b bytes.Buffer
+			w, err := JSONFormat.writer(&b)
+			Expect(err).ToNot(HaveOccurred())
+			for _, r := range exp {
+				Expect(w.write(r)).To(Succeed())
+			}
+			Expect(b.String()).To(Equal(
+				`{"key":"a","type":"key","value":"v","expiry":"1970-01-01T00:00:00.000000001Z"}` + "\n" +
+					`{"key":"b","type":"list","value":["l1","l2"],"expiry":"1970-01-01T00:00:00.000000002Z"}` + "\n" +
+					`{"key":"c","type":"dict","value":{"dk":"dv"},"expiry":"1970-01-01T00:00:00.000000003Z"}` + "\n"))
+			r, err := JSONFormat.reader(&b)
+			Expect(err).ToNot(HaveOccurred())
+			for _, e := range exp {
+				Expect(r.read()).To(Equal(e))
+			}
+			_, err = r.read()
+			Expect(err).To(Equal(io.EOF))
+		})
+		Specify("writes and reads base64 encoded records not valid UTF-8", func() {
+			exp := []record{
+				{Key: "\xff", Type: keyItemType, Expiry: 1, Value: "\xff\xfea"},
+				{Key: "b", Type: listItemType, Expiry: 2, List: []string{"l1", "\xff"}},
+				{Key: "c", Type: dictItemType, Expiry: 3, Dict: map[string]string{"\xfe": "dv"}},
+			}
+			var b bytes.Buffer
+			w, err := JSONFormat.writer(&b)
+			Expect(err).ToNot(HaveOccurred())
+			for _, r := range exp {
+				Expect(w.write(r)).To(Succeed())
+			}
+			Expect(b.String()).To(Equal(
+				`{"key":"/w==","type":"key","encoding":"base64","value":"//5h","expiry":"1970-01-01T00:00:00.000000001Z"}` +
+					"\n" + `{"key":"Yg==","type":"list","encoding":"base64","value":["bDE=","/w=="],` +
+					`"expiry":"1970-01-01T00:00:00.000000002Z"}` + "\n" + `{"key":"Yw==","type":"dict","encoding":"base64",` +
+					`"value":{"/g==":"ZHY="},"expiry":"1970-01-01T00:00:00.000000003Z"}` + "\n"))
+			r, err := JSONFormat.reader(&b)
+			Expect(err).ToNot(HaveOccurred())
+			for _, e := range exp {
+				Expect(r.read()).To(Equal(e))
+			}
+		})
+		Specify("invalid encoding errors", func() {
+			r, err := JSONFormat.reader(bytes.NewBufferString(
+				`{"key":"a","type":"key","encoding":"hex","value":"76","expiry":"1970-01-01T00:00:00Z"}` + "\n" +
+					`{"key":"a","type":"key","encoding":"base64","value":"!","expiry":"1970-01-01T00:00:00Z"}`))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = r.read()
+			Expect(err).To(MatchError(`unknown encoding "hex" of record "a"`))
+			_, err = r.read()
+			Expect(err).To(MatchError(`invalid base64 value of record "a": illegal base64 data at input byte 0`))
+		})
+	})
+})
@@ -0,0 +1,65 @@
+package store
+
+import "time"
+
+const (
+	// itemOverhead is estimated memory used by any item besides its key and value: map entry, interface and expiry
+	itemOverhead = 64
+
+	// stringOverhead is memory used by string header
+	stringOverhead = 16
+)
+
+// Info is a store state summary
+type Info struct {
+	// Keys, Lists and Dicts are not expired items count of each type
+	Keys  int `yaml:"keys" json:"keys" msgpack:"keys"`
+	Lists int `yaml:"lists" json:"lists" msgpack:"lists"`
+	Dicts int `yaml:"dicts" json:"dicts" msgpack:"dicts"`
+
+	// Memory is rough estimate of memory used by items in bytes
+	Memory int64 `yaml:"memory" json:"memory" msgpack:"memory"`
+
+	// Uptime is time since store construction
+	Uptime time.Duration `yaml:"uptime" json:"uptime" msgpack:"uptime"`
+
+	// Cleaning and Dumping determine if periodical cleaning and dumping are running
+	Cleaning bool `yaml:"cleaning" json:"cleaning" msgpack:"cleaning"`
+	Dumping  bool `yaml:"dumping" json:"dumping" msgpack:"dumping"`
+
+	// Dump is dumping status
+	Dump DumpStatus `yaml:"dump" json:"dump" msgpack:"dump"`
+
+	// Replication is replication status
+	Replication ReplicationStatus `yaml:"replication" json:"replication" msgpack:"replication"`
+}
+
+// Info returns store state summary
+func (s *store) Info() Info {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	now := s.clock.now()
+	info := Info{
+		Uptime:   now.Sub(s.started),
+		Cleaning: s.cleaning != nil && s.cleaning.isRunning(),
+		Dumping:  s.dumping != nil && s.dumping.isRunning(),
+		Dump:     s.dumpStatus,
+
+		Replication: s.replicationStatus(),
+	}
+	for k, i := range s.items {
+		if i.expired(now) {
+			continue
+		}
+		switch i.(type) {
+		case keyItem:
+			info.Keys++
+		case listItem:
+			info.Lists++
+		case dictItem:
+			info.Dicts++
+		}
+		info.Memory += size(k, i)
+	}
+	return info
+}
@@ -7,16 +7,23 @@ import (
 // item is store item
 type item interface {
 	expired(now time.Time) bool
+	ttl(now time.Time) time.Duration
+	expiring(expiry time.Time) item
+	ownerOf() string
+	owned(owner string) item
 	keyValue() (string, error)
 	listValue(i int) (string, error)
+	listValues() ([]string, error)
 	dictValue(k string) (string, error)
+	dictValues() (map[string]string, error)
 }
 
 type items map[string]item
 
-// baseItem is general store item
+// baseItem is general store item. Owner is login of account which wrote item last, empty if item is not owned
 type baseItem struct {
 	expiry time.Time
+	owner  string
 }
 
 // expired determines if item is expired
@@ -24,6 +31,28 @@ func (bi baseItem) expired(now time.Time) bool {
 	return bi.expiry.Before(now) || bi.expiry.Equal(now)
 }
 
+// ttl returns item's time to live at now
+func (bi baseItem) ttl(now time.Time) time.Duration {
+	return bi.expiry.Sub(now)
+}
+
+// expiring returns copy of item with expiry
+func (bi baseItem) expiring(expiry time.Time) item {
+	bi.expiry = expiry
+	return bi
+}
+
+// ownerOf returns login of item's owner
+func (bi baseItem) ownerOf() string {
+	return bi.owner
+}
+
+// owned returns copy of item owned by owner
+func (bi baseItem) owned(owner string) item {
+	bi.owner = owner
+	return bi
+}
+
 // keyValue is default returns keyItem value or error if item is not keyItem
 func (_ baseItem) keyValue() (string, error) {
 	return "", ErrNotKeyItem
@@ -34,15 +63,26 @@ func (_ baseItem) listValue(_ int) (string, error) {
 	return "", ErrNotListItem
 }
 
+// listValues returns listItem values or error if item is not listItem
+func (_ baseItem) listValues() ([]string, error) {
+	return nil, ErrNotListItem
+}
+
 // dictValue returns dict value  or error if item is not dictItem
 func (_ baseItem) dictValue(_ string) (string, error) {
 	return "", ErrNotDictItem
 }
 
-// keyItem is a simple string scalar item
+// dictValues returns dictItem values or error if item is not dictItem
+func (_ baseItem) dictValues() (map[string]string, error) {
+	return nil, ErrNotDictItem
+}
+
+// keyItem is a simple string scalar item. Version is a CAS token, which grows on every modification of item
 type keyItem struct {
 	baseItem
-	value string
+	value   string
+	version uint64
 }
 
 // newKeyItem is a keyItem constructor
@@ -55,6 +95,24 @@ func newKeyItem(value string, expiry time.Time) keyItem {
 	}
 }
 
+// expiring returns copy of item with expiry
+func (ki keyItem) expiring(expiry time.Time) item {
+	ki.expiry = expiry
+	return ki
+}
+
+// owned returns copy of item owned by owner
+func (ki keyItem) owned(owner string) item {
+	ki.owner = owner
+	return ki
+}
+
+// versioned returns copy of item with version
+func (ki keyItem) versioned(version uint64) keyItem {
+	ki.version = version
+	return ki
+}
+
 // keyValue is default returns keyItem value
 func (ki keyItem) keyValue() (string, error) {
 	return ki.value, nil
@@ -87,6 +145,23 @@ func (li listItem) listValue(i int) (string, error) {
 	return li.list[i], nil
 }
 
+// listValues returns copy of listItem values
+func (li listItem) listValues() ([]string, error) {
+	return append([]string{}, li.list...), nil
+}
+
+// expiring returns copy of item with expiry
+func (li listItem) expiring(expiry time.Time) item {
+	li.expiry = expiry
+	return li
+}
+
+// owned returns copy of item owned by owner
+func (li listItem) owned(owner string) item {
+	li.owner = owner
+	return li
+}
+
 // dictItem is a strings to strings map item
 type dictItem struct {
 	baseItem
@@ -114,3 +189,24 @@ func (di dictItem) dictValue(k string) (string, error) {
 	}
 	return v, nil
 }
+
+// dictValues returns copy of dictItem values
+func (di dictItem) dictValues() (map[string]string, error) {
+	dict := make(map[string]string, len(di.dict))
+	for k, v := range di.dict {
+		dict[k] = v
+	}
+	return dict, nil
+}
+
+// expiring returns copy of item with expiry
+func (di dictItem) expiring(expiry time.Time) item {
+	di.expiry = expiry
+	return di
+}
+
+// owned returns copy of item owned by owner
+func (di dictItem) owned(owner string) item {
+	di.owner = owner
+	return di
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"strconv"
+	"time"
+)
+
+// GetVersion returns value and version by key. Version grows on every modification of item, even if value and expiry
+// are the same. Errors if key is not exists or item is not keyItem
+func (s *store) GetVersion(key string) (string, uint64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return "", 0, ErrStoreClosed
+	}
+	i, err := s.lookup(key, keyItemType)
+	if err != nil {
+		return "", 0, err
+	}
+	ki, ok := i.(keyItem)
+	if !ok {
+		return "", 0, ErrNotKeyItem
+	}
+	return ki.value, ki.version, nil
+}
+
+// Add sets value by key with time to live ttl if key is not exists. Errors with ErrKeyExists if item of any type
+// exists
+func (s *store) Add(key string, value string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if _, err := s.get(key); err == nil {
+		return ErrKeyExists
+	}
+	i := newKeyItem(value, s.expiry(ttl))
+	return s.set(key, i)
+}
+
+// Replace sets value by key with time to live ttl if key exists. Errors if key is not exists or item is not keyItem
+func (s *store) Replace(key string, value string, ttl time.Duration) error {
+	return s.updateKey(key, func(ki keyItem) (keyItem, error) {
+		return newKeyItem(value, s.expiry(ttl)), nil
+	})
+}
+
+// Append appends value to value by key keeping expiry. Errors if key is not exists or item is not keyItem
+func (s *store) Append(key string, value string) error {
+	return s.updateKey(key, func(ki keyItem) (keyItem, error) {
+		return newKeyItem(ki.value+value, ki.expiry), nil
+	})
+}
+
+// Prepend prepends value to value by key keeping expiry. Errors if key is not exists or item is not keyItem
+func (s *store) Prepend(key string, value string) error {
+	return s.updateKey(key, func(ki keyItem) (keyItem, error) {
+		return newKeyItem(value+ki.value, ki.expiry), nil
+	})
+}
+
+// CompareAndSet sets value by key with time to live ttl if item version equals version. Errors if key is not exists,
+// item is not keyItem or with ErrVersionMismatch
+func (s *store) CompareAndSet(key string, value string, ttl time.Duration, version uint64) error {
+	return s.updateKey(key, func(ki keyItem) (keyItem, error) {
+		if ki.version != version {
+			return keyItem{}, ErrVersionMismatch
+		}
+		return newKeyItem(value, s.expiry(ttl)), nil
+	})
+}
+
+// Incr increments unsigned 64-bit decimal value by key by delta keeping expiry, wrapping around on overflow. Returns
+// incremented value. Errors if key is not exists, item is not keyItem or with ErrNotNumber
+func (s *store) Incr(key string, delta uint64) (uint64, error) {
+	return s.add(key, func(n uint64) uint64 {
+		return n + delta
+	})
+}
+
+// Decr decrements unsigned 64-bit decimal value by key by delta keeping expiry, value doesn't go below zero. Returns
+// decremented value. Errors if key is not exists, item is not keyItem or with ErrNotNumber
+func (s *store) Decr(key string, delta uint64) (uint64, error) {
+	return s.add(key, func(n uint64) uint64 {
+		if n < delta {
+			return 0
+		}
+		return n - delta
+	})
+}
+
+// add replaces unsigned 64-bit decimal value by key with result of f keeping expiry. Returns new value
+func (s *store) add(key string, f func(n uint64) uint64) (uint64, error) {
+	var n uint64
+	err := s.updateKey(key, func(ki keyItem) (keyItem, error) {
+		old, err := strconv.ParseUint(ki.value, 10, 64)
+		if err != nil {
+			return keyItem{}, ErrNotNumber
+		}
+		n = f(old)
+		return newKeyItem(strconv.FormatUint(n, 10), ki.expiry), nil
+	})
+	return n, err
+}
+
+// updateKey replaces keyItem by key with result of f. Errors if key is not exists, item is not keyItem or f errors
+func (s *store) updateKey(key string, f func(ki keyItem) (keyItem, error)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.writable(); err != nil {
+		return err
+	}
+	i, err := s.get(key)
+	if err != nil {
+		return err
+	}
+	ki, ok := i.(keyItem)
+	if !ok {
+		return ErrNotKeyItem
+	}
+	if ki, err = f(ki); err != nil {
+		return err
+	}
+	return s.set(key, ki)
+}
@@ -0,0 +1,184 @@
+package store
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("store key operations", func() {
+	var (
+		c testClock
+		s *store
+		v uint64
+	)
+	BeforeEach(func() {
+		c = testClock(time.Now())
+		v = uint64(c.now().UnixNano())
+		d := &testDumper{}
+		si, err := NewStore(Params{
+			CleaningPeriod: 100 * time.Millisecond,
+			DumpingPeriod:  60 * time.Second,
+		}, c, d)
+		Expect(err).ToNot(HaveOccurred())
+		s = si.(*store)
+	})
+	AfterEach(func() {
+		s.Close(false)
+	})
+	Describe("GetVersion", func() {
+		Specify("not existed item error", func() {
+			_, _, err := s.GetVersion("a")
+			Expect(err).To(MatchError(ErrKeyNotExists))
+		})
+		Specify("not key item error", func() {
+			s.items["a"] = newListItem(nil, c.now().Add(time.Minute))
+			_, _, err := s.GetVersion("a")
+			Expect(err).To(MatchError(ErrNotKeyItem))
+		})
+		Specify("succeeds", func() {
+			i := newKeyItem("v", c.now().Add(time.Minute))
+			s.items["a"] = i
+			v, version, err := s.GetVersion("a")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v).To(Equal("v"))
+			Expect(version).To(Equal(i.version))
+		})
+	})
+	Describe("Add", func() {
+		Specify("creating new key", func() {
+			Expect(s.Add("a", "v", time.Minute)).To(Succeed())
+			Expect(s.items["a"]).To(Equal(newKeyItem("v", c.now().Add(time.Minute)).versioned(v)))
+		})
+		Specify("creating new key over expired item", func() {
+			s.items["a"] = newListItem(nil, c.now())
+			Expect(s.Add("a", "v", time.Minute)).To(Succeed())
+			Expect(s.items["a"]).To(Equal(newKeyItem("v", c.now().Add(time.Minute)).versioned(v)))
+		})
+		Specify("existed item error", func() {
+			s.items["a"] = newListItem(nil, c.now().Add(time.Minute))
+			Expect(s.Add("a", "v", time.Minute)).To(MatchError(ErrKeyExists))
+			Expect(s.items["a"]).To(Equal(newListItem(nil, c.now().Add(time.Minute))))
+		})
+	})
+	Describe("Replace", func() {
+		Specify("not existed item error", func() {
+			Expect(s.Replace("a", "v", time.Minute)).To(MatchError(ErrKeyNotExists))
+			Expect(s.items).To(BeEmpty())
+		})
+		Specify("not key item error", func() {
+			s.items["a"] = newListItem(nil, c.now().Add(time.Minute))
+			Expect(s.Replace("a", "v", time.Minute)).To(MatchError(ErrNotKeyItem))
+		})
+		Specify("succeeds", func() {
+			s.items["a"] = newKeyItem("old", c.now().Add(time.Minute))
+			Expect(s.Replace("a", "v", time.Second)).To(Succeed())
+			Expect(s.items["a"]).To(Equal(newKeyItem("v", c.now().Add(time.Second)).versioned(v)))
+		})
+	})
+	Describe("Append and Prepend", func() {
+		Specify("not existed item error", func() {
+			Expect(s.Append("a", "v")).To(MatchError(ErrKeyNotExists))
+			Expect(s.Prepend("a", "v")).To(MatchError(ErrKeyNotExists))
+		})
+		Specify("keep expiry", func() {
+			s.items["a"] = newKeyItem("b", c.now().Add(time.Minute))
+			Expect(s.Append("a", "c")).To(Succeed())
+			Expect(s.Prepend("a", "a")).To(Succeed())
+			Expect(s.items["a"]).To(Equal(newKeyItem("abc", c.now().Add(time.Minute)).versioned(v + 1)))
+		})
+	})
+	Describe("CompareAndSet", func() {
+		Specify("not existed item error", func() {
+			Expect(s.CompareAndSet("a", "v", time.Minute, 1)).To(MatchError(ErrKeyNotExists))
+		})
+		Specify("version mismatch error", func() {
+			i := newKeyItem("old", c.now().Add(time.Minute))
+			s.items["a"] = i
+			Expect(s.CompareAndSet("a", "v", time.Second, i.version+1)).To(MatchError(ErrVersionMismatch))
+			Expect(s.items["a"]).To(Equal(i))
+		})
+		Specify("succeeds", func() {
+			i := newKeyItem("old", c.now().Add(time.Minute))
+			s.items["a"] = i
+			Expect(s.CompareAndSet("a", "v", time.Second, i.version)).To(Succeed())
+			Expect(s.items["a"]).To(Equal(newKeyItem("v", c.now().Add(time.Second)).versioned(v)))
+		})
+		Specify("stale version of same value error", func() {
+			Expect(s.Add("a", "v", time.Minute)).To(Succeed())
+			_, version, err := s.GetVersion("a")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Replace("a", "v", time.Minute)).To(Succeed())
+			Expect(s.CompareAndSet("a", "v", time.Minute, version)).To(MatchError(ErrVersionMismatch))
+			Expect(s.Remove("a")).To(Succeed())
+			Expect(s.Add("a", "v", time.Minute)).To(Succeed())
+			Expect(s.CompareAndSet("a", "v", time.Minute, version)).To(MatchError(ErrVersionMismatch))
+		})
+	})
+	Describe("Incr and Decr", func() {
+		Specify("not existed item error", func() {
+			_, err := s.Incr("a", 1)
+			Expect(err).To(MatchError(ErrKeyNotExists))
+			_, err = s.Decr("a", 1)
+			Expect(err).To(MatchError(ErrKeyNotExists))
+		})
+		Specify("not number error", func() {
+			s.items["a"] = newKeyItem("-1", c.now().Add(time.Minute))
+			_, err := s.Incr("a", 1)
+			Expect(err).To(MatchError(ErrNotNumber))
+			_, err = s.Decr("a", 1)
+			Expect(err).To(MatchError(ErrNotNumber))
+		})
+		Specify("keep expiry", func() {
+			s.items["a"] = newKeyItem("10", c.now().Add(time.Minute))
+			Expect(s.Incr("a", 5)).To(BeEquivalentTo(15))
+			Expect(s.Decr("a", 3)).To(BeEquivalentTo(12))
+			Expect(s.items["a"]).To(Equal(newKeyItem("12", c.now().Add(time.Minute)).versioned(v + 1)))
+		})
+		Specify("increment wraps around", func() {
+			s.items["a"] = newKeyItem("18446744073709551615", c.now().Add(time.Minute))
+			Expect(s.Incr("a", 2)).To(BeEquivalentTo(1))
+		})
+		Specify("decrement doesn't go below zero", func() {
+			s.items["a"] = newKeyItem("2", c.now().Add(time.Minute))
+			Expect(s.Decr("a", 3)).To(BeEquivalentTo(0))
+		})
+	})
+	Specify("read only store errors", func() {
+		s.params.ReadOnly = true
+		s.items["a"] = newKeyItem("1", c.now().Add(time.Minute))
+		Expect(s.Add("b", "v", time.Minute)).To(MatchError(ErrReadOnly))
+		Expect(s.Replace("a", "v", time.Minute)).To(MatchError(ErrReadOnly))
+		Expect(s.Append("a", "v")).To(MatchError(ErrReadOnly))
+		_, err := s.Incr("a", 1)
+		Expect(err).To(MatchError(ErrReadOnly))
+		Expect(s.items).To(Equal(items{"a": newKeyItem("1", c.now().Add(time.Minute))}))
+	})
+	Specify("versions are kept by export and import", func() {
+		Expect(s.Add("a", "v", time.Minute)).To(Succeed())
+		_, version, err := s.GetVersion("a")
+		Expect(err).ToNot(HaveOccurred())
+		var b bytes.Buffer
+		Expect(s.Export(&b)).To(Succeed())
+		Expect(s.Flush()).To(Succeed())
+		s.version = 0
+		Expect(s.Import(&b)).To(Equal(1))
+		_, imported, err := s.GetVersion("a")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(imported).To(Equal(version))
+		Expect(s.Set("b", "v", time.Minute)).To(Succeed())
+		_, next, err := s.GetVersion("b")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(next).To(BeNumerically(">", version))
+	})
+	Specify("updates are logged as sets", func() {
+		s.replication.subscribed = true
+		s.Add("a", "1", time.Minute)
+		s.Incr("a", 1)
+		Expect(s.replication.log).To(HaveLen(2))
+		Expect(s.replication.log[1].rec).To(Equal(record{Key: "a", Type: keyItemType,
+			Expiry: c.now().Add(time.Minute).UnixNano(), Value: "2", Version: v + 1}))
+	})
+})
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// itemTypes is a size of arrays indexed by item type
+const itemTypes = dictItemType + 1
+
+// typeOf returns type of item i
+func typeOf(i item) itemType {
+	switch i.(type) {
+	case keyItem:
+		return keyItemType
+	case listItem:
+		return listItemType
+	case dictItem:
+		return dictItemType
+	}
+	return 0
+}
+
+// TypeMetrics is a metrics of items of one type
+type TypeMetrics struct {
+	// Items is items count, expired items are counted until they are cleaned
+	Items int
+
+	// Hits and Misses are counts of reads by key finding and not finding item of type. Read of item of other type is
+	// a miss
+	Hits   uint64
+	Misses uint64
+}
+
+// Metrics is a store metrics. Counters are counted since store construction
+type Metrics struct {
+	Keys  TypeMetrics
+	Lists TypeMetrics
+	Dicts TypeMetrics
+
+	// Bytes is rough estimate of memory used by items, same as Usage
+	Bytes int64
+
+	// Expired is count of expired items removed by cleaning
+	Expired uint64
+
+	// Cleanings and CleaningTime are count and total duration of cleaning sweeps
+	Cleanings    uint64
+	CleaningTime time.Duration
+
+	// Dumps, DumpFailures and DumpTime are count of dumps, count of failed ones and total duration of dumps
+	Dumps        uint64
+	DumpFailures uint64
+	DumpTime     time.Duration
+
+	// Dump is dumping status
+	Dump DumpStatus
+}
+
+// counters are store metrics counters. They are updated atomically, so reads under read lock count without write
+// lock
+type counters struct {
+	hits         [itemTypes]uint64
+	misses       [itemTypes]uint64
+	expired      uint64
+	cleanings    uint64
+	cleaningTime uint64
+	dumps        uint64
+	dumpFailures uint64
+	dumpTime     uint64
+}
+
+// Metrics returns store metrics
+func (s *store) Metrics() Metrics {
+	s.mutex.RLock()
+	m := Metrics{Bytes: s.usage.Bytes, Dump: s.dumpStatus}
+	counts := s.counts
+	s.mutex.RUnlock()
+	c := s.counters
+	for t, tm := range map[itemType]*TypeMetrics{keyItemType: &m.Keys, listItemType: &m.Lists, dictItemType: &m.Dicts} {
+		tm.Items = counts[t]
+		tm.Hits = atomic.LoadUint64(&c.hits[t])
+		tm.Misses = atomic.LoadUint64(&c.misses[t])
+	}
+	m.Expired = atomic.LoadUint64(&c.expired)
+	m.Cleanings = atomic.LoadUint64(&c.cleanings)
+	m.CleaningTime = time.Duration(atomic.LoadUint64(&c.cleaningTime))
+	m.Dumps = atomic.LoadUint64(&c.dumps)
+	m.DumpFailures = atomic.LoadUint64(&c.dumpFailures)
+	m.DumpTime = time.Duration(atomic.LoadUint64(&c.dumpTime))
+	return m
+}
+
+// lookup returns not expired item by key as get does, counting hit or miss of item type t. Must be called under lock
+func (s *store) lookup(key string, t itemType) (item, error) {
+	i, err := s.get(key)
+	if err != nil || typeOf(i) != t {
+		atomic.AddUint64(&s.counters.misses[t], 1)
+	} else {
+		atomic.AddUint64(&s.counters.hits[t], 1)
+	}
+	return i, err
+}
+
+// countCleaning counts cleaning sweep of duration d removed expired items
+func (c *counters) countCleaning(d time.Duration, expired int) {
+	atomic.AddUint64(&c.cleanings, 1)
+	atomic.AddUint64(&c.cleaningTime, uint64(d))
+	atomic.AddUint64(&c.expired, uint64(expired))
+}
+
+// countDump counts dump of duration d, failed if err is not nil
+func (c *counters) countDump(d time.Duration, err error) {
+	atomic.AddUint64(&c.dumps, 1)
+	atomic.AddUint64(&c.dumpTime, uint64(d))
+	if err != nil {
+		atomic.AddUint64(&c.dumpFailures, 1)
+	}
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"regexp"
+)
+
+// DefaultNamespace is a namespace of accounts not bound to namespace
+const DefaultNamespace = ""
+
+// namespaceRegexp is a valid namespace name, which is safe to use in dump file name
+var namespaceRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidateNamespace validates namespace name. Name must be 1-64 latin letters, digits, "_" or "-"
+func ValidateNamespace(name string) error {
+	if !namespaceRegexp.MatchString(name) {
+		return ErrInvalidNamespace.detailed(`"` + name + `" must be 1-64 latin letters, digits, "_" or "-"`)
+	}
+	return nil
+}
+
+// NamespaceDumpPath returns dump file path of namespace for dump file path of default namespace
+func NamespaceDumpPath(path string, name string) string {
+	if name == DefaultNamespace {
+		return path
+	}
+	return path + "." + name
+}
+
+// Namespaces are stores of logical namespaces by name. Namespaces have separate items, keys, flushing, cleaning and
+// dumping. Default namespace is a main store
+type Namespaces map[string]Store
+
+// Namespace returns store of namespace. Errors if namespace is not exists
+func (n Namespaces) Namespace(name string) (Store, error) {
+	s, exists := n[name]
+	if !exists {
+		return nil, ErrNamespaceNotExists
+	}
+	return s, nil
+}
+
+// Close closes stores of all namespaces except default, dumping them if dump is true. Default namespace store is
+// closed by its owner. Returns first error
+func (n Namespaces) Close(dump bool) error {
+	var err error
+	for name, s := range n {
+		if name == DefaultNamespace {
+			continue
+		}
+		if cerr := s.Close(dump); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Namespaces", func() {
+	newStore := func() Store {
+		s, err := NewStore(Params{CleaningPeriod: 100 * time.Millisecond, DumpingPeriod: 60 * time.Second},
+			testClock(time.Now()), &testDumper{})
+		Expect(err).ToNot(HaveOccurred())
+		return s
+	}
+	Specify("validate namespace", func() {
+		Expect(ValidateNamespace("team-a_1")).To(Succeed())
+		Expect(ValidateNamespace("")).To(MatchError(ErrInvalidNamespace.detailed(
+			`"" must be 1-64 latin letters, digits, "_" or "-"`)))
+		Expect(ValidateNamespace("../a")).ToNot(Succeed())
+		Expect(ValidateNamespace("a.b")).ToNot(Succeed())
+	})
+	Specify("dump path", func() {
+		Expect(NamespaceDumpPath("./dump", DefaultNamespace)).To(Equal("./dump"))
+		Expect(NamespaceDumpPath("./dump", "a")).To(Equal("./dump.a"))
+	})
+	Specify("namespaces are separate", func() {
+		n := Namespaces{DefaultNamespace: newStore(), "a": newStore()}
+		defer n[DefaultNamespace].Close(false)
+		s, err := n.Namespace("a")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.Set("k", "v", time.Minute)).To(Succeed())
+		Expect(n[DefaultNamespace].Keys()).To(BeEmpty())
+		Expect(s.Keys()).To(ConsistOf("k"))
+		_, err = n.Namespace("b")
+		Expect(err).To(MatchError(ErrNamespaceNotExists))
+		Expect(n.Close(false)).To(Succeed())
+		Expect(s.Keys()).Error().To(MatchError(ErrStoreClosed))
+		Expect(n[DefaultNamespace].Keys()).To(BeEmpty())
+	})
+})
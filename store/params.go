@@ -12,6 +12,9 @@ type Params struct {
 
 	// DumpingPeriod determines how often store will be dumped to the disk
 	DumpingPeriod time.Duration
+
+	// ReadOnly determines if store rejects all mutations except replicated from primary
+	ReadOnly bool
 }
 
 // Validate validates store parameters
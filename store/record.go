@@ -0,0 +1,68 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// itemType is a store item type
+type itemType uint8
+
+const (
+	keyItemType itemType = iota + 1
+	listItemType
+	dictItemType
+)
+
+// String returns item type name
+func (t itemType) String() string {
+	switch t {
+	case keyItemType:
+		return "key"
+	case listItemType:
+		return "list"
+	case dictItemType:
+		return "dict"
+	}
+	return "unknown"
+}
+
+// record is a flat self-contained item representation used for item streaming
+type record struct {
+	Key     string
+	Type    itemType
+	Expiry  int64
+	Value   string
+	List    []string
+	Dict    map[string]string
+	Owner   string
+	Version uint64
+}
+
+// newRecord constructs record from item i stored by key. Returns error if item type is unknown
+func newRecord(key string, i item) (record, error) {
+	switch ii := i.(type) {
+	case keyItem:
+		return record{Key: key, Type: keyItemType, Expiry: ii.expiry.UnixNano(), Value: ii.value, Owner: ii.owner,
+			Version: ii.version}, nil
+	case listItem:
+		return record{Key: key, Type: listItemType, Expiry: ii.expiry.UnixNano(), List: ii.list, Owner: ii.owner}, nil
+	case dictItem:
+		return record{Key: key, Type: dictItemType, Expiry: ii.expiry.UnixNano(), Dict: ii.dict, Owner: ii.owner}, nil
+	}
+	return record{}, fmt.Errorf(`unknown type of item "%s"`, key)
+}
+
+// item constructs item from record. Returns error if record type is unknown
+func (r record) item() (item, error) {
+	expiry := time.Unix(0, r.Expiry)
+	switch r.Type {
+	case keyItemType:
+		return newKeyItem(r.Value, expiry).versioned(r.Version).owned(r.Owner), nil
+	case listItemType:
+		return newListItem(r.List, expiry).owned(r.Owner), nil
+	case dictItemType:
+		return newDictItem(r.Dict, expiry).owned(r.Owner), nil
+	}
+	return nil, fmt.Errorf(`unknown type %d of record "%s"`, r.Type, r.Key)
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("record", func() {
+	var t time.Time
+	BeforeEach(func() {
+		t = time.Unix(0, time.Now().UnixNano())
+	})
+	Describe("newRecord", func() {
+		Specify("unknown item error", func() {
+			_, err := newRecord("a", baseItem{expiry: t})
+			Expect(err).To(MatchError(`unknown type of item "a"`))
+		})
+		Specify("key item", func() {
+			r, err := newRecord("a", newKeyItem("v", t))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r).To(Equal(record{Key: "a", Type: keyItemType, Expiry: t.UnixNano(), Value: "v"}))
+		})
+		Specify("list item", func() {
+			r, err := newRecord("a", newListItem([]string{"v"}, t))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r).To(Equal(record{Key: "a", Type: listItemType, Expiry: t.UnixNano(), List: []string{"v"}}))
+		})
+		Specify("dict item", func() {
+			r, err := newRecord("a", newDictItem(map[string]string{"k": "v"}, t))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r).To(Equal(record{Key: "a", Type: dictItemType, Expiry: t.UnixNano(),
+				Dict: map[string]string{"k": "v"}}))
+		})
+	})
+	Describe("item", func() {
+		Specify("unknown type error", func() {
+			_, err := record{Key: "a", Type: 0}.item()
+			Expect(err).To(MatchError(`unknown type 0 of record "a"`))
+		})
+		Specify("key item", func() {
+			i, err := record{Key: "a", Type: keyItemType, Expiry: t.UnixNano(), Value: "v"}.item()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(i).To(beKeyItem(newKeyItem("v", t)))
+		})
+		Specify("list item", func() {
+			i, err := record{Key: "a", Type: listItemType, Expiry: t.UnixNano(), List: []string{"v"}}.item()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(i).To(beListItem(newListItem([]string{"v"}, t)))
+		})
+		Specify("dict item", func() {
+			i, err := record{Key: "a", Type: dictItemType, Expiry: t.UnixNano(), Dict: map[string]string{"k": "v"}}.item()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(i).To(beDictItem(newDictItem(map[string]string{"k": "v"}, t)))
+		})
+	})
+})
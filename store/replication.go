@@ -0,0 +1,406 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// replicationLogSize is max count of mutations kept in replication log
+	replicationLogSize = 10000
+
+	// replicationLogBytes is max rough estimate of memory used by mutations kept in replication log
+	replicationLogBytes = 64 << 20
+
+	// replicationHeartbeatPeriod is a period of heartbeats sent by idle replication stream
+	replicationHeartbeatPeriod = time.Second
+)
+
+// ReplicationPosition is a position in replication log
+type ReplicationPosition struct {
+	// ID is replication history identifier. It is generated on store construction and inherited by replica on restore
+	ID string `json:"id"`
+
+	// Seq is last mutation sequence number
+	Seq uint64 `json:"seq"`
+}
+
+// ReplicationStatus is a replication status
+type ReplicationStatus struct {
+	// ID is replication history identifier
+	ID string `yaml:"id" json:"id" msgpack:"id"`
+
+	// Seq is last logged or applied mutation sequence number
+	Seq uint64 `yaml:"seq" json:"seq" msgpack:"seq"`
+
+	// PrimarySeq is last known primary mutation sequence number, zero if store never replicated from primary
+	PrimarySeq uint64 `yaml:"primary_seq,omitempty" json:"primary_seq,omitempty" msgpack:"primary_seq,omitempty"`
+
+	// Lag is count of primary mutations not applied yet
+	Lag uint64 `yaml:"lag,omitempty" json:"lag,omitempty" msgpack:"lag,omitempty"`
+
+	// LastContact is time of last mutation or heartbeat received from primary
+	LastContact time.Time `yaml:"last_contact,omitempty" json:"last_contact,omitempty" msgpack:"last_contact,omitempty"`
+}
+
+// Position returns replication position of last logged or applied mutation
+func (rs ReplicationStatus) Position() ReplicationPosition {
+	return ReplicationPosition{ID: rs.ID, Seq: rs.Seq}
+}
+
+// mutationOp is a replicated mutation operation
+type mutationOp string
+
+const (
+	setOp       mutationOp = "set"
+	removeOp    mutationOp = "remove"
+	flushOp     mutationOp = "flush"
+	heartbeatOp mutationOp = "heartbeat"
+)
+
+// mutation is a replication log entry. Record is set for setOp, key is set for removeOp
+type mutation struct {
+	seq uint64
+	op  mutationOp
+	key string
+	rec record
+}
+
+// size returns rough estimate of memory used by mutation
+func (m mutation) size() int64 {
+	n := int64(len(m.key) + len(m.rec.Key) + len(m.rec.Value) + itemOverhead)
+	for _, v := range m.rec.List {
+		n += int64(len(v) + stringOverhead)
+	}
+	for k, v := range m.rec.Dict {
+		n += int64(len(k) + len(v) + 2*stringOverhead)
+	}
+	return n
+}
+
+// jsonMutation is mutation JSON representation. Key is base64 encoded if encoding is base64
+type jsonMutation struct {
+	Seq      uint64      `json:"seq"`
+	Op       string      `json:"op"`
+	Key      string      `json:"key,omitempty"`
+	Encoding string      `json:"encoding,omitempty"`
+	Record   *jsonRecord `json:"record,omitempty"`
+}
+
+// newJSONMutation constructs JSON representation of mutation m. Key is base64 encoded if it is not valid UTF-8
+func newJSONMutation(m mutation) (jsonMutation, error) {
+	jm := jsonMutation{Seq: m.seq, Op: string(m.op), Key: m.key}
+	if !utf8.ValidString(m.key) {
+		jm.Key, jm.Encoding = base64.StdEncoding.EncodeToString([]byte(m.key)), base64Encoding
+	}
+	if m.op == setOp {
+		jr, err := newJSONRecord(m.rec)
+		if err != nil {
+			return jsonMutation{}, err
+		}
+		jm.Record = &jr
+	}
+	return jm, nil
+}
+
+// mutation constructs mutation from its JSON representation. Returns error if operation is unknown or record is
+// invalid
+func (jm jsonMutation) mutation() (mutation, error) {
+	m := mutation{seq: jm.Seq, op: mutationOp(jm.Op), key: jm.Key}
+	switch jm.Encoding {
+	case "":
+	case base64Encoding:
+		key, err := base64.StdEncoding.DecodeString(jm.Key)
+		if err != nil {
+			return mutation{}, fmt.Errorf("invalid base64 key of mutation %d: %s", jm.Seq, err.Error())
+		}
+		m.key = string(key)
+	default:
+		return mutation{}, fmt.Errorf(`unknown encoding "%s" of mutation %d`, jm.Encoding, jm.Seq)
+	}
+	switch m.op {
+	case setOp:
+		if jm.Record == nil {
+			return mutation{}, fmt.Errorf("no record of set mutation %d", jm.Seq)
+		}
+		rec, err := jm.Record.record()
+		if err != nil {
+			return mutation{}, err
+		}
+		m.rec = rec
+	case removeOp, flushOp, heartbeatOp:
+	default:
+		return mutation{}, fmt.Errorf(`unknown operation "%s" of mutation %d`, jm.Op, jm.Seq)
+	}
+	return m, nil
+}
+
+// replication is a store replication state. Mutations are kept in log only since replica subscribed by snapshot or
+// replication stream, so store without replicas doesn't keep them. Must be accessed under store lock
+type replication struct {
+	id          string
+	seq         uint64
+	subscribed  bool
+	log         []mutation
+	logBytes    int64
+	notify      chan struct{}
+	primarySeq  uint64
+	lastContact time.Time
+}
+
+// newReplication constructs replication state with new random history identifier
+func newReplication() replication {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return replication{
+		id:     hex.EncodeToString(id),
+		notify: make(chan struct{}),
+	}
+}
+
+// append appends mutation m to log if replica subscribed, dropping oldest mutations if log is full by count or bytes,
+// and wakes up replication streams
+func (r *replication) append(m mutation) {
+	r.seq = m.seq
+	if r.subscribed {
+		r.log = append(r.log, m)
+		r.logBytes += m.size()
+		for len(r.log) > replicationLogSize || r.logBytes > replicationLogBytes {
+			r.logBytes -= r.log[0].size()
+			r.log[0] = mutation{}
+			r.log = r.log[1:]
+		}
+	}
+	close(r.notify)
+	r.notify = make(chan struct{})
+}
+
+// since returns copy of mutations logged after position from. Errors if mutations are not in log anymore or position
+// belongs to other replication history
+func (r *replication) since(from ReplicationPosition) ([]mutation, error) {
+	if from.ID != r.id || from.Seq > r.seq {
+		return nil, ErrReplicationLogTruncated
+	}
+	if from.Seq == r.seq {
+		return nil, nil
+	}
+	if len(r.log) == 0 || r.log[0].seq > from.Seq+1 {
+		return nil, ErrReplicationLogTruncated
+	}
+	return append([]mutation(nil), r.log[from.Seq+1-r.log[0].seq:]...), nil
+}
+
+// reset starts replication history from position pos with empty log and wakes up replication streams
+func (r *replication) reset(pos ReplicationPosition) {
+	r.id, r.seq, r.log, r.logBytes, r.primarySeq = pos.ID, pos.Seq, nil, 0, pos.Seq
+	close(r.notify)
+	r.notify = make(chan struct{})
+}
+
+// logSet logs set of item i by key. Must be called under lock
+func (s *store) logSet(key string, i item) {
+	rec, err := newRecord(key, i)
+	if err != nil {
+		return
+	}
+	s.replication.append(mutation{seq: s.replication.seq + 1, op: setOp, rec: rec})
+}
+
+// logRemove logs removing of item by key. Must be called under lock
+func (s *store) logRemove(key string) {
+	s.replication.append(mutation{seq: s.replication.seq + 1, op: removeOp, key: key})
+}
+
+// logFlush logs removing of all items. Must be called under lock
+func (s *store) logFlush() {
+	s.replication.append(mutation{seq: s.replication.seq + 1, op: flushOp})
+}
+
+// Snapshot writes replication position as first JSON line to w, then all not expired items as newline delimited JSON
+// records, same as Export. Replica restored from snapshot continues replication from written position, so mutations
+// are logged since first snapshot
+func (s *store) Snapshot(w io.Writer) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrStoreClosed
+	}
+	s.replication.subscribed = true
+	snapshot := s.snapshotItems()
+	pos := ReplicationPosition{ID: s.replication.id, Seq: s.replication.seq}
+	s.mutex.Unlock()
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(pos); err != nil {
+		return ErrFailToExportItems.detailed(err.Error())
+	}
+	return s.export(jsonRecordWriter{encoder}, snapshot)
+}
+
+// Replicate writes mutations logged after position from to w as newline delimited JSON, then waits for new
+// mutations and writes them too, sending heartbeats with current sequence number when idle. Returns nil when ctx is
+// done, ErrReplicationLogTruncated if mutations after from are not in log anymore
+func (s *store) Replicate(ctx context.Context, w io.Writer, from ReplicationPosition) error {
+	s.mutex.Lock()
+	s.replication.subscribed = true
+	s.mutex.Unlock()
+	encoder := json.NewEncoder(w)
+	heartbeat := time.NewTicker(s.heartbeatPeriod)
+	defer heartbeat.Stop()
+	for {
+		s.mutex.RLock()
+		if s.closed {
+			s.mutex.RUnlock()
+			return ErrStoreClosed
+		}
+		mutations, err := s.replication.since(from)
+		seq, notify := s.replication.seq, s.replication.notify
+		s.mutex.RUnlock()
+		if err != nil {
+			return err
+		}
+		for _, m := range mutations {
+			jm, err := newJSONMutation(m)
+			if err != nil {
+				return ErrFailToReplicate.detailed(err.Error())
+			}
+			if err := encoder.Encode(jm); err != nil {
+				return ErrFailToReplicate.detailed(err.Error())
+			}
+			from.Seq = m.seq
+		}
+		if len(mutations) > 0 {
+			continue
+		}
+		select {
+		case <-notify:
+		case <-heartbeat.C:
+			if err := encoder.Encode(jsonMutation{Seq: seq, Op: string(heartbeatOp)}); err != nil {
+				return ErrFailToReplicate.detailed(err.Error())
+			}
+		case <-ctx.Done():
+			return nil
+		case <-s.ctx.Done():
+			return ErrStoreClosed
+		}
+	}
+}
+
+// Restore reads primary's snapshot from r and replaces all items with its records, expired records are skipped. Then
+// replication continues from snapshot position. Restore is atomic: items are not changed on error
+func (s *store) Restore(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	var pos ReplicationPosition
+	if err := decoder.Decode(&pos); err != nil {
+		return ErrFailToRestoreItems.detailed("invalid position: " + err.Error())
+	}
+	rr := jsonRecordReader{decoder}
+	restored := items{}
+	for {
+		rec, err := rr.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return ErrFailToRestoreItems.detailed(err.Error())
+		}
+		i, err := rec.item()
+		if err != nil {
+			return ErrFailToRestoreItems.detailed(err.Error())
+		}
+		if i.expired(s.clock.now()) {
+			continue
+		}
+		restored[rec.Key] = i
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	s.reset(restored)
+	s.replication.reset(pos)
+	s.replication.lastContact = s.clock.now()
+	return nil
+}
+
+// Apply reads primary's replication stream from r and applies mutations until stream is over. Mutations must follow
+// current replication position without gaps
+func (s *store) Apply(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var jm jsonMutation
+		if err := decoder.Decode(&jm); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return ErrFailToApplyMutations.detailed(err.Error())
+		}
+		m, err := jm.mutation()
+		if err != nil {
+			return ErrFailToApplyMutations.detailed(err.Error())
+		}
+		if err := s.apply(m); err != nil {
+			return err
+		}
+	}
+}
+
+// ReplicationStatus returns replication status
+func (s *store) ReplicationStatus() ReplicationStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.replicationStatus()
+}
+
+// apply applies mutation m received from primary
+func (s *store) apply(m mutation) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	s.replication.lastContact = s.clock.now()
+	if m.seq > s.replication.primarySeq {
+		s.replication.primarySeq = m.seq
+	}
+	if m.op == heartbeatOp {
+		return nil
+	}
+	if m.seq != s.replication.seq+1 {
+		return ErrFailToApplyMutations.detailed(fmt.Sprintf("got mutation %d, expected %d", m.seq,
+			s.replication.seq+1))
+	}
+	switch m.op {
+	case setOp:
+		i, err := m.rec.item()
+		if err != nil {
+			return ErrFailToApplyMutations.detailed(err.Error())
+		}
+		s.put(m.rec.Key, i)
+	case removeOp:
+		s.drop(m.key)
+	case flushOp:
+		s.reset(items{})
+	}
+	s.replication.append(m)
+	return nil
+}
+
+// replicationStatus returns replication status. Must be called under lock
+func (s *store) replicationStatus() ReplicationStatus {
+	status := ReplicationStatus{
+		ID:          s.replication.id,
+		Seq:         s.replication.seq,
+		PrimarySeq:  s.replication.primarySeq,
+		LastContact: s.replication.lastContact,
+	}
+	if status.PrimarySeq > status.Seq {
+		status.Lag = status.PrimarySeq - status.Seq
+	}
+	return status
+}
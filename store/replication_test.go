@@ -0,0 +1,280 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("replication", func() {
+	var (
+		c       testClock
+		primary *store
+		replica *store
+	)
+	newTestStore := func(readOnly bool) *store {
+		s, err := NewStore(Params{
+			CleaningPeriod: 100 * time.Millisecond,
+			DumpingPeriod:  60 * time.Second,
+			ReadOnly:       readOnly,
+		}, c, &flakyDumper{})
+		Expect(err).ToNot(HaveOccurred())
+		return s.(*store)
+	}
+	BeforeEach(func() {
+		c = testClock(time.Now())
+		primary = newTestStore(false)
+		replica = newTestStore(true)
+		Expect(primary.Snapshot(ioutil.Discard)).To(Succeed())
+	})
+	AfterEach(func() {
+		primary.Close(false)
+		replica.Close(false)
+	})
+	Specify("new store has unique replication id", func() {
+		Expect(primary.replication.id).ToNot(BeEmpty())
+		Expect(primary.replication.id).ToNot(Equal(replica.replication.id))
+		Expect(primary.ReplicationStatus()).To(Equal(ReplicationStatus{ID: primary.replication.id}))
+	})
+	Specify("mutations are logged", func() {
+		exp := c.now().Add(time.Second)
+		primary.Set("a", "v", time.Second)
+		primary.ListSet("b", []string{"l"}, time.Second)
+		primary.DictSet("c", map[string]string{"dk": "dv"}, time.Second)
+		primary.Remove("a")
+		primary.Remove("not exists")
+		primary.Flush()
+		primary.Import(strings.NewReader(`{"key":"d","type":"key","value":"v","expiry":"2100-01-01T00:00:00Z"}`))
+		Expect(primary.replication.log).To(Equal([]mutation{
+			{seq: 1, op: setOp, rec: record{Key: "a", Type: keyItemType, Expiry: exp.UnixNano(), Value: "v",
+				Version: uint64(c.now().UnixNano())}},
+			{seq: 2, op: setOp, rec: record{Key: "b", Type: listItemType, Expiry: exp.UnixNano(), List: []string{"l"}}},
+			{seq: 3, op: setOp, rec: record{Key: "c", Type: dictItemType, Expiry: exp.UnixNano(),
+				Dict: map[string]string{"dk": "dv"}}},
+			{seq: 4, op: removeOp, key: "a"},
+			{seq: 5, op: flushOp},
+			{seq: 6, op: setOp, rec: record{Key: "d", Type: keyItemType,
+				Expiry: time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano(), Value: "v"}},
+		}))
+		Expect(primary.ReplicationStatus().Seq).To(BeEquivalentTo(6))
+	})
+	Specify("mutations are not logged until replica subscribes", func() {
+		s := newTestStore(false)
+		defer s.Close(false)
+		s.Set("a", "v", time.Second)
+		Expect(s.replication.log).To(BeEmpty())
+		Expect(s.ReplicationStatus().Seq).To(BeEquivalentTo(1))
+		_, err := s.replication.since(ReplicationPosition{ID: s.replication.id})
+		Expect(err).To(MatchError(ErrReplicationLogTruncated))
+
+		By("logged since snapshot")
+		var b bytes.Buffer
+		Expect(s.Snapshot(&b)).To(Succeed())
+		s.Set("b", "v", time.Second)
+		mutations, err := s.replication.since(ReplicationPosition{ID: s.replication.id, Seq: 1})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mutations).To(HaveLen(1))
+		Expect(mutations[0].rec.Key).To(Equal("b"))
+	})
+	Specify("item updates are logged as sets", func() {
+		exp := c.now().Add(time.Second)
+		primary.ListPush("a", []string{"l"}, time.Second)
+		primary.DictUpdate("b", map[string]string{"dk": "dv"}, time.Second)
+		primary.Expire("a", 2*time.Second)
+		Expect(primary.replication.log).To(Equal([]mutation{
+			{seq: 1, op: setOp, rec: record{Key: "a", Type: listItemType, Expiry: exp.UnixNano(), List: []string{"l"}}},
+			{seq: 2, op: setOp, rec: record{Key: "b", Type: dictItemType, Expiry: exp.UnixNano(),
+				Dict: map[string]string{"dk": "dv"}}},
+			{seq: 3, op: setOp, rec: record{Key: "a", Type: listItemType, Expiry: exp.Add(time.Second).UnixNano(),
+				List: []string{"l"}}},
+		}))
+	})
+	Specify("log keeps last mutations", func() {
+		for i := 0; i < replicationLogSize+10; i++ {
+			primary.Set("a", "v", time.Second)
+		}
+		Expect(primary.replication.log).To(HaveLen(replicationLogSize))
+		Expect(primary.replication.log[0].seq).To(BeEquivalentTo(11))
+		_, err := primary.replication.since(ReplicationPosition{ID: primary.replication.id, Seq: 9})
+		Expect(err).To(MatchError(ErrReplicationLogTruncated))
+		mutations, err := primary.replication.since(ReplicationPosition{ID: primary.replication.id, Seq: 10})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mutations).To(HaveLen(replicationLogSize))
+	})
+	Specify("log keeps last mutations up to bytes limit", func() {
+		value := strings.Repeat("v", replicationLogBytes/4)
+		for i := 0; i < 5; i++ {
+			primary.Set("a", value, time.Second)
+		}
+		Expect(primary.replication.log).To(HaveLen(3))
+		Expect(primary.replication.log[0].seq).To(BeEquivalentTo(3))
+		Expect(primary.replication.logBytes).To(BeNumerically("<=", replicationLogBytes))
+		Expect(primary.replication.logBytes).To(Equal(3 * primary.replication.log[0].size()))
+	})
+	Describe("since", func() {
+		BeforeEach(func() {
+			primary.Set("a", "v", time.Second)
+			primary.Set("b", "v", time.Second)
+		})
+		Specify("other id error", func() {
+			_, err := primary.replication.since(ReplicationPosition{ID: "other", Seq: 1})
+			Expect(err).To(MatchError(ErrReplicationLogTruncated))
+		})
+		Specify("future seq error", func() {
+			_, err := primary.replication.since(ReplicationPosition{ID: primary.replication.id, Seq: 3})
+			Expect(err).To(MatchError(ErrReplicationLogTruncated))
+		})
+		Specify("succeeds", func() {
+			mutations, err := primary.replication.since(ReplicationPosition{ID: primary.replication.id, Seq: 1})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mutations).To(HaveLen(1))
+			Expect(mutations[0].rec.Key).To(Equal("b"))
+		})
+		Specify("when up to date", func() {
+			mutations, err := primary.replication.since(ReplicationPosition{ID: primary.replication.id, Seq: 2})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mutations).To(BeEmpty())
+		})
+	})
+	Specify("read only store errors", func() {
+		Expect(replica.Set("a", "v", time.Second)).To(MatchError(ErrReadOnly))
+		Expect(replica.ListSet("a", nil, time.Second)).To(MatchError(ErrReadOnly))
+		Expect(replica.DictSet("a", nil, time.Second)).To(MatchError(ErrReadOnly))
+		Expect(replica.Remove("a")).To(MatchError(ErrReadOnly))
+		Expect(replica.Expire("a", time.Second)).To(MatchError(ErrReadOnly))
+		_, err := replica.ListPush("a", nil, time.Second)
+		Expect(err).To(MatchError(ErrReadOnly))
+		_, err = replica.DictUpdate("a", nil, time.Second)
+		Expect(err).To(MatchError(ErrReadOnly))
+		Expect(replica.Flush()).To(MatchError(ErrReadOnly))
+		_, err = replica.Import(strings.NewReader(`{"key":"d","type":"key","value":"v","expiry":"2100-01-01T00:00:00Z"}`))
+		Expect(err).To(MatchError(ErrReadOnly))
+		Expect(replica.items).To(BeEmpty())
+		Expect(replica.replication.log).To(BeEmpty())
+	})
+	Describe("Restore", func() {
+		Specify("invalid position error", func() {
+			err := replica.Restore(strings.NewReader(`[]`))
+			Expect(err).To(BeAssignableToTypeOf(StoreError{}))
+			Expect(err.(StoreError).Code).To(Equal(ErrFailToRestoreItems.Code))
+		})
+		Specify("invalid record error keeps items", func() {
+			replica.items["a"] = newKeyItem("v", c.now().Add(time.Second))
+			err := replica.Restore(strings.NewReader(`{"id":"id","seq":1}` + "\n" + `{"key":"b","type":"unknown"}`))
+			Expect(err).To(MatchError(ErrFailToRestoreItems.detailed(`unknown type "unknown" of record "b"`)))
+			Expect(replica.items).To(HaveLen(1))
+			Expect(replica.ReplicationStatus().ID).ToNot(Equal("id"))
+		})
+		Specify("restores snapshot", func() {
+			replica.items["old"] = newKeyItem("v", c.now().Add(time.Second))
+			primary.Set("a", "v", time.Second)
+			primary.ListSet("b", []string{"l"}, time.Second)
+			var b bytes.Buffer
+			Expect(primary.Snapshot(&b)).To(Succeed())
+			Expect(b.String()).To(HavePrefix(`{"id":"` + primary.replication.id + `","seq":2}` + "\n"))
+			Expect(replica.Restore(&b)).To(Succeed())
+			Expect(replica.items).To(HaveLen(2))
+			Expect(replica.Get("a")).To(Equal("v"))
+			Expect(replica.ListGet("b", 0)).To(Equal("l"))
+			Expect(replica.ReplicationStatus()).To(Equal(ReplicationStatus{
+				ID:          primary.replication.id,
+				Seq:         2,
+				PrimarySeq:  2,
+				LastContact: c.now(),
+			}))
+		})
+	})
+	Describe("Apply", func() {
+		Specify("out of order mutation error", func() {
+			err := replica.Apply(strings.NewReader(`{"seq":2,"op":"flush"}`))
+			Expect(err).To(MatchError(ErrFailToApplyMutations.detailed("got mutation 2, expected 1")))
+			Expect(replica.ReplicationStatus().Lag).To(BeEquivalentTo(2))
+		})
+		Specify("unknown operation error", func() {
+			err := replica.Apply(strings.NewReader(`{"seq":1,"op":"unknown"}`))
+			Expect(err).To(MatchError(ErrFailToApplyMutations.detailed(`unknown operation "unknown" of mutation 1`)))
+		})
+		Specify("heartbeat updates lag", func() {
+			Expect(replica.Apply(strings.NewReader(`{"seq":5,"op":"heartbeat"}`))).To(Succeed())
+			status := replica.ReplicationStatus()
+			Expect(status.PrimarySeq).To(BeEquivalentTo(5))
+			Expect(status.Lag).To(BeEquivalentTo(5))
+			Expect(status.LastContact).To(Equal(c.now()))
+		})
+	})
+	Specify("mutations of keys not valid UTF-8", func() {
+		primary.Set("\xff", "\xfe", time.Second)
+		primary.Remove("\xff")
+		mutations, err := primary.replication.since(ReplicationPosition{ID: primary.replication.id})
+		Expect(err).ToNot(HaveOccurred())
+		var b bytes.Buffer
+		for _, m := range mutations {
+			jm, err := newJSONMutation(m)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(json.NewEncoder(&b).Encode(jm)).To(Succeed())
+		}
+		Expect(b.String()).To(ContainSubstring(`{"seq":2,"op":"remove","key":"/w==","encoding":"base64"}`))
+		decoder := json.NewDecoder(&b)
+		for _, m := range mutations {
+			var jm jsonMutation
+			Expect(decoder.Decode(&jm)).To(Succeed())
+			Expect(jm.mutation()).To(Equal(m))
+		}
+		_, err = jsonMutation{Seq: 1, Op: "remove", Key: "a", Encoding: "hex"}.mutation()
+		Expect(err).To(MatchError(`unknown encoding "hex" of mutation 1`))
+	})
+	Specify("Replicate truncated log error", func() {
+		err := primary.Replicate(context.Background(), ioutil.Discard, ReplicationPosition{ID: "other"})
+		Expect(err).To(MatchError(ErrReplicationLogTruncated))
+	})
+	Specify("streaming replication", func() {
+		primary.heartbeatPeriod = 10 * time.Millisecond
+		primary.Set("a", "v", time.Second)
+		var b bytes.Buffer
+		Expect(primary.Snapshot(&b)).To(Succeed())
+		Expect(replica.Restore(&b)).To(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		pr, pw := io.Pipe()
+		replicated := make(chan error, 1)
+		go func() {
+			replicated <- primary.Replicate(ctx, pw, replica.ReplicationStatus().Position())
+			pw.Close()
+		}()
+		applied := make(chan error, 1)
+		go func() {
+			applied <- replica.Apply(pr)
+		}()
+
+		primary.ListSet("b", []string{"l"}, time.Second)
+		primary.DictSet("c", map[string]string{"dk": "dv"}, time.Second)
+		primary.Remove("a")
+		Eventually(func() uint64 { return replica.ReplicationStatus().Seq }).Should(BeEquivalentTo(4))
+		Expect(replica.Keys()).To(ConsistOf("b", "c"))
+		Expect(replica.DictGet("c", "dk")).To(Equal("dv"))
+
+		By("heartbeats when idle")
+		contact := c.now().Add(time.Minute)
+		replica.mutex.Lock()
+		replica.clock = testClock(contact)
+		replica.mutex.Unlock()
+		Eventually(func() time.Time { return replica.ReplicationStatus().LastContact }).Should(Equal(contact))
+		Expect(replica.ReplicationStatus().Lag).To(BeZero())
+
+		By("flushing")
+		primary.Flush()
+		Eventually(func() uint64 { return replica.ReplicationStatus().Seq }).Should(BeEquivalentTo(5))
+		Expect(replica.Keys()).To(BeEmpty())
+
+		cancel()
+		Eventually(replicated).Should(Receive(BeNil()))
+		Eventually(applied).Should(Receive(BeNil()))
+	})
+})
@@ -1,6 +1,8 @@
 package store
 
 import (
+	"context"
+	"io"
 	"sync"
 	"time"
 )
@@ -8,28 +10,100 @@ import (
 // Store is memory store
 type Store interface {
 	Get(key string) (string, error)
-	Set(key string, value string, ttl time.Duration)
+	Set(key string, value string, ttl time.Duration) error
+	GetVersion(key string) (string, uint64, error)
+	Add(key string, value string, ttl time.Duration) error
+	Replace(key string, value string, ttl time.Duration) error
+	Append(key string, value string) error
+	Prepend(key string, value string) error
+	CompareAndSet(key string, value string, ttl time.Duration, version uint64) error
+	Incr(key string, delta uint64) (uint64, error)
+	Decr(key string, delta uint64) (uint64, error)
 	ListGet(key string, index int) (string, error)
-	ListSet(key string, list []string, ttl time.Duration)
+	ListSet(key string, list []string, ttl time.Duration) error
 	DictGet(key string, dkey string) (string, error)
-	DictSet(key string, dict map[string]string, ttl time.Duration)
+	DictSet(key string, dict map[string]string, ttl time.Duration) error
+	ListGetAll(key string) ([]string, error)
+	ListPush(key string, values []string, ttl time.Duration) (int, error)
+	DictGetAll(key string) (map[string]string, error)
+	DictUpdate(key string, dict map[string]string, ttl time.Duration) (int, error)
+	TTL(key string) (time.Duration, error)
+	Expire(key string, ttl time.Duration) error
 	Remove(key string) error
-	Keys() []string
+	KeyRemove(key string) error
+	ListRemove(key string) error
+	DictRemove(key string) error
+	Keys() ([]string, error)
+	Export(w io.Writer) error
+	Import(r io.Reader) (int, error)
+	Flush() error
+	Dump() (DumpStatus, error)
+	DumpStatus() DumpStatus
+	OnDumpError(hook func(err error))
+	Checkpoint() (Checkpoint, error)
+	Load(r io.Reader) error
+	Info() Info
+	Usage() Usage
+	UsageOf(login string) Usage
+	As(login string, q Quota) Store
+	Metrics() Metrics
 	StartCleaning() error
 	StopCleaning() error
 	StartDumping() error
 	StopDumping() error
+	Snapshot(w io.Writer) error
+	Replicate(ctx context.Context, w io.Writer, from ReplicationPosition) error
+	Restore(r io.Reader) error
+	Apply(r io.Reader) error
+	ReplicationStatus() ReplicationStatus
+	Close(dump bool) error
 }
 
-// store is a store implementation
+// TimedStore is a store which mutations can be applied at given time instead of clock's now, so mutations replicated
+// with their time are applied same way on all replicas
+type TimedStore interface {
+	Store
+
+	// At returns view of store sharing its items, which mutations, expiry checks and cleaning are done at time now
+	At(now time.Time) TimedStore
+
+	// Clean removes all expired items
+	Clean()
+}
+
+// store is a store implementation. Views of store made by At and As share state and differ by clock, owner and quota
 type store struct {
-	mutex    sync.RWMutex
-	params   Params
-	clock    Clock
-	dumper   Dumper
-	items    items
-	cleaning *ticker
-	dumping  *ticker
+	*state
+	clock Clock
+	owner string
+	quota Quota
+}
+
+// state is a store state
+type state struct {
+	ctx            context.Context
+	cancel         context.CancelFunc
+	closed         bool
+	mutex          sync.RWMutex
+	dumpMutex      sync.Mutex
+	params         Params
+	dumper         Dumper
+	items          items
+	usage          Usage
+	owned          map[string]Usage
+	counts         [itemTypes]int
+	version        uint64
+	counters       *counters
+	cleaning       *ticker
+	dumping        *ticker
+	started        time.Time
+	dumpStatus     DumpStatus
+	dumpErrorHooks []func(err error)
+	dumpRetryDelay time.Duration
+	dumpRetry      *time.Timer
+
+	replication     replication
+	heartbeatPeriod time.Duration
 }
 
 // NewStore constructs new store according params p with clock c. Returns error if params are invalid or clock is nil
@@ -38,25 +112,46 @@ func NewStore(p Params, c Clock, d Dumper) (Store, error) {
 	if err != nil {
 		return nil, ErrInvalidParams.detailed(err.Error())
 	}
-	s := &store{
-		mutex:  sync.RWMutex{},
-		params: p,
-		clock:  c,
-		dumper: d,
-		items:  map[string]item{},
-	}
-	s.items, err = s.dumper.load()
+	s := &store{state: &state{
+		mutex:    sync.RWMutex{},
+		params:   p,
+		dumper:   d,
+		items:    map[string]item{},
+		counters: &counters{},
+		started:  c.now(),
+
+		dumpRetryDelay: dumpRetryDelay,
+
+		replication:     newReplication(),
+		heartbeatPeriod: replicationHeartbeatPeriod,
+	}, clock: c}
+	loaded, err := s.dumper.load()
 	if err != nil {
 		return nil, err
 	}
+	s.reset(loaded)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
 	return s, nil
 }
 
+// At returns view of store sharing its items, which mutations, expiry checks and cleaning are done at time now
+func (s *store) At(now time.Time) TimedStore {
+	return &store{state: s.state, clock: fixedClock(now), owner: s.owner, quota: s.quota}
+}
+
+// Clean removes all expired items
+func (s *store) Clean() {
+	s.clean()
+}
+
 // Get returns value by key. Errors if key is not exists or item is not simple keyItem
 func (s *store) Get(key string) (string, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
-	i, err := s.get(key)
+	if s.closed {
+		return "", ErrStoreClosed
+	}
+	i, err := s.lookup(key, keyItemType)
 	if err != nil {
 		return "", err
 	}
@@ -64,17 +159,24 @@ func (s *store) Get(key string) (string, error) {
 }
 
 // Set sets value by key with time to live ttl. Creates new or overrides old of any type
-func (s *store) Set(key string, value string, ttl time.Duration) {
+func (s *store) Set(key string, value string, ttl time.Duration) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.items[key] = newKeyItem(value, s.expiry(ttl))
+	if err := s.writable(); err != nil {
+		return err
+	}
+	i := newKeyItem(value, s.expiry(ttl))
+	return s.set(key, i)
 }
 
 // Get returns value by key and list index. Errors if key is not exists or key item is not listItem
 func (s *store) ListGet(key string, index int) (string, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
-	i, err := s.get(key)
+	if s.closed {
+		return "", ErrStoreClosed
+	}
+	i, err := s.lookup(key, listItemType)
 	if err != nil {
 		return "", err
 	}
@@ -82,17 +184,24 @@ func (s *store) ListGet(key string, index int) (string, error) {
 }
 
 // Set sets list by key with time to live ttl. Creates new or overrides old of any type
-func (s *store) ListSet(key string, list []string, ttl time.Duration) {
+func (s *store) ListSet(key string, list []string, ttl time.Duration) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.items[key] = newListItem(list, s.expiry(ttl))
+	if err := s.writable(); err != nil {
+		return err
+	}
+	i := newListItem(list, s.expiry(ttl))
+	return s.set(key, i)
 }
 
 // Get returns value by key and dict key dkey. Errors if key is not exists or key item is not simple dictItem
 func (s *store) DictGet(key string, dkey string) (string, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
-	i, err := s.get(key)
+	if s.closed {
+		return "", ErrStoreClosed
+	}
+	i, err := s.lookup(key, dictItemType)
 	if err != nil {
 		return "", err
 	}
@@ -100,27 +209,196 @@ func (s *store) DictGet(key string, dkey string) (string, error) {
 }
 
 // Set sets dict by key with time to live ttl. Creates new or overrides old of any type
-func (s *store) DictSet(key string, dict map[string]string, ttl time.Duration) {
+func (s *store) DictSet(key string, dict map[string]string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.writable(); err != nil {
+		return err
+	}
+	i := newDictItem(dict, s.expiry(ttl))
+	return s.set(key, i)
+}
+
+// ListGetAll returns whole list by key. Errors if key is not exists or key item is not listItem
+func (s *store) ListGetAll(key string) ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
+	i, err := s.lookup(key, listItemType)
+	if err != nil {
+		return nil, err
+	}
+	return i.listValues()
+}
+
+// ListPush inserts values to the head of list by key one by one, so last value becomes first. Creates new list with
+// time to live ttl if key is not exists, otherwise keeps list's expiry. Errors if key item is not listItem. Returns
+// list length
+func (s *store) ListPush(key string, values []string, ttl time.Duration) (int, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.items[key] = newDictItem(dict, s.expiry(ttl))
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	var old []string
+	expiry := s.expiry(ttl)
+	if i, err := s.get(key); err == nil {
+		if old, err = i.listValues(); err != nil {
+			return 0, err
+		}
+		expiry = s.clock.now().Add(i.ttl(s.clock.now()))
+	}
+	list := make([]string, 0, len(values)+len(old))
+	for k := len(values) - 1; k >= 0; k-- {
+		list = append(list, values[k])
+	}
+	i := newListItem(append(list, old...), expiry)
+	if err := s.set(key, i); err != nil {
+		return 0, err
+	}
+	return len(i.list), nil
+}
+
+// DictGetAll returns whole dict by key. Errors if key is not exists or key item is not dictItem
+func (s *store) DictGetAll(key string) (map[string]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
+	i, err := s.lookup(key, dictItemType)
+	if err != nil {
+		return nil, err
+	}
+	return i.dictValues()
+}
+
+// DictUpdate sets fields of dict to dict by key. Creates new dict with time to live ttl if key is not exists,
+// otherwise keeps dict's expiry. Errors if key item is not dictItem. Returns count of added fields
+func (s *store) DictUpdate(key string, dict map[string]string, ttl time.Duration) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	updated := map[string]string{}
+	expiry := s.expiry(ttl)
+	if i, err := s.get(key); err == nil {
+		if updated, err = i.dictValues(); err != nil {
+			return 0, err
+		}
+		expiry = s.clock.now().Add(i.ttl(s.clock.now()))
+	}
+	added := 0
+	for k, v := range dict {
+		if _, exists := updated[k]; !exists {
+			added++
+		}
+		updated[k] = v
+	}
+	i := newDictItem(updated, expiry)
+	if err := s.set(key, i); err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// TTL returns time to live of item of any type by key. Errors if key is not exists
+func (s *store) TTL(key string) (time.Duration, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return 0, ErrStoreClosed
+	}
+	i, err := s.get(key)
+	if err != nil {
+		return 0, err
+	}
+	return i.ttl(s.clock.now()), nil
+}
+
+// Expire sets time to live ttl of item of any type by key. Errors if key is not exists
+func (s *store) Expire(key string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.writable(); err != nil {
+		return err
+	}
+	i, err := s.get(key)
+	if err != nil {
+		return err
+	}
+	i = i.expiring(s.expiry(ttl))
+	return s.set(key, i)
 }
 
 // Remove removes item of any type by key. Errors if key is not exists
 func (s *store) Remove(key string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	if err := s.writable(); err != nil {
+		return err
+	}
 	if _, exists := s.items[key]; !exists {
 		return ErrKeyNotExists
 	}
-	delete(s.items, key)
+	s.drop(key)
+	s.logRemove(key)
+	return nil
+}
+
+// KeyRemove removes keyItem by key. Errors if key is not exists or key item is not keyItem
+func (s *store) KeyRemove(key string) error {
+	return s.removeTyped(key, func(i item) error {
+		_, err := i.keyValue()
+		return err
+	})
+}
+
+// ListRemove removes listItem by key. Errors if key is not exists or key item is not listItem
+func (s *store) ListRemove(key string) error {
+	return s.removeTyped(key, func(i item) error {
+		_, err := i.listValues()
+		return err
+	})
+}
+
+// DictRemove removes dictItem by key. Errors if key is not exists or key item is not dictItem
+func (s *store) DictRemove(key string) error {
+	return s.removeTyped(key, func(i item) error {
+		_, err := i.dictValues()
+		return err
+	})
+}
+
+// removeTyped removes item by key if check of its type succeeds. Errors if key is not exists or check fails
+func (s *store) removeTyped(key string, check func(i item) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.writable(); err != nil {
+		return err
+	}
+	i, err := s.get(key)
+	if err != nil {
+		return err
+	}
+	if err := check(i); err != nil {
+		return err
+	}
+	s.drop(key)
+	s.logRemove(key)
 	return nil
 }
 
 // Keys returns all keys list, not sorted
-func (s *store) Keys() []string {
+func (s *store) Keys() ([]string, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
 	var keys []string
 	for k, i := range s.items {
 		if i.expired(s.clock.now()) {
@@ -128,13 +406,95 @@ func (s *store) Keys() []string {
 		}
 		keys = append(keys, k)
 	}
-	return keys
+	return keys, nil
+}
+
+// Export writes all not expired items to w as newline delimited JSON records. Store is not locked while writing, so
+// slow writer doesn't block store
+func (s *store) Export(w io.Writer) error {
+	rw, err := JSONFormat.writer(w)
+	if err != nil {
+		return ErrFailToExportItems.detailed(err.Error())
+	}
+	snapshot, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+	return s.export(rw, snapshot)
+}
+
+// export writes not expired items of snapshot to records writer rw
+func (s *store) export(rw recordWriter, snapshot items) error {
+	now := s.clock.now()
+	for k, i := range snapshot {
+		if i.expired(now) {
+			continue
+		}
+		r, err := newRecord(k, i)
+		if err != nil {
+			return ErrFailToExportItems.detailed(err.Error())
+		}
+		if err := rw.write(r); err != nil {
+			return ErrFailToExportItems.detailed(err.Error())
+		}
+	}
+	return nil
+}
+
+// Import reads newline delimited JSON records from r and sets them to store, overriding existed items. Expired
+// records are skipped. Items keep owners of records. Returns imported items count. Import is not atomic: records read
+// before error are kept
+func (s *store) Import(r io.Reader) (int, error) {
+	rr, err := JSONFormat.reader(r)
+	if err != nil {
+		return 0, ErrFailToImportItems.detailed(err.Error())
+	}
+	n := 0
+	for {
+		rec, err := rr.read()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, ErrFailToImportItems.detailed(err.Error())
+		}
+		i, err := rec.item()
+		if err != nil {
+			return n, ErrFailToImportItems.detailed(err.Error())
+		}
+		if i.expired(s.clock.now()) {
+			continue
+		}
+		s.mutex.Lock()
+		if err := s.writable(); err != nil {
+			s.mutex.Unlock()
+			return n, err
+		}
+		s.put(rec.Key, i)
+		s.logSet(rec.Key, i)
+		s.mutex.Unlock()
+		n++
+	}
+}
+
+// Flush removes all items
+func (s *store) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.writable(); err != nil {
+		return err
+	}
+	s.reset(items{})
+	s.logFlush()
+	return nil
 }
 
 // StartCleaning starts periodical expired items cleaning. Can be called multiple times
 func (s *store) StartCleaning() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
 	if s.cleaning == nil {
 		c, err := newTicker(s.params.CleaningPeriod, s.clean)
 		if err != nil {
@@ -142,22 +502,30 @@ func (s *store) StartCleaning() error {
 		}
 		s.cleaning = c
 	}
-	if err := s.cleaning.start(); err != nil {
+	if err := s.cleaning.start(s.ctx); err != nil {
 		return ErrFailToStartCleaning.detailed(err.Error())
 	}
 	return nil
 }
 
-// StopCleaning stops periodical expired items cleaning. Can be called multiple times
+// StopCleaning stops periodical expired items cleaning and waits for in-progress cleaning. Can be called multiple
+// times
 func (s *store) StopCleaning() error {
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrStoreClosed
+	}
 	if s.cleaning == nil {
+		s.mutex.Unlock()
 		return ErrCleaningNotStartedYet
 	}
-	if err := s.cleaning.stop(); err != nil {
+	done, err := s.cleaning.stop()
+	s.mutex.Unlock()
+	if err != nil {
 		return ErrFailToStopCleaning.detailed(err.Error())
 	}
+	<-done
 	return nil
 }
 
@@ -165,32 +533,78 @@ func (s *store) StopCleaning() error {
 func (s *store) StartDumping() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
 	if s.dumping == nil {
-		d, err := newTicker(s.params.DumpingPeriod, s.dump)
+		d, err := newTicker(s.params.DumpingPeriod, s.periodicDump)
 		if err != nil {
 			return ErrFailToCreateDumping.detailed(err.Error())
 		}
 		s.dumping = d
 	}
-	if err := s.dumping.start(); err != nil {
+	if err := s.dumping.start(s.ctx); err != nil {
 		return ErrFailToStartDumping.detailed(err.Error())
 	}
 	return nil
 }
 
-// StopDumping stops periodical file dumping. Can be called multiple times
+// StopDumping stops periodical file dumping and pending retry of failed dump, then waits for in-progress periodical
+// dump. Can be called multiple times
 func (s *store) StopDumping() error {
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrStoreClosed
+	}
 	if s.dumping == nil {
+		s.mutex.Unlock()
 		return ErrDumperNotStartedYet
 	}
-	if err := s.dumping.stop(); err != nil {
+	done, err := s.dumping.stop()
+	s.stopDumpRetry()
+	s.mutex.Unlock()
+	if err != nil {
 		return ErrFailToStopDumping.detailed(err.Error())
 	}
+	<-done
 	return nil
 }
 
+// Close stops all background work: periodical cleaning, dumping and failed dump retries, then waits for in-progress
+// cleaning and dumps. If dump is true final dump is made. After closing all store methods return ErrStoreClosed,
+// except Info, DumpStatus and OnDumpError. Returns final dump error
+func (s *store) Close(dump bool) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrStoreClosed
+	}
+	s.closed = true
+	var dones []<-chan struct{}
+	for _, t := range []*ticker{s.cleaning, s.dumping} {
+		if t == nil || !t.isRunning() {
+			continue
+		}
+		done, _ := t.stop()
+		dones = append(dones, done)
+	}
+	s.stopDumpRetry()
+	s.cancel()
+	s.mutex.Unlock()
+	for _, done := range dones {
+		<-done
+	}
+	// waiting for in-progress requested dump or failed dump retry
+	s.dumpMutex.Lock()
+	s.dumpMutex.Unlock()
+	if !dump {
+		return nil
+	}
+	_, err := s.dump()
+	return err
+}
+
 // get is item getter. Returns error if key is not exists
 func (s *store) get(key string) (item, error) {
 	i, exists := s.items[key]
@@ -200,23 +614,49 @@ func (s *store) get(key string) (item, error) {
 	return i, nil
 }
 
+// snapshot returns shallow copy of items. Items are never modified in place, so copy can be read without lock
+func (s *store) snapshot() (items, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
+	return s.snapshotItems(), nil
+}
+
+// snapshotItems returns shallow copy of items. Must be called under lock
+func (s *store) snapshotItems() items {
+	snapshot := make(items, len(s.items))
+	for k, i := range s.items {
+		snapshot[k] = i
+	}
+	return snapshot
+}
+
+// writable returns error if store is closed or read only. Must be called under lock
+func (s *store) writable() error {
+	if s.closed {
+		return ErrStoreClosed
+	}
+	if s.params.ReadOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
+
 // clean removes all expired items
 func (s *store) clean() {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	started := s.clock.now()
+	expired := 0
 	for k, i := range s.items {
 		if i.expired(s.clock.now()) {
-			delete(s.items, k)
+			s.drop(k)
+			expired++
 		}
 	}
-}
-
-func (s *store) dump() {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	if err := s.dumper.dump(s.items); err != nil {
-		// TODO: log error
-	}
+	s.counters.countCleaning(s.clock.now().Sub(started), expired)
 }
 
 // expiry computes expire time according clock's now and given ttl
@@ -1,9 +1,12 @@
 package store
 
 import (
+	"bytes"
+	"io"
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -49,9 +52,11 @@ var _ = Describe("store", func() {
 		c testClock
 		d *testDumper
 		s *store
+		v uint64
 	)
 	BeforeEach(func() {
 		c = testClock(time.Now())
+		v = uint64(c.now().UnixNano())
 		d = &testDumper{}
 		si, err := NewStore(Params{
 			CleaningPeriod: 100 * time.Millisecond,
@@ -61,9 +66,25 @@ var _ = Describe("store", func() {
 		d.expectLoad()
 		s = si.(*store)
 	})
+	AfterEach(func() {
+		s.Close(false)
+	})
+	Specify("At", func() {
+		at := c.now().Add(-time.Hour)
+		Expect(s.At(at).Set("a", "v", time.Minute)).To(Succeed())
+		Expect(s.items["a"].(keyItem).expiry).To(Equal(at.Add(time.Minute)))
+		_, err := s.Get("a")
+		Expect(err).To(MatchError(ErrKeyNotExists))
+		Expect(s.At(at).Add("a", "v", time.Minute)).To(MatchError(ErrKeyExists))
+		Expect(s.At(at).TTL("a")).To(Equal(time.Minute))
+		s.At(at).Clean()
+		Expect(s.Usage().Items).To(Equal(1))
+		s.Clean()
+		Expect(s.Usage().Items).To(BeZero())
+	})
 	Describe("Get", func() {
 		Specify("expired item error", func() {
-			s.items["a"] = newKeyItem("a", time.Now())
+			s.items["a"] = newKeyItem("a", c.now())
 			_, err := s.Get("a")
 			Expect(err).To(MatchError(ErrKeyNotExists))
 		})
@@ -72,12 +93,12 @@ var _ = Describe("store", func() {
 			Expect(err).To(MatchError(ErrKeyNotExists))
 		})
 		Specify("not key item error", func() {
-			s.items["a"] = newListItem(nil, time.Now().Add(time.Nanosecond))
+			s.items["a"] = newListItem(nil, c.now().Add(time.Nanosecond))
 			_, err := s.Get("a")
 			Expect(err).To(MatchError(ErrNotKeyItem))
 		})
 		Specify("succeeds", func() {
-			s.items["a"] = newKeyItem("a", time.Now().Add(time.Nanosecond))
+			s.items["a"] = newKeyItem("a", c.now().Add(time.Nanosecond))
 			v, err := s.Get("a")
 			Expect(err).ToNot(HaveOccurred())
 			Expect(v).To(Equal("a"))
@@ -87,32 +108,32 @@ var _ = Describe("store", func() {
 		Specify("creating new key", func() {
 			s.Set("a", "aa", time.Nanosecond)
 			Expect(s.items).To(HaveKey("a"))
-			Expect(s.items["a"]).To(Equal(newKeyItem("aa", c.now().Add(time.Nanosecond))))
+			Expect(s.items["a"]).To(Equal(newKeyItem("aa", c.now().Add(time.Nanosecond)).versioned(v)))
 		})
 		Specify("creating new empty key", func() {
 			s.Set("", "aa", time.Nanosecond)
 			Expect(s.items).To(HaveKey(""))
-			Expect(s.items[""]).To(Equal(newKeyItem("aa", c.now().Add(time.Nanosecond))))
+			Expect(s.items[""]).To(Equal(newKeyItem("aa", c.now().Add(time.Nanosecond)).versioned(v)))
 		})
 		Specify("creating new key with empty value", func() {
 			s.Set("a", "", time.Nanosecond)
 			Expect(s.items).To(HaveKey("a"))
-			Expect(s.items["a"]).To(Equal(newKeyItem("", c.now().Add(time.Nanosecond))))
+			Expect(s.items["a"]).To(Equal(newKeyItem("", c.now().Add(time.Nanosecond)).versioned(v)))
 		})
 		Specify("rewriting same type", func() {
 			s.items["a"] = newKeyItem("aa", c.now())
 			s.Set("a", "bb", time.Nanosecond)
-			Expect(s.items["a"]).To(Equal(newKeyItem("bb", c.now().Add(time.Nanosecond))))
+			Expect(s.items["a"]).To(Equal(newKeyItem("bb", c.now().Add(time.Nanosecond)).versioned(v)))
 		})
 		Specify("rewriting other type", func() {
 			s.items["a"] = newListItem(nil, c.now())
 			s.Set("a", "bb", time.Nanosecond)
-			Expect(s.items["a"]).To(Equal(newKeyItem("bb", c.now().Add(time.Nanosecond))))
+			Expect(s.items["a"]).To(Equal(newKeyItem("bb", c.now().Add(time.Nanosecond)).versioned(v)))
 		})
 	})
 	Describe("ListGet", func() {
 		Specify("expired item error", func() {
-			s.items["a"] = newListItem([]string{"a"}, time.Now())
+			s.items["a"] = newListItem([]string{"a"}, c.now())
 			_, err := s.ListGet("a", 0)
 			Expect(err).To(MatchError(ErrKeyNotExists))
 		})
@@ -121,17 +142,17 @@ var _ = Describe("store", func() {
 			Expect(err).To(MatchError(ErrKeyNotExists))
 		})
 		Specify("not list item error", func() {
-			s.items["a"] = newKeyItem("a", time.Now().Add(time.Nanosecond))
+			s.items["a"] = newKeyItem("a", c.now().Add(time.Nanosecond))
 			_, err := s.ListGet("a", 0)
 			Expect(err).To(MatchError(ErrNotListItem))
 		})
 		Specify("index not exists error", func() {
-			s.items["a"] = newListItem([]string{"a"}, time.Now().Add(time.Nanosecond))
+			s.items["a"] = newListItem([]string{"a"}, c.now().Add(time.Nanosecond))
 			_, err := s.ListGet("a", 1)
 			Expect(err).To(MatchError(ErrListIndexNotExists))
 		})
 		Specify("succeeds", func() {
-			s.items["a"] = newListItem([]string{"a"}, time.Now().Add(time.Nanosecond))
+			s.items["a"] = newListItem([]string{"a"}, c.now().Add(time.Nanosecond))
 			v, err := s.ListGet("a", 0)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(v).To(Equal("a"))
@@ -171,7 +192,7 @@ var _ = Describe("store", func() {
 	})
 	Describe("DictGet", func() {
 		Specify("expired item error", func() {
-			s.items["a"] = newDictItem(map[string]string{"b": "aa"}, time.Now())
+			s.items["a"] = newDictItem(map[string]string{"b": "aa"}, c.now())
 			_, err := s.DictGet("a", "b")
 			Expect(err).To(MatchError(ErrKeyNotExists))
 		})
@@ -180,17 +201,17 @@ var _ = Describe("store", func() {
 			Expect(err).To(MatchError(ErrKeyNotExists))
 		})
 		Specify("not dict item error", func() {
-			s.items["a"] = newKeyItem("a", time.Now().Add(time.Nanosecond))
+			s.items["a"] = newKeyItem("a", c.now().Add(time.Nanosecond))
 			_, err := s.DictGet("a", "b")
 			Expect(err).To(MatchError(ErrNotDictItem))
 		})
 		Specify("dict key not exists error", func() {
-			s.items["a"] = newDictItem(map[string]string{"b": "aa"}, time.Now().Add(time.Nanosecond))
+			s.items["a"] = newDictItem(map[string]string{"b": "aa"}, c.now().Add(time.Nanosecond))
 			_, err := s.DictGet("a", "c")
 			Expect(err).To(MatchError(ErrDictKeyNotExists))
 		})
 		Specify("succeeds", func() {
-			s.items["a"] = newDictItem(map[string]string{"b": "aa"}, time.Now().Add(time.Nanosecond))
+			s.items["a"] = newDictItem(map[string]string{"b": "aa"}, c.now().Add(time.Nanosecond))
 			v, err := s.DictGet("a", "b")
 			Expect(err).ToNot(HaveOccurred())
 			Expect(v).To(Equal("aa"))
@@ -228,6 +249,113 @@ var _ = Describe("store", func() {
 			Expect(s.items["a"]).To(Equal(newDictItem(map[string]string{"cc": "dd"}, c.now().Add(time.Nanosecond))))
 		})
 	})
+	Describe("ListGetAll", func() {
+		Specify("expired item error", func() {
+			s.items["a"] = newListItem([]string{"a"}, c.now())
+			_, err := s.ListGetAll("a")
+			Expect(err).To(MatchError(ErrKeyNotExists))
+		})
+		Specify("not list item error", func() {
+			s.items["a"] = newKeyItem("a", c.now().Add(time.Nanosecond))
+			_, err := s.ListGetAll("a")
+			Expect(err).To(MatchError(ErrNotListItem))
+		})
+		Specify("succeeds with copy", func() {
+			s.items["a"] = newListItem([]string{"a", "b"}, c.now().Add(time.Nanosecond))
+			list, err := s.ListGetAll("a")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(list).To(Equal([]string{"a", "b"}))
+			list[0] = "c"
+			Expect(s.items["a"]).To(Equal(newListItem([]string{"a", "b"}, c.now().Add(time.Nanosecond))))
+		})
+	})
+	Describe("ListPush", func() {
+		Specify("creating new list", func() {
+			Expect(s.ListPush("a", []string{"a", "b"}, time.Second)).To(Equal(2))
+			Expect(s.items["a"]).To(Equal(newListItem([]string{"b", "a"}, c.now().Add(time.Second))))
+		})
+		Specify("creating new list over expired item", func() {
+			s.items["a"] = newKeyItem("a", c.now())
+			Expect(s.ListPush("a", []string{"a"}, time.Second)).To(Equal(1))
+			Expect(s.items["a"]).To(Equal(newListItem([]string{"a"}, c.now().Add(time.Second))))
+		})
+		Specify("pushing to existed list keeps expiry", func() {
+			s.items["a"] = newListItem([]string{"a"}, c.now().Add(time.Minute))
+			Expect(s.ListPush("a", []string{"b", "c"}, time.Second)).To(Equal(3))
+			Expect(s.items["a"]).To(Equal(newListItem([]string{"c", "b", "a"}, c.now().Add(time.Minute))))
+		})
+		Specify("not list item error", func() {
+			s.items["a"] = newKeyItem("a", c.now().Add(time.Nanosecond))
+			_, err := s.ListPush("a", []string{"a"}, time.Second)
+			Expect(err).To(MatchError(ErrNotListItem))
+		})
+	})
+	Describe("DictGetAll", func() {
+		Specify("expired item error", func() {
+			s.items["a"] = newDictItem(map[string]string{"b": "aa"}, c.now())
+			_, err := s.DictGetAll("a")
+			Expect(err).To(MatchError(ErrKeyNotExists))
+		})
+		Specify("not dict item error", func() {
+			s.items["a"] = newKeyItem("a", c.now().Add(time.Nanosecond))
+			_, err := s.DictGetAll("a")
+			Expect(err).To(MatchError(ErrNotDictItem))
+		})
+		Specify("succeeds with copy", func() {
+			s.items["a"] = newDictItem(map[string]string{"b": "aa"}, c.now().Add(time.Nanosecond))
+			dict, err := s.DictGetAll("a")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dict).To(Equal(map[string]string{"b": "aa"}))
+			dict["b"] = "bb"
+			Expect(s.items["a"]).To(Equal(newDictItem(map[string]string{"b": "aa"}, c.now().Add(time.Nanosecond))))
+		})
+	})
+	Describe("DictUpdate", func() {
+		Specify("creating new dict", func() {
+			Expect(s.DictUpdate("a", map[string]string{"b": "aa"}, time.Second)).To(Equal(1))
+			Expect(s.items["a"]).To(Equal(newDictItem(map[string]string{"b": "aa"}, c.now().Add(time.Second))))
+		})
+		Specify("updating existed dict keeps expiry", func() {
+			old := map[string]string{"b": "aa", "c": "cc"}
+			s.items["a"] = newDictItem(old, c.now().Add(time.Minute))
+			Expect(s.DictUpdate("a", map[string]string{"b": "bb", "d": "dd"}, time.Second)).To(Equal(1))
+			Expect(s.items["a"]).To(Equal(newDictItem(map[string]string{"b": "bb", "c": "cc", "d": "dd"},
+				c.now().Add(time.Minute))))
+			Expect(old).To(Equal(map[string]string{"b": "aa", "c": "cc"}))
+		})
+		Specify("not dict item error", func() {
+			s.items["a"] = newKeyItem("a", c.now().Add(time.Nanosecond))
+			_, err := s.DictUpdate("a", map[string]string{"b": "aa"}, time.Second)
+			Expect(err).To(MatchError(ErrNotDictItem))
+		})
+	})
+	Describe("TTL", func() {
+		Specify("expired item error", func() {
+			s.items["a"] = newKeyItem("a", c.now())
+			_, err := s.TTL("a")
+			Expect(err).To(MatchError(ErrKeyNotExists))
+		})
+		Specify("succeeds", func() {
+			s.items["a"] = newListItem(nil, c.now().Add(time.Minute))
+			Expect(s.TTL("a")).To(Equal(time.Minute))
+		})
+	})
+	Describe("Expire", func() {
+		Specify("key not exists error", func() {
+			Expect(s.Expire("a", time.Second)).To(MatchError(ErrKeyNotExists))
+		})
+		Specify("succeeds", func() {
+			s.items["a"] = newKeyItem("a", c.now().Add(time.Minute))
+			s.items["b"] = newListItem([]string{"a"}, c.now().Add(time.Minute))
+			s.items["c"] = newDictItem(map[string]string{"a": "b"}, c.now().Add(time.Minute))
+			Expect(s.Expire("a", time.Second)).To(Succeed())
+			Expect(s.Expire("b", time.Second)).To(Succeed())
+			Expect(s.Expire("c", time.Second)).To(Succeed())
+			Expect(s.items["a"]).To(Equal(newKeyItem("a", c.now().Add(time.Second)).versioned(v)))
+			Expect(s.items["b"]).To(Equal(newListItem([]string{"a"}, c.now().Add(time.Second))))
+			Expect(s.items["c"]).To(Equal(newDictItem(map[string]string{"a": "b"}, c.now().Add(time.Second))))
+		})
+	})
 	Describe("Remove", func() {
 		Specify("key not exists error", func() {
 			Expect(s.Remove("a")).To(MatchError(ErrKeyNotExists))
@@ -243,6 +371,55 @@ var _ = Describe("store", func() {
 			Expect(s.items).ToNot(HaveKey("a"))
 		})
 	})
+	Describe("KeyRemove", func() {
+		Specify("key not exists error", func() {
+			Expect(s.KeyRemove("a")).To(MatchError(ErrKeyNotExists))
+		})
+		Specify("expired key error", func() {
+			s.items["a"] = newKeyItem("v", c.now().Add(-time.Nanosecond))
+			Expect(s.KeyRemove("a")).To(MatchError(ErrKeyNotExists))
+		})
+		Specify("not key item error", func() {
+			s.items["a"] = newListItem([]string{"v"}, c.now().Add(time.Second))
+			Expect(s.KeyRemove("a")).To(MatchError(ErrNotKeyItem))
+			Expect(s.items).To(HaveKey("a"))
+		})
+		Specify("key item", func() {
+			s.items["a"] = newKeyItem("v", c.now().Add(time.Second))
+			Expect(s.KeyRemove("a")).To(Succeed())
+			Expect(s.items).ToNot(HaveKey("a"))
+		})
+	})
+	Describe("ListRemove", func() {
+		Specify("key not exists error", func() {
+			Expect(s.ListRemove("a")).To(MatchError(ErrKeyNotExists))
+		})
+		Specify("not list item error", func() {
+			s.items["a"] = newDictItem(map[string]string{"k": "v"}, c.now().Add(time.Second))
+			Expect(s.ListRemove("a")).To(MatchError(ErrNotListItem))
+			Expect(s.items).To(HaveKey("a"))
+		})
+		Specify("list item", func() {
+			s.items["a"] = newListItem([]string{"v"}, c.now().Add(time.Second))
+			Expect(s.ListRemove("a")).To(Succeed())
+			Expect(s.items).ToNot(HaveKey("a"))
+		})
+	})
+	Describe("DictRemove", func() {
+		Specify("key not exists error", func() {
+			Expect(s.DictRemove("a")).To(MatchError(ErrKeyNotExists))
+		})
+		Specify("not dict item error", func() {
+			s.items["a"] = newKeyItem("v", c.now().Add(time.Second))
+			Expect(s.DictRemove("a")).To(MatchError(ErrNotDictItem))
+			Expect(s.items).To(HaveKey("a"))
+		})
+		Specify("dict item", func() {
+			s.items["a"] = newDictItem(map[string]string{"k": "v"}, c.now().Add(time.Second))
+			Expect(s.DictRemove("a")).To(Succeed())
+			Expect(s.items).ToNot(HaveKey("a"))
+		})
+	})
 	Describe("Keys", func() {
 		Specify("when empty store", func() {
 			Expect(s.Keys()).To(BeEmpty())
@@ -260,6 +437,428 @@ var _ = Describe("store", func() {
 			Expect(s.Keys()).To(ConsistOf("c", "d"))
 		})
 	})
+	Describe("Export", func() {
+		Specify("unknown item error", func() {
+			s.items["a"] = baseItem{expiry: c.now().Add(time.Second)}
+			Expect(s.Export(&bytes.Buffer{})).To(MatchError(ErrFailToExportItems.detailed(`unknown type of item "a"`)))
+		})
+		Specify("when empty store", func() {
+			var b bytes.Buffer
+			Expect(s.Export(&b)).To(Succeed())
+			Expect(b.String()).To(BeEmpty())
+		})
+		Specify("when not expired items exists", func() {
+			exp := c.now().Add(time.Second)
+			expJSON := exp.UTC().Format(time.RFC3339Nano)
+			s.items["a"] = newKeyItem("v", c.now())
+			s.items["b"] = newKeyItem("v", exp)
+			s.items["c"] = newListItem([]string{"l1", "l2"}, exp)
+			s.items["d"] = newDictItem(map[string]string{"dk": "dv"}, exp)
+			var b bytes.Buffer
+			Expect(s.Export(&b)).To(Succeed())
+			Expect(strings.Split(strings.TrimSpace(b.String()), "\n")).To(ConsistOf(
+				`{"key":"b","type":"key","value":"v","expiry":"`+expJSON+`"}`,
+				`{"key":"c","type":"list","value":["l1","l2"],"expiry":"`+expJSON+`"}`,
+				`{"key":"d","type":"dict","value":{"dk":"dv"},"expiry":"`+expJSON+`"}`,
+			))
+		})
+	})
+	Describe("Import", func() {
+		var (
+			exp     time.Time
+			expJSON string
+		)
+		BeforeEach(func() {
+			exp = time.Unix(0, c.now().Add(time.Second).UnixNano())
+			expJSON = exp.UTC().Format(time.RFC3339Nano)
+		})
+		Specify("invalid record error", func() {
+			n, err := s.Import(strings.NewReader(`{"key":"a"`))
+			Expect(err).To(MatchError(ErrFailToImportItems.detailed("unexpected EOF")))
+			Expect(n).To(BeZero())
+		})
+		Specify("invalid record value error", func() {
+			n, err := s.Import(strings.NewReader(`{"key":"a","type":"list","value":"v","expiry":"` + expJSON + `"}`))
+			Expect(err).To(MatchError(ErrFailToImportItems.detailed(
+				`invalid value of record "a": json: cannot unmarshal string into Go value of type []string`)))
+			Expect(n).To(BeZero())
+		})
+		Specify("keeps records imported before error", func() {
+			n, err := s.Import(strings.NewReader(
+				`{"key":"a","type":"key","value":"v","expiry":"` + expJSON + `"}` + "\n" +
+					`{"key":"b","type":"set","value":"v","expiry":"` + expJSON + `"}`))
+			Expect(err).To(MatchError(ErrFailToImportItems.detailed(`unknown type "set" of record "b"`)))
+			Expect(n).To(Equal(1))
+			Expect(s.items).To(HaveLen(1))
+			Expect(s.items["a"]).To(beKeyItem(newKeyItem("v", exp)))
+		})
+		Specify("succeeds", func() {
+			s.items["b"] = newKeyItem("old", exp)
+			n, err := s.Import(strings.NewReader(
+				`{"key":"a","type":"key","value":"v","expiry":"` + c.now().UTC().Format(time.RFC3339Nano) + `"}` + "\n" +
+					`{"key":"b","type":"key","value":"v","expiry":"` + expJSON + `"}` + "\n" +
+					`{"key":"c","type":"list","value":["l1","l2"],"expiry":"` + expJSON + `"}` + "\n" +
+					`{"key":"d","type":"dict","value":{"dk":"dv"},"expiry":"` + expJSON + `"}` + "\n"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(3))
+			Expect(s.items).To(HaveLen(3))
+			Expect(s.items["b"]).To(beKeyItem(newKeyItem("v", exp)))
+			Expect(s.items["c"]).To(beListItem(newListItem([]string{"l1", "l2"}, exp)))
+			Expect(s.items["d"]).To(beDictItem(newDictItem(map[string]string{"dk": "dv"}, exp)))
+		})
+		Specify("imports exported items", func() {
+			s.items["a"] = newKeyItem("v", exp)
+			s.items["b"] = newListItem([]string{"l1", "l2"}, exp)
+			s.items["c"] = newDictItem(map[string]string{"dk": "dv"}, exp)
+			var b bytes.Buffer
+			Expect(s.Export(&b)).To(Succeed())
+			s.items = items{}
+			n, err := s.Import(&b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(3))
+			Expect(s.items["a"]).To(beKeyItem(newKeyItem("v", exp)))
+			Expect(s.items["b"]).To(beListItem(newListItem([]string{"l1", "l2"}, exp)))
+			Expect(s.items["c"]).To(beDictItem(newDictItem(map[string]string{"dk": "dv"}, exp)))
+		})
+		Specify("imports exported values not valid UTF-8", func() {
+			s.items["\xff"] = newKeyItem("\xff\xfea", exp)
+			s.items["b"] = newListItem([]string{"l1", "\xff"}, exp)
+			s.items["c"] = newDictItem(map[string]string{"\xfe": "\xff"}, exp)
+			var b bytes.Buffer
+			Expect(s.Export(&b)).To(Succeed())
+			s.items = items{}
+			n, err := s.Import(&b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(3))
+			Expect(s.items["\xff"]).To(beKeyItem(newKeyItem("\xff\xfea", exp)))
+			Expect(s.items["b"]).To(beListItem(newListItem([]string{"l1", "\xff"}, exp)))
+			Expect(s.items["c"]).To(beDictItem(newDictItem(map[string]string{"\xfe": "\xff"}, exp)))
+		})
+	})
+	Specify("Flush", func() {
+		s.Set("k", "v", time.Second)
+		s.ListSet("lk", []string{"a", "b"}, time.Second)
+		s.Flush()
+		Expect(s.items).To(BeEmpty())
+		Expect(s.Keys()).To(BeEmpty())
+	})
+	Describe("Dump", func() {
+		Specify("dumper error", func() {
+			d.error = ErrFailToDumpItems
+			var hookErrs []error
+			s.OnDumpError(func(err error) { hookErrs = append(hookErrs, err) })
+			s.dumpStatus = DumpStatus{LastSuccess: c.now().Add(-time.Second), Failures: 1}
+			status, err := s.Dump()
+			Expect(err).To(MatchError(ErrFailToDumpItems))
+			Expect(status).To(Equal(DumpStatus{
+				Time:        c.now(),
+				Error:       ErrFailToDumpItems.Error(),
+				LastSuccess: c.now().Add(-time.Second),
+				Failures:    2,
+			}))
+			d.expectDump(s.items)
+			d.expectNoCalls()
+			Expect(s.DumpStatus()).To(Equal(status))
+			Expect(hookErrs).To(Equal([]error{ErrFailToDumpItems}))
+		})
+		Specify("succeeds", func() {
+			s.Set("k", "v", time.Second)
+			var hookErrs []error
+			s.OnDumpError(func(err error) { hookErrs = append(hookErrs, err) })
+			s.dumpStatus = DumpStatus{Time: c.now().Add(-time.Second), Error: "error", Failures: 2}
+			status, err := s.Dump()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status).To(Equal(DumpStatus{Time: c.now(), LastSuccess: c.now()}))
+			d.expectDump(s.items)
+			d.expectNoCalls()
+			Expect(s.DumpStatus()).To(Equal(status))
+			Expect(hookErrs).To(BeEmpty())
+		})
+	})
+	Describe("Checkpoint and Load", func() {
+		var exp time.Time
+		BeforeEach(func() {
+			exp = time.Unix(0, c.now().Add(time.Second).UnixNano())
+			s.dumper = FileDumper{format: JSONFormat, compression: GzipCompression}
+		})
+		Specify("checkpoint is point-in-time copy of not expired items", func() {
+			s.items["a"] = newKeyItem("v", exp)
+			s.items["b"] = newKeyItem("v", c.now())
+			checkpoint, err := s.Checkpoint()
+			Expect(err).ToNot(HaveOccurred())
+			s.Set("c", "v", time.Second)
+			Expect(checkpoint.items).To(HaveLen(1))
+			Expect(checkpoint.items["a"]).To(beKeyItem(newKeyItem("v", exp)))
+		})
+		Specify("loads written checkpoint", func() {
+			s.items["a"] = newKeyItem("v", exp)
+			s.items["b"] = newListItem([]string{"l1", "l2"}, exp)
+			s.items["c"] = newDictItem(map[string]string{"dk": "dv"}, exp)
+			checkpoint, err := s.Checkpoint()
+			Expect(err).ToNot(HaveOccurred())
+			var b bytes.Buffer
+			Expect(checkpoint.Write(&b)).To(Succeed())
+			s.items = items{"old": newKeyItem("v", exp)}
+			s.replication.subscribed = true
+			Expect(s.Load(&b)).To(Succeed())
+			Expect(s.items).To(HaveLen(3))
+			Expect(s.items["a"]).To(beKeyItem(newKeyItem("v", exp)))
+			Expect(s.items["b"]).To(beListItem(newListItem([]string{"l1", "l2"}, exp)))
+			Expect(s.items["c"]).To(beDictItem(newDictItem(map[string]string{"dk": "dv"}, exp)))
+			Expect(s.replication.log[0].op).To(Equal(flushOp))
+			Expect(s.replication.log).To(HaveLen(4))
+		})
+		Specify("invalid data error keeps items", func() {
+			s.items["a"] = newKeyItem("v", exp)
+			err := s.Load(strings.NewReader(`{"key":"a"`))
+			Expect(err).To(BeAssignableToTypeOf(StoreError{}))
+			Expect(err.(StoreError).Code).To(Equal(ErrFailToDecodeDumpFile.Code))
+			Expect(s.items).To(HaveLen(1))
+		})
+		Specify("closed store error", func() {
+			s.Close(false)
+			_, err := s.Checkpoint()
+			Expect(err).To(MatchError(ErrStoreClosed))
+			Expect(s.Load(strings.NewReader(""))).To(MatchError(ErrStoreClosed))
+		})
+	})
+	Describe("periodical dump retry", func() {
+		var fd *flakyDumper
+		BeforeEach(func() {
+			fd = &flakyDumper{}
+			s.dumper = fd
+			s.dumpRetryDelay = 10 * time.Millisecond
+			s.params.DumpingPeriod = time.Minute
+			Expect(s.StartDumping()).To(Succeed())
+		})
+		AfterEach(func() {
+			s.StopDumping()
+		})
+		Specify("retries failed dump until success", func() {
+			fd.setFailures(3)
+			s.periodicDump()
+			Eventually(s.DumpStatus).Should(Equal(DumpStatus{Time: c.now(), LastSuccess: c.now()}))
+			Expect(fd.getDumps()).To(Equal(4))
+			Consistently(fd.getDumps, 100*time.Millisecond).Should(Equal(4))
+		})
+		Specify("stops retrying when delay reaches dumping period", func() {
+			fd.setFailures(10)
+			s.params.DumpingPeriod = 50 * time.Millisecond
+			s.periodicDump()
+			Eventually(fd.getDumps).Should(Equal(4))
+			Consistently(fd.getDumps, 100*time.Millisecond).Should(Equal(4))
+			Expect(s.DumpStatus().Failures).To(Equal(4))
+		})
+		Specify("stopping dumping cancels retry", func() {
+			fd.setFailures(10)
+			s.periodicDump()
+			Expect(s.StopDumping()).To(Succeed())
+			Expect(s.dumpRetry).To(BeNil())
+			Consistently(fd.getDumps, 100*time.Millisecond).Should(Equal(1))
+		})
+	})
+	Describe("Close", func() {
+		Specify("without dump", func() {
+			Expect(s.StartCleaning()).To(Succeed())
+			Expect(s.StartDumping()).To(Succeed())
+			Expect(s.Close(false)).To(Succeed())
+			Expect(s.cleaning.isRunning()).To(BeFalse())
+			Expect(s.dumping.isRunning()).To(BeFalse())
+			Expect(s.ctx.Err()).To(HaveOccurred())
+			d.expectNoCalls()
+		})
+		Specify("with dump", func() {
+			s.Set("k", "v", time.Second)
+			Expect(s.Close(true)).To(Succeed())
+			d.expectDump(s.items)
+			d.expectNoCalls()
+			Expect(s.DumpStatus()).To(Equal(DumpStatus{Time: c.now(), LastSuccess: c.now()}))
+		})
+		Specify("final dump error", func() {
+			d.error = ErrFailToDumpItems
+			Expect(s.Close(true)).To(MatchError(ErrFailToDumpItems))
+			d.expectDump(s.items)
+		})
+		Specify("waits for in-progress dump", func() {
+			bd := &blockingDumper{started: make(chan struct{}), release: make(chan struct{})}
+			s.dumper = bd
+			go s.Dump()
+			Eventually(bd.started).Should(BeClosed())
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				s.Close(false)
+			}()
+			Consistently(closed, 50*time.Millisecond).ShouldNot(BeClosed())
+			close(bd.release)
+			Eventually(closed).Should(BeClosed())
+		})
+		Specify("closed store errors", func() {
+			s.Set("k", "v", time.Second)
+			Expect(s.Close(false)).To(Succeed())
+			_, err := s.Get("k")
+			Expect(err).To(MatchError(ErrStoreClosed))
+			Expect(s.Set("k", "v", time.Second)).To(MatchError(ErrStoreClosed))
+			_, err = s.ListGet("k", 0)
+			Expect(err).To(MatchError(ErrStoreClosed))
+			Expect(s.ListSet("k", nil, time.Second)).To(MatchError(ErrStoreClosed))
+			_, err = s.DictGet("k", "dk")
+			Expect(err).To(MatchError(ErrStoreClosed))
+			Expect(s.DictSet("k", nil, time.Second)).To(MatchError(ErrStoreClosed))
+			Expect(s.Remove("k")).To(MatchError(ErrStoreClosed))
+			Expect(s.KeyRemove("k")).To(MatchError(ErrStoreClosed))
+			_, err = s.Keys()
+			Expect(err).To(MatchError(ErrStoreClosed))
+			Expect(s.Export(&bytes.Buffer{})).To(MatchError(ErrStoreClosed))
+			_, err = s.Import(strings.NewReader(`{"key":"a","type":"key","value":"v","expiry":"2100-01-01T00:00:00Z"}`))
+			Expect(err).To(MatchError(ErrStoreClosed))
+			Expect(s.Flush()).To(MatchError(ErrStoreClosed))
+			_, err = s.Dump()
+			Expect(err).To(MatchError(ErrStoreClosed))
+			Expect(s.StartCleaning()).To(MatchError(ErrStoreClosed))
+			Expect(s.StopCleaning()).To(MatchError(ErrStoreClosed))
+			Expect(s.StartDumping()).To(MatchError(ErrStoreClosed))
+			Expect(s.StopDumping()).To(MatchError(ErrStoreClosed))
+			Expect(s.Close(false)).To(MatchError(ErrStoreClosed))
+			Expect(s.Info().Keys).To(Equal(1))
+			d.expectNoCalls()
+		})
+	})
+	Describe("Info", func() {
+		Specify("when empty store", func() {
+			Expect(s.Info()).To(Equal(Info{Replication: ReplicationStatus{ID: s.replication.id}}))
+		})
+		Specify("when items exists", func() {
+			s.items["a"] = newKeyItem("expired", c.now())
+			s.items["b"] = newKeyItem("v", c.now().Add(time.Second))
+			s.items["c"] = newKeyItem("vv", c.now().Add(time.Second))
+			s.items["d"] = newListItem([]string{"l1", "l2"}, c.now().Add(time.Second))
+			s.items["e"] = newDictItem(map[string]string{"dk": "dv"}, c.now().Add(time.Second))
+			s.dumpStatus = DumpStatus{Time: c.now(), Duration: time.Second, Error: "error", Failures: 1}
+			Expect(s.StartCleaning()).To(Succeed())
+			defer s.StopCleaning()
+			Expect(s.Info()).To(Equal(Info{
+				Keys:     2,
+				Lists:    1,
+				Dicts:    1,
+				Memory:   4*(1+itemOverhead) + 3 + 2*(2+stringOverhead) + 4 + 2*stringOverhead,
+				Cleaning: true,
+				Dump:     DumpStatus{Time: c.now(), Duration: time.Second, Error: "error", Failures: 1},
+
+				Replication: ReplicationStatus{ID: s.replication.id},
+			}))
+		})
+	})
+	Specify("Usage", func() {
+		Expect(s.Usage()).To(Equal(Usage{}))
+		Expect(s.Set("a", "v", time.Second)).To(Succeed())
+		Expect(s.ListSet("b", []string{"l1", "l2"}, time.Second)).To(Succeed())
+		Expect(s.DictSet("c", map[string]string{"dk": "dv"}, time.Second)).To(Succeed())
+		Expect(s.Usage()).To(Equal(Usage{Items: 3, Bytes: 3*(1+itemOverhead) + 1 + 2*(2+stringOverhead) + 4 +
+			2*stringOverhead}))
+		Expect(s.Set("b", "vv", time.Second)).To(Succeed())
+		Expect(s.Usage()).To(Equal(Usage{Items: 3, Bytes: 3*(1+itemOverhead) + 1 + 2 + 4 + 2*stringOverhead}))
+		Expect(s.Remove("c")).To(Succeed())
+		Expect(s.Usage()).To(Equal(Usage{Items: 2, Bytes: 2*(1+itemOverhead) + 1 + 2}))
+		Expect(s.Set("d", "v", 0)).To(Succeed())
+		s.clean()
+		Expect(s.Usage()).To(Equal(Usage{Items: 2, Bytes: 2*(1+itemOverhead) + 1 + 2}))
+		Expect(s.Set("a", "v", time.Second)).To(Succeed())
+		Expect(s.Flush()).To(Succeed())
+		Expect(s.Usage()).To(Equal(Usage{}))
+	})
+	Describe("As", func() {
+		Specify("owns set items and accounts usage of owners", func() {
+			a := s.As("a", Quota{})
+			Expect(a.Set("k", "v", time.Second)).To(Succeed())
+			Expect(a.ListSet("l", []string{"l1"}, time.Second)).To(Succeed())
+			Expect(s.Set("x", "v", time.Second)).To(Succeed())
+			Expect(s.items["k"].ownerOf()).To(Equal("a"))
+			Expect(s.items["x"].ownerOf()).To(BeEmpty())
+			Expect(s.UsageOf("a")).To(Equal(Usage{Items: 2, Bytes: 2*(1+itemOverhead) + 1 + 2 + stringOverhead}))
+			Expect(s.As("b", Quota{}).Set("k", "vv", time.Second)).To(Succeed())
+			Expect(s.UsageOf("a")).To(Equal(Usage{Items: 1, Bytes: 1 + itemOverhead + 2 + stringOverhead}))
+			Expect(s.UsageOf("b")).To(Equal(Usage{Items: 1, Bytes: 1 + itemOverhead + 2}))
+			Expect(s.Expire("k", time.Minute)).To(Succeed())
+			Expect(s.UsageOf("b")).To(Equal(Usage{Items: 1, Bytes: 1 + itemOverhead + 2}))
+			Expect(s.Remove("l")).To(Succeed())
+			Expect(s.UsageOf("a")).To(Equal(Usage{}))
+			Expect(s.Flush()).To(Succeed())
+			Expect(s.UsageOf("b")).To(Equal(Usage{}))
+		})
+		Specify("keys quota allows overwriting own keys", func() {
+			a := s.As("a", Quota{MaxKeys: 1})
+			Expect(a.Set("k", "v", time.Second)).To(Succeed())
+			Expect(a.Set("k", "vv", time.Second)).To(Succeed())
+			Expect(a.Set("l", "v", time.Second)).To(MatchError(ErrKeysQuotaExceeded))
+			Expect(s.Set("x", "v", time.Second)).To(Succeed())
+			Expect(a.Set("x", "vv", time.Second)).To(MatchError(ErrKeysQuotaExceeded))
+			Expect(s.Get("x")).To(Equal("v"))
+			Expect(s.UsageOf("a").Items).To(Equal(1))
+		})
+		Specify("bytes quota is checked by size difference", func() {
+			a := s.As("a", Quota{MaxBytes: 1 + itemOverhead + 2})
+			Expect(a.Set("k", "vv", time.Second)).To(Succeed())
+			Expect(a.Set("k", "v", time.Second)).To(Succeed())
+			Expect(a.Append("k", "vv")).To(MatchError(ErrBytesQuotaExceeded))
+			Expect(a.Append("k", "v")).To(Succeed())
+			Expect(a.Get("k")).To(Equal("vv"))
+			Expect(a.ListPush("l", []string{"v"}, time.Second)).Error().To(MatchError(ErrBytesQuotaExceeded))
+			Expect(a.Remove("k")).To(Succeed())
+			Expect(a.ListPush("l", []string{"v"}, time.Second)).Error().To(MatchError(ErrBytesQuotaExceeded))
+			Expect(a.Set("l", "v", time.Second)).To(Succeed())
+		})
+		Specify("owners are kept by export and checkpoint", func() {
+			Expect(s.As("a", Quota{}).Set("k", "\xff", time.Second)).To(Succeed())
+			var b bytes.Buffer
+			Expect(s.Export(&b)).To(Succeed())
+			Expect(b.String()).To(ContainSubstring(`"owner":"a"`))
+			s.dumper = FileDumper{format: GobFormat, compression: GzipCompression}
+			checkpoint, err := s.Checkpoint()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Flush()).To(Succeed())
+			Expect(s.Import(&b)).To(Equal(1))
+			Expect(s.UsageOf("a").Items).To(Equal(1))
+			b.Reset()
+			Expect(checkpoint.Write(&b)).To(Succeed())
+			Expect(s.Flush()).To(Succeed())
+			Expect(s.Load(&b)).To(Succeed())
+			Expect(s.items["k"].ownerOf()).To(Equal("a"))
+			Expect(s.UsageOf("a").Items).To(Equal(1))
+		})
+	})
+	Specify("Metrics", func() {
+		Expect(s.Metrics()).To(Equal(Metrics{}))
+		Expect(s.Set("a", "v", time.Second)).To(Succeed())
+		Expect(s.ListSet("b", []string{"l1", "l2"}, time.Second)).To(Succeed())
+		Expect(s.DictSet("c", map[string]string{"dk": "dv"}, 0)).To(Succeed())
+		Expect(s.Get("a")).To(Equal("v"))
+		_, _, err := s.GetVersion("a")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = s.Get("b")
+		Expect(err).To(MatchError(ErrNotKeyItem))
+		_, err = s.Get("x")
+		Expect(err).To(MatchError(ErrKeyNotExists))
+		_, err = s.ListGet("b", 5)
+		Expect(err).To(MatchError(ErrListIndexNotExists))
+		_, err = s.DictGetAll("c")
+		Expect(err).To(MatchError(ErrKeyNotExists))
+		s.clean()
+		d.error = ErrFailToDumpItems
+		_, err = s.Dump()
+		Expect(err).To(HaveOccurred())
+		Expect(s.Metrics()).To(Equal(Metrics{
+			Keys:         TypeMetrics{Items: 1, Hits: 2, Misses: 2},
+			Lists:        TypeMetrics{Items: 1, Hits: 1},
+			Dicts:        TypeMetrics{Misses: 1},
+			Bytes:        2*(1+itemOverhead) + 1 + 2*(2+stringOverhead),
+			Expired:      1,
+			Cleanings:    1,
+			Dumps:        1,
+			DumpFailures: 1,
+			Dump:         DumpStatus{Time: c.now(), Error: ErrFailToDumpItems.Error(), Failures: 1},
+		}))
+	})
 	Specify("StartCleaning and StopCleaning", func() {
 		defer s.StopCleaning()
 		s.items["a"] = baseItem{expiry: c.now().Add(-time.Nanosecond)}
@@ -341,7 +940,7 @@ var _ = Describe("store", func() {
 	})
 	Describe("get", func() {
 		Specify("expired item error", func() {
-			s.items["a"] = baseItem{expiry: time.Now()}
+			s.items["a"] = baseItem{expiry: c.now()}
 			_, err := s.get("a")
 			Expect(err).To(MatchError(ErrKeyNotExists))
 		})
@@ -350,16 +949,16 @@ var _ = Describe("store", func() {
 			Expect(err).To(MatchError(ErrKeyNotExists))
 		})
 		Specify("succeeds", func() {
-			s.items["a"] = baseItem{expiry: time.Now().Add(time.Nanosecond)}
+			s.items["a"] = baseItem{expiry: c.now().Add(time.Nanosecond)}
 			i, err := s.get("a")
 			Expect(err).ToNot(HaveOccurred())
 			Expect(i).To(Equal(s.items["a"]))
 		})
 	})
 	Specify("clean", func() {
-		s.items["a"] = baseItem{expiry: time.Now()}
-		s.items["b"] = baseItem{expiry: time.Now().Add(-time.Nanosecond)}
-		s.items["c"] = baseItem{expiry: time.Now().Add(time.Nanosecond)}
+		s.items["a"] = baseItem{expiry: c.now()}
+		s.items["b"] = baseItem{expiry: c.now().Add(-time.Nanosecond)}
+		s.items["c"] = baseItem{expiry: c.now().Add(time.Nanosecond)}
 		s.clean()
 		Expect(s.items).To(HaveLen(1))
 		Expect(s.items).To(HaveKey("c"))
@@ -372,6 +971,18 @@ var _ = Describe("store", func() {
 		d.expectDump(s.items)
 		d.expectNoCalls()
 	})
+	Specify("dump doesn't block writes", func() {
+		bd := &blockingDumper{started: make(chan struct{}), release: make(chan struct{})}
+		s.dumper = bd
+		go s.dump()
+		Eventually(bd.started).Should(BeClosed())
+		set := make(chan error)
+		go func() {
+			set <- s.Set("k", "v", time.Second)
+		}()
+		Eventually(set).Should(Receive(BeNil()))
+		close(bd.release)
+	})
 	Specify("expiry", func() {
 		Expect(s.expiry(100 * time.Nanosecond)).To(Equal(c.now().Add(100 * time.Nanosecond)))
 		Expect(s.expiry(0)).To(Equal(c.now()))
@@ -421,6 +1032,16 @@ func (td *testDumper) expectDump(items items) {
 	ExpectWithOffset(1, td.popCall()).To(beCall(td.dump, items))
 }
 
+func (td *testDumper) write(w io.Writer, items items) error {
+	td.newCall(td.write, w, items)
+	return td.error
+}
+
+func (td *testDumper) read(r io.Reader) (items, error) {
+	td.newCall(td.read, r)
+	return td.items, td.error
+}
+
 func (td *testDumper) load() (items, error) {
 	td.newCall(td.load)
 	if td.items == nil {
@@ -434,6 +1055,72 @@ func (td *testDumper) expectLoad() {
 	ExpectWithOffset(1, td.popCall()).To(beCall(td.load))
 }
 
+// flakyDumper is concurrency safe dumper failing given number of dumps
+type flakyDumper struct {
+	mutex    sync.Mutex
+	failures int
+	dumps    int
+}
+
+func (fd *flakyDumper) setFailures(failures int) {
+	fd.mutex.Lock()
+	defer fd.mutex.Unlock()
+	fd.failures = failures
+}
+
+func (fd *flakyDumper) getDumps() int {
+	fd.mutex.Lock()
+	defer fd.mutex.Unlock()
+	return fd.dumps
+}
+
+func (fd *flakyDumper) dump(_ items) error {
+	fd.mutex.Lock()
+	defer fd.mutex.Unlock()
+	fd.dumps++
+	if fd.failures > 0 {
+		fd.failures--
+		return ErrFailToDumpItems
+	}
+	return nil
+}
+
+func (fd *flakyDumper) write(_ io.Writer, _ items) error {
+	return nil
+}
+
+func (fd *flakyDumper) read(_ io.Reader) (items, error) {
+	return items{}, nil
+}
+
+func (fd *flakyDumper) load() (items, error) {
+	return items{}, nil
+}
+
+// blockingDumper is dumper blocking dump until released
+type blockingDumper struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (bd *blockingDumper) dump(_ items) error {
+	close(bd.started)
+	<-bd.release
+	return nil
+}
+
+func (bd *blockingDumper) write(_ io.Writer, _ items) error {
+	return nil
+}
+
+func (bd *blockingDumper) read(_ io.Reader) (items, error) {
+	return items{}, nil
+}
+
+func (bd *blockingDumper) load() (items, error) {
+	return items{}, nil
+}
+
 type call struct {
 	method string
 	args   []interface{}
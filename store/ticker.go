@@ -1,15 +1,17 @@
 package store
 
 import (
+	"context"
 	"errors"
 	"time"
 )
 
 // ticker is timer ticker, runs function f every period time
 type ticker struct {
-	period  time.Duration
-	f       func()
-	stopper chan struct{}
+	period time.Duration
+	f      func()
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
 // newTicker constructs new ticker with given period and working function f. Returns error if f is nil
@@ -18,31 +20,36 @@ func newTicker(period time.Duration, f func()) (*ticker, error) {
 		return nil, errors.New("nil ticker function")
 	}
 	return &ticker{
-		period:  period,
-		f:       f,
-		stopper: nil,
+		period: period,
+		f:      f,
+		cancel: nil,
+		done:   nil,
 	}, nil
 }
 
 // isRunning determines if ticker is running
 func (t *ticker) isRunning() bool {
-	return t.stopper != nil
+	return t.cancel != nil
 }
 
-// start starts ticker. Can be called multiple times. Returns error if ticker is already started
-func (t *ticker) start() error {
+// start starts ticker. Ticker runs until stop is called or context ctx is done. Can be called multiple times.
+// Returns error if ticker is already started
+func (t *ticker) start(ctx context.Context) error {
 	if t.isRunning() {
 		return errors.New("already started")
 	}
-	t.stopper = make(chan struct{})
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	t.cancel, t.done = cancel, done
 	ticker := time.NewTicker(t.period)
 	go func() {
+		defer close(done)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
 				t.f()
-			case <-t.stopper:
-				ticker.Stop()
+			case <-ctx.Done():
 				return
 			}
 		}
@@ -50,12 +57,14 @@ func (t *ticker) start() error {
 	return nil
 }
 
-// stop stops ticker. Can be called multiple times. Returns errors if ticker already stopped
-func (t *ticker) stop() error {
+// stop stops ticker. Returned channel is closed when in-progress working function call is finished. Can be called
+// multiple times. Returns errors if ticker already stopped
+func (t *ticker) stop() (<-chan struct{}, error) {
 	if !t.isRunning() {
-		return errors.New("already stopped")
+		return nil, errors.New("already stopped")
 	}
-	close(t.stopper)
-	t.stopper = nil
-	return nil
+	t.cancel()
+	done := t.done
+	t.cancel, t.done = nil, nil
+	return done, nil
 }
@@ -1,6 +1,8 @@
 package store
 
 import (
+	"context"
+	"sync/atomic"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -18,49 +20,89 @@ var _ = Describe("newTicker", func() {
 		Expect(t).ToNot(BeNil())
 		Expect(t.period).To(Equal(time.Nanosecond))
 		Expect(t.f).ToNot(BeNil())
-		Expect(t.stopper).To(BeNil())
+		Expect(t.cancel).To(BeNil())
+		Expect(t.done).To(BeNil())
 		Expect(t.isRunning()).To(BeFalse())
 	})
 })
 
 var _ = Specify("Ticker", func() {
 	By("creating ticker")
-	ticks := 0
+	var ticks int32
 	t, err := newTicker(100*time.Millisecond, func() {
 		By("tick occurred")
-		ticks++
+		atomic.AddInt32(&ticks, 1)
 	})
 	Expect(err).ToNot(HaveOccurred())
 	Expect(t.isRunning()).To(BeFalse())
 
 	By("starting ticker")
-	Expect(t.start()).To(Succeed())
+	Expect(t.start(context.Background())).To(Succeed())
 	Expect(t.isRunning()).To(BeTrue())
 
 	time.Sleep(110 * time.Millisecond)
 
 	By("after tick")
-	Expect(ticks).To(Equal(1))
+	Expect(atomic.LoadInt32(&ticks)).To(BeEquivalentTo(1))
 
 	By("trying to start one more time")
-	Expect(t.start()).ToNot(Succeed())
+	Expect(t.start(context.Background())).ToNot(Succeed())
 	Expect(t.isRunning()).To(BeTrue())
 
 	time.Sleep(110 * time.Millisecond)
 
 	By("after tick")
-	Expect(ticks).To(Equal(2))
+	Expect(atomic.LoadInt32(&ticks)).To(BeEquivalentTo(2))
 
 	By("stopping timer")
-	Expect(t.stop()).To(Succeed())
+	done, err := t.stop()
+	Expect(err).ToNot(HaveOccurred())
+	Eventually(done).Should(BeClosed())
 	Expect(t.isRunning()).To(BeFalse())
 
 	time.Sleep(110 * time.Millisecond)
 
 	By("after tick")
-	Expect(ticks).To(Equal(2))
+	Expect(atomic.LoadInt32(&ticks)).To(BeEquivalentTo(2))
 
 	By("stopping timer second time")
-	Expect(t.stop()).ToNot(Succeed())
+	_, err = t.stop()
+	Expect(err).To(HaveOccurred())
 	Expect(t.isRunning()).To(BeFalse())
 })
+
+var _ = Describe("ticker", func() {
+	Specify("stops when context is done", func() {
+		var ticks int32
+		t, err := newTicker(10*time.Millisecond, func() { atomic.AddInt32(&ticks, 1) })
+		Expect(err).ToNot(HaveOccurred())
+		ctx, cancel := context.WithCancel(context.Background())
+		Expect(t.start(ctx)).To(Succeed())
+		Eventually(func() int32 { return atomic.LoadInt32(&ticks) }).ShouldNot(BeZero())
+		cancel()
+		done, err := t.stop()
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(done).Should(BeClosed())
+		stopped := atomic.LoadInt32(&ticks)
+		time.Sleep(30 * time.Millisecond)
+		Expect(atomic.LoadInt32(&ticks)).To(Equal(stopped))
+	})
+	Specify("done is closed after in-progress call", func() {
+		started, release := make(chan struct{}), make(chan struct{})
+		var once int32
+		t, err := newTicker(time.Millisecond, func() {
+			if atomic.CompareAndSwapInt32(&once, 0, 1) {
+				close(started)
+				<-release
+			}
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(t.start(context.Background())).To(Succeed())
+		Eventually(started).Should(BeClosed())
+		done, err := t.stop()
+		Expect(err).ToNot(HaveOccurred())
+		Consistently(done, 30*time.Millisecond).ShouldNot(BeClosed())
+		close(release)
+		Eventually(done).Should(BeClosed())
+	})
+})
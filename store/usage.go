@@ -0,0 +1,154 @@
+package store
+
+// Usage is a store accounting: items count and rough estimate of memory used by items in bytes. Unlike Info it is
+// kept up to date on every mutation, so it is cheap to get, but expired items are counted until they are cleaned
+type Usage struct {
+	Items int   `yaml:"items" json:"items" msgpack:"items"`
+	Bytes int64 `yaml:"bytes" json:"bytes" msgpack:"bytes"`
+}
+
+// Quota limits items count and bytes of items owned by account. Zero limit is unlimited
+type Quota struct {
+	MaxKeys  int
+	MaxBytes int64
+}
+
+// Usage returns store accounting
+func (s *store) Usage() Usage {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.usage
+}
+
+// UsageOf returns accounting of items owned by account of login
+func (s *store) UsageOf(login string) Usage {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.owned[login]
+}
+
+// As returns view of store sharing its items, which mutations make items owned by account of login and are rejected
+// if they exceed quota q of items owned by account. Overwriting account's own item is accounted by size difference
+func (s *store) As(login string, q Quota) Store {
+	return &store{state: s.state, clock: s.clock, owner: login, quota: q}
+}
+
+// set takes item i owned by view's owner if any, checks owner's quota, then sets item by key and logs it. Must be
+// called under lock
+func (s *store) set(key string, i item) error {
+	if ki, ok := i.(keyItem); ok {
+		i = ki.versioned(s.nextVersion())
+	}
+	if s.owner != "" {
+		i = i.owned(s.owner)
+		if err := s.admit(key, i); err != nil {
+			return err
+		}
+	}
+	s.put(key, i)
+	s.logSet(key, i)
+	return nil
+}
+
+// nextVersion returns version of next keyItem: mutation time in nanoseconds, or next to last version if it's not less,
+// so versions grow on every modification, even if key is removed and set again, and are the same on consensus members
+// applying same mutations at same time. Must be called under lock
+func (s *store) nextVersion() uint64 {
+	v := uint64(s.clock.now().UnixNano())
+	if v <= s.version {
+		v = s.version + 1
+	}
+	s.version = v
+	return v
+}
+
+// admit returns error if setting item i by key exceeds quota of view's owner. Must be called under lock
+func (s *store) admit(key string, i item) error {
+	u := s.owned[s.owner]
+	n := size(key, i)
+	if old, exists := s.items[key]; exists && old.ownerOf() == s.owner {
+		n -= size(key, old)
+	} else if s.quota.MaxKeys > 0 && u.Items >= s.quota.MaxKeys {
+		return ErrKeysQuotaExceeded
+	}
+	if s.quota.MaxBytes > 0 && n > 0 && u.Bytes+n > s.quota.MaxBytes {
+		return ErrBytesQuotaExceeded
+	}
+	return nil
+}
+
+// put sets item i by key and accounts it. Must be called under lock
+func (s *store) put(key string, i item) {
+	s.drop(key)
+	s.items[key] = i
+	s.account(key, i, 1)
+	s.seen(i)
+}
+
+// seen keeps last version not less than version of item i, so versions of loaded and replicated items aren't reused.
+// Must be called under lock
+func (s *store) seen(i item) {
+	if ki, ok := i.(keyItem); ok && ki.version > s.version {
+		s.version = ki.version
+	}
+}
+
+// drop removes item by key and accounts it. Must be called under lock
+func (s *store) drop(key string) {
+	i, exists := s.items[key]
+	if !exists {
+		return
+	}
+	delete(s.items, key)
+	s.account(key, i, -1)
+}
+
+// reset replaces all items and accounts them. Must be called under lock
+func (s *store) reset(all items) {
+	s.items = all
+	s.usage = Usage{}
+	s.owned = map[string]Usage{}
+	s.counts = [itemTypes]int{}
+	for k, i := range all {
+		s.account(k, i, 1)
+		s.seen(i)
+	}
+}
+
+// account adds item i of key to accounting if sign is 1 or subtracts it if sign is -1. Must be called under lock
+func (s *store) account(key string, i item, sign int) {
+	n := int64(sign) * size(key, i)
+	s.counts[typeOf(i)] += sign
+	s.usage.Items += sign
+	s.usage.Bytes += n
+	owner := i.ownerOf()
+	if owner == "" {
+		return
+	}
+	u := s.owned[owner]
+	u.Items += sign
+	u.Bytes += n
+	if u.Items == 0 {
+		delete(s.owned, owner)
+		return
+	}
+	s.owned[owner] = u
+}
+
+// size returns rough estimate of memory used by item i of key
+func size(key string, i item) int64 {
+	n := int64(len(key) + itemOverhead)
+	switch ii := i.(type) {
+	case keyItem:
+		n += int64(len(ii.value))
+	case listItem:
+		for _, v := range ii.list {
+			n += int64(len(v) + stringOverhead)
+		}
+	case dictItem:
+		for dk, v := range ii.dict {
+			n += int64(len(dk) + len(v) + 2*stringOverhead)
+		}
+	}
+	return n
+}